@@ -0,0 +1,545 @@
+/*
+  The MIT License (MIT)
+
+  Copyright (c) 2015 Nirbhay Choubey
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in all
+  copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+  SOFTWARE.
+*/
+
+package mysql
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// conflict handling (duplicate keys, rows to update or delete not found)
+const (
+	APPLY_CONFLICT_FAIL      = iota // abort the transaction
+	APPLY_CONFLICT_SKIP             // skip the conflicting row or statement
+	APPLY_CONFLICT_OVERWRITE        // replace the existing (or missing) row
+)
+
+// server errors reported on duplicate keys
+const (
+	_ER_DUP_ENTRY               = 1062
+	_ER_DUP_ENTRY_WITH_KEY_NAME = 1586
+)
+
+// Flags2 options (Q_FLAGS2_CODE)
+const (
+	_OPTION_AUTO_IS_NULL          = 1 << 14
+	_OPTION_NO_FOREIGN_KEY_CHECKS = 1 << 26
+	_OPTION_RELAXED_UNIQUE_CHECKS = 1 << 27
+)
+
+// Applier applies the transactions of a binlog to a target server, through
+// this driver. Statements are executed in the context (default database,
+// session variables, INSERT_ID, RAND seeds, user variables) they were logged
+// with, row changes are executed as INSERT, UPDATE and DELETE statements (see
+// RowsEventSQL).
+//
+// Each transaction is applied in a transaction of the target and committed
+// along with its checkpoint, if a checkpoint store is set. Statements causing
+// an implicit commit (DDL) can't be applied atomically though. XA
+// transactions are not supported: the applier stops with ErrXATransaction on
+// the first one, prepared or committed, before saving any checkpoint past it.
+//
+// The applier changes the session variables of the connections it uses and
+// sets their character set to utf8mb4, the target database should hence not
+// be shared with other users. Row changes are converted to UTF-8 from the
+// character set of their columns, which has to be logged (MySQL 8.0+): the
+// rows events of tables with text columns of unknown character set fail with
+// ErrCharset.
+type Applier struct {
+	db          *sql.DB
+	checkpoints *TableCheckpointStore
+	conflict    int
+}
+
+// NewApplier returns an applier executing the changes against the specified
+// database and saving the checkpoints of the applied transactions to the
+// given store (nil for none). To resume where the applier stopped, set the
+// same store as the checkpoint store of the binlog (see SetCheckpointStore):
+// Run only loads the checkpoint from it, the binlog never saving checkpoints
+// of transactions which have not been applied. Callers of Apply must not set
+// a checkpoint store on the binlog though, as the binlog would save the
+// checkpoint of a transaction failing to apply (on Next or Close).
+func NewApplier(db *sql.DB, checkpoints *TableCheckpointStore) *Applier {
+	return &Applier{db: db, checkpoints: checkpoints}
+}
+
+// SetConflictHandling sets how conflicts are handled (APPLY_CONFLICT_*,
+// APPLY_CONFLICT_FAIL by default) : duplicate-key errors, and updated or
+// deleted rows not found on the target (ErrRowNotFound). Statements can't be
+// overwritten, APPLY_CONFLICT_OVERWRITE skips them. Missing rows are inserted
+// on update and ignored on delete.
+func (a *Applier) SetConflictHandling(conflict int) {
+	a.conflict = conflict
+}
+
+// Run applies the transactions read from the binlog until its end. Run takes
+// over the checkpointing of the binlog: the checkpoint of a transaction is
+// saved along with it, or, if the applier has no store, left to the checkpoint
+// store of the binlog once the transaction has been applied.
+func (a *Applier) Run(b *Binlog) error {
+	for {
+		tx, err := b.NextTransaction()
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		// held by the binlog until committed, their changes would be
+		// lost on a restart from a later checkpoint
+		for _, prepared := range b.prepared {
+			b.unsaved = nil
+			return myError(ErrXATransaction, prepared.xid)
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+
+		// not saved by the binlog before the transaction gets applied
+		cp := b.checkpoint()
+		b.unsaved = nil
+
+		if err = a.Apply(tx, cp); err != nil {
+			return err
+		}
+
+		if a.checkpoints == nil && b.checkpoints != nil {
+			b.unsaved = &cp
+		}
+	}
+}
+
+// Apply applies the specified transaction and saves the given checkpoint (the
+// position right after the transaction) in the same target transaction.
+func (a *Applier) Apply(tx *Transaction, cp Checkpoint) error {
+	if tx.xid != "" {
+		return myError(ErrXATransaction, tx.xid)
+	}
+
+	sqlTx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	c := &applyContext{tx: sqlTx, foreignKeyChecks: true, uniqueChecks: true}
+
+	if err = a.apply(c, tx); err == nil {
+		err = c.reset()
+	}
+
+	if err == nil && a.checkpoints != nil {
+		err = a.checkpoints.SaveTx(sqlTx, cp)
+	}
+
+	if err != nil {
+		sqlTx.Rollback()
+		return err
+	}
+	return sqlTx.Commit()
+}
+
+// applyContext holds the session state of the target transaction.
+type applyContext struct {
+	tx               *sql.Tx
+	schema           string // default database
+	foreignKeyChecks bool
+	uniqueChecks     bool
+	names            bool // character set changed by a statement
+	timestamp        bool // timestamp set by a statement
+}
+
+func (c *applyContext) exec(query string) error {
+	_, err := c.tx.Exec(query)
+	return err
+}
+
+// setNames restores the character set of the connection (changed by the
+// last statement), the values rendered by the applier being UTF-8.
+func (c *applyContext) setNames() error {
+	if !c.names {
+		return nil
+	}
+	c.names = false
+	return c.exec("SET NAMES utf8mb4")
+}
+
+// setChecks sets the foreign key and unique checks of the session, if they
+// differ.
+func (c *applyContext) setChecks(foreignKeyChecks, uniqueChecks bool) error {
+	if foreignKeyChecks == c.foreignKeyChecks &&
+		uniqueChecks == c.uniqueChecks {
+		return nil
+	}
+
+	c.foreignKeyChecks, c.uniqueChecks = foreignKeyChecks, uniqueChecks
+	return c.exec(fmt.Sprintf("SET @@session.foreign_key_checks = %d, "+
+		"@@session.unique_checks = %d", boolInt(foreignKeyChecks),
+		boolInt(uniqueChecks)))
+}
+
+// reset restores the session variables changed while applying a
+// transaction.
+func (c *applyContext) reset() error {
+	if err := c.setNames(); err != nil {
+		return err
+	}
+
+	if err := c.setChecks(true, true); err != nil {
+		return err
+	}
+
+	if c.timestamp {
+		c.timestamp = false
+		return c.exec("SET @@session.timestamp = DEFAULT")
+	}
+	return nil
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// apply executes the events of the specified transaction.
+func (a *Applier) apply(c *applyContext, tx *Transaction) error {
+	var err error
+
+	for _, ev := range tx.events {
+		switch e := ev.(type) {
+		case *QueryEvent:
+			switch e.query {
+			case "BEGIN", "COMMIT", "ROLLBACK":
+			default:
+				err = a.applyStatement(c, e)
+			}
+
+		case *IntvarEvent:
+			switch e.type_ {
+			case LAST_INSERT_ID_EVENT:
+				err = c.exec("SET LAST_INSERT_ID = " +
+					strconv.FormatUint(e.value, 10))
+			case INSERT_ID_EVENT:
+				err = c.exec("SET INSERT_ID = " +
+					strconv.FormatUint(e.value, 10))
+			default:
+			}
+
+		case *RandEvent:
+			err = c.exec(fmt.Sprintf("SET @@RAND_SEED1 = %d, @@RAND_SEED2 = %d",
+				e.seed1, e.seed2))
+
+		case *UserVarEvent:
+			err = a.applyUserVar(c, e)
+
+		case *RowsEvent:
+			err = a.applyRows(c, e)
+
+		default:
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyStatement executes the specified statement in the context it was
+// logged with.
+func (a *Applier) applyStatement(c *applyContext, ev *QueryEvent) error {
+	if ev.schema != "" && ev.schema != c.schema {
+		if err := c.exec("USE " + quoteIdentifier(ev.schema)); err != nil {
+			return err
+		}
+		c.schema = ev.schema
+	}
+
+	st := &ev.status
+
+	if st.Has(Q_FLAGS2_CODE) {
+		if err := c.setChecks(st.Flags2&_OPTION_NO_FOREIGN_KEY_CHECKS == 0,
+			st.Flags2&_OPTION_RELAXED_UNIQUE_CHECKS == 0); err != nil {
+			return err
+		}
+	}
+
+	// the timestamp the statement was executed at (NOW() etc.)
+	timestamp := strconv.FormatUint(uint64(ev.header.timestamp), 10)
+	if st.Has(Q_MICROSECONDS) {
+		timestamp += fmt.Sprintf(".%06d", st.Microseconds)
+	} else if st.Has(Q_HRNOW) {
+		timestamp += fmt.Sprintf(".%06d", st.HrNow)
+	}
+	vars := []string{"@@session.timestamp = " + timestamp}
+	c.timestamp = true
+
+	if st.Has(Q_FLAGS2_CODE) {
+		vars = append(vars, fmt.Sprintf("@@session.sql_auto_is_null = %d",
+			boolInt(st.Flags2&_OPTION_AUTO_IS_NULL != 0)))
+	}
+
+	if st.Has(Q_SQL_MODE_CODE) {
+		vars = append(vars, fmt.Sprintf("@@session.sql_mode = %d",
+			st.SqlMode))
+	}
+
+	if st.Has(Q_AUTO_INCREMENT) {
+		vars = append(vars, fmt.Sprintf("@@session.auto_increment_increment = %d, "+
+			"@@session.auto_increment_offset = %d",
+			st.AutoIncrementIncrement, st.AutoIncrementOffset))
+	}
+
+	if st.Has(Q_CHARSET_CODE) {
+		vars = append(vars, fmt.Sprintf("@@session.character_set_client = %d, "+
+			"@@session.collation_connection = %d, "+
+			"@@session.collation_server = %d", st.CharsetClient,
+			st.CollationConnection, st.CollationServer))
+		c.names = true
+	} else if err := c.setNames(); err != nil {
+		return err
+	}
+
+	if st.Has(Q_TIME_ZONE_CODE) {
+		vars = append(vars, "@@session.time_zone = "+quoteString(st.TimeZone))
+	}
+
+	if err := c.exec("SET " + strings.Join(vars, ", ")); err != nil {
+		return err
+	}
+
+	err := c.exec(ev.query)
+	if isDuplicateKey(err) && a.conflict != APPLY_CONFLICT_FAIL {
+		return nil
+	}
+	return err
+}
+
+// applyUserVar sets the specified user variable for the next statement.
+func (a *Applier) applyUserVar(c *applyContext, ev *UserVarEvent) error {
+	var value string
+
+	switch v := ev.Value().(type) {
+	case nil:
+		value = "NULL"
+	case string:
+		if ev.type_ == USER_VAR_DECIMAL {
+			value = v
+		} else {
+			value = quoteString(v)
+		}
+	case []byte:
+		value = hexLiteral(v)
+	case float64:
+		value = strconv.FormatFloat(v, 'g', -1, 64)
+	default:
+		value = fmt.Sprint(v)
+	}
+
+	if err := c.setNames(); err != nil {
+		return err
+	}
+	return c.exec("SET @" + quoteIdentifier(ev.name) + " := " + value)
+}
+
+// applyRows executes the row changes of the specified rows event.
+func (a *Applier) applyRows(c *applyContext, ev *RowsEvent) error {
+	if ev.tableMap == nil {
+		return myError(ErrUnknownTable, ev.tableId)
+	}
+	if ev.err != nil {
+		// never apply a partially decoded event
+		return ev.err
+	}
+
+	t := &sqlTable{tableMap: ev.tableMap}
+
+	// the text values are converted to UTF-8 from the column character set,
+	// never guessed (e.g. no charset meta data, binlog_row_metadata=MINIMAL
+	// or older servers)
+	for i := range ev.tableMap.columns {
+		col := &ev.tableMap.columns[i]
+		if isCharacterType(col) && !isBinaryColumn(col) &&
+			collationCharset(uint32(col.charset)) == _CHARSET_OTHER {
+			return myError(ErrCharset, t.column(i), t.name(), col.charset)
+		}
+	}
+
+	if err := c.setNames(); err != nil {
+		return err
+	}
+
+	if err := c.setChecks(ev.flags&NO_FOREIGN_KEY_CHECKS_F == 0,
+		ev.flags&RELAXED_UNIQUE_CHECKS_F == 0); err != nil {
+		return err
+	}
+
+	before := ev.columnsPresentBitmap1
+	after := ev.columnsPresentBitmap2
+
+	for i, r := range ev.rows1.Rows {
+		var (
+			stmt string
+			err  error
+		)
+
+		switch ev.header.type_ {
+		case PRE_GA_WRITE_ROWS_EVENT, WRITE_ROWS_EVENT_V1, WRITE_ROWS_EVENT:
+			verb := "INSERT"
+			if a.conflict == APPLY_CONFLICT_OVERWRITE {
+				verb = "REPLACE"
+			}
+			if stmt, err = t.write(verb, r, before); err == nil {
+				err = a.execRow(c, stmt)
+			}
+
+		case PRE_GA_UPDATE_ROWS_EVENT, UPDATE_ROWS_EVENT_V1, UPDATE_ROWS_EVENT,
+			PARTIAL_UPDATE_ROWS_EVENT:
+			if i >= len(ev.rows2.Rows) {
+				break
+			}
+			if stmt, err = t.update(ev.rows2.Rows[i], after, r, before,
+				false); err == nil {
+				err = a.execChange(c, t, stmt, r, before)
+			}
+			if a.conflict != APPLY_CONFLICT_OVERWRITE {
+				break
+			}
+			if isDuplicateKey(err) {
+				err = a.overwriteRow(c, t, r, before, ev.rows2.Rows[i], after)
+			} else if isRowNotFound(err) {
+				if stmt, err = t.write("REPLACE", ev.rows2.Rows[i],
+					after); err == nil {
+					err = c.exec(stmt)
+				}
+			}
+
+		case PRE_GA_DELETE_ROWS_EVENT, DELETE_ROWS_EVENT_V1, DELETE_ROWS_EVENT:
+			if stmt, err = t.delete(r, before); err == nil {
+				err = a.execChange(c, t, stmt, r, before)
+			}
+			if isRowNotFound(err) && a.conflict == APPLY_CONFLICT_OVERWRITE {
+				err = nil
+			}
+
+		default:
+			return myError(ErrEventType, ev.header.type_)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// execRow executes the specified row change, skipping it on duplicate-key
+// errors if so configured.
+func (a *Applier) execRow(c *applyContext, stmt string) error {
+	err := c.exec(stmt)
+	if isDuplicateKey(err) && a.conflict == APPLY_CONFLICT_SKIP {
+		return nil
+	}
+	return err
+}
+
+// execChange executes the specified update or delete of the given row, a row
+// not found being a conflict (skipped if so configured). As the affected rows
+// of an update are the rows actually changed, an update changing no row only
+// conflicts if the row can't be found (it may already hold the new values).
+func (a *Applier) execChange(c *applyContext, t *sqlTable, stmt string,
+	r EventRow, bitmap []byte) error {
+	res, err := c.tx.Exec(stmt)
+	if isDuplicateKey(err) && a.conflict == APPLY_CONFLICT_SKIP {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n > 0 {
+		return err
+	}
+
+	where, err := t.where(r, bitmap)
+	if err != nil {
+		return err
+	}
+
+	rows, err := c.tx.Query("SELECT 1 FROM " + t.name() + " WHERE " + where +
+		" LIMIT 1")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		return nil
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if a.conflict == APPLY_CONFLICT_SKIP {
+		return nil
+	}
+	return myError(ErrRowNotFound, t.name(), where)
+}
+
+// overwriteRow replaces the row of the before image by the after image, on
+// update conflicts.
+func (a *Applier) overwriteRow(c *applyContext, t *sqlTable, before EventRow,
+	beforeBitmap []byte, after EventRow, afterBitmap []byte) error {
+	stmt, err := t.delete(before, beforeBitmap)
+	if err != nil {
+		return err
+	}
+	if err = c.exec(stmt); err != nil {
+		return err
+	}
+
+	if stmt, err = t.write("REPLACE", after, afterBitmap); err != nil {
+		return err
+	}
+	return c.exec(stmt)
+}
+
+// isRowNotFound returns whether the specified error is a missing row
+// conflict.
+func isRowNotFound(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.code == ErrRowNotFound
+}
+
+// isDuplicateKey returns whether the specified error is a duplicate-key
+// error.
+func isDuplicateKey(err error) bool {
+	e, ok := err.(*Error)
+	return ok && (e.code == _ER_DUP_ENTRY ||
+		e.code == _ER_DUP_ENTRY_WITH_KEY_NAME)
+}
@@ -0,0 +1,508 @@
+/*
+  The MIT License (MIT)
+
+  Copyright (c) 2015 Nirbhay Choubey
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in all
+  copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+  SOFTWARE.
+*/
+
+package mysql
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strings"
+	"testing"
+)
+
+// testTarget is the target database of the applier tests : it records the
+// statements executed, UPDATE and DELETE statements changing the number of
+// rows given by their prefix (1 by default), SELECT statements finding a row
+// if their prefix is listed, and statements whose prefix is listed in dup
+// failing with a duplicate-key error.
+type testTarget struct {
+	log      []string
+	affected map[string]int64
+	found    []string
+	dup      []string
+}
+
+var target *testTarget
+
+type targetDriver struct{}
+type targetConn struct{}
+type targetTx struct{}
+type targetRows struct{ n int }
+
+func (targetDriver) Open(string) (driver.Conn, error) { return targetConn{}, nil }
+
+func (targetConn) Prepare(string) (driver.Stmt, error) {
+	return nil, driver.ErrSkip
+}
+func (targetConn) Close() error { return nil }
+func (targetConn) Begin() (driver.Tx, error) {
+	target.log = append(target.log, "BEGIN")
+	return targetTx{}, nil
+}
+
+func (targetConn) Exec(query string, args []driver.Value) (driver.Result, error) {
+	target.log = append(target.log, query)
+	for _, prefix := range target.dup {
+		if strings.HasPrefix(query, prefix) {
+			return nil, &Error{code: _ER_DUP_ENTRY}
+		}
+	}
+	for prefix, n := range target.affected {
+		if strings.HasPrefix(query, prefix) {
+			return driver.RowsAffected(n), nil
+		}
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (targetConn) Query(query string, args []driver.Value) (driver.Rows, error) {
+	target.log = append(target.log, query)
+	for _, prefix := range target.found {
+		if strings.HasPrefix(query, prefix) {
+			return &targetRows{n: 1}, nil
+		}
+	}
+	return &targetRows{}, nil
+}
+
+func (targetTx) Commit() error {
+	target.log = append(target.log, "COMMIT")
+	return nil
+}
+func (targetTx) Rollback() error {
+	target.log = append(target.log, "ROLLBACK")
+	return nil
+}
+
+func (r *targetRows) Columns() []string { return []string{"1"} }
+func (r *targetRows) Close() error      { return nil }
+func (r *targetRows) Next(dest []driver.Value) error {
+	if r.n == 0 {
+		return io.EOF
+	}
+	r.n--
+	dest[0] = int64(1)
+	return nil
+}
+
+func init() {
+	sql.Register("mysql-applier-test", targetDriver{})
+}
+
+func TestApplierMissingRow(t *testing.T) {
+	const (
+		update = "UPDATE `test`.`t1` SET `id` = 1, `v` = 2 WHERE `id` = 1 LIMIT 1"
+		delete = "DELETE FROM `test`.`t1` WHERE `id` = 2 LIMIT 1"
+		locate = "SELECT 1 FROM `test`.`t1` WHERE `id` = 1 LIMIT 1"
+		insert = "REPLACE INTO `test`.`t1` (`id`, `v`) VALUES (1, 2)"
+	)
+
+	e := &evBuilder{}
+	e.fde()
+	e.query("test", "BEGIN")
+
+	// id INT PRIMARY KEY, v INT
+	e.tableMap(1, "test", "t1", []byte{_TYPE_LONG, _TYPE_LONG}, nil,
+		_TABLE_MAP_COLUMN_NAME, 5, 2, 'i', 'd', 1, 'v',
+		_TABLE_MAP_SIMPLE_PRIMARY_KEY, 1, 0)
+	e.rows(UPDATE_ROWS_EVENT, 1, 0, 2, rowLong(1, 1), rowLong(1, 2))
+	e.rows(DELETE_ROWS_EVENT, 1, STMT_END_F, 2, rowLong(2, 2))
+	e.xid(1)
+
+	tests := []struct {
+		name     string
+		conflict int
+		affected map[string]int64
+		found    []string
+		fail     bool
+		want     []string // statements executed after BEGIN
+	}{
+		{"update missing", APPLY_CONFLICT_FAIL,
+			map[string]int64{update: 0}, nil, true,
+			[]string{update, locate, "ROLLBACK"}},
+		{"update unchanged", APPLY_CONFLICT_FAIL,
+			map[string]int64{update: 0}, []string{locate}, false,
+			[]string{update, locate, delete, "COMMIT"}},
+		{"delete missing", APPLY_CONFLICT_FAIL,
+			map[string]int64{delete: 0}, nil, true,
+			[]string{update, delete,
+				"SELECT 1 FROM `test`.`t1` WHERE `id` = 2 LIMIT 1", "ROLLBACK"}},
+		{"skip", APPLY_CONFLICT_SKIP,
+			map[string]int64{update: 0, delete: 0}, nil, false,
+			[]string{update, locate, delete,
+				"SELECT 1 FROM `test`.`t1` WHERE `id` = 2 LIMIT 1", "COMMIT"}},
+		{"overwrite", APPLY_CONFLICT_OVERWRITE,
+			map[string]int64{update: 0, delete: 0}, nil, false,
+			[]string{update, locate, insert, delete,
+				"SELECT 1 FROM `test`.`t1` WHERE `id` = 2 LIMIT 1", "COMMIT"}},
+	}
+
+	for _, test := range tests {
+		target = &testTarget{affected: test.affected, found: test.found}
+
+		db, err := sql.Open("mysql-applier-test", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		a := NewApplier(db, nil)
+		a.SetConflictHandling(test.conflict)
+
+		err = a.Run(newTestBinlog(e))
+		db.Close()
+
+		if test.fail {
+			if !isRowNotFound(err) {
+				t.Errorf("%s: got %v, want a missing row error", test.name, err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+
+		// skip the session setup
+		var got []string
+		for _, q := range target.log {
+			if q == update || len(got) > 0 {
+				got = append(got, q)
+			}
+		}
+		if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+			t.Errorf("%s: executed\n\t%s\nwant\n\t%s", test.name,
+				strings.Join(got, "\n\t"), strings.Join(test.want, "\n\t"))
+		}
+	}
+}
+
+func TestApplierDuplicateKey(t *testing.T) {
+	const (
+		stmt    = "INSERT INTO t1 VALUES (3, 3)"
+		insert  = "INSERT INTO `test`.`t1` (`id`, `v`) VALUES (1, 1)"
+		replace = "REPLACE INTO `test`.`t1` (`id`, `v`) VALUES (1, 1)"
+		update  = "UPDATE `test`.`t1` SET `id` = 2, `v` = 1 WHERE `id` = 1 LIMIT 1"
+		delete  = "DELETE FROM `test`.`t1` WHERE `id` = 1 LIMIT 1"
+		moved   = "REPLACE INTO `test`.`t1` (`id`, `v`) VALUES (2, 1)"
+		reset   = "SET @@session.timestamp = DEFAULT"
+	)
+
+	e := &evBuilder{}
+	e.fde()
+	e.query("test", "BEGIN")
+	e.query("test", stmt)
+
+	// id INT PRIMARY KEY, v INT
+	e.tableMap(1, "test", "t1", []byte{_TYPE_LONG, _TYPE_LONG}, nil,
+		_TABLE_MAP_COLUMN_NAME, 5, 2, 'i', 'd', 1, 'v',
+		_TABLE_MAP_SIMPLE_PRIMARY_KEY, 1, 0)
+	e.rows(WRITE_ROWS_EVENT, 1, 0, 2, rowLong(1, 1))
+	e.rows(UPDATE_ROWS_EVENT, 1, STMT_END_F, 2, rowLong(1, 1), rowLong(2, 1))
+	e.xid(1)
+
+	dup := []string{stmt, insert, update}
+
+	tests := []struct {
+		name     string
+		conflict int
+		want     []string // statements executed from the first change
+	}{
+		{"fail", APPLY_CONFLICT_FAIL, []string{stmt, "ROLLBACK"}},
+		{"skip", APPLY_CONFLICT_SKIP,
+			[]string{stmt, insert, update, reset, "COMMIT"}},
+		{"overwrite", APPLY_CONFLICT_OVERWRITE,
+			[]string{stmt, replace, update, delete, moved, reset, "COMMIT"}},
+	}
+
+	for _, test := range tests {
+		target = &testTarget{dup: dup}
+
+		db, err := sql.Open("mysql-applier-test", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		a := NewApplier(db, nil)
+		a.SetConflictHandling(test.conflict)
+
+		err = a.Run(newTestBinlog(e))
+		db.Close()
+
+		if test.conflict == APPLY_CONFLICT_FAIL {
+			if !isDuplicateKey(err) {
+				t.Errorf("%s: got %v, want a duplicate-key error", test.name, err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+
+		// skip the session setup
+		var got []string
+		for _, q := range target.log {
+			if q == stmt || len(got) > 0 {
+				got = append(got, q)
+			}
+		}
+		if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+			t.Errorf("%s: executed\n\t%s\nwant\n\t%s", test.name,
+				strings.Join(got, "\n\t"), strings.Join(test.want, "\n\t"))
+		}
+	}
+}
+
+// memCheckpointStore keeps the saved checkpoints in memory.
+type memCheckpointStore struct {
+	saved []Checkpoint
+}
+
+func (s *memCheckpointStore) Load() (Checkpoint, error) {
+	if len(s.saved) == 0 {
+		return Checkpoint{}, nil
+	}
+	return s.saved[len(s.saved)-1], nil
+}
+
+func (s *memCheckpointStore) Save(cp Checkpoint) error {
+	s.saved = append(s.saved, cp)
+	return nil
+}
+
+func TestApplierCheckpoint(t *testing.T) {
+	const update = "UPDATE `test`.`t1` SET `id` = 1, `v` = 2 WHERE `id` = 1 LIMIT 1"
+
+	e := &evBuilder{}
+	e.fde()
+	e.query("test", "BEGIN")
+	e.tableMap(1, "test", "t1", []byte{_TYPE_LONG, _TYPE_LONG}, nil,
+		_TABLE_MAP_COLUMN_NAME, 5, 2, 'i', 'd', 1, 'v',
+		_TABLE_MAP_SIMPLE_PRIMARY_KEY, 1, 0)
+	e.rows(UPDATE_ROWS_EVENT, 1, STMT_END_F, 2, rowLong(1, 1), rowLong(1, 2))
+	e.xid(1)
+	end := uint64(e.pos)
+
+	tests := []struct {
+		name     string
+		table    bool // applier store
+		affected map[string]int64
+		saved    []uint64 // positions saved to the binlog store
+		saveTx   bool     // checkpoint saved in the target transaction
+	}{
+		{"failed", false, map[string]int64{update: 0}, nil, false},
+		{"failed with store", true, map[string]int64{update: 0}, nil, false},
+		{"applied", false, nil, []uint64{end}, false},
+		{"applied with store", true, nil, nil, true},
+	}
+
+	for _, test := range tests {
+		target = &testTarget{affected: test.affected}
+
+		db, err := sql.Open("mysql-applier-test", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var table *TableCheckpointStore
+		if test.table {
+			if table, err = NewTableCheckpointStore(db, "cp", "test"); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		store := new(memCheckpointStore)
+		b := newTestBinlog(e)
+		b.SetCheckpointStore(store)
+		if err = b.Begin(); err != nil {
+			t.Fatal(err)
+		}
+
+		err = NewApplier(db, table).Run(b)
+		if test.affected != nil && !isRowNotFound(err) {
+			t.Errorf("%s: got %v, want a missing row error", test.name, err)
+		} else if test.affected == nil && err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+
+		if err = b.Close(); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+		db.Close()
+
+		var saved []uint64
+		for _, cp := range store.saved {
+			saved = append(saved, cp.Position)
+		}
+		if len(saved) != len(test.saved) ||
+			(len(saved) > 0 && saved[0] != test.saved[0]) {
+			t.Errorf("%s: saved %v, want %v", test.name, saved, test.saved)
+		}
+
+		// the checkpoint query precedes the commit
+		var saveTx bool
+		for i, q := range target.log {
+			if strings.HasPrefix(q, "INSERT INTO `cp`") {
+				saveTx = i+1 < len(target.log) && target.log[i+1] == "COMMIT"
+			}
+		}
+		if saveTx != test.saveTx {
+			t.Errorf("%s: checkpoint saved in the transaction: %v, want %v",
+				test.name, saveTx, test.saveTx)
+		}
+	}
+}
+
+func TestApplierCharset(t *testing.T) {
+	tests := []struct {
+		name  string
+		opt   []byte // table map charset meta data
+		value string
+		want  string // "" for an error
+	}{
+		{"latin1", []byte{_TABLE_MAP_COLUMN_CHARSET, 1, 8}, "caf\xe9",
+			"INSERT INTO `test`.`t1` (`id`, `v`) VALUES (1, _utf8mb4'café')"},
+		{"utf16", []byte{_TABLE_MAP_DEFAULT_CHARSET, 1, 54}, "\x00h\x00i",
+			"INSERT INTO `test`.`t1` (`id`, `v`) VALUES (1, _utf8mb4'hi')"},
+		{"unknown", nil, "caf\xe9", ""},
+	}
+
+	for _, test := range tests {
+		// id INT, v VARCHAR(20)
+		row := append(rowLong(1), byte(len(test.value)))
+		row = append(row, test.value...)
+
+		e := &evBuilder{}
+		e.fde()
+		e.query("test", "BEGIN")
+		e.tableMap(1, "test", "t1", []byte{_TYPE_LONG, _TYPE_VARCHAR},
+			[]byte{20, 0}, append([]byte{_TABLE_MAP_COLUMN_NAME, 5,
+				2, 'i', 'd', 1, 'v'}, test.opt...)...)
+		e.rows(WRITE_ROWS_EVENT, 1, STMT_END_F, 2, row)
+		e.xid(1)
+
+		target = &testTarget{}
+		db, err := sql.Open("mysql-applier-test", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = NewApplier(db, nil).Run(newTestBinlog(e))
+		db.Close()
+
+		var inserted []string
+		for _, q := range target.log {
+			if strings.HasPrefix(q, "INSERT") {
+				inserted = append(inserted, q)
+			}
+		}
+
+		if test.want == "" {
+			if e, ok := err.(*Error); !ok || e.Code() != ErrCharset ||
+				len(inserted) != 0 {
+				t.Errorf("%s: got %q, %v", test.name, inserted, err)
+			}
+			continue
+		}
+		if err != nil || len(inserted) != 1 || inserted[0] != test.want {
+			t.Errorf("%s: got %q, %v, want %s", test.name, inserted, err,
+				test.want)
+		}
+	}
+}
+
+func TestApplierXA(t *testing.T) {
+	insert := func(e *evBuilder, id int32) {
+		e.tableMap(1, "test", "t1", []byte{_TYPE_LONG}, nil,
+			_TABLE_MAP_COLUMN_NAME, 3, 2, 'i', 'd')
+		e.rows(WRITE_ROWS_EVENT, 1, STMT_END_F, 1, rowLong(id))
+	}
+	tx := func(e *evBuilder, id int32) {
+		e.query("test", "BEGIN")
+		insert(e, id)
+		e.xid(uint64(id))
+	}
+	xa := func(e *evBuilder, id int32, onePhase bool) {
+		e.query("test", "XA START X'31',X'',1")
+		insert(e, id)
+		e.query("test", "XA END X'31',X'',1")
+		e.xaPrepare("1", onePhase)
+	}
+
+	tests := []struct {
+		name  string
+		build func(e *evBuilder)
+	}{
+		{"two phase", func(e *evBuilder) {
+			xa(e, 2, false)
+			tx(e, 3)
+			e.query("test", "XA COMMIT X'31',X'',1")
+		}},
+		{"one phase", func(e *evBuilder) {
+			xa(e, 2, true)
+			tx(e, 3)
+		}},
+		{"prepared", func(e *evBuilder) {
+			xa(e, 2, false)
+		}},
+	}
+
+	for _, test := range tests {
+		e := &evBuilder{}
+		e.fde()
+		tx(e, 1)
+		end := uint64(e.pos)
+		test.build(e)
+
+		target = &testTarget{}
+		db, err := sql.Open("mysql-applier-test", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		store := new(memCheckpointStore)
+		b := newTestBinlog(e)
+		b.SetCheckpointStore(store)
+		if err = b.Begin(); err != nil {
+			t.Fatal(err)
+		}
+
+		err = NewApplier(db, nil).Run(b)
+		if e, ok := err.(*Error); !ok || e.Code() != ErrXATransaction {
+			t.Errorf("%s: got %v, want an XA transaction error", test.name, err)
+		}
+		b.Close()
+		db.Close()
+
+		// only the transaction preceding the XA transaction is applied
+		var inserted []string
+		for _, q := range target.log {
+			if strings.HasPrefix(q, "INSERT") {
+				inserted = append(inserted, q)
+			}
+		}
+		if len(inserted) != 1 ||
+			inserted[0] != "INSERT INTO `test`.`t1` (`id`) VALUES (1)" {
+			t.Errorf("%s: applied %q", test.name, inserted)
+		}
+		if len(store.saved) != 1 || store.saved[0].Position != end {
+			t.Errorf("%s: saved %v, want the position %d", test.name,
+				store.saved, end)
+		}
+	}
+}
@@ -0,0 +1,363 @@
+/*
+  The MIT License (MIT)
+
+  Copyright (c) 2015 Nirbhay Choubey
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in all
+  copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+  SOFTWARE.
+*/
+
+package mysql
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BackupWriter writes the events received from the server into local
+// binlog files, byte-identical to the ones on the server (like mysqlbinlog
+// --read-from-remote-server --raw --stop-never).
+type BackupWriter struct {
+	dir          string
+	syncInterval time.Duration
+
+	file     *os.File
+	name     string
+	position uint64 // end of the last written event
+
+	lastSync time.Time
+	unsynced bool
+}
+
+// NewBackupWriter returns a writer storing the binlog files into the
+// specified directory. The files are synced at most syncInterval apart (0
+// syncs after every event), as well as on rotation and close.
+func NewBackupWriter(dir string, syncInterval time.Duration) *BackupWriter {
+	return &BackupWriter{dir: dir, syncInterval: syncInterval}
+}
+
+// Resume prepares the writer to continue the backup in the newest binlog
+// file of the directory, discarding any partially written event at its end.
+// It returns the file and position the binlog stream has to be started from
+// (Binlog.SetFile & SetPosition), or an empty file name if there is nothing
+// to resume.
+func (w *BackupWriter) Resume() (file string, position uint64, err error) {
+	names, err := w.files()
+	if err != nil || len(names) == 0 {
+		return "", 0, err
+	}
+
+	name := names[len(names)-1]
+
+	f, err := os.OpenFile(filepath.Join(w.dir, name), os.O_RDWR, 0)
+	if err != nil {
+		return "", 0, myError(ErrFile, err)
+	}
+
+	if position, err = lastCompleteEvent(f); err != nil {
+		f.Close()
+		return "", 0, err
+	}
+
+	// drop the incomplete event, if any
+	if err = f.Truncate(int64(position)); err != nil {
+		f.Close()
+		return "", 0, myError(ErrFile, err)
+	}
+
+	if _, err = f.Seek(int64(position), io.SeekStart); err != nil {
+		f.Close()
+		return "", 0, myError(ErrFile, err)
+	}
+
+	if err = w.closeFile(); err != nil {
+		f.Close()
+		return "", 0, err
+	}
+
+	w.file = f
+	w.name = name
+	w.position = position
+	return name, position, nil
+}
+
+// Write writes the specified event received from the server. Heartbeats
+// and the artificial events generated by the server are not written, a
+// ROTATE_EVENT starts a new file.
+func (w *BackupWriter) Write(re *RawEvent) error {
+	var err error
+
+	if re.embedded {
+		return myError(ErrFile, "can't write the events of a transaction "+
+			"payload (see Binlog.SetKeepPayloads)")
+	}
+
+	switch {
+	case re.header.type_ == HEARTBEAT_LOG_EVENT,
+		re.header.type_ == HEARTBEAT_LOG_EVENT_V2:
+		// the source is idle, sync the last written events in time
+		return w.syncIfDue()
+
+	case re.header.type_ == ROTATE_EVENT:
+		ev, ok := re.Event().(*RotateEvent)
+		if !ok {
+			return myError(ErrInvalidPacket)
+		}
+
+		artificial := re.header.position == 0 ||
+			(re.header.flags&_LOG_EVENT_ARTIFICIAL_F) != 0
+
+		if artificial {
+			// sent at the beginning of the stream to tell the
+			// file the events come from
+			if w.file != nil && w.name == filepath.Base(ev.file) {
+				return nil
+			}
+			return w.openFile(ev.file, ev.position)
+		}
+
+		// a real rotate event closes the current file
+		if err = w.writeEvent(re); err != nil {
+			return err
+		}
+		return w.openFile(ev.file, _BINLOG_HEADER_SIZE)
+
+	case re.header.type_ == FORMAT_DESCRIPTION_EVENT &&
+		w.position > _BINLOG_HEADER_SIZE:
+		// resent when the stream is started past the beginning of
+		// a file, which already contains it
+		return nil
+
+	case re.header.position == 0,
+		(re.header.flags & _LOG_EVENT_ARTIFICIAL_F) != 0:
+		// not part of the binlog file (e.g. MariaDB fake GTID_LIST)
+		return nil
+
+	default:
+	}
+	return w.writeEvent(re)
+}
+
+// Sync commits the written events to stable storage.
+func (w *BackupWriter) Sync() error {
+	if w.file == nil || !w.unsynced {
+		return nil
+	}
+
+	if err := w.file.Sync(); err != nil {
+		return myError(ErrFile, err)
+	}
+	w.lastSync = time.Now()
+	w.unsynced = false
+	return nil
+}
+
+// Close syncs and closes the current file.
+func (w *BackupWriter) Close() error {
+	return w.closeFile()
+}
+
+// File returns the name of the file being written and the position of the
+// end of the last written event.
+func (w *BackupWriter) File() (string, uint64) {
+	return w.name, w.position
+}
+
+func (w *BackupWriter) writeEvent(re *RawEvent) error {
+	if w.file == nil {
+		// the stream must start with a rotate event
+		return myError(ErrFile, "no binlog file to write the event to")
+	}
+
+	// make sure the event belongs right after the previous one (the
+	// server stores the lower 32 bits of the next position)
+	end := w.position + uint64(len(re.body))
+	if re.header.position != uint32(end) {
+		return myError(ErrFile, "event at position "+
+			strconv.FormatUint(uint64(re.header.position), 10)+
+			" does not follow "+w.name+":"+
+			strconv.FormatUint(w.position, 10))
+	}
+
+	if _, err := w.file.Write(re.body); err != nil {
+		return myError(ErrFile, err)
+	}
+	w.position = end
+	w.unsynced = true
+
+	return w.syncIfDue()
+}
+
+// syncIfDue syncs the written events if the last sync is syncInterval old.
+func (w *BackupWriter) syncIfDue() error {
+	if time.Since(w.lastSync) >= w.syncInterval {
+		return w.Sync()
+	}
+	return nil
+}
+
+// openFile switches to the specified binlog file, to be written from the
+// specified position.
+func (w *BackupWriter) openFile(name string, position uint64) error {
+	var err error
+
+	if err = w.closeFile(); err != nil {
+		return err
+	}
+
+	// never write outside the backup directory
+	name = filepath.Base(name)
+	path := filepath.Join(w.dir, name)
+
+	if position <= _BINLOG_HEADER_SIZE {
+		// a new file
+		if w.file, err = os.OpenFile(path,
+			os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0640); err != nil {
+			return myError(ErrFile, err)
+		}
+
+		if _, err = w.file.Write([]byte(_BINLOG_MAGIC)); err != nil {
+			return myError(ErrFile, err)
+		}
+		position = _BINLOG_HEADER_SIZE
+	} else {
+		// an existing file, to be continued
+		if w.file, err = os.OpenFile(path, os.O_WRONLY, 0); err != nil {
+			return myError(ErrFile, err)
+		}
+
+		var info os.FileInfo
+		if info, err = w.file.Stat(); err != nil {
+			return myError(ErrFile, err)
+		}
+
+		if uint64(info.Size()) != position {
+			return myError(ErrFile, "can't continue "+name+" at "+
+				strconv.FormatUint(position, 10))
+		}
+
+		if _, err = w.file.Seek(int64(position), io.SeekStart); err != nil {
+			return myError(ErrFile, err)
+		}
+	}
+
+	w.name = name
+	w.position = position
+	w.unsynced = true
+	return nil
+}
+
+func (w *BackupWriter) closeFile() error {
+	if w.file == nil {
+		return nil
+	}
+
+	err := w.Sync()
+	if e := w.file.Close(); e != nil && err == nil {
+		err = myError(ErrFile, e)
+	}
+	w.file = nil
+	return err
+}
+
+// files returns the names of the binlog files in the backup directory,
+// ordered by sequence number.
+func (w *BackupWriter) files() ([]string, error) {
+	entries, err := ioutil.ReadDir(w.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, myError(ErrFile, err)
+	}
+
+	var names []string
+
+	for _, e := range entries {
+		if !e.Mode().IsRegular() || !isBinlogFile(filepath.Join(w.dir, e.Name())) {
+			continue
+		}
+		names = append(names, e.Name())
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		return binlogFileLess(names[i], names[j])
+	})
+	return names, nil
+}
+
+// isBinlogFile returns whether the specified file starts with the binlog
+// magic number.
+func isBinlogFile(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	magic := make([]byte, _BINLOG_HEADER_SIZE)
+	if _, err = io.ReadFull(f, magic); err != nil {
+		return false
+	}
+	return string(magic) == _BINLOG_MAGIC
+}
+
+// binlogFileLess orders the binlog file names by their numeric extension
+// (which may outgrow its zero padding).
+func binlogFileLess(a, b string) bool {
+	na, errA := strconv.ParseUint(strings.TrimPrefix(filepath.Ext(a), "."), 10, 64)
+	nb, errB := strconv.ParseUint(strings.TrimPrefix(filepath.Ext(b), "."), 10, 64)
+
+	if errA == nil && errB == nil && na != nb {
+		return na < nb
+	}
+	return a < b
+}
+
+// lastCompleteEvent returns the end position of the last complete event of
+// the specified binlog file.
+func lastCompleteEvent(f *os.File) (uint64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, myError(ErrFile, err)
+	}
+	size := uint64(info.Size())
+
+	header := make([]byte, _EVENT_HEADER_LENGTH)
+	position := uint64(_BINLOG_HEADER_SIZE)
+
+	for position+_EVENT_HEADER_LENGTH <= size {
+		if _, err = f.ReadAt(header, int64(position)); err != nil {
+			return 0, myError(ErrFile, err)
+		}
+
+		length := uint64(binary.LittleEndian.Uint32(header[9:]))
+		if length < _EVENT_HEADER_LENGTH || position+length > size {
+			break
+		}
+		position += length
+	}
+	return position, nil
+}
@@ -0,0 +1,243 @@
+/*
+  The MIT License (MIT)
+
+  Copyright (c) 2015 Nirbhay Choubey
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in all
+  copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+  SOFTWARE.
+*/
+
+package mysql
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// backupStream returns the events sent by the server when streaming the
+// specified files: each file is announced by an artificial rotate event.
+func backupStream(names []string, files ...*evBuilder) *evBuilder {
+	stream := new(evBuilder)
+	for i, e := range files {
+		stream.rotate(names[i], 4, true)
+		stream.events = append(stream.events, e.events...)
+
+		// the server is idle
+		hb := new(evBuilder)
+		hb.add(HEARTBEAT_LOG_EVENT, []byte(names[i]))
+		stream.events = append(stream.events, hb.events...)
+	}
+	return stream
+}
+
+// writeBackup writes the events of the specified stream with w.
+func writeBackup(t *testing.T, w *BackupWriter, stream *evBuilder) {
+	b := newTestBinlog(stream)
+	for b.Next() {
+		re, err := b.RawEvent()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = w.Write(&re); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Error(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkBackupFile checks that the specified backup file holds the built
+// events.
+func checkBackupFile(t *testing.T, dir, name string, e *evBuilder) {
+	want := bytes.NewBufferString(_BINLOG_MAGIC)
+	for _, ev := range e.events {
+		want.Write(ev)
+	}
+
+	got, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want.Bytes()) {
+		t.Errorf("%s: got %d bytes, want %d", name, len(got), want.Len())
+	}
+}
+
+func TestBackupWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	e1 := new(evBuilder)
+	e1.fde()
+	e1.query("test", "CREATE TABLE t1 (a INT)")
+	e1.rotate("bin.000002", 4, false)
+
+	e2 := new(evBuilder)
+	e2.fde()
+	e2.query("test", "CREATE TABLE t2 (a INT)")
+
+	w := NewBackupWriter(dir, 0)
+	writeBackup(t, w, backupStream([]string{"bin.000001", "bin.000002"}, e1, e2))
+
+	if name, pos := w.File(); name != "bin.000002" || pos != uint64(e2.pos) {
+		t.Errorf("writing %s:%d, want bin.000002:%d", name, pos, e2.pos)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	checkBackupFile(t, dir, "bin.000001", e1)
+	checkBackupFile(t, dir, "bin.000002", e2)
+
+	// an event not following the previous one is refused
+	e3 := new(evBuilder)
+	e3.fde()
+	e3.pos += 100
+	e3.query("test", "CREATE TABLE t3 (a INT)")
+
+	b := newTestBinlog(backupStream([]string{"bin.000003"}, e3))
+	w = NewBackupWriter(dir, 0)
+	for err == nil && b.Next() {
+		re, _ := b.RawEvent()
+		err = w.Write(&re)
+	}
+	if err == nil {
+		t.Error("wrote an event at the wrong position")
+	}
+	w.Close()
+}
+
+func TestBackupWriterResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// nothing to resume
+	w := NewBackupWriter(dir, 0)
+	if file, _, err := w.Resume(); err != nil || file != "" {
+		t.Fatalf("got %q, %v", file, err)
+	}
+
+	e1 := new(evBuilder)
+	e1.fde()
+	e1.rotate("bin.000002", 4, false)
+	writeBinlogFile(t, filepath.Join(dir, "bin.000001"), e1)
+
+	e2 := new(evBuilder)
+	e2.fde()
+	e2.query("test", "CREATE TABLE t1 (a INT)")
+	writeBinlogFile(t, filepath.Join(dir, "bin.000002"), e2)
+	end := uint64(e2.pos)
+
+	// the last event got partially written, as well as the header of
+	// the next one
+	e2.query("test", "CREATE TABLE t2 (a INT)")
+	f, err := os.OpenFile(filepath.Join(dir, "bin.000002"), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(e2.events[2][:30])
+	f.Close()
+
+	// not binlog files
+	if err = ioutil.WriteFile(filepath.Join(dir, "bin.index"),
+		[]byte("./bin.000001\n./bin.000002\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "bin.000010"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w = NewBackupWriter(dir, 0)
+	file, position, err := w.Resume()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file != "bin.000002" || position != end {
+		t.Fatalf("resuming from %s:%d, want bin.000002:%d", file, position, end)
+	}
+
+	// the server resends the format description event first
+	stream := new(evBuilder)
+	stream.rotate("bin.000002", position, true)
+	stream.events = append(stream.events, e2.events[0], e2.events[2])
+	writeBackup(t, w, stream)
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	checkBackupFile(t, dir, "bin.000002", e2)
+}
+
+func TestLastCompleteEvent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	e := new(evBuilder)
+	e.fde()
+	e.query("test", "CREATE TABLE t1 (a INT)")
+	end := uint64(e.pos)
+
+	// the size of the next event is corrupted
+	corrupted := make([]byte, _EVENT_HEADER_LENGTH)
+	e.events = append(e.events, corrupted)
+
+	name := filepath.Join(dir, "bin.000001")
+	writeBinlogFile(t, name, e)
+
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if position, err := lastCompleteEvent(f); err != nil || position != end {
+		t.Errorf("got %d, %v, want %d", position, err, end)
+	}
+}
+
+func TestBinlogFileLess(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"bin.000001", "bin.000002", true},
+		{"bin.000002", "bin.000001", false},
+		{"bin.999999", "bin.1000000", true},
+		{"a.000001", "b.000001", true},
+	}
+
+	for _, test := range tests {
+		if got := binlogFileLess(test.a, test.b); got != test.want {
+			t.Errorf("binlogFileLess(%q, %q) = %v, want %v", test.a,
+				test.b, got, test.want)
+		}
+	}
+}
@@ -27,7 +27,7 @@ package mysql
 import (
 	"encoding/binary"
 	"fmt"
-	"strconv"
+	"path/filepath"
 	"time"
 )
 
@@ -71,6 +71,10 @@ const (
 	TRANSACTION_CONTEXT_EVENT
 	VIEW_CHANGE_EVENT
 	XA_PREPARE_LOG_EVENT
+	PARTIAL_UPDATE_ROWS_EVENT
+	TRANSACTION_PAYLOAD_EVENT
+	HEARTBEAT_LOG_EVENT_V2
+	GTID_TAGGED_LOG_EVENT
 
 	// new Oracle MySQL events should go right above this comment
 	_ // MYSQL_EVENTS_END
@@ -81,6 +85,16 @@ const (
 	BINLOG_CHECKPOINT_EVENT = 161
 	GTID_EVENT              = 162
 	GTID_LIST_EVENT         = 163
+	START_ENCRYPTION_EVENT  = 164
+
+	// compressed events (log_bin_compress)
+	QUERY_COMPRESSED_EVENT          = 165
+	WRITE_ROWS_COMPRESSED_EVENT_V1  = 166
+	UPDATE_ROWS_COMPRESSED_EVENT_V1 = 167
+	DELETE_ROWS_COMPRESSED_EVENT_V1 = 168
+	WRITE_ROWS_COMPRESSED_EVENT     = 169
+	UPDATE_ROWS_COMPRESSED_EVENT    = 170
+	DELETE_ROWS_COMPRESSED_EVENT    = 171
 )
 
 // Binlog represents the binlog context
@@ -89,8 +103,54 @@ type Binlog struct {
 	index    binlogIndex
 	checksum checksumVerifier
 	desc     eventDescription
-	tableMap *TableMapEvent
 	p        properties
+
+	// table maps of the current statement, keyed by table id
+	tableMaps map[uint64]*TableMapEvent
+
+	// event read last and its position, the position of the transaction
+	// payload for the events it contains
+	current RawEvent
+	start   uint64
+
+	// transaction in progress
+	inTransaction bool
+	gtid          *MysqlGtid
+	mariadbGtid   *MariadbGtid
+
+	// position after the last committed transaction, where the stream
+	// resumes from after a reconnection
+	resume  binlogIndex
+	commits uint64 // number of transactions committed
+	pending int    // events of the transaction in progress delivered
+	skip    int    // events to skip after a reconnection
+
+	// checkpoint store and the checkpoint yet to be saved
+	checkpoints CheckpointStore
+	unsaved     *Checkpoint
+
+	filter  *EventFilter
+	schemas *SchemaTracker
+
+	// deliver the events rejected by the filter too, flagged as filtered
+	// (NextTransaction needs the transaction boundaries)
+	deliverFiltered bool
+	filtered        bool
+
+	// XA transactions prepared and not yet committed nor rolled back,
+	// keyed by XID (NextTransaction)
+	prepared map[string]*Transaction
+
+	// stop before the event at this position
+	stop    binlogIndex
+	stopped bool
+
+	// events of the last transaction payload yet to be delivered, or the
+	// payload events themselves if keepPayloads is set
+	payload      [][]byte
+	keepPayloads bool
+
+	e error
 }
 
 type binlogReader interface {
@@ -99,6 +159,15 @@ type binlogReader interface {
 	next() bool
 	event() []byte
 	error() error
+
+	// rotate is called when a ROTATE_EVENT names the next binlog file
+	rotate(file string, position uint64)
+}
+
+// binlogReconnector is implemented by the binlog readers able to restart the
+// stream from the specified index once the connection got lost (netReader).
+type binlogReconnector interface {
+	reconnect(index binlogIndex) error
 }
 
 // received from format descriptor event
@@ -112,9 +181,12 @@ type eventDescription struct {
 }
 
 type binlogIndex struct {
-	position uint32
+	position uint64
 	file     string
-	// TODO: add GTID support
+	gtidSet  *GTIDSet // MySQL GTID set of executed transactions
+
+	// MariaDB GTID position (last applied GTID per domain)
+	mariadbGtidPos mariadbGtidPos
 }
 
 func (b *Binlog) Connect(dsn string) error {
@@ -144,6 +216,7 @@ func (b *Binlog) Connect(dsn string) error {
 
 	case "file":
 		fr := new(fileReader)
+		fr.enterFile = b.enterFile
 		if err = fr.init(p); err != nil {
 			return err
 		} else {
@@ -161,11 +234,13 @@ func (b *Binlog) Connect(dsn string) error {
 	return nil
 }
 
-func (b *Binlog) SetPosition(position uint32) {
+func (b *Binlog) SetPosition(position uint64) {
 	b.index.position = position
 }
 
-func (b *Binlog) GetPosition() uint32 {
+// GetPosition returns the position in the current binlog file right after the
+// last event read; it advances as the events are read.
+func (b *Binlog) GetPosition() uint64 {
 	return b.index.position
 }
 
@@ -173,45 +248,346 @@ func (b *Binlog) SetFile(file string) {
 	b.index.file = file
 }
 
+// SetKeepPayloads sets whether the TRANSACTION_PAYLOAD_EVENTs (binlog
+// transaction compression) are delivered as they are, rather than replaced
+// by the events they contain (default). Keeping them is required to back up
+// the binlog (BackupWriter). Note: replacing the zstd compressed payloads
+// (MySQL) requires a zstd decompressor to be registered, e.g.:
+//
+//	mysql.RegisterDecompressor("zstd", []byte{0x28, 0xb5, 0x2f, 0xfd},
+//		func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) })
+//
+// otherwise the stream fails with ErrCompression on the first one.
+func (b *Binlog) SetKeepPayloads(keep bool) {
+	b.keepPayloads = keep
+}
+
+// SetStopPosition sets the position, in the specified file, the stream stops
+// at: Next returns false once it gets to an event starting at or past it.
+func (b *Binlog) SetStopPosition(file string, position uint64) {
+	b.stop.file = file
+	b.stop.position = position
+}
+
+// reachedStop returns whether the event just read starts at or past the
+// stop position.
+func (b *Binlog) reachedStop() bool {
+	file := filepath.Base(b.index.file)
+	stop := filepath.Base(b.stop.file)
+
+	if file == stop {
+		return b.index.position >= b.stop.position
+	}
+	// already past the stop file
+	return file != "" && binlogFileLess(stop, file)
+}
+
+// GetFile returns the name of the current binlog file; it changes as the
+// stream gets rotated to the next file.
 func (b *Binlog) GetFile() string {
 	return b.index.file
 }
 
+// SetGTIDSet sets the (MySQL) GTID set of the transactions that have already
+// been executed. If set, the binlog stream starts with the first transaction
+// not contained in the set.
+func (b *Binlog) SetGTIDSet(set string) error {
+	var err error
+	b.index.gtidSet, err = ParseGTIDSet(set)
+	return err
+}
+
+// GetGTIDSet returns the GTID set of the executed transactions, including the
+// ones committed in the stream so far.
+func (b *Binlog) GetGTIDSet() string {
+	if b.index.gtidSet == nil {
+		return ""
+	}
+	return b.index.gtidSet.String()
+}
+
+// SetMariadbGtidPosition sets the MariaDB GTID position to start streaming
+// from; the position is a comma-separated list of the last applied GTID in
+// each replication domain (e.g. 0-1-100,1-2-5), as in @@gtid_slave_pos.
+func (b *Binlog) SetMariadbGtidPosition(pos string) error {
+	var err error
+	b.index.mariadbGtidPos, err = parseMariadbGtidPos(pos)
+	return err
+}
+
+// GetMariadbGtidPosition returns the current MariaDB GTID position, updated
+// as transactions get committed in the stream.
+func (b *Binlog) GetMariadbGtidPosition() string {
+	if b.index.mariadbGtidPos == nil {
+		return ""
+	}
+	return b.index.mariadbGtidPos.String()
+}
+
 func (b *Binlog) Begin() error {
+	if b.checkpoints != nil {
+		if err := b.loadCheckpoint(); err != nil {
+			return err
+		}
+	}
+
+	b.resume.file = b.index.file
+	b.resume.position = b.index.position
+
 	return b.reader.begin(b.index)
 }
 
 func (b *Binlog) Next() bool {
-	return b.reader.next()
+	// the previous transaction has been consumed by now
+	if b.e = b.saveCheckpoint(); b.e != nil {
+		return false
+	}
+
+	if b.stopped {
+		return false
+	}
+
+	for {
+		var (
+			body     []byte
+			embedded bool
+		)
+
+		if len(b.payload) > 0 {
+			// events of a transaction payload
+			body, embedded = b.payload[0], true
+			b.payload = b.payload[1:]
+		} else if b.reader.next() {
+			body = b.reader.event()
+
+			// the event just read starts at the current position
+			if b.stop.file != "" && b.reachedStop() {
+				b.stopped = true
+				return false
+			}
+		} else {
+			if !b.p.binlogAutoReconnect || !isNetworkError(b.reader.error()) {
+				return false
+			}
+
+			if !b.reconnect() {
+				return false
+			}
+			continue
+		}
+
+		// verify the event checksum before the event updates the
+		// binlog context (the events of a transaction payload have
+		// none, the payload event does)
+		if b.p.binlogVerifyChecksum && !embedded && !b.checksumValid(body) {
+			b.e = myError(ErrEventChecksumFailure)
+			return false
+		}
+
+		commits := b.commits
+		domain := b.mariadbGtidDomain()
+		b.current = b.readRawEvent(body, embedded)
+
+		if b.e != nil {
+			return false
+		}
+
+		if b.current.header.type_ == TRANSACTION_PAYLOAD_EVENT &&
+			!b.keepPayloads {
+			// replaced by its events
+			continue
+		}
+
+		// the commit event belongs to the group of the committed GTID
+		if d := b.mariadbGtidDomain(); d != nil {
+			domain = d
+		}
+
+		if b.commits != commits {
+			// transaction boundary
+			b.pending, b.skip = 0, 0
+		} else if isTransactionEvent(b.current.header.type_) {
+			b.pending++
+
+			// skip the events of the interrupted transaction, which
+			// have already been delivered before the reconnection
+			if b.skip > 0 {
+				b.skip--
+				continue
+			}
+		}
+
+		b.filtered = b.filter != nil && !b.filter.match(&b.current, domain)
+		if b.filtered && !b.deliverFiltered {
+			continue
+		}
+		return true
+	}
+}
+
+// mariadbGtidDomain returns the MariaDB GTID domain of the event group in
+// progress, nil if none.
+func (b *Binlog) mariadbGtidDomain() *uint32 {
+	if b.mariadbGtid == nil {
+		return nil
+	}
+	domain := b.mariadbGtid.domainId
+	return &domain
+}
+
+// reconnect re-establishes the binlog stream from the last committed
+// transaction, the events of the interrupted transaction which have already
+// been delivered get skipped.
+func (b *Binlog) reconnect() bool {
+	r, ok := b.reader.(binlogReconnector)
+	if !ok {
+		return false
+	}
+
+	index := b.index
+	if index.gtidSet != nil || index.mariadbGtidPos != nil {
+		// the server looks up the position from the GTID(s)
+		index.file, index.position = "", 4
+	} else {
+		index.file, index.position = b.resume.file, b.resume.position
+	}
+
+	if err := r.reconnect(index); err != nil {
+		return false
+	}
+
+	// the transaction in progress gets resent from its beginning
+	b.skip, b.pending = b.pending, 0
+	b.gtid, b.mariadbGtid = nil, nil
+	b.inTransaction = false
+	b.payload = nil
+	b.clearTableMaps()
+
+	return true
+}
+
+// enterFile moves to the specified position of the given binlog file, the
+// position is no longer widened relative to the previous file.
+func (b *Binlog) enterFile(file string, position uint64) {
+	b.index.file = file
+	b.index.position = position
+
+	// transactions never span across binlog files
+	b.resume.file = file
+	b.resume.position = position
+
+	b.clearTableMaps()
+}
+
+// widenPosition returns the 64-bit position for the specified event (end)
+// position, stored in 32 bits in the event header, based on the current
+// position, so that binlog files larger than 4GB can be followed.
+func widenPosition(current uint64, position uint32) uint64 {
+	v := (current &^ 0xffffffff) | uint64(position)
+	if v < current {
+		// wrapped around
+		v += 1 << 32
+	}
+	return v
+}
+
+// isTransactionEvent returns whether the events of the specified type are part
+// of a transaction (as opposed to the binlog housekeeping events).
+func isTransactionEvent(type_ uint8) bool {
+	switch type_ {
+	case START_EVENT_V3, STOP_EVENT, ROTATE_EVENT, FORMAT_DESCRIPTION_EVENT,
+		INCIDENT_EVENT, HEARTBEAT_LOG_EVENT, HEARTBEAT_LOG_EVENT_V2,
+		IGNORABLE_LOG_EVENT, PREVIOUS_GTIDS_LOG_EVENT, BINLOG_CHECKPOINT_EVENT, GTID_LIST_EVENT:
+		return false
+	default:
+	}
+	return true
 }
 
 func (b *Binlog) RawEvent() (re RawEvent, err error) {
+	err = b.Error()
+
+	if err != nil {
+		return
+	}
+
+	re = b.current
+	return
+}
+
+// checksumValid returns whether the checksum of the specified event is
+// valid, a FORMAT_DESCRIPTION_EVENT being verified according to the
+// algorithm it carries as it may change it.
+func (b *Binlog) checksumValid(body []byte) bool {
+	if len(body) > _EVENT_HEADER_LENGTH && body[4] == FORMAT_DESCRIPTION_EVENT {
+		if fdeChecksumAlg(body) != BINLOG_CHECKSUM_ALG_CRC32 {
+			return true
+		}
+		return new(checksumCRC32IEEE).test(body)
+	}
+	return b.checksum.test(body)
+}
+
+// readRawEvent parses the specified event and updates the binlog context
+// (description, table maps, transaction state, etc.) accordingly; embedded
+// is true for the events of a transaction payload.
+func (b *Binlog) readRawEvent(body []byte, embedded bool) (re RawEvent) {
 	var (
 		off int
 		end int
 	)
 
-	err = b.Error()
+	re.body = body
+	re.embedded = embedded
+	re.header, off = parseEventHeader(re.body)
 
-	if err != nil {
-		return
+	// advance the position past this event; artificial events (e.g. fake
+	// rotate), heartbeats and the events of a transaction payload do not
+	// carry a valid position.
+	if re.header.position != 0 && re.header.type_ != HEARTBEAT_LOG_EVENT &&
+		re.header.type_ != HEARTBEAT_LOG_EVENT_V2 &&
+		(re.header.flags&_LOG_EVENT_ARTIFICIAL_F) == 0 && !embedded {
+		if re.header.type_ == FORMAT_DESCRIPTION_EVENT &&
+			re.header.position == _BINLOG_HEADER_SIZE+re.header.size {
+			// first event of a new file (possibly entered without
+			// a ROTATE_EVENT, e.g. after a STOP_EVENT), the
+			// position is not relative to the previous file's
+			b.index.position = 0
+		}
+		b.index.position = widenPosition(b.index.position,
+			re.header.position)
 	}
 
-	re.body = b.reader.event()
-	re.header, off = parseEventHeader(re.body)
+	if !embedded {
+		b.start = b.index.position
+		if size := uint64(re.header.size); b.start >= size {
+			b.start -= size
+		}
+	}
 
 	end = len(re.body)
-	end -= _BINLOG_CHECKSUM_LENGTH
 
-	//if b.checksum.algorithm() != BINLOG_CHECKSUM_ALG_OFF {
-	//	// exclude the event checksum
-	//	end -= _BINLOG_CHECKSUM_LENGTH
-	//}
+	if b.checksum.algorithm() != BINLOG_CHECKSUM_ALG_OFF && !embedded {
+		// exclude the event checksum
+		end -= _BINLOG_CHECKSUM_LENGTH
+	}
+
+	data := re.body[off:end]
+	type_ := re.header.type_
 
-	switch re.header.type_ {
+	// compressed events (MariaDB) are handled like their uncompressed
+	// counterparts
+	if isCompressedEvent(type_) {
+		if re.uncompressed, b.e = b.uncompressEvent(type_, data); b.e != nil {
+			return
+		}
+		data, type_ = re.uncompressed, uncompressedEventType(type_)
+	}
+
+	switch type_ {
 	case START_EVENT_V3:
 		ev := new(StartEventV3)
-		b.parseStartEventV3(re.body[off:end], ev)
+		b.parseStartEventV3(data, ev)
 
 		// now that we have parsed START_EVENT_V3, we can
 		// update binlog description
@@ -221,8 +597,16 @@ func (b *Binlog) RawEvent() (re RawEvent, err error) {
 	case FORMAT_DESCRIPTION_EVENT:
 		ev := new(FormatDescriptionEvent)
 
-		//end -= _BINLOG_CHECKSUM_LENGTH
+		// FD event always carries the checksum (algorithm decides
+		// whether it's valid), unless written by an older server
+		alg := fdeChecksumAlg(re.body)
+		if alg != BINLOG_CHECKSUM_ALG_UNDEF {
+			end = len(re.body) - _BINLOG_CHECKSUM_LENGTH
+		} else {
+			end = len(re.body)
+		}
 		b.parseFormatDescriptionEvent(re.body[off:end], ev)
+		ev.checksumAlg = alg
 
 		// now that we have parsed FORMAT_DESCRIPTION_EVENT, we can
 		// update binlog description
@@ -237,24 +621,209 @@ func (b *Binlog) RawEvent() (re RawEvent, err error) {
 		b.desc.checksumAlg = ev.checksumAlg
 		// update event checksum verifier
 		updateChecksumVerifier(b)
+
+		// a new binlog begins, table ids are no longer valid
+		b.clearTableMaps()
+
+	case STOP_EVENT:
+		b.clearTableMaps()
+
+	case ROTATE_EVENT:
+		ev := new(RotateEvent)
+		ev.header = re.header
+		b.parseRotateEvent(data, ev)
+
+		if re.header.position != 0 &&
+			(re.header.flags&_LOG_EVENT_ARTIFICIAL_F) == 0 {
+			b.reader.rotate(ev.file, ev.position)
+		}
+
+		// switch to the next file (fake rotate events are sent at
+		// the beginning of the stream)
+		b.enterFile(ev.file, ev.position)
+
+	case TABLE_MAP_EVENT:
+		ev := new(TableMapEvent)
+		ev.header = re.header
+		b.parseTableMapEvent(data, ev)
+		if b.schemas != nil {
+			b.schemas.annotate(ev)
+		}
+		b.registerTableMap(ev)
+		re.tableMap = ev
+
+	case PRE_GA_UPDATE_ROWS_EVENT, UPDATE_ROWS_EVENT_V1,
+		UPDATE_ROWS_EVENT, PARTIAL_UPDATE_ROWS_EVENT, PRE_GA_WRITE_ROWS_EVENT,
+		WRITE_ROWS_EVENT_V1, WRITE_ROWS_EVENT,
+		PRE_GA_DELETE_ROWS_EVENT, DELETE_ROWS_EVENT_V1,
+		DELETE_ROWS_EVENT:
+		tableId, flags := b.parseRowsEventPostHeader(data, type_)
+
+		// resolve the table map now, as it might be discarded at the
+		// end of the statement
+		re.tableMap = b.tableMaps[tableId]
+
+		if (flags & STMT_END_F) != 0 {
+			b.clearTableMaps()
+		}
+
+	case GTID_LOG_EVENT:
+		ev := new(GtidLogEvent)
+		ev.header = re.header
+		b.parseGtidLogEvent(data, ev)
+		b.gtid = &ev.gtid
+
+	case GTID_TAGGED_LOG_EVENT:
+		ev := new(GtidTaggedLogEvent)
+		ev.header = re.header
+		b.parseGtidTaggedLogEvent(data, ev)
+		b.gtid = &ev.gtid
+
+	case ANONYMOUS_GTID_LOG_EVENT:
+		b.gtid = nil
+
+	case QUERY_EVENT:
+		ev := new(QueryEvent)
+		ev.header = re.header
+		if b.e = b.parseQueryEvent(data, ev); b.e != nil {
+			break
+		}
+
+		if b.schemas != nil && isDDL(ev.query) {
+			b.schemas.ApplyQuery(ev.schema, ev.query)
+		}
+
+		switch {
+		case ev.query == "BEGIN", isXAStatement(ev.query, "XA START"):
+			b.inTransaction = true
+		case ev.query == "COMMIT", ev.query == "ROLLBACK",
+			isXAStatement(ev.query, "XA COMMIT"),
+			isXAStatement(ev.query, "XA ROLLBACK"):
+			// XA COMMIT/ROLLBACK of a prepared transaction is an
+			// event group on its own, XA COMMIT ... ONE PHASE (MariaDB)
+			// ends the XA transaction in progress
+			b.commit()
+		default:
+			// a statement outside BEGIN/COMMIT (e.g. DDL) is a
+			// transaction on its own
+			if !b.inTransaction {
+				b.commit()
+			}
+		}
+
+	case XID_EVENT:
+		b.commit()
+
+	case XA_PREPARE_LOG_EVENT:
+		// end of the event group of a prepared XA transaction, committed
+		// or rolled back by a later group (unless one phase), see
+		// NextTransaction
+		b.commit()
+
+		if len(data) > 0 && data[0] == 0 {
+			// not consumed yet, its checkpoint is only saved along
+			// with a later transaction (see Applier.Run)
+			b.unsaved = nil
+		}
+
+	case TRANSACTION_PAYLOAD_EVENT:
+		if b.keepPayloads {
+			// the payload holds the whole transaction
+			b.commit()
+			break
+		}
+
+		ev := new(TransactionPayloadEvent)
+		ev.header = re.header
+		b.parseTransactionPayloadEvent(data, ev)
+
+		if b.payload, b.e = ev.events(); b.e != nil {
+			b.payload = nil
+		}
+
+	case GTID_EVENT:
+		ev := new(GtidEvent)
+		ev.header = re.header
+		b.parseGtidEvent(data, ev)
+		b.mariadbGtid = &ev.gtid
+
+		// GTID_EVENT implies BEGIN, unless the event group is a single
+		// standalone statement (e.g. DDL)
+		b.inTransaction = (ev.flags & FL_STANDALONE) == 0
+
+	case GTID_LIST_EVENT:
+		if b.index.mariadbGtidPos != nil {
+			ev := new(GtidListEvent)
+			ev.header = re.header
+			b.parseGtidListEvent(data, ev)
+
+			// learn about the domains we have no position for
+			for _, gtid := range ev.list {
+				if _, ok := b.index.mariadbGtidPos[gtid.domainId]; !ok {
+					b.index.mariadbGtidPos.update(gtid)
+				}
+			}
+		}
+
 	default: // do nothing
 	}
 	re.binlog = b
 
-	// verify event checksum
-	if b.p.binlogVerifyChecksum && !b.checksum.test(re.body) {
-		err = myError(ErrEventChecksumFailure)
-		return
+	return
+}
+
+// commit marks the end of the transaction in progress and adds its GTID (if
+// any) to the set of executed transactions (MySQL) or GTID position (MariaDB).
+func (b *Binlog) commit() {
+	b.resume.position = b.index.position
+	b.commits++
+
+	if b.gtid != nil && b.index.gtidSet != nil {
+		b.index.gtidSet.Add(*b.gtid)
 	}
 
-	return
+	if b.mariadbGtid != nil && b.index.mariadbGtidPos != nil {
+		b.index.mariadbGtidPos.update(*b.mariadbGtid)
+	}
+
+	b.gtid = nil
+	b.mariadbGtid = nil
+	b.inTransaction = false
+
+	if b.checkpoints != nil {
+		cp := b.checkpoint()
+		b.unsaved = &cp
+	}
+}
+
+// registerTableMap adds the specified table map to the table map cache,
+// replacing the one (if any) with the same table id.
+func (b *Binlog) registerTableMap(ev *TableMapEvent) {
+	if b.tableMaps == nil {
+		b.tableMaps = make(map[uint64]*TableMapEvent)
+	}
+	b.tableMaps[ev.tableId] = ev
+}
+
+// clearTableMaps discards all the cached table maps.
+func (b *Binlog) clearTableMaps() {
+	for id := range b.tableMaps {
+		delete(b.tableMaps, id)
+	}
 }
 
 func (b *Binlog) Close() error {
+	if err := b.saveCheckpoint(); err != nil {
+		b.reader.close()
+		return err
+	}
 	return b.reader.close()
 }
 
 func (b *Binlog) Error() error {
+	if b.e != nil {
+		return b.e
+	}
 	return b.reader.error()
 }
 
@@ -279,6 +848,16 @@ type RawEvent struct {
 	header eventHeader
 	binlog *Binlog
 	body   []byte
+
+	// table map (resolved for TABLE_MAP_EVENT and rows events only)
+	tableMap *TableMapEvent
+
+	// part of a transaction payload (no checksum nor position)
+	embedded bool
+
+	// content of a compressed event, laid out as its uncompressed
+	// counterpart (post-header included)
+	uncompressed []byte
 }
 
 func (e *RawEvent) Time() time.Time {
@@ -319,12 +898,18 @@ func (re *RawEvent) Event() Event {
 
 	end := len(re.body)
 
-	if binlog.checksum.algorithm() != BINLOG_CHECKSUM_ALG_OFF {
+	if binlog.checksum.algorithm() != BINLOG_CHECKSUM_ALG_OFF && !re.embedded {
 		// exclude the event checksum
 		end -= _BINLOG_CHECKSUM_LENGTH
 	}
 
-	switch re.header.type_ {
+	// compressed events are parsed as their uncompressed counterparts
+	if re.uncompressed != nil {
+		header.type_ = uncompressedEventType(header.type_)
+		buf, off, end = re.uncompressed, 0, len(re.uncompressed)
+	}
+
+	switch header.type_ {
 	case START_EVENT_V3:
 		ev := new(StartEventV3)
 		ev.header = re.header
@@ -451,19 +1036,20 @@ func (re *RawEvent) Event() Event {
 		return ev
 
 	case TABLE_MAP_EVENT:
-		ev := new(TableMapEvent)
-		ev.header = header
-		binlog.parseTableMapEvent(buf[off:end], ev)
-		binlog.tableMap = ev
-		return ev
+		/*
+		   no need to parse the payload, it has already been parsed in
+		   RawEvent().
+		*/
+		return re.tableMap
 
 	case PRE_GA_UPDATE_ROWS_EVENT, UPDATE_ROWS_EVENT_V1,
-		UPDATE_ROWS_EVENT, PRE_GA_WRITE_ROWS_EVENT,
+		UPDATE_ROWS_EVENT, PARTIAL_UPDATE_ROWS_EVENT, PRE_GA_WRITE_ROWS_EVENT,
 		WRITE_ROWS_EVENT_V1, WRITE_ROWS_EVENT,
 		PRE_GA_DELETE_ROWS_EVENT, DELETE_ROWS_EVENT_V1,
 		DELETE_ROWS_EVENT:
 		ev := new(RowsEvent)
 		ev.header = header
+		ev.tableMap = re.tableMap
 		binlog.parseRowsEvent(buf[off:end], ev)
 		return ev
 
@@ -496,9 +1082,33 @@ func (re *RawEvent) Event() Event {
 		return ev
 
 	case ANONYMOUS_GTID_LOG_EVENT:
-		ev := new(GtidLogEvent)
+		ev := new(AnonymousGtidLogEvent)
 		ev.header = header
-		binlog.parseGtidLogEvent(buf[off:end], ev)
+		binlog.parseGtidLogEvent(buf[off:end], &ev.GtidLogEvent)
+		return ev
+
+	case GTID_TAGGED_LOG_EVENT:
+		ev := new(GtidTaggedLogEvent)
+		ev.header = header
+		binlog.parseGtidTaggedLogEvent(buf[off:end], ev)
+		return ev
+
+	case TRANSACTION_CONTEXT_EVENT:
+		ev := new(TransactionContextEvent)
+		ev.header = header
+		binlog.parseTransactionContextEvent(buf[off:end], ev)
+		return ev
+
+	case VIEW_CHANGE_EVENT:
+		ev := new(ViewChangeEvent)
+		ev.header = header
+		binlog.parseViewChangeEvent(buf[off:end], ev)
+		return ev
+
+	case XA_PREPARE_LOG_EVENT:
+		ev := new(XaPrepareLogEvent)
+		ev.header = header
+		binlog.parseXaPrepareLogEvent(buf[off:end], ev)
 		return ev
 
 	case PREVIOUS_GTIDS_LOG_EVENT:
@@ -507,6 +1117,12 @@ func (re *RawEvent) Event() Event {
 		binlog.parsePreviousGtidsLogEvent(buf[off:end], ev)
 		return ev
 
+	case TRANSACTION_PAYLOAD_EVENT:
+		ev := new(TransactionPayloadEvent)
+		ev.header = header
+		binlog.parseTransactionPayloadEvent(buf[off:end], ev)
+		return ev
+
 	case ANNOTATE_ROWS_EVENT:
 		ev := new(AnnotateRowsEvent)
 		ev.header = header
@@ -545,6 +1161,7 @@ type QueryEvent struct {
 	schema        string
 	query         string
 	statusVars    string
+	status        QueryStatusVars
 }
 
 func (e *QueryEvent) Time() time.Time {
@@ -591,8 +1208,108 @@ func (e *QueryEvent) StatusVars() string {
 	return e.statusVars
 }
 
+// Status returns the decoded status variables.
+func (e *QueryEvent) Status() *QueryStatusVars {
+	return &e.status
+}
+
+// query event status variable codes
+const (
+	Q_FLAGS2_CODE                     = 0
+	Q_SQL_MODE_CODE                   = 1
+	Q_CATALOG_CODE                    = 2
+	Q_AUTO_INCREMENT                  = 3
+	Q_CHARSET_CODE                    = 4
+	Q_TIME_ZONE_CODE                  = 5
+	Q_CATALOG_NZ_CODE                 = 6
+	Q_LC_TIME_NAMES_CODE              = 7
+	Q_CHARSET_DATABASE_CODE           = 8
+	Q_TABLE_MAP_FOR_UPDATE_CODE       = 9
+	Q_MASTER_DATA_WRITTEN_CODE        = 10
+	Q_INVOKER                         = 11
+	Q_UPDATED_DB_NAMES                = 12
+	Q_MICROSECONDS                    = 13
+	Q_COMMIT_TS                       = 14
+	Q_COMMIT_TS2                      = 15
+	Q_EXPLICIT_DEFAULTS_FOR_TIMESTAMP = 16
+	Q_DDL_LOGGED_WITH_XID             = 17
+	Q_DEFAULT_COLLATION_FOR_UTF8MB4   = 18
+	Q_SQL_REQUIRE_PRIMARY_KEY         = 19
+	Q_DEFAULT_TABLE_ENCRYPTION        = 20
+
+	// MariaDB
+	Q_HRNOW                    = 128
+	Q_XID                      = 129
+	Q_GTID_FLAGS3              = 130
+	Q_CHARACTER_SET_COLLATIONS = 131
+)
+
+// QueryStatusVars holds the status variables of a query event, i.e. the
+// session context the statement was executed in. Fields of the variables
+// not logged are left zero (see Has).
+type QueryStatusVars struct {
+	Flags2                       uint32
+	SqlMode                      uint64
+	Catalog                      string
+	AutoIncrementIncrement       uint16
+	AutoIncrementOffset          uint16
+	CharsetClient                uint16 // collation ids
+	CollationConnection          uint16
+	CollationServer              uint16
+	TimeZone                     string
+	LcTimeNames                  uint16
+	CollationDatabase            uint16
+	TableMapForUpdate            uint64
+	MasterDataWritten            uint32
+	InvokerUser                  string
+	InvokerHost                  string
+	UpdatedDbNames               []string // nil if too many databases
+	Microseconds                 uint32
+	ExplicitDefaultsForTimestamp bool
+	DdlXid                       uint64
+	DefaultCollationForUtf8mb4   uint16
+	SqlRequirePrimaryKey         bool
+	DefaultTableEncryption       bool
+	HrNow                        uint32 // MariaDB, microseconds
+	Xid                          uint64 // MariaDB
+	GtidFlags3                   uint8  // MariaDB, FL_*_E1 flags
+	StartAlterSeqno              uint64 // MariaDB, if FL_COMMIT/ROLLBACK_ALTER_E1
+
+	// MariaDB, default collation ids by charset id
+	CharacterSetCollations map[uint16]uint16
+
+	codes      []uint8 // logged variables
+	incomplete bool    // decoding stopped at an unknown/truncated variable
+}
+
+// Incomplete returns whether decoding stopped at a variable of an unknown
+// code (or a truncated one), the variables following it being then only
+// available in the raw status variables (StatusVars of the event).
+func (v *QueryStatusVars) Incomplete() bool {
+	return v.incomplete
+}
+
+// Has returns whether the variable of the specified code (Q_*) was logged.
+func (v *QueryStatusVars) Has(code uint8) bool {
+	for _, c := range v.codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
 const UNSIGNED = 1
 
+// user variable value types (Item_result)
+const (
+	USER_VAR_STRING  = 0
+	USER_VAR_REAL    = 1
+	USER_VAR_INT     = 2
+	USER_VAR_ROW     = 3
+	USER_VAR_DECIMAL = 4
+)
+
 // USER_VAR_EVENT
 type UserVarEvent struct {
 	header  eventHeader
@@ -628,89 +1345,69 @@ func (e *UserVarEvent) Name() string {
 	return e.name
 }
 
-func (e *UserVarEvent) Value() interface{} {
-	var unsigned bool
+// IsNull returns whether the variable was set to NULL.
+func (e *UserVarEvent) IsNull() bool {
+	return e.null
+}
+
+// ValueType returns the type of the value (USER_VAR_*).
+func (e *UserVarEvent) ValueType() uint8 {
+	return e.type_
+}
+
+// Charset returns the collation id of a string value.
+func (e *UserVarEvent) Charset() uint32 {
+	return e.charset
+}
+
+func (e *UserVarEvent) Unsigned() bool {
+	return (e.flags & uint8(UNSIGNED)) != 0
+}
 
+// RawValue returns the value as logged.
+func (e *UserVarEvent) RawValue() []byte {
+	return e.value
+}
+
+// Value returns the decoded value :
+//
+//	NULL                    nil
+//	USER_VAR_STRING         string (converted to UTF-8), []byte if binary
+//	USER_VAR_REAL           float64
+//	USER_VAR_INT            int64 (uint64 if unsigned)
+//	USER_VAR_DECIMAL        string (exact, with the value scale)
+func (e *UserVarEvent) Value() interface{} {
 	if e.null {
 		return nil
 	}
 
-	if (e.flags & uint8(UNSIGNED)) != 0 {
-		unsigned = true
-	}
-
 	switch e.type_ {
-	// string
-	case _TYPE_STRING, _TYPE_VARCHAR,
-		_TYPE_VARSTRING, _TYPE_ENUM,
-		_TYPE_SET, _TYPE_BLOB,
-		_TYPE_TINY_BLOB, _TYPE_MEDIUM_BLOB,
-		_TYPE_LONG_BLOB, _TYPE_GEOMETRY,
-		_TYPE_BIT, _TYPE_DECIMAL,
-		_TYPE_NEW_DECIMAL:
-		v, _ := parseString(e.value)
-		return v
+	case USER_VAR_STRING:
+		return decodeCharsetString(e.value, e.charset)
 
-	// int64/uint64
-	case _TYPE_LONG_LONG:
-		if unsigned {
-			return binary.LittleEndian.Uint64(e.value)
-		} else {
-			return parseInt64(e.value)
+	case USER_VAR_REAL:
+		if len(e.value) < 8 {
+			break
 		}
+		return parseDouble(e.value)
 
-	// int32/uint32
-	case _TYPE_LONG, _TYPE_INT24:
-		if unsigned {
-			return binary.LittleEndian.Uint32(e.value)
-		} else {
-			return parseInt32(e.value)
+	case USER_VAR_INT:
+		if len(e.value) < 8 {
+			break
 		}
-
-		// int16/uint6
-	case _TYPE_SHORT:
-		if unsigned {
-			return binary.LittleEndian.Uint16(e.value)
-		} else {
-			return parseInt16(e.value)
+		if e.Unsigned() {
+			return binary.LittleEndian.Uint64(e.value)
 		}
+		return parseInt64(e.value)
 
-	// uint16
-	case _TYPE_YEAR:
-		return binary.LittleEndian.Uint16(e.value)
-
-	// int8
-	case _TYPE_TINY:
-		if unsigned {
-			return uint8(e.value[0])
-		} else {
-			return int8(e.value[0])
+	case USER_VAR_DECIMAL:
+		// precision, scale and the binary decimal
+		if len(e.value) < 2 {
+			break
 		}
-
-	// float64
-	case _TYPE_DOUBLE:
-		return parseDouble(e.value)
-
-	// float32
-	case _TYPE_FLOAT:
-		return parseFloat(e.value)
-
-	// time.Time
-	case _TYPE_DATE, _TYPE_DATETIME,
-		_TYPE_TIMESTAMP:
-		v, _ := parseDate(e.value)
+		v, _ := decodeDecimal(e.value[2:], int(e.value[0]), int(e.value[1]))
 		return v
 
-	// time.Duration
-	case _TYPE_TIME:
-		v, _ := parseTime(e.value)
-		return v
-
-	// TODO: map the following unhandled types accordingly
-	case _TYPE_NEW_DATE, _TYPE_TIMESTAMP2,
-		_TYPE_DATETIME2, _TYPE_TIME2,
-		_TYPE_NULL:
-		fallthrough
 	default:
 	}
 	return nil
@@ -1255,6 +1952,7 @@ func (e *SlaveEvent) MasterLog() string {
 type CreateFileEvent struct {
 	header eventHeader
 	fileId uint32
+	load   *LoadEvent
 	data   []byte
 }
 
@@ -1286,6 +1984,11 @@ func (e *CreateFileEvent) Data() []byte {
 	return e.data
 }
 
+// Load returns the LOAD DATA INFILE statement the file is loaded with.
+func (e *CreateFileEvent) Load() *LoadEvent {
+	return e.load
+}
+
 // DELETE_FILE_EVENT
 type DeleteFileEvent struct {
 	header eventHeader
@@ -1347,6 +2050,10 @@ func (e *AppendBlockEvent) FieldId() uint32 {
 	return e.fieldId
 }
 
+func (e *AppendBlockEvent) FileId() uint32 {
+	return e.fieldId
+}
+
 func (e *AppendBlockEvent) Data() []byte {
 	return e.data
 }
@@ -1428,6 +2135,10 @@ type ExecuteLoadQueryEvent struct {
 	startPosition    uint32
 	endPosition      uint32
 	dupHandlingFlags uint8
+	statusVars       string
+	status           QueryStatusVars
+	schema           string
+	query            string
 }
 
 func (e *ExecuteLoadQueryEvent) Time() time.Time {
@@ -1450,6 +2161,53 @@ func (e *ExecuteLoadQueryEvent) Position() uint32 {
 	return e.header.position
 }
 
+func (e *ExecuteLoadQueryEvent) SlaveProxyId() uint32 {
+	return e.slaveProxyId
+}
+
+func (e *ExecuteLoadQueryEvent) ExecutionTime() time.Time {
+	return e.executionTime
+}
+
+func (e *ExecuteLoadQueryEvent) Error() uint16 {
+	return e.errorCode
+}
+
+func (e *ExecuteLoadQueryEvent) StatusVars() string {
+	return e.statusVars
+}
+
+func (e *ExecuteLoadQueryEvent) Status() *QueryStatusVars {
+	return &e.status
+}
+
+func (e *ExecuteLoadQueryEvent) Schema() string {
+	return e.schema
+}
+
+func (e *ExecuteLoadQueryEvent) Query() string {
+	return e.query
+}
+
+func (e *ExecuteLoadQueryEvent) FileId() uint32 {
+	return e.fileId
+}
+
+// StartPosition returns the offset in the query of the part naming the file
+// (from INFILE to INTO).
+func (e *ExecuteLoadQueryEvent) StartPosition() uint32 {
+	return e.startPosition
+}
+
+func (e *ExecuteLoadQueryEvent) EndPosition() uint32 {
+	return e.endPosition
+}
+
+// DupHandlingFlags returns how duplicate keys are handled (LOAD_DUP_*).
+func (e *ExecuteLoadQueryEvent) DupHandlingFlags() uint8 {
+	return e.dupHandlingFlags
+}
+
 type EventColumns struct {
 	columnCount uint16
 	columns     []*EventColumn
@@ -1463,6 +2221,39 @@ type EventColumn struct {
 	type_    uint8
 	meta     uint16
 	nullable bool
+
+	// available with binlog_row_metadata (MySQL 8.0+)
+	name     string
+	unsigned bool
+	signed   bool // signedness known (logged or tracked)
+	charset  uint16
+}
+
+func (c *EventColumn) Type() uint8 {
+	return c.type_
+}
+
+func (c *EventColumn) Meta() uint16 {
+	return c.meta
+}
+
+func (c *EventColumn) Nullable() bool {
+	return c.nullable
+}
+
+// Name returns the column name, empty if the server did not log it
+// (binlog_row_metadata=MINIMAL).
+func (c *EventColumn) Name() string {
+	return c.name
+}
+
+func (c *EventColumn) Unsigned() bool {
+	return c.unsigned
+}
+
+// Charset returns the collation id of character columns, 0 if unknown.
+func (c *EventColumn) Charset() uint16 {
+	return c.charset
 }
 
 // TABLE_MAP_EVENT
@@ -1474,6 +2265,7 @@ type TableMapEvent struct {
 	table       string
 	columnCount uint64
 	columns     []EventColumn
+	primaryKey  []int
 }
 
 func (e *TableMapEvent) Time() time.Time {
@@ -1516,8 +2308,28 @@ func (e *TableMapEvent) ColumnCount() uint64 {
 	return e.columnCount
 }
 
+func (e *TableMapEvent) Columns() []EventColumn {
+	return e.columns
+}
+
+// PrimaryKey returns the indexes of the primary key columns, nil if the
+// server did not log them.
+func (e *TableMapEvent) PrimaryKey() []int {
+	return e.primaryKey
+}
+
+// RowsEvent flags
+const (
+	STMT_END_F = 1 << iota
+	NO_FOREIGN_KEY_CHECKS_F
+	RELAXED_UNIQUE_CHECKS_F
+	COMPLETE_ROWS_F
+)
+
+// WRITE_ROWS_EVENT, UPDATE_ROWS_EVENT & DELETE_ROWS_EVENT (all versions)
 type RowsEvent struct {
 	header                eventHeader
+	tableMap              *TableMapEvent
 	tableId               uint64
 	flags                 uint16
 	extraData             []byte
@@ -1526,6 +2338,7 @@ type RowsEvent struct {
 	columnsPresentBitmap2 []byte
 	rows1                 EventRows
 	rows2                 EventRows
+	err                   error // error decoding the rows
 }
 
 func (e *RowsEvent) Time() time.Time {
@@ -1548,6 +2361,41 @@ func (e *RowsEvent) Position() uint32 {
 	return e.header.position
 }
 
+func (e *RowsEvent) TableId() uint64 {
+	return e.tableId
+}
+
+func (e *RowsEvent) Flags() uint16 {
+	return e.flags
+}
+
+// TableMap returns the TABLE_MAP_EVENT the rows event refers to, nil if it
+// could not be resolved (e.g. the stream was started mid-statement).
+func (e *RowsEvent) TableMap() *TableMapEvent {
+	return e.tableMap
+}
+
+func (e *RowsEvent) Schema() string {
+	if e.tableMap == nil {
+		return ""
+	}
+	return e.tableMap.schema
+}
+
+func (e *RowsEvent) Table() string {
+	if e.tableMap == nil {
+		return ""
+	}
+	return e.tableMap.table
+}
+
+func (e *RowsEvent) Columns() []EventColumn {
+	if e.tableMap == nil {
+		return nil
+	}
+	return e.tableMap.columns
+}
+
 func (e *RowsEvent) Image() EventRows {
 	return e.rows1
 }
@@ -1556,6 +2404,12 @@ func (e *RowsEvent) AfterImage() EventRows {
 	return e.rows2
 }
 
+// Error returns the error met decoding the rows (e.g. a column type that
+// can't be decoded), the images then only hold the rows decoded before it.
+func (e *RowsEvent) Error() error {
+	return e.err
+}
+
 type EventRows struct {
 	Rows []EventRow
 
@@ -1573,24 +2427,44 @@ type EventRow struct {
 type MysqlGtid struct {
 	commitFlag  bool
 	sourceId    UUID
-	groupNumber int64 // transaction ID
+	tag         string // MySQL 8.3+
+	groupNumber int64  // transaction ID
 }
 
 func (gtid *MysqlGtid) SourceId() UUID {
 	return gtid.sourceId
 }
 
+func (gtid *MysqlGtid) Tag() string {
+	return gtid.tag
+}
+
 func (gtid *MysqlGtid) GroupNumber() int64 {
 	return gtid.groupNumber
 }
 
 func (gtid *MysqlGtid) String() string {
+	if gtid.tag != "" {
+		return fmt.Sprintf("%s:%s:%d", gtid.sourceId.String(), gtid.tag,
+			gtid.groupNumber)
+	}
 	return fmt.Sprintf("%s:%d", gtid.sourceId.String(), gtid.groupNumber)
 }
 
 type GtidLogEvent struct {
 	header eventHeader
 	gtid   MysqlGtid
+
+	// logical clock (MySQL 5.7+)
+	lastCommitted  int64
+	sequenceNumber int64
+
+	// MySQL 8.0+
+	immediateCommitTimestamp uint64 // microseconds
+	originalCommitTimestamp  uint64
+	transactionLength        uint64
+	immediateServerVersion   uint32
+	originalServerVersion    uint32
 }
 
 func (e *GtidLogEvent) Time() time.Time {
@@ -1621,9 +2495,221 @@ func (e *GtidLogEvent) Gtid() MysqlGtid {
 	return e.gtid
 }
 
+// LastCommitted returns the sequence number of the last transaction this
+// transaction depends on (logical clock).
+func (e *GtidLogEvent) LastCommitted() int64 {
+	return e.lastCommitted
+}
+
+func (e *GtidLogEvent) SequenceNumber() int64 {
+	return e.sequenceNumber
+}
+
+// ImmediateCommitTime returns the time the transaction was committed on the
+// server that wrote this binlog.
+func (e *GtidLogEvent) ImmediateCommitTime() time.Time {
+	return microsecondsTime(e.immediateCommitTimestamp)
+}
+
+// OriginalCommitTime returns the time the transaction was committed on the
+// originating server.
+func (e *GtidLogEvent) OriginalCommitTime() time.Time {
+	return microsecondsTime(e.originalCommitTimestamp)
+}
+
+// TransactionLength returns the size of the transaction in bytes, this event
+// included.
+func (e *GtidLogEvent) TransactionLength() uint64 {
+	return e.transactionLength
+}
+
+func (e *GtidLogEvent) ImmediateServerVersion() uint32 {
+	return e.immediateServerVersion
+}
+
+func (e *GtidLogEvent) OriginalServerVersion() uint32 {
+	return e.originalServerVersion
+}
+
+// microsecondsTime converts the specified commit timestamp, zero meaning
+// unknown.
+func microsecondsTime(us uint64) time.Time {
+	if us == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(us/1000000), int64(us%1000000)*1000)
+}
+
+// ANONYMOUS_GTID_LOG_EVENT (gtid_mode=OFF), same layout as GTID_LOG_EVENT
+// but without a GTID
+type AnonymousGtidLogEvent struct {
+	GtidLogEvent
+}
+
+func (e *AnonymousGtidLogEvent) String() string {
+	return "ANONYMOUS"
+}
+
+// GTID_TAGGED_LOG_EVENT (MySQL 8.3+), a GTID_LOG_EVENT carrying a tagged
+// GTID
+type GtidTaggedLogEvent struct {
+	GtidLogEvent
+}
+
+// TRANSACTION_CONTEXT_EVENT (group replication)
+type TransactionContextEvent struct {
+	header          eventHeader
+	serverUuid      string
+	threadId        uint32
+	gtidSpecified   bool
+	snapshotVersion *GTIDSet
+	writeSet        []string
+	readSet         []string
+}
+
+func (e *TransactionContextEvent) Time() time.Time {
+	return time.Unix(int64(e.header.timestamp), 0)
+}
+
+func (e *TransactionContextEvent) Type() uint8 {
+	return e.header.type_
+}
+
+func (e *TransactionContextEvent) ServerId() uint32 {
+	return e.header.serverId
+}
+
+func (e *TransactionContextEvent) Size() uint32 {
+	return e.header.size
+}
+
+func (e *TransactionContextEvent) Position() uint32 {
+	return e.header.position
+}
+
+func (e *TransactionContextEvent) ServerUuid() string {
+	return e.serverUuid
+}
+
+func (e *TransactionContextEvent) ThreadId() uint32 {
+	return e.threadId
+}
+
+func (e *TransactionContextEvent) GtidSpecified() bool {
+	return e.gtidSpecified
+}
+
+// SnapshotVersion returns the set of GTIDs executed when the transaction was
+// certified.
+func (e *TransactionContextEvent) SnapshotVersion() *GTIDSet {
+	return e.snapshotVersion
+}
+
+// WriteSet returns the hashes of the rows written by the transaction.
+func (e *TransactionContextEvent) WriteSet() []string {
+	return e.writeSet
+}
+
+func (e *TransactionContextEvent) ReadSet() []string {
+	return e.readSet
+}
+
+// VIEW_CHANGE_EVENT (group replication)
+type ViewChangeEvent struct {
+	header            eventHeader
+	viewId            string
+	seqNumber         int64
+	certificationInfo map[string]string
+}
+
+func (e *ViewChangeEvent) Time() time.Time {
+	return time.Unix(int64(e.header.timestamp), 0)
+}
+
+func (e *ViewChangeEvent) Type() uint8 {
+	return e.header.type_
+}
+
+func (e *ViewChangeEvent) ServerId() uint32 {
+	return e.header.serverId
+}
+
+func (e *ViewChangeEvent) Size() uint32 {
+	return e.header.size
+}
+
+func (e *ViewChangeEvent) Position() uint32 {
+	return e.header.position
+}
+
+func (e *ViewChangeEvent) ViewId() string {
+	return e.viewId
+}
+
+func (e *ViewChangeEvent) SeqNumber() int64 {
+	return e.seqNumber
+}
+
+func (e *ViewChangeEvent) CertificationInfo() map[string]string {
+	return e.certificationInfo
+}
+
+// XA_PREPARE_LOG_EVENT
+type XaPrepareLogEvent struct {
+	header   eventHeader
+	onePhase bool
+	formatId int32
+	gtrid    []byte
+	bqual    []byte
+}
+
+func (e *XaPrepareLogEvent) Time() time.Time {
+	return time.Unix(int64(e.header.timestamp), 0)
+}
+
+func (e *XaPrepareLogEvent) Type() uint8 {
+	return e.header.type_
+}
+
+func (e *XaPrepareLogEvent) ServerId() uint32 {
+	return e.header.serverId
+}
+
+func (e *XaPrepareLogEvent) Size() uint32 {
+	return e.header.size
+}
+
+func (e *XaPrepareLogEvent) Position() uint32 {
+	return e.header.position
+}
+
+// OnePhase returns whether the event stands for XA COMMIT ... ONE PHASE
+// (rather than XA PREPARE).
+func (e *XaPrepareLogEvent) OnePhase() bool {
+	return e.onePhase
+}
+
+func (e *XaPrepareLogEvent) FormatId() int32 {
+	return e.formatId
+}
+
+func (e *XaPrepareLogEvent) Gtrid() []byte {
+	return e.gtrid
+}
+
+func (e *XaPrepareLogEvent) Bqual() []byte {
+	return e.bqual
+}
+
+// String returns the XID as written in XA statements.
+func (e *XaPrepareLogEvent) String() string {
+	return fmt.Sprintf("X'%x',X'%x',%d", e.gtrid, e.bqual, e.formatId)
+}
+
 type PreviousGtidsLogEvent struct {
-	header eventHeader
-	data   []byte
+	header  eventHeader
+	data    []byte
+	gtidSet *GTIDSet
 }
 
 func (e *PreviousGtidsLogEvent) Time() time.Time {
@@ -1646,54 +2732,65 @@ func (e *PreviousGtidsLogEvent) Position() uint32 {
 	return e.header.position
 }
 
+// GTIDSet returns the set of GTIDs executed before the current binlog file.
+func (e *PreviousGtidsLogEvent) GTIDSet() *GTIDSet {
+	return e.gtidSet
+}
+
 func (e *PreviousGtidsLogEvent) String() string {
-	var (
-		res  string
-		uuid UUID
-		off  int
-	)
+	return e.gtidSet.String()
+}
 
-	// read sourceId count
-	sidCount := binary.LittleEndian.Uint64(e.data[off:])
-	off += 8
+// transaction payload compression types
+const (
+	PAYLOAD_COMPRESSION_ZSTD = 0
+	PAYLOAD_COMPRESSION_NONE = 255
+)
 
-	for i := uint64(0); i < sidCount; i++ {
+// TRANSACTION_PAYLOAD_EVENT (binlog_transaction_compression)
+type TransactionPayloadEvent struct {
+	header           eventHeader
+	payloadSize      uint64
+	compressionType  uint64
+	uncompressedSize uint64
+	payload          []byte
+}
 
-		// UUID separator
-		if i > 0 {
-			res += ","
-		}
+func (e *TransactionPayloadEvent) Time() time.Time {
+	return time.Unix(int64(e.header.timestamp), 0)
+}
 
-		// read the sourceId
-		copy(uuid.data[0:], e.data[off:off+16])
-		res += uuid.String()
-		off += 16
+func (e *TransactionPayloadEvent) Type() uint8 {
+	return e.header.type_
+}
 
-		// read the intervals count
-		intervalsCount := binary.LittleEndian.Uint64(e.data[off:])
-		off += 8
-		res += ":"
+func (e *TransactionPayloadEvent) ServerId() uint32 {
+	return e.header.serverId
+}
 
-		var intervalStart, intervalEnd uint64
+func (e *TransactionPayloadEvent) Size() uint32 {
+	return e.header.size
+}
 
-		for j := uint64(0); j < intervalsCount; j++ {
-			// intervals separator
-			if j > 0 {
-				res += ":"
-			}
+func (e *TransactionPayloadEvent) Position() uint32 {
+	return e.header.position
+}
 
-			// read one interval
-			intervalStart = binary.LittleEndian.Uint64(e.data[off:])
-			off += 8
-			intervalEnd = binary.LittleEndian.Uint64(e.data[off:])
-			off += 8
-			res += strconv.FormatUint(intervalStart, 10) + "-" +
-				strconv.FormatUint(intervalEnd, 10)
-		}
-		break
-	}
+func (e *TransactionPayloadEvent) PayloadSize() uint64 {
+	return e.payloadSize
+}
 
-	return res
+func (e *TransactionPayloadEvent) CompressionType() uint64 {
+	return e.compressionType
+}
+
+func (e *TransactionPayloadEvent) UncompressedSize() uint64 {
+	return e.uncompressedSize
+}
+
+// Payload returns the (compressed) payload.
+func (e *TransactionPayloadEvent) Payload() []byte {
+	return e.payload
 }
 
 // MariaDB specific events
@@ -1794,6 +2891,14 @@ const (
 	FL_DDL
 )
 
+// GTID_EVENT extra flags (MariaDB, also logged as Q_GTID_FLAGS3)
+const (
+	FL_EXTRA_MULTI_ENGINE_E1 = 1 << iota
+	FL_START_ALTER_E1
+	FL_COMMIT_ALTER_E1
+	FL_ROLLBACK_ALTER_E1
+)
+
 // GTID_EVENT
 type GtidEvent struct {
 	header   eventHeader
@@ -1956,6 +3061,14 @@ func eventName(type_ uint8) string {
 		return "View_change"
 	case XA_PREPARE_LOG_EVENT:
 		return "Xa_prepare_log"
+	case PARTIAL_UPDATE_ROWS_EVENT:
+		return "Partial_update_rows"
+	case TRANSACTION_PAYLOAD_EVENT:
+		return "Transaction_payload"
+	case HEARTBEAT_LOG_EVENT_V2:
+		return "Heartbeat_log_v2"
+	case GTID_TAGGED_LOG_EVENT:
+		return "Gtid_tagged_log"
 	case ANNOTATE_ROWS_EVENT:
 		return "Annotate_rows"
 	case BINLOG_CHECKPOINT_EVENT:
@@ -1964,6 +3077,22 @@ func eventName(type_ uint8) string {
 		return "Gtid"
 	case GTID_LIST_EVENT:
 		return "Gtid_list"
+	case START_ENCRYPTION_EVENT:
+		return "Start_encryption"
+	case QUERY_COMPRESSED_EVENT:
+		return "Query_compressed"
+	case WRITE_ROWS_COMPRESSED_EVENT_V1:
+		return "Write_rows_compressed_v1"
+	case UPDATE_ROWS_COMPRESSED_EVENT_V1:
+		return "Update_rows_compressed_v1"
+	case DELETE_ROWS_COMPRESSED_EVENT_V1:
+		return "Delete_rows_compressed_v1"
+	case WRITE_ROWS_COMPRESSED_EVENT:
+		return "Write_rows_compressed"
+	case UPDATE_ROWS_COMPRESSED_EVENT:
+		return "Update_rows_compressed"
+	case DELETE_ROWS_COMPRESSED_EVENT:
+		return "Delete_rows_compressed"
 	default:
 	}
 	return "Unknown"
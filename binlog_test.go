@@ -0,0 +1,618 @@
+/*
+  The MIT License (MIT)
+
+  Copyright (c) 2015 Nirbhay Choubey
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in all
+  copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+  SOFTWARE.
+*/
+
+package mysql
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestTableMapCache(t *testing.T) {
+	e := new(evBuilder)
+	e.fde()
+	// a statement involving two tables
+	e.tableMap(5, "test", "t1", []byte{_TYPE_LONG}, nil)
+	e.tableMap(6, "test", "t2", []byte{_TYPE_LONG, _TYPE_LONG}, nil)
+	e.rows(WRITE_ROWS_EVENT, 6, 0, 2, rowLong(1, 2))
+	e.rows(WRITE_ROWS_EVENT, 5, STMT_END_F, 1, rowLong(3))
+	// the table maps are discarded at the end of the statement
+	e.rows(WRITE_ROWS_EVENT, 5, STMT_END_F, 1, rowLong(4))
+	// a table id can be reused for another table
+	e.tableMap(5, "test", "t3", []byte{_TYPE_LONG}, nil)
+	e.rows(DELETE_ROWS_EVENT, 5, STMT_END_F, 1, rowLong(5))
+
+	events := readEvents(t, e)
+	if len(events) != 8 {
+		t.Fatalf("got %d events, want 8", len(events))
+	}
+
+	tests := []struct {
+		index int
+		id    uint64
+		table string
+		value int32
+	}{
+		{3, 6, "t2", 1},
+		{4, 5, "t1", 3},
+		{5, 5, "", 0},
+		{7, 5, "t3", 5},
+	}
+
+	for _, test := range tests {
+		ev := events[test.index].(*RowsEvent)
+		if ev.Table() != test.table {
+			t.Errorf("event %d: table %q, want %q", test.index,
+				ev.Table(), test.table)
+			continue
+		}
+
+		rows := ev.Image().Rows
+		if test.table == "" {
+			// unresolved table map, the rows can't be decoded
+			if ev.TableMap() != nil || len(rows) != 0 {
+				t.Errorf("event %d: got %v, %v", test.index,
+					ev.TableMap(), rows)
+			}
+			continue
+		}
+
+		if ev.Schema() != "test" || ev.TableId() != test.id {
+			t.Errorf("event %d: %s.%s (%d)", test.index, ev.Schema(),
+				ev.Table(), ev.TableId())
+		}
+		if len(rows) != 1 || rows[0].Columns[0] != test.value {
+			t.Errorf("event %d: rows %v, want %d", test.index, rows,
+				test.value)
+		}
+	}
+}
+
+func TestTableMapCacheNewFile(t *testing.T) {
+	e := new(evBuilder)
+	e.fde()
+	e.tableMap(5, "test", "t1", []byte{_TYPE_LONG}, nil)
+	// a new binlog (e.g. after a server restart) invalidates the table ids
+	e.fde()
+	e.rows(WRITE_ROWS_EVENT, 5, STMT_END_F, 1, rowLong(1))
+
+	events := readEvents(t, e)
+	if ev := events[3].(*RowsEvent); ev.TableMap() != nil {
+		t.Errorf("table map %s.%s resolved across binlogs", ev.Schema(),
+			ev.Table())
+	}
+}
+
+// mariadbGtid appends a MariaDB GTID_EVENT.
+func (e *evBuilder) mariadbGtid(domain uint32, seqno uint64, flags uint8) {
+	b := make([]byte, 8+4+1+6)
+	binary.LittleEndian.PutUint64(b, seqno)
+	binary.LittleEndian.PutUint32(b[8:], domain)
+	b[12] = flags
+	e.add(GTID_EVENT, b)
+}
+
+// mariadbGtidList appends a MariaDB GTID_LIST_EVENT.
+func (e *evBuilder) mariadbGtidList(gtids ...MariadbGtid) {
+	b := make([]byte, 4, 4+16*len(gtids))
+	binary.LittleEndian.PutUint32(b, uint32(len(gtids)))
+	for _, gtid := range gtids {
+		var x [16]byte
+		binary.LittleEndian.PutUint32(x[0:], gtid.domainId)
+		binary.LittleEndian.PutUint32(x[4:], gtid.serverId)
+		binary.LittleEndian.PutUint64(x[8:], gtid.seqno)
+		b = append(b, x[:]...)
+	}
+	e.add(GTID_LIST_EVENT, b)
+}
+
+func TestMariadbGtidPosition(t *testing.T) {
+	e := new(evBuilder)
+	e.fde()
+	e.mariadbGtidList(MariadbGtid{0, 1, 5}, MariadbGtid{1, 2, 7})
+	e.mariadbGtid(0, 6, 0)
+	e.query("test", "BEGIN")
+	e.query("test", "INSERT INTO t1 VALUES (1)")
+	e.xid(10)
+	e.mariadbGtid(1, 8, FL_STANDALONE)
+	e.query("test", "CREATE TABLE t2 (a INT)")
+	e.mariadbGtid(2, 1, 0)
+	e.query("test", "BEGIN")
+	e.query("test", "INSERT INTO t1 VALUES (2)")
+
+	b := newTestBinlog(e)
+	if err := b.SetMariadbGtidPosition("0-1-5"); err != nil {
+		t.Fatal(err)
+	}
+
+	// position after each event
+	want := []string{
+		"0-1-5",
+		// domains we have no position for are taken from GTID_LIST
+		"0-1-5,1-2-7",
+		"0-1-5,1-2-7",
+		"0-1-5,1-2-7",
+		"0-1-5,1-2-7",
+		"0-1-6,1-2-7",
+		"0-1-6,1-2-7",
+		"0-1-6,1-1-8",
+		// the last transaction is incomplete
+		"0-1-6,1-1-8",
+		"0-1-6,1-1-8",
+		"0-1-6,1-1-8",
+	}
+
+	var i int
+	for ; b.Next(); i++ {
+		if _, err := b.RawEvent(); err != nil {
+			t.Fatal(err)
+		}
+		if i < len(want) && b.GetMariadbGtidPosition() != want[i] {
+			t.Errorf("event %d: position %q, want %q", i,
+				b.GetMariadbGtidPosition(), want[i])
+		}
+	}
+	if i != len(want) {
+		t.Errorf("got %d events, want %d", i, len(want))
+	}
+
+	if err := b.SetMariadbGtidPosition("0-1"); err == nil {
+		t.Error("SetMariadbGtidPosition: expected error")
+	}
+}
+
+// lossyReader delivers the built events, losing the connection once before
+// the specified event. It restarts from the position it is reconnected at.
+type lossyReader struct {
+	fakeReader
+	lostAt  int
+	lost    bool
+	resumed binlogIndex
+}
+
+func (r *lossyReader) next() bool {
+	if !r.lost && r.pos == r.lostAt {
+		r.lost = true
+		return false
+	}
+	return r.fakeReader.next()
+}
+
+func (r *lossyReader) error() error {
+	if r.lost && r.pos == r.lostAt {
+		return myError(ErrRead, "connection reset")
+	}
+	return nil
+}
+
+func (r *lossyReader) reconnect(index binlogIndex) error {
+	r.resumed = index
+
+	// the master sends its format description event first
+	resent := [][]byte{r.events[0]}
+	for _, ev := range r.events[1:] {
+		end := binary.LittleEndian.Uint32(ev[13:])
+		if uint64(end)-uint64(len(ev)) >= index.position {
+			resent = append(resent, ev)
+		}
+	}
+	r.events, r.pos, r.lostAt = resent, 0, -1
+	return nil
+}
+
+func TestReconnectResume(t *testing.T) {
+	e := new(evBuilder)
+	e.fde()
+	e.query("test", "BEGIN")
+	e.tableMap(5, "test", "t1", []byte{_TYPE_LONG}, nil)
+	e.rows(WRITE_ROWS_EVENT, 5, STMT_END_F, 1, rowLong(1))
+	e.xid(10)
+	committed := uint64(e.pos)
+	e.query("test", "BEGIN")
+	e.tableMap(5, "test", "t1", []byte{_TYPE_LONG}, nil)
+	e.rows(WRITE_ROWS_EVENT, 5, 0, 1, rowLong(2))
+	e.rows(WRITE_ROWS_EVENT, 5, STMT_END_F, 1, rowLong(3))
+	e.xid(11)
+
+	r := &lossyReader{fakeReader: fakeReader{events: e.events}, lostAt: 8}
+	b := newTestBinlog(e)
+	b.reader = r
+	b.p.binlogAutoReconnect = true
+
+	var got []int32
+	for b.Next() {
+		re, err := b.RawEvent()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ev, ok := re.Event().(*RowsEvent); ok {
+			if ev.TableMap() == nil {
+				t.Fatalf("rows event at %d without table map",
+					ev.Position())
+			}
+			got = append(got, ev.Image().Rows[0].Columns[0].(int32))
+		}
+	}
+	if err := b.Error(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !r.lost || r.resumed.position != committed {
+		t.Errorf("resumed from %d, want %d", r.resumed.position, committed)
+	}
+
+	// the rows already delivered are not delivered again
+	if len(got) != 3 || got[0] != 1 || got[1] != 2 || got[2] != 3 {
+		t.Errorf("got rows %v, want [1 2 3]", got)
+	}
+}
+
+func TestWidenPosition(t *testing.T) {
+	tests := []struct {
+		current  uint64
+		position uint32
+		want     uint64
+	}{
+		{0, 4, 4},
+		{120, 500, 500},
+		{0xfffffff0, 0x10, 0x100000010},
+		{0x100000010, 0x20, 0x100000020},
+		{0x1fffffff0, 5, 0x200000005},
+	}
+
+	for _, test := range tests {
+		if got := widenPosition(test.current, test.position); got != test.want {
+			t.Errorf("widenPosition(%#x, %#x) = %#x, want %#x",
+				test.current, test.position, got, test.want)
+		}
+	}
+}
+
+// rotate appends a rotate event, an artificial one carrying no position.
+func (e *evBuilder) rotate(file string, position uint64, artificial bool) {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, position)
+	b = append(b, file...)
+
+	pos := e.pos
+	ev := e.add(ROTATE_EVENT, b)
+	if artificial {
+		binary.LittleEndian.PutUint32(ev[13:], 0)
+		binary.LittleEndian.PutUint16(ev[17:], _LOG_EVENT_ARTIFICIAL_F)
+		e.pos = pos
+	}
+}
+
+func TestPositionTracking(t *testing.T) {
+	type position struct {
+		file     string
+		position uint64
+	}
+
+	var want []position
+	e := new(evBuilder)
+
+	// sent by the master at the beginning of the stream
+	e.rotate("bin.000007", 4, true)
+	want = append(want, position{"bin.000007", 4})
+	e.fde()
+	want = append(want, position{"bin.000007", uint64(e.pos)})
+	e.query("test", "CREATE TABLE t1 (a INT)")
+	want = append(want, position{"bin.000007", uint64(e.pos)})
+
+	// heartbeats carry no valid position
+	pos := e.pos
+	hb := e.add(HEARTBEAT_LOG_EVENT, []byte("bin.000007"))
+	binary.LittleEndian.PutUint32(hb[13:], 0x7fffffff)
+	e.pos = pos
+	want = append(want, position{"bin.000007", uint64(e.pos)})
+
+	// positions are widened beyond 4GB
+	e.pos = 0xffffff00
+	e.query("test", "INSERT INTO t1 VALUES (1)")
+	want = append(want, position{"bin.000007", uint64(e.pos)})
+	e.query("test", "INSERT INTO t1 VALUES ("+strings.Repeat("1", 300)+")")
+	if e.pos > 0xffffff00 {
+		t.Fatal("4GB not crossed")
+	}
+	want = append(want, position{"bin.000007", 1<<32 + uint64(e.pos)})
+
+	e.rotate("bin.000008", 4, false)
+	want = append(want, position{"bin.000008", 4})
+
+	// the next file starts over
+	e.pos = 0
+	e.fde()
+	want = append(want, position{"bin.000008", uint64(e.pos)})
+	e.query("test", "INSERT INTO t1 VALUES (2)")
+	want = append(want, position{"bin.000008", uint64(e.pos)})
+
+	b := newTestBinlog(e)
+
+	var i int
+	for ; b.Next(); i++ {
+		if _, err := b.RawEvent(); err != nil {
+			t.Fatal(err)
+		}
+		if i < len(want) && (b.GetFile() != want[i].file ||
+			b.GetPosition() != want[i].position) {
+			t.Errorf("event %d: position %s:%d, want %s:%d", i,
+				b.GetFile(), b.GetPosition(), want[i].file,
+				want[i].position)
+		}
+	}
+	if i != len(want) {
+		t.Errorf("got %d events, want %d", i, len(want))
+	}
+}
+
+// payload appends a transaction payload event holding the specified (possibly
+// compressed) data.
+func (e *evBuilder) payload(compression byte, data []byte, uncompressedSize int) {
+	b := []byte{_PAYLOAD_COMPRESSION_TYPE, 1, compression}
+	if compression >= 0xfb {
+		b = []byte{_PAYLOAD_COMPRESSION_TYPE, 3, 0xfc, compression, 0}
+	}
+	if uncompressedSize > 0 {
+		b = append(b, _PAYLOAD_UNCOMPRESSED_SIZE, 3, 0xfc,
+			byte(uncompressedSize), byte(uncompressedSize>>8))
+	}
+	b = append(b, _PAYLOAD_HEADER_END_MARK)
+	e.add(TRANSACTION_PAYLOAD_EVENT, append(b, data...))
+}
+
+func TestTransactionPayload(t *testing.T) {
+	defer func(list []decompressor) {
+		decompressors.list = list
+	}(append([]decompressor(nil), decompressors.list...))
+
+	sid := mustParseUUID(t, testSid1)
+
+	inner := new(evBuilder)
+	inner.query("test", "BEGIN")
+	inner.tableMap(5, "test", "t1", []byte{_TYPE_LONG}, nil)
+	inner.rows(WRITE_ROWS_EVENT, 5, STMT_END_F, 1, rowLong(7))
+	inner.xid(10)
+	var data []byte
+	for _, ev := range inner.events {
+		data = append(data, ev...)
+	}
+
+	tests := []struct {
+		name        string
+		compression byte
+		data        []byte
+		zstd        bool    // decompressor registered
+		keep        bool    // SetKeepPayloads
+		want        []uint8 // nil for a compression error
+	}{
+		{"none", PAYLOAD_COMPRESSION_NONE, data, false, false,
+			[]uint8{QUERY_EVENT, TABLE_MAP_EVENT, WRITE_ROWS_EVENT, XID_EVENT}},
+		{"zstd", PAYLOAD_COMPRESSION_ZSTD, gzipBytes(data), true, false,
+			[]uint8{QUERY_EVENT, TABLE_MAP_EVENT, WRITE_ROWS_EVENT, XID_EVENT}},
+		{"kept", PAYLOAD_COMPRESSION_ZSTD, gzipBytes(data), true, true,
+			[]uint8{TRANSACTION_PAYLOAD_EVENT}},
+		{"zstd without decompressor", PAYLOAD_COMPRESSION_ZSTD,
+			gzipBytes(data), false, false, nil},
+	}
+
+	for _, test := range tests {
+		decompressors.list = nil
+		if test.zstd {
+			// zstd stand-in
+			RegisterDecompressor("zstd", zstdMagic, func(r io.Reader) (io.Reader, error) {
+				return gzip.NewReader(r)
+			})
+		}
+
+		e := new(evBuilder)
+		e.fde()
+		e.gtid(sid, 1)
+		e.payload(test.compression, test.data, len(data))
+		end := uint64(e.pos)
+		e.gtid(sid, 2)
+
+		b := newTestBinlog(e)
+		b.SetKeepPayloads(test.keep)
+		if err := b.SetGTIDSet(""); err != nil {
+			t.Fatal(err)
+		}
+
+		var types []uint8
+		for b.Next() {
+			re, err := b.RawEvent()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			switch ev := re.Event().(type) {
+			case *RowsEvent:
+				if rows := ev.Image().Rows; len(rows) != 1 ||
+					rows[0].Columns[0] != int32(7) {
+					t.Errorf("%s: got rows %v", test.name, rows)
+				}
+			case *FormatDescriptionEvent, *GtidLogEvent:
+				continue
+			}
+			types = append(types, re.header.type_)
+
+			// the events of the payload are located at the payload
+			if b.GetPosition() != end {
+				t.Errorf("%s: position %d, want %d", test.name,
+					b.GetPosition(), end)
+			}
+		}
+		if test.want == nil {
+			// never passed through, its changes would go unnoticed
+			if e, ok := b.Error().(*Error); !ok || e.Code() != ErrCompression ||
+				len(types) != 0 || b.GetGTIDSet() != "" {
+				t.Errorf("%s: got events %v, %v", test.name, types, b.Error())
+			}
+			continue
+		}
+		if err := b.Error(); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+
+		if !bytes.Equal(types, test.want) {
+			t.Errorf("%s: got events %v, want %v", test.name, types,
+				test.want)
+		}
+
+		// committed
+		if b.GetGTIDSet() != testSid1+":1" {
+			t.Errorf("%s: GTID set %q", test.name, b.GetGTIDSet())
+		}
+	}
+}
+
+func TestTransactionPayloadInvalid(t *testing.T) {
+	e := new(evBuilder)
+	e.query("test", "BEGIN")
+	e.xid(10)
+	payload := append(append([]byte(nil), e.events[0]...), e.events[1]...)
+
+	tests := []struct {
+		name string
+		ev   TransactionPayloadEvent
+	}{
+		{"size", TransactionPayloadEvent{payload: payload,
+			compressionType:  PAYLOAD_COMPRESSION_NONE,
+			uncompressedSize: uint64(len(payload) + 1)}},
+		{"compression", TransactionPayloadEvent{payload: payload,
+			compressionType: 7}},
+		{"truncated", TransactionPayloadEvent{payload: payload[:len(payload)-3],
+			compressionType: PAYLOAD_COMPRESSION_NONE}},
+		{"truncated header", TransactionPayloadEvent{
+			payload:         payload[:len(e.events[0])+10],
+			compressionType: PAYLOAD_COMPRESSION_NONE}},
+	}
+
+	for _, test := range tests {
+		if events, err := test.ev.events(); err == nil {
+			t.Errorf("%s: got %d events, expected an error", test.name,
+				len(events))
+		}
+	}
+
+	ev := TransactionPayloadEvent{payload: payload,
+		compressionType: PAYLOAD_COMPRESSION_NONE}
+	if events, err := ev.events(); err != nil || len(events) != 2 {
+		t.Errorf("got %d events, %v", len(events), err)
+	}
+}
+
+// userVar adds a USER_VAR_EVENT setting the named variable, a nil value
+// logging NULL.
+func (e *evBuilder) userVar(name string, type_ uint8, charset uint32,
+	value []byte, flags uint8) {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, uint32(len(name)))
+	b = append(b, name...)
+	if value == nil {
+		e.add(USER_VAR_EVENT, append(b, 1))
+		return
+	}
+
+	b = append(b, 0, type_)
+	b = append(b, make([]byte, 8)...)
+	binary.LittleEndian.PutUint32(b[len(b)-8:], charset)
+	binary.LittleEndian.PutUint32(b[len(b)-4:], uint32(len(value)))
+	b = append(b, value...)
+	e.add(USER_VAR_EVENT, append(b, flags))
+}
+
+func TestUserVarEvent(t *testing.T) {
+	long := func(v uint64) []byte {
+		b := make([]byte, 8)
+		binary.LittleEndian.PutUint64(b, v)
+		return b
+	}
+
+	tests := []struct {
+		type_   uint8
+		charset uint32
+		value   []byte
+		flags   uint8
+		want    interface{}
+	}{
+		{USER_VAR_STRING, 45, []byte("caf\xc3\xa9"), 0, "café"},
+		{USER_VAR_STRING, 8, []byte("caf\xe9 \x80"), 0, "café €"},
+		{USER_VAR_STRING, _BINARY_CHARSET, []byte{0, 0xff}, 0, []byte{0, 0xff}},
+		{USER_VAR_REAL, 63, long(0x3ff8000000000000), 0, float64(1.5)},
+		{USER_VAR_INT, 63, long(uint64(1<<64 - 2)), 0, int64(-2)},
+		{USER_VAR_INT, 63, long(uint64(1<<64 - 2)), UNSIGNED,
+			uint64(1<<64 - 2)},
+		{USER_VAR_DECIMAL, 63, []byte{4, 2, 0x8c, 0x22}, 0, "12.34"},
+		{USER_VAR_DECIMAL, 63, []byte{4, 2, 0x73, 0xdd}, 0, "-12.34"},
+		{USER_VAR_INT, 63, []byte{1, 2, 3}, 0, nil},
+		{USER_VAR_REAL, 63, []byte{1, 2, 3}, 0, nil},
+		{USER_VAR_DECIMAL, 63, []byte{4}, 0, nil},
+		{USER_VAR_ROW, 63, []byte{1}, 0, nil},
+	}
+
+	e := new(evBuilder)
+	e.fde()
+	for _, test := range tests {
+		e.userVar("v", test.type_, test.charset, test.value, test.flags)
+	}
+	e.userVar("null", USER_VAR_STRING, 0, nil, 0)
+
+	events := readEvents(t, e)
+	if len(events) != len(tests)+2 {
+		t.Fatalf("got %d events", len(events))
+	}
+
+	for i, test := range tests {
+		ev, ok := events[i+1].(*UserVarEvent)
+		if !ok {
+			t.Fatalf("%d: got %T", i, events[i+1])
+		}
+		if ev.Name() != "v" || ev.IsNull() || ev.ValueType() != test.type_ ||
+			ev.Charset() != test.charset ||
+			ev.Unsigned() != (test.flags&UNSIGNED != 0) ||
+			!bytes.Equal(ev.RawValue(), test.value) {
+			t.Errorf("%d: got %q %v %d %d %v %v", i, ev.Name(), ev.IsNull(),
+				ev.ValueType(), ev.Charset(), ev.Unsigned(), ev.RawValue())
+		}
+
+		v := ev.Value()
+		if b, ok := test.want.([]byte); ok {
+			if got, ok := v.([]byte); !ok || !bytes.Equal(got, b) {
+				t.Errorf("%d: got %#v, expected %#v", i, v, b)
+			}
+		} else if v != test.want {
+			t.Errorf("%d: got %#v, expected %#v", i, v, test.want)
+		}
+	}
+
+	ev := events[len(events)-1].(*UserVarEvent)
+	if ev.Name() != "null" || !ev.IsNull() || ev.Value() != nil {
+		t.Errorf("got %q %v %#v", ev.Name(), ev.IsNull(), ev.Value())
+	}
+}
@@ -0,0 +1,128 @@
+/*
+  The MIT License (MIT)
+
+  Copyright (c) 2015 Nirbhay Choubey
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in all
+  copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+  SOFTWARE.
+*/
+
+package mysql
+
+import (
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// character sets needing a conversion to UTF-8
+const (
+	_CHARSET_OTHER = iota // unknown, possibly UTF-8 compatible
+	_CHARSET_UTF8         // utf8, utf8mb4 and ascii
+	_CHARSET_BINARY
+	_CHARSET_LATIN1
+	_CHARSET_UCS2
+	_CHARSET_UTF16
+	_CHARSET_UTF16LE
+	_CHARSET_UTF32
+)
+
+// collationCharset returns the character set of the specified collation id.
+func collationCharset(collation uint32) int {
+	switch {
+	case collation == _BINARY_CHARSET:
+		return _CHARSET_BINARY
+	case collation == 11, collation == 65, // ascii
+		collation == 33, collation == 76, collation == 83,
+		collation >= 192 && collation <= 215, collation == 223,
+		collation == 45, collation == 46,
+		collation >= 224 && collation <= 247,
+		collation >= 255 && collation <= 323:
+		return _CHARSET_UTF8
+	case collation == 5, collation == 8, collation == 15, collation == 31,
+		collation >= 47 && collation <= 49, collation == 94:
+		return _CHARSET_LATIN1
+	case collation == 35, collation == 90,
+		collation >= 128 && collation <= 151, collation == 159:
+		return _CHARSET_UCS2
+	case collation == 54, collation == 55,
+		collation >= 101 && collation <= 124:
+		return _CHARSET_UTF16
+	case collation == 56, collation == 62:
+		return _CHARSET_UTF16LE
+	case collation == 60, collation == 61,
+		collation >= 160 && collation <= 183:
+		return _CHARSET_UTF32
+	default:
+	}
+	return _CHARSET_OTHER
+}
+
+// cp1252 characters in 0x80-0x9f (MySQL's latin1 is cp1252)
+var cp1252 = [32]rune{
+	0x20ac, 0x81, 0x201a, 0x0192, 0x201e, 0x2026, 0x2020, 0x2021,
+	0x02c6, 0x2030, 0x0160, 0x2039, 0x0152, 0x8d, 0x017d, 0x8f,
+	0x90, 0x2018, 0x2019, 0x201c, 0x201d, 0x2022, 0x2013, 0x2014,
+	0x02dc, 0x2122, 0x0161, 0x203a, 0x0153, 0x9d, 0x017e, 0x0178,
+}
+
+// decodeCharsetString converts the specified string of the given collation
+// to UTF-8. Binary strings are returned as []byte, strings of the other
+// character sets (utf8, utf8mb4, ascii..) are returned as is.
+func decodeCharsetString(b []byte, collation uint32) interface{} {
+	var runes []rune
+
+	switch collationCharset(collation) {
+	case _CHARSET_BINARY:
+		return b
+
+	case _CHARSET_LATIN1:
+		runes = make([]rune, len(b))
+		for i, c := range b {
+			if c >= 0x80 && c < 0xa0 {
+				runes[i] = cp1252[c-0x80]
+			} else {
+				runes[i] = rune(c)
+			}
+		}
+
+	case _CHARSET_UCS2, _CHARSET_UTF16, _CHARSET_UTF16LE:
+		units := make([]uint16, len(b)/2)
+		for i := range units {
+			if collationCharset(collation) == _CHARSET_UTF16LE {
+				units[i] = uint16(b[2*i]) | uint16(b[2*i+1])<<8
+			} else {
+				units[i] = uint16(b[2*i])<<8 | uint16(b[2*i+1])
+			}
+		}
+		runes = utf16.Decode(units)
+
+	case _CHARSET_UTF32:
+		runes = make([]rune, len(b)/4)
+		for i := range runes {
+			runes[i] = rune(b[4*i])<<24 | rune(b[4*i+1])<<16 |
+				rune(b[4*i+2])<<8 | rune(b[4*i+3])
+			if !utf8.ValidRune(runes[i]) {
+				runes[i] = utf8.RuneError
+			}
+		}
+
+	default:
+		return string(b)
+	}
+	return string(runes)
+}
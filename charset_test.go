@@ -0,0 +1,57 @@
+/*
+  The MIT License (MIT)
+
+  Copyright (c) 2015 Nirbhay Choubey
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in all
+  copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+  SOFTWARE.
+*/
+
+package mysql
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDecodeCharsetString(t *testing.T) {
+	tests := []struct {
+		collation uint32
+		b         []byte
+		want      string
+	}{
+		{33, []byte("\xe2\x82\xac1"), "€1"},
+		{8, []byte("\x80\x9f\xe9"), "€Ÿé"},
+		{35, []byte{0x20, 0xac, 0, 0x31}, "€1"},
+		{54, []byte{0xd8, 0x3d, 0xde, 0x00}, "😀"},
+		{56, []byte{0x3d, 0xd8, 0x00, 0xde}, "😀"},
+		{60, []byte{0, 1, 0xf6, 0, 0x7f, 0, 0, 0}, "😀�"},
+	}
+
+	for _, test := range tests {
+		if s := decodeCharsetString(test.b, test.collation); s != test.want {
+			t.Errorf("%d: got %q, expected %q", test.collation, s, test.want)
+		}
+	}
+
+	b := []byte{0xe9, 0}
+	if v, ok := decodeCharsetString(b, _BINARY_CHARSET).([]byte); !ok ||
+		!bytes.Equal(v, b) {
+		t.Errorf("got %#v", v)
+	}
+}
@@ -0,0 +1,270 @@
+/*
+  The MIT License (MIT)
+
+  Copyright (c) 2015 Nirbhay Choubey
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in all
+  copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+  SOFTWARE.
+*/
+
+package mysql
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Checkpoint represents the position of a binlog consumer, right after the
+// last transaction it has processed.
+type Checkpoint struct {
+	File                string `json:"file"`
+	Position            uint64 `json:"position"`
+	GTIDSet             string `json:"gtid_set"`              // MySQL
+	MariadbGtidPosition string `json:"mariadb_gtid_position"` // MariaDB
+}
+
+// IsZero returns whether the checkpoint is empty (nothing has been saved).
+func (cp Checkpoint) IsZero() bool {
+	return cp.File == "" && cp.GTIDSet == "" && cp.MariadbGtidPosition == ""
+}
+
+// CheckpointStore is the interface implemented by the persistent stores of
+// binlog consumer checkpoints.
+type CheckpointStore interface {
+	// Load returns the last saved checkpoint, a zero Checkpoint if
+	// none has been saved yet.
+	Load() (Checkpoint, error)
+
+	// Save persists the specified checkpoint.
+	Save(cp Checkpoint) error
+}
+
+// SetCheckpointStore sets the store the binlog checkpoints are loaded from (in
+// Begin) and saved to. A checkpoint is saved after each transaction, once it
+// has been consumed, i.e. on the following call to Next (or Close).
+func (b *Binlog) SetCheckpointStore(store CheckpointStore) {
+	b.checkpoints = store
+}
+
+// loadCheckpoint loads the last saved checkpoint (if any) to start the binlog
+// stream from.
+func (b *Binlog) loadCheckpoint() error {
+	var (
+		cp  Checkpoint
+		err error
+	)
+
+	if cp, err = b.checkpoints.Load(); err != nil {
+		return err
+	}
+
+	if cp.IsZero() {
+		// nothing saved yet, start from the specified index
+		return nil
+	}
+
+	if cp.File != "" {
+		b.index.file = cp.File
+		b.index.position = cp.Position
+	}
+
+	if cp.GTIDSet != "" {
+		if err = b.SetGTIDSet(cp.GTIDSet); err != nil {
+			return err
+		}
+	}
+
+	if cp.MariadbGtidPosition != "" {
+		if err = b.SetMariadbGtidPosition(cp.MariadbGtidPosition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkpoint returns the checkpoint of the last committed transaction.
+func (b *Binlog) checkpoint() Checkpoint {
+	return Checkpoint{
+		File:                b.resume.file,
+		Position:            b.resume.position,
+		GTIDSet:             b.GetGTIDSet(),
+		MariadbGtidPosition: b.GetMariadbGtidPosition(),
+	}
+}
+
+// saveCheckpoint saves the checkpoint of the last consumed transaction, if
+// not saved already.
+func (b *Binlog) saveCheckpoint() error {
+	if b.checkpoints == nil || b.unsaved == nil {
+		return nil
+	}
+
+	if err := b.checkpoints.Save(*b.unsaved); err != nil {
+		return err
+	}
+	b.unsaved = nil
+	return nil
+}
+
+// FileCheckpointStore stores the checkpoint in a local file; the file is
+// replaced atomically, so that a crash never leaves a partially written
+// checkpoint behind.
+type FileCheckpointStore struct {
+	path string
+}
+
+func NewFileCheckpointStore(path string) *FileCheckpointStore {
+	return &FileCheckpointStore{path: path}
+}
+
+func (s *FileCheckpointStore) Load() (Checkpoint, error) {
+	var cp Checkpoint
+
+	b, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return cp, nil
+	} else if err != nil {
+		return cp, myError(ErrFile, err)
+	}
+
+	if err = json.Unmarshal(b, &cp); err != nil {
+		return cp, myError(ErrFile, err)
+	}
+	return cp, nil
+}
+
+func (s *FileCheckpointStore) Save(cp Checkpoint) error {
+	var (
+		f   *os.File
+		b   []byte
+		err error
+	)
+
+	if b, err = json.Marshal(cp); err != nil {
+		return myError(ErrFile, err)
+	}
+
+	// write the checkpoint to a temporary file and flush it to the disk
+	tmp := s.path + ".tmp"
+	if f, err = os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
+		return myError(ErrFile, err)
+	}
+
+	if _, err = f.Write(b); err == nil {
+		err = f.Sync()
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		os.Remove(tmp)
+		return myError(ErrFile, err)
+	}
+
+	// atomically replace the old checkpoint
+	if err = os.Rename(tmp, s.path); err != nil {
+		return myError(ErrFile, err)
+	}
+
+	// make the rename durable
+	if dir, err := os.Open(filepath.Dir(s.path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+// TableCheckpointStore stores the checkpoint in a MySQL table (one row per
+// consumer, identified by name), using this driver.
+type TableCheckpointStore struct {
+	db    *sql.DB
+	table string
+	name  string
+}
+
+// NewTableCheckpointStore returns a store for the checkpoints of the consumer
+// with the given name, in the specified table; the table is created if it
+// does not exist.
+func NewTableCheckpointStore(db *sql.DB, table, name string) (*TableCheckpointStore, error) {
+	s := &TableCheckpointStore{db: db, table: quoteIdentifier(table), name: name}
+
+	if _, err := db.Exec("CREATE TABLE IF NOT EXISTS " + s.table + " (" +
+		"name VARCHAR(255) NOT NULL PRIMARY KEY, " +
+		"file VARCHAR(512) NOT NULL DEFAULT '', " +
+		"position BIGINT UNSIGNED NOT NULL DEFAULT 0, " +
+		"gtid_set TEXT, " +
+		"mariadb_gtid_position TEXT, " +
+		"updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP " +
+		"ON UPDATE CURRENT_TIMESTAMP)"); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *TableCheckpointStore) Load() (Checkpoint, error) {
+	var (
+		cp                      Checkpoint
+		gtidSet, mariadbGtidPos sql.NullString
+	)
+
+	err := s.db.QueryRow("SELECT file, position, gtid_set, mariadb_gtid_position "+
+		"FROM "+s.table+" WHERE name = ?", s.name).Scan(&cp.File, &cp.Position,
+		&gtidSet, &mariadbGtidPos)
+
+	switch {
+	case err == sql.ErrNoRows:
+		return Checkpoint{}, nil
+	case err != nil:
+		return Checkpoint{}, err
+	}
+
+	cp.GTIDSet = gtidSet.String
+	cp.MariadbGtidPosition = mariadbGtidPos.String
+	return cp, nil
+}
+
+func (s *TableCheckpointStore) Save(cp Checkpoint) error {
+	_, err := s.db.Exec(s.saveQuery(), s.saveArgs(cp)...)
+	return err
+}
+
+// SaveTx saves the checkpoint as part of the specified transaction, so that
+// the changes applied in the transaction and the checkpoint are committed
+// atomically.
+func (s *TableCheckpointStore) SaveTx(tx *sql.Tx, cp Checkpoint) error {
+	_, err := tx.Exec(s.saveQuery(), s.saveArgs(cp)...)
+	return err
+}
+
+func (s *TableCheckpointStore) saveQuery() string {
+	return "INSERT INTO " + s.table + " (name, file, position, gtid_set, " +
+		"mariadb_gtid_position) VALUES (?, ?, ?, ?, ?) ON DUPLICATE KEY " +
+		"UPDATE file = VALUES(file), position = VALUES(position), " +
+		"gtid_set = VALUES(gtid_set), " +
+		"mariadb_gtid_position = VALUES(mariadb_gtid_position)"
+}
+
+func (s *TableCheckpointStore) saveArgs(cp Checkpoint) []interface{} {
+	return []interface{}{s.name, cp.File, int64(cp.Position), cp.GTIDSet,
+		cp.MariadbGtidPosition}
+}
@@ -0,0 +1,163 @@
+/*
+  The MIT License (MIT)
+
+  Copyright (c) 2015 Nirbhay Choubey
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in all
+  copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+  SOFTWARE.
+*/
+
+package mysql
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileCheckpointStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "cp.json")
+	s := NewFileCheckpointStore(name)
+
+	// nothing saved yet
+	cp, err := s.Load()
+	if err != nil || !cp.IsZero() {
+		t.Fatalf("got %+v, %v, want a zero checkpoint", cp, err)
+	}
+
+	saved := []Checkpoint{
+		{File: "bin.000001", Position: 120},
+		{File: "bin.000002", Position: 5 << 32, GTIDSet: testSid1 + ":1-7"},
+		{MariadbGtidPosition: "0-1-5,1-2-7"},
+	}
+
+	for _, want := range saved {
+		if err = s.Save(want); err != nil {
+			t.Fatal(err)
+		}
+		if cp, err = s.Load(); err != nil || cp != want {
+			t.Errorf("got %+v, %v, want %+v", cp, err, want)
+		}
+		if _, err = os.Stat(name + ".tmp"); !os.IsNotExist(err) {
+			t.Errorf("temporary file left behind (%v)", err)
+		}
+	}
+
+	// a failed save leaves the last checkpoint in place
+	if err = os.Mkdir(name+".tmp", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Save(Checkpoint{File: "bin.000003", Position: 4}); err == nil {
+		t.Error("Save: expected error")
+	}
+	if cp, err = s.Load(); err != nil || cp != saved[len(saved)-1] {
+		t.Errorf("got %+v, %v, want %+v", cp, err, saved[len(saved)-1])
+	}
+
+	if err = ioutil.WriteFile(name, []byte(`{"file": "bin.0`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Load(); err == nil {
+		t.Error("Load of a corrupted checkpoint: expected error")
+	}
+}
+
+func TestBinlogCheckpoint(t *testing.T) {
+	sid := mustParseUUID(t, testSid1)
+
+	e := new(evBuilder)
+	e.rotate("bin.000003", 4, true)
+	e.fde()
+	e.gtid(sid, 6)
+	e.query("test", "BEGIN")
+	e.query("test", "INSERT INTO t1 VALUES (1)")
+	e.xid(10)
+	first := uint64(e.pos)
+	e.gtid(sid, 7)
+	e.query("test", "CREATE TABLE t2 (a INT)")
+	second := uint64(e.pos)
+
+	store := new(memCheckpointStore)
+	b := newTestBinlog(e)
+	b.SetCheckpointStore(store)
+	if err := b.SetGTIDSet(testSid1 + ":1-5"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Begin(); err != nil {
+		t.Fatal(err)
+	}
+
+	// number of checkpoints saved while each event is consumed
+	want := []int{0, 0, 0, 0, 0, 0, 1, 1}
+
+	var i int
+	for ; b.Next(); i++ {
+		if i < len(want) && len(store.saved) != want[i] {
+			t.Errorf("event %d: %d checkpoints saved, want %d", i,
+				len(store.saved), want[i])
+		}
+	}
+	if i != len(want) {
+		t.Errorf("got %d events, want %d", i, len(want))
+	}
+
+	// the last transaction gets saved on close
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	saved := []Checkpoint{
+		{File: "bin.000003", Position: first, GTIDSet: testSid1 + ":1-6"},
+		{File: "bin.000003", Position: second, GTIDSet: testSid1 + ":1-7"},
+	}
+	if len(store.saved) != len(saved) {
+		t.Fatalf("got checkpoints %+v, want %+v", store.saved, saved)
+	}
+	for i := range saved {
+		if store.saved[i] != saved[i] {
+			t.Errorf("checkpoint %d: got %+v, want %+v", i,
+				store.saved[i], saved[i])
+		}
+	}
+
+	// a new stream starts from the last checkpoint
+	b = newTestBinlog(new(evBuilder))
+	b.SetCheckpointStore(store)
+	if err := b.Begin(); err != nil {
+		t.Fatal(err)
+	}
+	if b.GetFile() != "bin.000003" || b.GetPosition() != second ||
+		b.GetGTIDSet() != testSid1+":1-7" {
+		t.Errorf("started from %s:%d (%s)", b.GetFile(), b.GetPosition(),
+			b.GetGTIDSet())
+	}
+
+	store.Save(Checkpoint{GTIDSet: "zz:1"})
+	b = newTestBinlog(new(evBuilder))
+	b.SetCheckpointStore(store)
+	if err := b.Begin(); err == nil {
+		t.Error("Begin from an invalid checkpoint: expected error")
+	}
+}
@@ -28,6 +28,8 @@ import (
 	"database/sql/driver"
 	"encoding/binary"
 	"hash/crc32"
+	"strconv"
+	"strings"
 )
 
 const _BINLOG_CHECKSUM_LENGTH = 4
@@ -138,6 +140,46 @@ func fetchBinlogChecksum(c *Conn) (checksumVerifier, error) {
 
 }
 
+// fdeChecksumAlg returns the checksum algorithm stored in the specified
+// FORMAT_DESCRIPTION_EVENT (whole event), BINLOG_CHECKSUM_ALG_UNDEF if the
+// server that wrote it predates the event checksums (MySQL 5.6.1, MariaDB
+// 5.3), in which case the event carries neither the algorithm nor the
+// checksum.
+func fdeChecksumAlg(ev []byte) uint8 {
+	// header, binlog version (2), server version (50), create timestamp
+	// (4), header length (1), post-header lengths, alg (1), checksum (4)
+	const versionOffset = _EVENT_HEADER_LENGTH + 2
+
+	if len(ev) < versionOffset+50+4+1+1+_BINLOG_CHECKSUM_LENGTH {
+		return BINLOG_CHECKSUM_ALG_UNDEF
+	}
+
+	version := string(ev[versionOffset : versionOffset+50])
+	if i := strings.IndexByte(version, 0); i >= 0 {
+		version = version[:i]
+	}
+
+	var v [3]int
+	for i, s := range strings.SplitN(strings.SplitN(version, "-", 2)[0], ".", 3) {
+		v[i], _ = strconv.Atoi(s)
+	}
+
+	supported := [3]int{5, 6, 1}
+	if strings.Contains(version, "MariaDB") {
+		supported = [3]int{5, 3, 0}
+	}
+
+	for i := range v {
+		if v[i] != supported[i] {
+			if v[i] < supported[i] {
+				return BINLOG_CHECKSUM_ALG_UNDEF
+			}
+			break
+		}
+	}
+	return uint8(ev[len(ev)-_BINLOG_CHECKSUM_LENGTH-1])
+}
+
 // updateChecksumVerifier updates the current checksum verifier
 func updateChecksumVerifier(b *Binlog) {
 	// return if checksum algorithm has not changed
@@ -0,0 +1,787 @@
+/*
+  The MIT License (MIT)
+
+  Copyright (c) 2015 Nirbhay Choubey
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in all
+  copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+  SOFTWARE.
+*/
+
+package mysql
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// token kinds
+const (
+	_TOKEN_WORD   = iota // keyword or unquoted identifier
+	_TOKEN_QUOTED        // `quoted identifier`
+	_TOKEN_STRING        // 'string' or "string"
+	_TOKEN_PUNCT         // any other single character
+)
+
+type token struct {
+	kind  int
+	value string
+}
+
+// ddlParser is a minimal parser for the table DDL statements, it only
+// extracts what's needed to keep track of the table columns.
+type ddlParser struct {
+	tokens []token
+	pos    int
+	schema string // default schema
+
+	// tables forgotten, as their definition could not be kept up to date
+	forgotten []TableSchema
+}
+
+func newDDLParser(query, schema string) *ddlParser {
+	return &ddlParser{tokens: tokenize(query), schema: schema}
+}
+
+// tokenize splits the specified statement into tokens, skipping the white
+// spaces and comments. The content of the executable comments (/*! ... */)
+// is tokenized like the rest of the statement.
+func tokenize(query string) []token {
+	var (
+		tokens     []token
+		executable bool
+	)
+
+	for i := 0; i < len(query); {
+		c := query[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+
+		case strings.HasPrefix(query[i:], "/*!"):
+			i += 3
+			for i < len(query) && query[i] >= '0' && query[i] <= '9' {
+				i++
+			}
+			executable = true
+
+		case strings.HasPrefix(query[i:], "*/") && executable:
+			i += 2
+			executable = false
+
+		case strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				return tokens
+			}
+			i += end + 4
+
+		case c == '#' || strings.HasPrefix(query[i:], "-- "):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				return tokens
+			}
+			i += end + 1
+
+		case c == '`' || c == '\'' || c == '"':
+			var (
+				value []byte
+				j     int
+			)
+
+			for j = i + 1; j < len(query); j++ {
+				if query[j] == c {
+					// doubled quote
+					if j+1 < len(query) && query[j+1] == c {
+						value = append(value, c)
+						j++
+						continue
+					}
+					break
+				}
+				if query[j] == '\\' && c != '`' && j+1 < len(query) {
+					j++
+				}
+				value = append(value, query[j])
+			}
+
+			kind := _TOKEN_STRING
+			if c == '`' {
+				kind = _TOKEN_QUOTED
+			}
+			tokens = append(tokens, token{kind, string(value)})
+			i = j + 1
+
+		case isWordChar(query[i:]):
+			j := i
+			for j < len(query) && isWordChar(query[j:]) {
+				_, n := utf8.DecodeRuneInString(query[j:])
+				j += n
+			}
+			tokens = append(tokens, token{_TOKEN_WORD, query[i:j]})
+			i = j
+
+		default:
+			tokens = append(tokens, token{_TOKEN_PUNCT, query[i : i+1]})
+			i++
+		}
+	}
+	return tokens
+}
+
+func isWordChar(s string) bool {
+	c := s[0]
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+		c >= '0' && c <= '9' || c == '_' || c == '$' || c >= utf8.RuneSelf
+}
+
+func (p *ddlParser) done() bool {
+	return p.pos >= len(p.tokens) || p.isPunct(";")
+}
+
+// is returns whether the next token is one of the specified keywords.
+func (p *ddlParser) is(keywords ...string) bool {
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != _TOKEN_WORD {
+		return false
+	}
+
+	for _, k := range keywords {
+		if strings.EqualFold(p.tokens[p.pos].value, k) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *ddlParser) isPunct(c string) bool {
+	return p.pos < len(p.tokens) && p.tokens[p.pos].kind == _TOKEN_PUNCT &&
+		p.tokens[p.pos].value == c
+}
+
+// accept skips the specified sequence of keywords, if present.
+func (p *ddlParser) accept(keywords ...string) bool {
+	for i, k := range keywords {
+		j := p.pos + i
+		if j >= len(p.tokens) || p.tokens[j].kind != _TOKEN_WORD ||
+			!strings.EqualFold(p.tokens[j].value, k) {
+			return false
+		}
+	}
+	p.pos += len(keywords)
+	return true
+}
+
+func (p *ddlParser) acceptPunct(c string) bool {
+	if p.isPunct(c) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// identifier returns the next (quoted or unquoted) identifier.
+func (p *ddlParser) identifier() (string, bool) {
+	if p.pos >= len(p.tokens) {
+		return "", false
+	}
+
+	t := p.tokens[p.pos]
+	if t.kind != _TOKEN_WORD && t.kind != _TOKEN_QUOTED {
+		return "", false
+	}
+	p.pos++
+	return t.value, true
+}
+
+// tableName returns the next [schema.]table name.
+func (p *ddlParser) tableName() (schema, table string, ok bool) {
+	if table, ok = p.identifier(); !ok {
+		return
+	}
+
+	schema = p.schema
+	if p.acceptPunct(".") {
+		schema = table
+		table, ok = p.identifier()
+	}
+	return
+}
+
+// skip skips the tokens up to the next comma or closing parenthesis at the
+// current nesting level.
+func (p *ddlParser) skip() {
+	var depth int
+
+	for !p.done() {
+		switch {
+		case p.isPunct("("):
+			depth++
+		case p.isPunct(")"):
+			if depth == 0 {
+				return
+			}
+			depth--
+		case p.isPunct(","):
+			if depth == 0 {
+				return
+			}
+		default:
+		}
+		p.pos++
+	}
+}
+
+// identifierList parses a parenthesized list of column names, ignoring the
+// key part lengths and orders.
+func (p *ddlParser) identifierList() ([]string, bool) {
+	var names []string
+
+	if !p.acceptPunct("(") {
+		return nil, false
+	}
+
+	for {
+		name, ok := p.identifier()
+		if !ok {
+			return nil, false
+		}
+		names = append(names, name)
+
+		p.skip()
+		if p.acceptPunct(")") {
+			return names, true
+		}
+		if !p.acceptPunct(",") {
+			return nil, false
+		}
+	}
+}
+
+// columnPosition represents a FIRST or AFTER <column> clause.
+type columnPosition struct {
+	first bool
+	after string
+}
+
+// columnDefinition parses a column definition, the column is part of the
+// primary key if primary is true.
+func (p *ddlParser) columnDefinition() (c ColumnSchema, primary bool,
+	pos columnPosition, ok bool) {
+	if c.Name, ok = p.identifier(); !ok {
+		return
+	}
+
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != _TOKEN_WORD {
+		ok = false
+		return
+	}
+	c.Type = strings.ToLower(p.tokens[p.pos].value)
+	p.pos++
+
+	// column attributes
+	var depth int
+
+	for !p.done() {
+		switch {
+		case p.isPunct("("):
+			depth++
+		case p.isPunct(")"):
+			if depth == 0 {
+				return
+			}
+			depth--
+		case p.isPunct(","):
+			if depth == 0 {
+				return
+			}
+		case depth > 0:
+		case p.is("UNSIGNED"):
+			c.Unsigned = true
+		case p.is("UNIQUE"):
+			// UNIQUE [KEY]
+			p.pos++
+			p.accept("KEY")
+			continue
+		case p.is("PRIMARY", "KEY"):
+			// [PRIMARY] KEY
+			primary = true
+		case p.is("FIRST"):
+			pos.first = true
+		case p.is("AFTER"):
+			p.pos++
+			if pos.after, ok = p.identifier(); !ok {
+				return
+			}
+			continue
+		default:
+		}
+		p.pos++
+	}
+	return
+}
+
+// apply applies the statement to the specified table definitions.
+func (p *ddlParser) apply(tables map[string]*TableSchema) {
+	switch {
+	case p.accept("CREATE"):
+		p.accept("OR", "REPLACE")
+		if p.accept("TEMPORARY") {
+			// temporary tables are not row logged
+			return
+		}
+		if p.accept("TABLE") {
+			p.createTable(tables)
+		}
+
+	case p.accept("ALTER"):
+		p.accept("ONLINE")
+		p.accept("IGNORE")
+		if p.accept("TABLE") {
+			p.alterTable(tables)
+		}
+
+	case p.accept("DROP"):
+		switch {
+		case p.accept("TEMPORARY"):
+		case p.accept("TABLE"):
+			p.dropTable(tables)
+		case p.accept("DATABASE"), p.accept("SCHEMA"):
+			p.accept("IF", "EXISTS")
+			if schema, ok := p.identifier(); ok {
+				for key, ts := range tables {
+					if ts.Schema == schema {
+						delete(tables, key)
+					}
+				}
+			}
+		default:
+		}
+
+	case p.accept("RENAME", "TABLE"):
+		p.renameTable(tables)
+
+	default:
+	}
+}
+
+func (p *ddlParser) createTable(tables map[string]*TableSchema) {
+	ifNotExists := p.accept("IF", "NOT", "EXISTS")
+
+	schema, table, ok := p.tableName()
+	if !ok {
+		return
+	}
+	key := tableKey(schema, table)
+
+	if _, ok := tables[key]; ok && ifNotExists {
+		// logged even though the existing table is left unchanged
+		return
+	}
+
+	// CREATE TABLE ... LIKE
+	parens := p.acceptPunct("(")
+	if p.accept("LIKE") {
+		if s, t, ok := p.tableName(); ok {
+			if src, ok := tables[tableKey(s, t)]; ok {
+				ts := src.clone()
+				ts.Schema, ts.Table = schema, table
+				tables[key] = ts
+				return
+			}
+		}
+		p.forget(tables, schema, table)
+		return
+	}
+
+	if !parens {
+		// CREATE TABLE ... SELECT, the columns are unknown
+		p.forget(tables, schema, table)
+		return
+	}
+
+	ts := &TableSchema{Schema: schema, Table: table}
+
+	for {
+		switch {
+		case p.accept("PRIMARY", "KEY"):
+			p.skipIndexType()
+			if ts.PrimaryKey, ok = p.identifierList(); !ok {
+				p.forget(tables, schema, table)
+				return
+			}
+			p.skip()
+
+		case p.accept("CONSTRAINT"):
+			if !p.is("PRIMARY", "UNIQUE", "FOREIGN", "CHECK") {
+				p.identifier()
+			}
+			if p.accept("PRIMARY", "KEY") {
+				p.skipIndexType()
+				if ts.PrimaryKey, ok = p.identifierList(); !ok {
+					p.forget(tables, schema, table)
+					return
+				}
+			}
+			p.skip()
+
+		case p.is("KEY", "INDEX", "UNIQUE", "FOREIGN", "FULLTEXT",
+			"SPATIAL", "CHECK", "PERIOD", "SYSTEM"):
+			p.skip()
+
+		default:
+			c, primary, _, ok := p.columnDefinition()
+			if !ok {
+				p.forget(tables, schema, table)
+				return
+			}
+			ts.Columns = append(ts.Columns, c)
+			if primary {
+				ts.PrimaryKey = []string{c.Name}
+			}
+		}
+
+		if p.acceptPunct(")") {
+			break
+		}
+		if !p.acceptPunct(",") {
+			p.forget(tables, schema, table)
+			return
+		}
+	}
+
+	// CREATE TABLE (...) SELECT adds the columns of the select
+	for ; !p.done(); p.pos++ {
+		if p.is("SELECT") {
+			p.forget(tables, schema, table)
+			return
+		}
+	}
+	tables[key] = ts
+}
+
+// forget stops tracking the specified table, whose definition can't be kept
+// up to date.
+func (p *ddlParser) forget(tables map[string]*TableSchema, schema, table string) {
+	delete(tables, tableKey(schema, table))
+	p.forgotten = append(p.forgotten, TableSchema{Schema: schema, Table: table})
+}
+
+// skipIndexType skips the optional USING {BTREE|HASH} of an index.
+func (p *ddlParser) skipIndexType() {
+	if p.accept("USING") {
+		p.identifier()
+	}
+}
+
+func (p *ddlParser) alterTable(tables map[string]*TableSchema) {
+	schema, table, ok := p.tableName()
+	if !ok {
+		return
+	}
+	key := tableKey(schema, table)
+
+	src, ok := tables[key]
+	if !ok {
+		// untracked table
+		return
+	}
+
+	// alter a copy so that a failure leaves nothing half applied
+	ts := src.clone()
+
+	for !p.done() {
+		if !p.alterSpecification(ts) {
+			p.forget(tables, schema, table)
+			return
+		}
+
+		p.skip()
+		if !p.acceptPunct(",") {
+			break
+		}
+	}
+
+	delete(tables, key)
+	tables[tableKey(ts.Schema, ts.Table)] = ts
+}
+
+// alterSpecification applies a single ALTER TABLE specification, it returns
+// false if the specification affects the columns but could not be parsed.
+func (p *ddlParser) alterSpecification(ts *TableSchema) bool {
+	switch {
+	case p.accept("ADD"):
+		switch {
+		case p.accept("PRIMARY", "KEY"):
+			var ok bool
+			p.skipIndexType()
+			ts.PrimaryKey, ok = p.identifierList()
+			return ok
+
+		case p.accept("CONSTRAINT"):
+			if !p.is("PRIMARY", "UNIQUE", "FOREIGN", "CHECK") {
+				p.identifier()
+			}
+			if p.accept("PRIMARY", "KEY") {
+				var ok bool
+				p.skipIndexType()
+				ts.PrimaryKey, ok = p.identifierList()
+				return ok
+			}
+			return true
+
+		case p.is("KEY", "INDEX", "UNIQUE", "FOREIGN", "FULLTEXT",
+			"SPATIAL", "CHECK", "PARTITION", "PERIOD", "SYSTEM"):
+			return true
+
+		default:
+		}
+
+		p.accept("COLUMN")
+		p.accept("IF", "NOT", "EXISTS")
+
+		if p.acceptPunct("(") {
+			for {
+				c, primary, _, ok := p.columnDefinition()
+				if !ok {
+					return false
+				}
+				ts.addColumn(c, primary, columnPosition{})
+
+				if p.acceptPunct(")") {
+					return true
+				}
+				if !p.acceptPunct(",") {
+					return false
+				}
+			}
+		}
+
+		c, primary, pos, ok := p.columnDefinition()
+		if !ok {
+			return false
+		}
+		if ts.columnIndex(c.Name) >= 0 {
+			// ADD COLUMN IF NOT EXISTS of an existing column
+			return true
+		}
+		return ts.addColumn(c, primary, pos)
+
+	case p.accept("DROP"):
+		switch {
+		case p.accept("PRIMARY", "KEY"):
+			ts.PrimaryKey = nil
+			return true
+
+		case p.is("KEY", "INDEX", "FOREIGN", "CHECK", "CONSTRAINT",
+			"PARTITION", "PERIOD", "SYSTEM"):
+			return true
+
+		default:
+		}
+
+		p.accept("COLUMN")
+		p.accept("IF", "EXISTS")
+
+		name, ok := p.identifier()
+		if !ok {
+			return false
+		}
+		ts.dropColumn(name)
+		return true
+
+	case p.accept("CHANGE"):
+		p.accept("COLUMN")
+		p.accept("IF", "EXISTS")
+
+		name, ok := p.identifier()
+		if !ok {
+			return false
+		}
+
+		c, primary, pos, ok := p.columnDefinition()
+		if !ok {
+			return false
+		}
+		return ts.replaceColumn(name, c, primary, pos)
+
+	case p.accept("MODIFY"):
+		p.accept("COLUMN")
+		p.accept("IF", "EXISTS")
+
+		c, primary, pos, ok := p.columnDefinition()
+		if !ok {
+			return false
+		}
+		return ts.replaceColumn(c.Name, c, primary, pos)
+
+	case p.accept("RENAME"):
+		switch {
+		case p.accept("COLUMN"):
+			from, ok := p.identifier()
+			if !ok || !p.accept("TO") {
+				return false
+			}
+
+			to, ok := p.identifier()
+			if !ok {
+				return false
+			}
+			return ts.renameColumn(from, to)
+
+		case p.is("INDEX", "KEY"):
+			return true
+
+		default:
+		}
+
+		if !p.accept("TO") {
+			p.accept("AS")
+		}
+
+		schema, table, ok := p.tableName()
+		if !ok {
+			return false
+		}
+		ts.Schema, ts.Table = schema, table
+		return true
+
+	default:
+	}
+
+	// not affecting the columns (e.g. ENGINE=..., ALGORITHM=...)
+	return true
+}
+
+func (p *ddlParser) dropTable(tables map[string]*TableSchema) {
+	p.accept("IF", "EXISTS")
+
+	for {
+		schema, table, ok := p.tableName()
+		if !ok {
+			return
+		}
+		delete(tables, tableKey(schema, table))
+
+		if !p.acceptPunct(",") {
+			return
+		}
+	}
+}
+
+func (p *ddlParser) renameTable(tables map[string]*TableSchema) {
+	for {
+		fromSchema, fromTable, ok := p.tableName()
+		if !ok || !p.accept("TO") {
+			return
+		}
+
+		toSchema, toTable, ok := p.tableName()
+		if !ok {
+			return
+		}
+
+		from := tableKey(fromSchema, fromTable)
+		if ts, ok := tables[from]; ok {
+			delete(tables, from)
+			ts.Schema, ts.Table = toSchema, toTable
+			tables[tableKey(toSchema, toTable)] = ts
+		}
+
+		if !p.acceptPunct(",") {
+			return
+		}
+	}
+}
+
+// addColumn inserts the specified column at the specified position (last
+// by default).
+func (ts *TableSchema) addColumn(c ColumnSchema, primary bool,
+	pos columnPosition) bool {
+	i := len(ts.Columns)
+
+	if pos.first {
+		i = 0
+	} else if pos.after != "" {
+		if i = ts.columnIndex(pos.after); i < 0 {
+			return false
+		}
+		i++
+	}
+
+	ts.Columns = append(ts.Columns, ColumnSchema{})
+	copy(ts.Columns[i+1:], ts.Columns[i:])
+	ts.Columns[i] = c
+
+	if primary {
+		ts.PrimaryKey = []string{c.Name}
+	}
+	return true
+}
+
+func (ts *TableSchema) dropColumn(name string) {
+	if i := ts.columnIndex(name); i >= 0 {
+		ts.Columns = append(ts.Columns[:i], ts.Columns[i+1:]...)
+	}
+
+	for i := 0; i < len(ts.PrimaryKey); i++ {
+		if strings.EqualFold(ts.PrimaryKey[i], name) {
+			ts.PrimaryKey = append(ts.PrimaryKey[:i], ts.PrimaryKey[i+1:]...)
+			i--
+		}
+	}
+}
+
+// replaceColumn replaces the definition of the specified column (CHANGE and
+// MODIFY), moving it if a position is given.
+func (ts *TableSchema) replaceColumn(name string, c ColumnSchema,
+	primary bool, pos columnPosition) bool {
+	i := ts.columnIndex(name)
+	if i < 0 {
+		return false
+	}
+
+	if !ts.renameColumn(name, c.Name) {
+		return false
+	}
+
+	if pos.first || pos.after != "" {
+		ts.Columns = append(ts.Columns[:i], ts.Columns[i+1:]...)
+		return ts.addColumn(c, primary, pos)
+	}
+
+	ts.Columns[i] = c
+	if primary {
+		ts.PrimaryKey = []string{c.Name}
+	}
+	return true
+}
+
+func (ts *TableSchema) renameColumn(from, to string) bool {
+	i := ts.columnIndex(from)
+	if i < 0 {
+		return false
+	}
+	ts.Columns[i].Name = to
+
+	for j := range ts.PrimaryKey {
+		if strings.EqualFold(ts.PrimaryKey[j], from) {
+			ts.PrimaryKey[j] = to
+		}
+	}
+	return true
+}
@@ -0,0 +1,384 @@
+/*
+  The MIT License (MIT)
+
+  Copyright (c) 2015 Nirbhay Choubey
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in all
+  copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+  SOFTWARE.
+*/
+
+package mysql
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ChangeSource describes where a change event originates from.
+type ChangeSource struct {
+	File     string `json:"file"`
+	Pos      uint64 `json:"pos"`
+	Gtid     string `json:"gtid,omitempty"`
+	TsMs     int64  `json:"ts_ms"`
+	ServerId uint32 `json:"server_id"`
+	Db       string `json:"db,omitempty"`
+	Table    string `json:"table,omitempty"`
+	Row      int    `json:"row"`
+}
+
+// ChangeEvent is a Debezium-style envelope of a single row change.
+type ChangeEvent struct {
+	Before map[string]interface{} `json:"before"`
+	After  map[string]interface{} `json:"after"`
+	Op     string                 `json:"op"`
+	Source ChangeSource           `json:"source"`
+	TsMs   int64                  `json:"ts_ms"`
+}
+
+// SchemaChange is the record of a DDL statement.
+type SchemaChange struct {
+	Source       ChangeSource `json:"source"`
+	DatabaseName string       `json:"databaseName"`
+	Ddl          string       `json:"ddl"`
+}
+
+// change event operations
+const (
+	OP_CREATE = "c"
+	OP_UPDATE = "u"
+	OP_DELETE = "d"
+)
+
+// Source returns the source information of the last event read by Next.
+func (b *Binlog) Source() ChangeSource {
+	var s ChangeSource
+
+	s.File = b.index.file
+	s.TsMs = int64(b.current.header.timestamp) * 1000
+	s.ServerId = b.current.header.serverId
+
+	// position of the event itself (of its transaction payload, if any)
+	s.Pos = b.start
+
+	if b.gtid != nil {
+		s.Gtid = b.gtid.String()
+	} else if b.mariadbGtid != nil {
+		s.Gtid = b.mariadbGtid.String()
+	}
+	return s
+}
+
+// NewChangeEvents returns the change events for the rows of the specified
+// rows event. The columns are named after the table map meta data, columns
+// without a name are called col_<n> (1-based).
+func NewChangeEvents(ev *RowsEvent, source ChangeSource) ([]ChangeEvent, error) {
+	var op string
+
+	switch ev.header.type_ {
+	case PRE_GA_WRITE_ROWS_EVENT, WRITE_ROWS_EVENT_V1, WRITE_ROWS_EVENT:
+		op = OP_CREATE
+	case PRE_GA_UPDATE_ROWS_EVENT, UPDATE_ROWS_EVENT_V1, UPDATE_ROWS_EVENT,
+		PARTIAL_UPDATE_ROWS_EVENT:
+		op = OP_UPDATE
+	case PRE_GA_DELETE_ROWS_EVENT, DELETE_ROWS_EVENT_V1, DELETE_ROWS_EVENT:
+		op = OP_DELETE
+	default:
+		return nil, myError(ErrEventType, ev.header.type_)
+	}
+
+	if ev.tableMap == nil {
+		return nil, myError(ErrUnknownTable, ev.tableId)
+	}
+	if ev.err != nil {
+		return nil, ev.err
+	}
+
+	source.Db = ev.tableMap.schema
+	source.Table = ev.tableMap.table
+
+	changes := make([]ChangeEvent, 0, len(ev.rows1.Rows))
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+
+	for i, r := range ev.rows1.Rows {
+		c := ChangeEvent{Op: op, Source: source, TsMs: now}
+		c.Source.Row = i
+
+		image := changeImage(ev.tableMap, r)
+		switch op {
+		case OP_CREATE:
+			c.After = image
+		case OP_UPDATE:
+			c.Before = image
+			if i < len(ev.rows2.Rows) {
+				c.After = changeImage(ev.tableMap, ev.rows2.Rows[i])
+			}
+		case OP_DELETE:
+			c.Before = image
+		}
+		changes = append(changes, c)
+	}
+	return changes, nil
+}
+
+// NewSchemaChange returns the schema change record for the specified query
+// event, ok is false if the query is not a DDL statement.
+func NewSchemaChange(ev *QueryEvent, source ChangeSource) (c SchemaChange, ok bool) {
+	if !isDDL(ev.query) {
+		return c, false
+	}
+
+	source.Db = ev.schema
+
+	c.Source = source
+	c.DatabaseName = ev.schema
+	c.Ddl = ev.query
+	return c, true
+}
+
+// EncodeRowsEvent encodes the changes of the specified rows event, one JSON
+// document per row.
+func EncodeRowsEvent(ev *RowsEvent, source ChangeSource) ([][]byte, error) {
+	changes, err := NewChangeEvents(ev, source)
+	if err != nil {
+		return nil, err
+	}
+
+	docs := make([][]byte, 0, len(changes))
+	for i := range changes {
+		b, err := json.Marshal(&changes[i])
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, b)
+	}
+	return docs, nil
+}
+
+// EncodeQueryEvent encodes the schema change record of the specified query
+// event, nil if the query is not a DDL statement.
+func EncodeQueryEvent(ev *QueryEvent, source ChangeSource) ([]byte, error) {
+	c, ok := NewSchemaChange(ev, source)
+	if !ok {
+		return nil, nil
+	}
+	return json.Marshal(&c)
+}
+
+// changeImage returns the column name -> JSON value map of a row image.
+func changeImage(tableMap *TableMapEvent, r EventRow) map[string]interface{} {
+	image := make(map[string]interface{}, len(r.Columns))
+
+	for i, v := range r.Columns {
+		if i >= len(tableMap.columns) {
+			break
+		}
+		c := &tableMap.columns[i]
+
+		name := c.name
+		if name == "" {
+			name = "col_" + strconv.Itoa(i+1)
+		}
+		image[name] = jsonValue(c, v)
+	}
+	return image
+}
+
+// jsonValue maps a decoded column value to its JSON representation:
+//
+//	NULL                      null
+//	integers                  number (unsigned if so logged)
+//	ENUM, SET                 number (index, bitmap)
+//	FLOAT, DOUBLE             number
+//	DECIMAL                   string (exact value)
+//	DATE                      "2006-01-02"
+//	DATETIME                  "2006-01-02T15:04:05.999999"
+//	TIMESTAMP                 RFC 3339 in UTC
+//	zero dates                "0000-00-00[ 00:00:00]"
+//	TIME                      "[-]hh:mm:ss[.ffffff]"
+//	BLOB, BIT, GEOMETRY,
+//	binary strings and
+//	strings of unknown charset base64 encoded string
+//	other strings             string (converted to UTF-8)
+//	JSON                      JSON value
+func jsonValue(c *EventColumn, v interface{}) interface{} {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case int8:
+		if c.unsigned {
+			return uint8(v)
+		}
+		return v
+	case int16:
+		if c.unsigned {
+			return uint16(v)
+		}
+		return v
+	case int32:
+		if c.unsigned {
+			if c.type_ == _TYPE_INT24 {
+				return uint32(v) & 0xffffff
+			}
+			return uint32(v)
+		}
+		return v
+	case int64:
+		if c.unsigned {
+			return uint64(v)
+		}
+		return v
+	case uint16, uint64:
+		// ENUM index, SET bitmap
+		return v
+	case float32:
+		return json.Number(strconv.FormatFloat(float64(v), 'g', -1, 32))
+	case float64:
+		return v
+	case time.Time:
+		switch c.type_ {
+		case _TYPE_DATE, _TYPE_NEW_DATE:
+			return v.Format("2006-01-02")
+		case _TYPE_TIMESTAMP, _TYPE_TIMESTAMP2:
+			return v.UTC().Format(time.RFC3339Nano)
+		default:
+		}
+		return v.Format("2006-01-02T15:04:05.999999")
+	case time.Duration:
+		return formatTimeValue(v)
+	case string:
+		return jsonString(c, v)
+	case []byte:
+		return jsonString(c, string(v))
+	case json.RawMessage, []JSONDiff:
+		return v
+	default:
+	}
+	return fmt.Sprint(v)
+}
+
+// _BINARY_CHARSET is the collation id of the binary character set.
+const _BINARY_CHARSET = 63
+
+// isBinaryColumn returns whether the values of the specified column have to
+// be treated as bytes. Without charset meta data, blob columns are assumed
+// to be binary.
+func isBinaryColumn(c *EventColumn) bool {
+	switch c.type_ {
+	case _TYPE_BIT, _TYPE_GEOMETRY:
+		return true
+	case _TYPE_BLOB, _TYPE_TINY_BLOB, _TYPE_MEDIUM_BLOB, _TYPE_LONG_BLOB:
+		return c.charset == 0 || c.charset == _BINARY_CHARSET
+	default:
+	}
+	return c.charset == _BINARY_CHARSET
+}
+
+// jsonString returns the specified string value of the given column, text
+// being converted to UTF-8. Binary values and text of an unknown character set
+// (e.g. without charset meta data) are returned as bytes, base64 encoded in
+// JSON, as they would not survive the UTF-8 encoding.
+func jsonString(c *EventColumn, v string) interface{} {
+	if !isCharacterType(c) && !isBinaryColumn(c) {
+		// DECIMAL, zero date
+		return v
+	}
+	if s, ok := columnText(c, v); ok {
+		return s
+	}
+	return []byte(v)
+}
+
+// columnText returns the specified value of a character column converted to
+// UTF-8, ok being false if the column character set is binary or unknown.
+func columnText(c *EventColumn, v string) (s string, ok bool) {
+	switch collationCharset(uint32(c.charset)) {
+	case _CHARSET_OTHER, _CHARSET_BINARY:
+		return "", false
+	default:
+	}
+	s, ok = decodeCharsetString([]byte(v), uint32(c.charset)).(string)
+	return
+}
+
+// formatTimeValue formats a TIME value as [-]hh:mm:ss[.ffffff].
+func formatTimeValue(d time.Duration) string {
+	var sign string
+
+	if d < 0 {
+		sign = "-"
+		d = -d
+	}
+
+	h := d / time.Hour
+	m := (d % time.Hour) / time.Minute
+	s := (d % time.Minute) / time.Second
+	us := (d % time.Second) / time.Microsecond
+
+	if us != 0 {
+		return fmt.Sprintf("%s%02d:%02d:%02d.%06d", sign, h, m, s, us)
+	}
+	return fmt.Sprintf("%s%02d:%02d:%02d", sign, h, m, s)
+}
+
+// isDDL returns whether the specified query is a DDL statement.
+func isDDL(query string) bool {
+	switch strings.ToUpper(firstKeyword(query)) {
+	case "CREATE", "ALTER", "DROP", "RENAME", "TRUNCATE":
+		return true
+	default:
+	}
+	return false
+}
+
+// firstKeyword returns the first keyword of the specified statement,
+// skipping the leading white spaces and comments.
+func firstKeyword(query string) string {
+	for {
+		query = strings.TrimLeft(query, " \t\r\n")
+
+		switch {
+		case strings.HasPrefix(query, "/*"):
+			end := strings.Index(query, "*/")
+			if end < 0 {
+				return ""
+			}
+			query = query[end+2:]
+			continue
+		case strings.HasPrefix(query, "#"), strings.HasPrefix(query, "-- "):
+			end := strings.IndexByte(query, '\n')
+			if end < 0 {
+				return ""
+			}
+			query = query[end+1:]
+			continue
+		default:
+		}
+		break
+	}
+
+	end := strings.IndexFunc(query, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '_')
+	})
+	if end < 0 {
+		return query
+	}
+	return query[:end]
+}
@@ -0,0 +1,205 @@
+/*
+  The MIT License (MIT)
+
+  Copyright (c) 2015 Nirbhay Choubey
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in all
+  copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+  SOFTWARE.
+*/
+
+package mysql
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestChangeEvents(t *testing.T) {
+	sid := mustParseUUID(t, testSid1)
+
+	e := new(evBuilder)
+	e.rotate("bin.000002", 4, true)
+	e.fde()
+	e.gtid(sid, 3)
+	e.query("test", "BEGIN")
+	// id INT, v INT UNSIGNED
+	e.tableMap(1, "test", "t1", []byte{_TYPE_LONG, _TYPE_LONG}, nil,
+		_TABLE_MAP_SIGNEDNESS, 1, 0x40,
+		_TABLE_MAP_COLUMN_NAME, 5, 2, 'i', 'd', 1, 'v')
+	e.rows(WRITE_ROWS_EVENT, 1, 0, 2, rowLong(1, -1), rowLong(2, 5))
+	start := uint64(e.pos)
+	e.rows(UPDATE_ROWS_EVENT, 1, 0, 2, rowLong(1, -1), rowLong(1, 7))
+	// columns without a name
+	e.tableMap(2, "test", "t2", []byte{_TYPE_LONG, _TYPE_LONG}, nil)
+	e.rows(DELETE_ROWS_EVENT, 2, STMT_END_F, 2, rowLong(3, -3))
+
+	type change struct {
+		Before map[string]interface{} `json:"before"`
+		After  map[string]interface{} `json:"after"`
+		Op     string                 `json:"op"`
+		Source ChangeSource           `json:"source"`
+	}
+
+	source := ChangeSource{File: "bin.000002", Gtid: testSid1 + ":3",
+		TsMs: 1500000000000, ServerId: 1, Db: "test", Table: "t1"}
+
+	want := []change{
+		{nil, map[string]interface{}{"id": 1.0, "v": 4294967295.0},
+			OP_CREATE, source},
+		{nil, map[string]interface{}{"id": 2.0, "v": 5.0}, OP_CREATE, source},
+		{map[string]interface{}{"id": 1.0, "v": 4294967295.0},
+			map[string]interface{}{"id": 1.0, "v": 7.0}, OP_UPDATE, source},
+		{map[string]interface{}{"col_1": 3.0, "col_2": -3.0}, nil,
+			OP_DELETE, source},
+	}
+	want[0].Source.Pos = start - uint64(len(e.events[5]))
+	want[1].Source.Pos, want[1].Source.Row = want[0].Source.Pos, 1
+	want[2].Source.Pos = start
+	want[3].Source.Pos = uint64(e.pos) - uint64(len(e.events[8]))
+	want[3].Source.Table = "t2"
+
+	b := newTestBinlog(e)
+
+	var got []change
+	for b.Next() {
+		re, err := b.RawEvent()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ev, ok := re.Event().(*RowsEvent)
+		if !ok {
+			continue
+		}
+
+		docs, err := EncodeRowsEvent(ev, b.Source())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, doc := range docs {
+			var c change
+			if err = json.Unmarshal(doc, &c); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, c)
+		}
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+
+	// the rows can't be named without the table map
+	ev := &RowsEvent{header: eventHeader{type_: WRITE_ROWS_EVENT}, tableId: 9}
+	if _, err := NewChangeEvents(ev, ChangeSource{}); err == nil {
+		t.Error("NewChangeEvents without table map: expected error")
+	}
+}
+
+func TestJSONValue(t *testing.T) {
+	tests := []struct {
+		column EventColumn
+		value  interface{}
+		want   string
+	}{
+		{EventColumn{type_: _TYPE_LONG}, nil, `null`},
+		{EventColumn{type_: _TYPE_TINY, unsigned: true}, int8(-1), `255`},
+		{EventColumn{type_: _TYPE_INT24, unsigned: true}, int32(-1), `16777215`},
+		{EventColumn{type_: _TYPE_LONG_LONG}, int64(-5), `-5`},
+		{EventColumn{type_: _TYPE_LONG_LONG, unsigned: true}, int64(-1),
+			`18446744073709551615`},
+		{EventColumn{type_: _TYPE_ENUM}, uint16(2), `2`},
+		{EventColumn{type_: _TYPE_FLOAT}, float32(0.1), `0.1`},
+		{EventColumn{type_: _TYPE_NEW_DECIMAL}, "-1.50", `"-1.50"`},
+		{EventColumn{type_: _TYPE_DATE},
+			time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC), `"2020-02-29"`},
+		{EventColumn{type_: _TYPE_DATETIME2},
+			time.Date(2020, 2, 29, 13, 4, 5, 120000000, time.UTC),
+			`"2020-02-29T13:04:05.12"`},
+		{EventColumn{type_: _TYPE_TIMESTAMP2},
+			time.Unix(1500000000, 0), `"2017-07-14T02:40:00Z"`},
+		{EventColumn{type_: _TYPE_TIME2},
+			-(25*time.Hour + 500*time.Microsecond), `"-25:00:00.000500"`},
+		{EventColumn{type_: _TYPE_VARCHAR, charset: 33}, "ab", `"ab"`},
+		{EventColumn{type_: _TYPE_VARCHAR, charset: _BINARY_CHARSET},
+			"ab", `"YWI="`},
+		{EventColumn{type_: _TYPE_BLOB}, []byte("ab"), `"YWI="`},
+		{EventColumn{type_: _TYPE_BLOB, charset: 33}, []byte("ab"), `"ab"`},
+		{EventColumn{type_: _TYPE_VARCHAR, charset: 8}, "caf\xe9", `"café"`},
+		{EventColumn{type_: _TYPE_VARCHAR, charset: 35}, "\x00a\x20\xac",
+			`"a€"`},
+		{EventColumn{type_: _TYPE_STRING, meta: 4<<8 | _TYPE_STRING,
+			charset: 56}, "a\x00", `"a"`},
+		// unknown charset (no meta data), gbk
+		{EventColumn{type_: _TYPE_VARCHAR}, "caf\xe9", `"Y2Fm6Q=="`},
+		{EventColumn{type_: _TYPE_VARCHAR, charset: 28}, "ab", `"YWI="`},
+		{EventColumn{type_: _TYPE_DATE}, "0000-00-00", `"0000-00-00"`},
+		{EventColumn{type_: _TYPE_JSON}, json.RawMessage(`{"a":[1]}`),
+			`{"a":[1]}`},
+	}
+
+	for _, test := range tests {
+		b, err := json.Marshal(jsonValue(&test.column, test.value))
+		if err != nil {
+			t.Errorf("%v: %v", test.value, err)
+		} else if string(b) != test.want {
+			t.Errorf("%v: got %s, want %s", test.value, b, test.want)
+		}
+	}
+}
+
+func TestSchemaChange(t *testing.T) {
+	tests := []struct {
+		query string
+		ddl   bool
+	}{
+		{"CREATE TABLE t1 (a INT)", true},
+		{"alter table t1 add b int", true},
+		{"/* comment */ DROP TABLE t1", true},
+		{"# comment\n  RENAME TABLE t1 TO t2", true},
+		{"-- comment\nTRUNCATE t1", true},
+		{"INSERT INTO t1 VALUES (1)", false},
+		{"BEGIN", false},
+		{"/* unterminated comment", false},
+	}
+
+	source := ChangeSource{File: "bin.000001", Pos: 120}
+	for _, test := range tests {
+		ev := &QueryEvent{schema: "test", query: test.query}
+		doc, err := EncodeQueryEvent(ev, source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !test.ddl {
+			if doc != nil {
+				t.Errorf("%q: got %s", test.query, doc)
+			}
+			continue
+		}
+
+		var c SchemaChange
+		if err = json.Unmarshal(doc, &c); err != nil {
+			t.Fatal(err)
+		}
+		if c.Ddl != test.query || c.DatabaseName != "test" ||
+			c.Source.Db != "test" || c.Source.Pos != 120 {
+			t.Errorf("%q: got %+v", test.query, c)
+		}
+	}
+}
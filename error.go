@@ -59,6 +59,16 @@ const (
 	ErrNetPacketTooLarge
 	ErrNetPacketsOutOfOrder
 	ErrEventChecksumFailure
+	ErrInvalidGtid
+	ErrEventType
+	ErrUnknownTable
+	ErrInvalidJSON
+	ErrInvalidRow
+	ErrRowNotFound
+	ErrSignedness
+	ErrBinlogPosition
+	ErrCharset
+	ErrXATransaction
 )
 
 var errFormat = map[uint16]string{
@@ -82,6 +92,16 @@ var errFormat = map[uint16]string{
 	ErrNetPacketTooLarge:    "Got a packet bigger than MaxAllowedPacket",
 	ErrNetPacketsOutOfOrder: "Got packets out of order",
 	ErrEventChecksumFailure: "Replication event checksum failed",
+	ErrInvalidGtid:          "Invalid GTID '%s'",
+	ErrEventType:            "Unexpected event type (%d)",
+	ErrUnknownTable:         "Unknown table id (%d)",
+	ErrInvalidJSON:          "Invalid JSON value (%s)",
+	ErrInvalidRow:           "Can't decode row image (%s)",
+	ErrRowNotFound:          "Can't find the row to change in %s (%s)",
+	ErrSignedness:           "Can't tell whether %d is unsigned, the column signedness is unknown",
+	ErrBinlogPosition:       "Can't stream the binlog from %s:%d (%s)",
+	ErrCharset:              "Can't convert the values of column %s of %s to UTF-8, its character set is unknown (%d)",
+	ErrXATransaction:        "Can't apply XA transaction %s",
 }
 
 func myError(code uint16, a ...interface{}) *Error {
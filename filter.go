@@ -0,0 +1,232 @@
+/*
+  The MIT License (MIT)
+
+  Copyright (c) 2015 Nirbhay Choubey
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in all
+  copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+  SOFTWARE.
+*/
+
+package mysql
+
+import (
+	"path"
+	"strings"
+)
+
+// EventFilter decides which binlog events get delivered by Binlog.Next. The
+// filtering is done on the client side: the server still sends all the
+// events, the filter only saves decoding and delivering them. The table
+// patterns apply to TABLE_MAP_EVENT and rows events, the latter get
+// skipped without decoding their rows. The domain filters apply to all the
+// events of a MariaDB event group (GTID_EVENT to commit).
+//
+// An event is delivered if it matches at least one of the includes (when
+// there are any) and none of the excludes.
+type EventFilter struct {
+	includeTables []tablePattern
+	excludeTables []tablePattern
+
+	includeTypes map[uint8]bool
+	excludeTypes map[uint8]bool
+
+	includeDomains map[uint32]bool
+	excludeDomains map[uint32]bool
+}
+
+// tablePattern represents a schema.table pattern; shell wildcards (*, ? and
+// [...]) are allowed in both the parts.
+type tablePattern struct {
+	schema string
+	table  string
+}
+
+func NewEventFilter() *EventFilter {
+	return &EventFilter{
+		includeTypes:   make(map[uint8]bool),
+		excludeTypes:   make(map[uint8]bool),
+		includeDomains: make(map[uint32]bool),
+		excludeDomains: make(map[uint32]bool),
+	}
+}
+
+// IncludeTable includes the tables matching the specified pattern
+// (e.g. shop.order*, *.audit); a pattern without table part matches all the
+// tables of the schema.
+func (f *EventFilter) IncludeTable(pattern string) error {
+	p, err := parseTablePattern(pattern)
+	if err != nil {
+		return err
+	}
+	f.includeTables = append(f.includeTables, p)
+	return nil
+}
+
+// ExcludeTable excludes the tables matching the specified pattern.
+func (f *EventFilter) ExcludeTable(pattern string) error {
+	p, err := parseTablePattern(pattern)
+	if err != nil {
+		return err
+	}
+	f.excludeTables = append(f.excludeTables, p)
+	return nil
+}
+
+// IncludeEventTypes includes the events of the specified types.
+func (f *EventFilter) IncludeEventTypes(types ...uint8) {
+	for _, t := range types {
+		f.includeTypes[t] = true
+	}
+}
+
+// ExcludeEventTypes excludes the events of the specified types.
+func (f *EventFilter) ExcludeEventTypes(types ...uint8) {
+	for _, t := range types {
+		f.excludeTypes[t] = true
+	}
+}
+
+// IncludeDomains includes the event groups of the specified MariaDB GTID
+// domains.
+func (f *EventFilter) IncludeDomains(ids ...uint32) {
+	for _, id := range ids {
+		f.includeDomains[id] = true
+	}
+}
+
+// ExcludeDomains excludes the event groups of the specified MariaDB GTID
+// domains.
+func (f *EventFilter) ExcludeDomains(ids ...uint32) {
+	for _, id := range ids {
+		f.excludeDomains[id] = true
+	}
+}
+
+// SetFilter sets the client-side filter for the events delivered by Next; nil
+// removes the filter. Note: the filtered events are still received from the
+// server and read to keep track of the binlog context (position, GTIDs,
+// transactions, etc.).
+func (b *Binlog) SetFilter(f *EventFilter) {
+	b.filter = f
+}
+
+// match returns whether the specified event passes the filter; domain is
+// the MariaDB GTID domain of the event group it belongs to (nil if none).
+func (f *EventFilter) match(re *RawEvent, domain *uint32) bool {
+	type_ := re.header.type_
+
+	if len(f.includeTypes) > 0 && !f.includeTypes[type_] {
+		return false
+	}
+
+	if f.excludeTypes[type_] {
+		return false
+	}
+
+	if domain != nil {
+		if len(f.includeDomains) > 0 && !f.includeDomains[*domain] {
+			return false
+		}
+
+		if f.excludeDomains[*domain] {
+			return false
+		}
+	}
+
+	if type_ == TABLE_MAP_EVENT || isRowsEvent(uncompressedEventType(type_)) {
+		return f.matchTable(re.tableMap)
+	}
+	return true
+}
+
+// matchTable returns whether the specified table passes the table filters.
+func (f *EventFilter) matchTable(tableMap *TableMapEvent) bool {
+	if tableMap == nil {
+		// unknown table, only matches if nothing is explicitly included
+		return len(f.includeTables) == 0
+	}
+
+	if len(f.includeTables) > 0 {
+		var included bool
+
+		for _, p := range f.includeTables {
+			if p.match(tableMap.schema, tableMap.table) {
+				included = true
+				break
+			}
+		}
+
+		if !included {
+			return false
+		}
+	}
+
+	for _, p := range f.excludeTables {
+		if p.match(tableMap.schema, tableMap.table) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseTablePattern parses the specified schema[.table] pattern.
+func parseTablePattern(pattern string) (tablePattern, error) {
+	var p tablePattern
+
+	v := strings.SplitN(pattern, ".", 2)
+
+	p.schema = v[0]
+	if len(v) == 2 {
+		p.table = v[1]
+	} else {
+		p.table = "*"
+	}
+
+	// verify the pattern syntax
+	for _, s := range []string{p.schema, p.table} {
+		if _, err := path.Match(s, ""); err != nil {
+			return p, myError(ErrInvalidPropertyValue, "table pattern", pattern)
+		}
+	}
+	return p, nil
+}
+
+func (p tablePattern) match(schema, table string) bool {
+	ok, _ := path.Match(p.schema, schema)
+	if !ok {
+		return false
+	}
+
+	ok, _ = path.Match(p.table, table)
+	return ok
+}
+
+// isRowsEvent returns whether the events of the specified type are rows
+// events.
+func isRowsEvent(type_ uint8) bool {
+	switch type_ {
+	case PRE_GA_WRITE_ROWS_EVENT, PRE_GA_UPDATE_ROWS_EVENT,
+		PRE_GA_DELETE_ROWS_EVENT, WRITE_ROWS_EVENT_V1,
+		UPDATE_ROWS_EVENT_V1, DELETE_ROWS_EVENT_V1,
+		WRITE_ROWS_EVENT, UPDATE_ROWS_EVENT, DELETE_ROWS_EVENT,
+		PARTIAL_UPDATE_ROWS_EVENT:
+		return true
+	default:
+	}
+	return false
+}
@@ -0,0 +1,608 @@
+/*
+  The MIT License (MIT)
+
+  Copyright (c) 2016 Nirbhay Choubey
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in all
+  copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+  SOFTWARE.
+*/
+
+package mysql
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// GTIDSet represents a set of MySQL global transaction identifiers, grouped
+// by source id and tag (e.g. 3E11FA47-71CA-11E1-9E33-C80AA9429562:1-5:7 or,
+// with MySQL 8.3+ tags, 3E11FA47-71CA-11E1-9E33-C80AA9429562:1-5:blue:1-3).
+type GTIDSet struct {
+	sids []gtidSid // sorted by source id, then tag
+}
+
+type gtidSid struct {
+	sid       UUID
+	tag       string         // empty if untagged
+	intervals []gtidInterval // sorted & non-overlapping
+}
+
+// gtidInterval represents the range of transaction ids [start, end).
+type gtidInterval struct {
+	start int64
+	end   int64
+}
+
+// binary format of the GTID sets with tags
+const (
+	_GTID_FORMAT_TAGGED = 1
+	_GTID_TAG_MAX_SIZE  = 32
+)
+
+// ParseGTIDSet parses the specified GTID set in MySQL's text format.
+func ParseGTIDSet(s string) (*GTIDSet, error) {
+	set := new(GTIDSet)
+
+	s = strings.Replace(strings.TrimSpace(s), "\n", "", -1)
+	if s == "" {
+		return set, nil
+	}
+
+	for _, sidStr := range strings.Split(s, ",") {
+		v := strings.Split(strings.TrimSpace(sidStr), ":")
+		if len(v) < 2 {
+			return nil, myError(ErrInvalidGtid, sidStr)
+		}
+
+		sid, err := parseUUID(v[0])
+		if err != nil {
+			return nil, err
+		}
+
+		// intervals, each tag applying to the intervals following it
+		var (
+			tag       string
+			intervals int
+		)
+
+		for _, intervalStr := range v[1:] {
+			var (
+				start, end int64
+				err        error
+			)
+
+			if isGtidTagStart(intervalStr) {
+				if tag != "" && intervals == 0 {
+					// tag without intervals
+					return nil, myError(ErrInvalidGtid, sidStr)
+				}
+
+				if tag, err = parseGtidTag(intervalStr); err != nil {
+					return nil, err
+				}
+				intervals = 0
+				continue
+			}
+
+			r := strings.Split(intervalStr, "-")
+			if start, err = strconv.ParseInt(r[0], 10, 64); err != nil || start < 1 {
+				return nil, myError(ErrInvalidGtid, sidStr)
+			}
+
+			switch len(r) {
+			case 1:
+				end = start
+			case 2:
+				if end, err = strconv.ParseInt(r[1], 10, 64); err != nil || end < start {
+					return nil, myError(ErrInvalidGtid, sidStr)
+				}
+			default:
+				return nil, myError(ErrInvalidGtid, sidStr)
+			}
+			set.addInterval(sid, tag, gtidInterval{start, end + 1})
+			intervals++
+		}
+
+		if intervals == 0 {
+			return nil, myError(ErrInvalidGtid, sidStr)
+		}
+	}
+	return set, nil
+}
+
+// isGtidTagStart returns whether the specified part of a GTID set is a tag
+// (tags start with a letter or an underscore).
+func isGtidTagStart(s string) bool {
+	if s == "" {
+		return false
+	}
+	c := s[0]
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// parseGtidTag verifies the specified GTID tag (up to 32 letters, digits
+// or underscores, not starting with a digit) and returns it in lower case.
+func parseGtidTag(s string) (string, error) {
+	if len(s) == 0 || len(s) > _GTID_TAG_MAX_SIZE || !isGtidTagStart(s) {
+		return "", myError(ErrInvalidGtid, s)
+	}
+
+	for _, c := range []byte(s) {
+		if c != '_' && !(c >= 'a' && c <= 'z') &&
+			!(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') {
+			return "", myError(ErrInvalidGtid, s)
+		}
+	}
+	return strings.ToLower(s), nil
+}
+
+// String returns the GTID set in MySQL's text format.
+func (set *GTIDSet) String() string {
+	var res []string
+
+	for i, s := range set.sids {
+		var str string
+
+		if i > 0 && set.sids[i-1].sid == s.sid {
+			// another tag of the same source id
+			str = res[len(res)-1]
+			res = res[:len(res)-1]
+		} else {
+			str = s.sid.String()
+		}
+
+		if s.tag != "" {
+			str += ":" + s.tag
+		}
+
+		for _, i := range s.intervals {
+			str += ":" + strconv.FormatInt(i.start, 10)
+			if i.end-1 > i.start {
+				str += "-" + strconv.FormatInt(i.end-1, 10)
+			}
+		}
+		res = append(res, str)
+	}
+	return strings.Join(res, ",")
+}
+
+// Add adds the specified GTID to the set.
+func (set *GTIDSet) Add(gtid MysqlGtid) {
+	set.addInterval(gtid.sourceId, gtid.tag,
+		gtidInterval{gtid.groupNumber, gtid.groupNumber + 1})
+}
+
+// compareSid orders the source ids of a set, then their tags.
+func compareSid(a gtidSid, sid UUID, tag string) int {
+	if c := bytes.Compare(a.sid.data[:], sid.data[:]); c != 0 {
+		return c
+	}
+	return strings.Compare(a.tag, tag)
+}
+
+// addInterval adds the specified interval of transaction ids of the given
+// source id and tag to the set, merging it with the existing intervals.
+func (set *GTIDSet) addInterval(sid UUID, tag string, interval gtidInterval) {
+	var i int
+
+	// locate the source id, add it if not found
+	for i = 0; i < len(set.sids); i++ {
+		if c := compareSid(set.sids[i], sid, tag); c == 0 {
+			break
+		} else if c > 0 {
+			set.sids = append(set.sids, gtidSid{})
+			copy(set.sids[i+1:], set.sids[i:])
+			set.sids[i] = gtidSid{sid: sid, tag: tag}
+			break
+		}
+	}
+
+	if i == len(set.sids) {
+		set.sids = append(set.sids, gtidSid{sid: sid, tag: tag})
+	}
+
+	s := &set.sids[i]
+
+	// merge all intervals overlapping (or adjacent to) the new one
+	merged := make([]gtidInterval, 0, len(s.intervals)+1)
+	for _, cur := range s.intervals {
+		if cur.end < interval.start || cur.start > interval.end {
+			merged = append(merged, cur)
+			continue
+		}
+		if cur.start < interval.start {
+			interval.start = cur.start
+		}
+		if cur.end > interval.end {
+			interval.end = cur.end
+		}
+	}
+
+	// insert the (merged) interval at its place
+	i = 0
+	for i < len(merged) && merged[i].start < interval.start {
+		i++
+	}
+	merged = append(merged, gtidInterval{})
+	copy(merged[i+1:], merged[i:])
+	merged[i] = interval
+
+	s.intervals = merged
+}
+
+// Contains returns whether the specified GTID is in the set.
+func (set *GTIDSet) Contains(gtid MysqlGtid) bool {
+	s := set.lookup(gtid.sourceId, gtid.tag)
+	if s == nil {
+		return false
+	}
+
+	for _, i := range s.intervals {
+		if gtid.groupNumber >= i.start && gtid.groupNumber < i.end {
+			return true
+		}
+	}
+	return false
+}
+
+// ContainsSet returns whether all the GTIDs of the specified set are also in
+// this set.
+func (set *GTIDSet) ContainsSet(other *GTIDSet) bool {
+	return other.Subtract(set).IsEmpty()
+}
+
+// Equal returns whether both the sets contain the same GTIDs.
+func (set *GTIDSet) Equal(other *GTIDSet) bool {
+	return set.String() == other.String()
+}
+
+// IsEmpty returns whether the set contains no GTIDs.
+func (set *GTIDSet) IsEmpty() bool {
+	return len(set.sids) == 0
+}
+
+// Clone returns a copy of the set.
+func (set *GTIDSet) Clone() *GTIDSet {
+	clone := new(GTIDSet)
+	clone.sids = make([]gtidSid, len(set.sids))
+
+	for i, s := range set.sids {
+		clone.sids[i].sid = s.sid
+		clone.sids[i].tag = s.tag
+		clone.sids[i].intervals = make([]gtidInterval, len(s.intervals))
+		copy(clone.sids[i].intervals, s.intervals)
+	}
+	return clone
+}
+
+// Union returns a new set with the GTIDs contained in either of the sets.
+func (set *GTIDSet) Union(other *GTIDSet) *GTIDSet {
+	res := set.Clone()
+
+	for _, s := range other.sids {
+		for _, i := range s.intervals {
+			res.addInterval(s.sid, s.tag, i)
+		}
+	}
+	return res
+}
+
+// Subtract returns a new set with the GTIDs of this set which are not
+// contained in the specified set (e.g. the transactions that a replica with
+// executed set 'other' is missing).
+func (set *GTIDSet) Subtract(other *GTIDSet) *GTIDSet {
+	res := new(GTIDSet)
+
+	for _, s := range set.sids {
+		intervals := s.intervals
+
+		if o := other.lookup(s.sid, s.tag); o != nil {
+			intervals = subtractIntervals(intervals, o.intervals)
+		}
+
+		if len(intervals) > 0 {
+			res.sids = append(res.sids, gtidSid{s.sid, s.tag, intervals})
+		}
+	}
+	return res
+}
+
+// lookup returns the intervals of the specified source id and tag, nil if
+// they are not in the set.
+func (set *GTIDSet) lookup(sid UUID, tag string) *gtidSid {
+	for i := range set.sids {
+		if set.sids[i].sid == sid && set.sids[i].tag == tag {
+			return &set.sids[i]
+		}
+	}
+	return nil
+}
+
+// tagged returns whether the set contains tagged GTIDs.
+func (set *GTIDSet) tagged() bool {
+	for _, s := range set.sids {
+		if s.tag != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// subtractIntervals returns the parts of intervals a not covered by any of the
+// intervals b; both the lists must be sorted.
+func subtractIntervals(a, b []gtidInterval) []gtidInterval {
+	res := make([]gtidInterval, 0, len(a))
+
+	for _, cur := range a {
+		for _, sub := range b {
+			if sub.end <= cur.start || sub.start >= cur.end {
+				// no overlap
+				continue
+			}
+
+			if sub.start > cur.start {
+				res = append(res, gtidInterval{cur.start, sub.start})
+			}
+			cur.start = sub.end
+
+			if cur.start >= cur.end {
+				break
+			}
+		}
+
+		if cur.start < cur.end {
+			res = append(res, cur)
+		}
+	}
+	return res
+}
+
+// Encode returns the set in binary format, as used by COM_BINLOG_DUMP_GTID
+// and PREVIOUS_GTIDS_LOG_EVENT. Sets with tagged GTIDs are encoded in the
+// tagged format (MySQL 8.3+).
+func (set *GTIDSet) Encode() []byte {
+	b := make([]byte, set.encodedLength())
+	set.encode(b)
+	return b
+}
+
+// DecodeGTIDSet decodes the specified GTID set stored in binary format.
+func DecodeGTIDSet(b []byte) (*GTIDSet, error) {
+	set, _, err := decodeGTIDSet(b)
+	return set, err
+}
+
+// decodeGTIDSet decodes the GTID set stored in binary format (tagged or not)
+// and returns the number of bytes read.
+func decodeGTIDSet(b []byte) (*GTIDSet, int, error) {
+	var (
+		off    int
+		sid    UUID
+		tag    string
+		tagged bool
+	)
+
+	set := new(GTIDSet)
+
+	if len(b) < 8 {
+		return nil, 0, myError(ErrInvalidGtid, "truncated GTID set")
+	}
+
+	sidCount := binary.LittleEndian.Uint64(b[off:])
+	off += 8
+
+	// tagged format: the format in the first and last bytes, the number of
+	// source ids in between
+	if b[0] == _GTID_FORMAT_TAGGED && b[7] == _GTID_FORMAT_TAGGED {
+		tagged = true
+		sidCount = (sidCount >> 8) & 0xffffffffffff
+	}
+
+	for i := uint64(0); i < sidCount; i++ {
+		if len(b[off:]) < 16 {
+			return nil, 0, myError(ErrInvalidGtid, "truncated GTID set")
+		}
+
+		copy(sid.data[:], b[off:off+16])
+		off += 16
+
+		if tagged {
+			if len(b[off:]) < 1 {
+				return nil, 0, myError(ErrInvalidGtid, "truncated GTID set")
+			}
+
+			length, n := getVarUint(b[off:])
+			off += n
+
+			if uint64(len(b[off:])) < length {
+				return nil, 0, myError(ErrInvalidGtid, "truncated GTID set")
+			}
+
+			var err error
+			if tag = string(b[off : off+int(length)]); tag != "" {
+				if tag, err = parseGtidTag(tag); err != nil {
+					return nil, 0, err
+				}
+			}
+			off += int(length)
+		}
+
+		if len(b[off:]) < 8 {
+			return nil, 0, myError(ErrInvalidGtid, "truncated GTID set")
+		}
+
+		intervalCount := binary.LittleEndian.Uint64(b[off:])
+		off += 8
+
+		if uint64(len(b[off:])) < 16*intervalCount {
+			return nil, 0, myError(ErrInvalidGtid, "truncated GTID set")
+		}
+
+		for j := uint64(0); j < intervalCount; j++ {
+			var interval gtidInterval
+
+			interval.start = getInt64(b[off:])
+			off += 8
+			interval.end = getInt64(b[off:])
+			off += 8
+
+			if interval.start < 1 || interval.end <= interval.start {
+				return nil, 0, myError(ErrInvalidGtid,
+					sid.String()+":"+strconv.FormatInt(interval.start, 10))
+			}
+			set.addInterval(sid, tag, interval)
+		}
+	}
+	return set, off, nil
+}
+
+// encodedLength returns the size of the set encoded in binary format.
+func (set *GTIDSet) encodedLength() int {
+	tagged := set.tagged()
+
+	length := 8
+	for _, s := range set.sids {
+		length += 16 + 8 + 16*len(s.intervals)
+		if tagged {
+			length += varUintSize(uint64(len(s.tag))) + len(s.tag)
+		}
+	}
+	return length
+}
+
+// encode stores the set into the specified buffer in the binary format (as
+// used by COM_BINLOG_DUMP_GTID and PREVIOUS_GTIDS_LOG_EVENT) and returns the
+// number of bytes written.
+func (set *GTIDSet) encode(b []byte) int {
+	var off int
+
+	tagged := set.tagged()
+
+	if tagged {
+		binary.LittleEndian.PutUint64(b[off:],
+			uint64(len(set.sids))<<8|_GTID_FORMAT_TAGGED<<56|_GTID_FORMAT_TAGGED)
+	} else {
+		binary.LittleEndian.PutUint64(b[off:], uint64(len(set.sids)))
+	}
+	off += 8
+
+	for _, s := range set.sids {
+		off += copy(b[off:], s.sid.data[:])
+
+		if tagged {
+			off += putVarUint(b[off:], uint64(len(s.tag)))
+			off += copy(b[off:], s.tag)
+		}
+
+		binary.LittleEndian.PutUint64(b[off:], uint64(len(s.intervals)))
+		off += 8
+
+		for _, i := range s.intervals {
+			binary.LittleEndian.PutUint64(b[off:], uint64(i.start))
+			off += 8
+			binary.LittleEndian.PutUint64(b[off:], uint64(i.end))
+			off += 8
+		}
+	}
+	return off
+}
+
+// parseMariadbGtid parses the specified MariaDB GTID (domain-server-seqno).
+func parseMariadbGtid(s string) (MariadbGtid, error) {
+	var (
+		gtid MariadbGtid
+		v    uint64
+		err  error
+	)
+
+	r := strings.Split(strings.TrimSpace(s), "-")
+	if len(r) != 3 {
+		return gtid, myError(ErrInvalidGtid, s)
+	}
+
+	if v, err = strconv.ParseUint(r[0], 10, 32); err != nil {
+		return gtid, myError(ErrInvalidGtid, s)
+	}
+	gtid.domainId = uint32(v)
+
+	if v, err = strconv.ParseUint(r[1], 10, 32); err != nil {
+		return gtid, myError(ErrInvalidGtid, s)
+	}
+	gtid.serverId = uint32(v)
+
+	if gtid.seqno, err = strconv.ParseUint(r[2], 10, 64); err != nil {
+		return gtid, myError(ErrInvalidGtid, s)
+	}
+	return gtid, nil
+}
+
+// mariadbGtidPos represents a MariaDB GTID position, i.e. the last GTID
+// applied in each replication domain.
+type mariadbGtidPos map[uint32]MariadbGtid
+
+// parseMariadbGtidPos parses the specified comma-separated list of MariaDB
+// GTIDs (at most one per domain), as in @@gtid_slave_pos.
+func parseMariadbGtidPos(s string) (mariadbGtidPos, error) {
+	pos := make(mariadbGtidPos)
+
+	if strings.TrimSpace(s) == "" {
+		return pos, nil
+	}
+
+	for _, v := range strings.Split(s, ",") {
+		gtid, err := parseMariadbGtid(v)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := pos[gtid.domainId]; ok {
+			// only one GTID per domain is allowed
+			return nil, myError(ErrInvalidGtid, s)
+		}
+		pos[gtid.domainId] = gtid
+	}
+	return pos, nil
+}
+
+// String returns the position as a comma-separated list of GTIDs, sorted by
+// domain id.
+func (pos mariadbGtidPos) String() string {
+	var (
+		domains []int
+		res     []string
+	)
+
+	for id := range pos {
+		domains = append(domains, int(id))
+	}
+	sort.Ints(domains)
+
+	for _, id := range domains {
+		gtid := pos[uint32(id)]
+		res = append(res, gtid.String())
+	}
+	return strings.Join(res, ",")
+}
+
+// update sets the specified GTID as the current position of its domain.
+func (pos mariadbGtidPos) update(gtid MariadbGtid) {
+	pos[gtid.domainId] = gtid
+}
@@ -0,0 +1,405 @@
+/*
+  The MIT License (MIT)
+
+  Copyright (c) 2015 Nirbhay Choubey
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in all
+  copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+  SOFTWARE.
+*/
+
+package mysql
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const (
+	testSid1 = "00000000-0000-0000-0000-000000000001"
+	testSid2 = "3e11fa47-71ca-11e1-9e33-c80aa9429562"
+)
+
+func mustParseGTIDSet(t *testing.T, s string) *GTIDSet {
+	set, err := ParseGTIDSet(s)
+	if err != nil {
+		t.Fatalf("ParseGTIDSet(%q): %v", s, err)
+	}
+	return set
+}
+
+func mustParseUUID(t *testing.T, s string) UUID {
+	u, err := parseUUID(s)
+	if err != nil {
+		t.Fatalf("parseUUID(%q): %v", s, err)
+	}
+	return u
+}
+
+func TestParseGTIDSet(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"", ""},
+		{testSid1 + ":1", testSid1 + ":1"},
+		{testSid1 + ":1-5", testSid1 + ":1-5"},
+		{testSid1 + ":1-1", testSid1 + ":1"},
+		// merging of overlapping and adjacent intervals
+		{testSid1 + ":1-5:6-10", testSid1 + ":1-10"},
+		{testSid1 + ":3-8:1-5", testSid1 + ":1-8"},
+		{testSid1 + ":7:1-5:6", testSid1 + ":1-7"},
+		{testSid1 + ":1-3:5-7", testSid1 + ":1-3:5-7"},
+		{testSid1 + ":5-7:1-3:2-6", testSid1 + ":1-7"},
+		// multiple source ids, sorted
+		{testSid2 + ":1-3," + testSid1 + ":4", testSid1 + ":4," + testSid2 + ":1-3"},
+		{testSid1 + ":1,\n" + testSid1 + ":2", testSid1 + ":1-2"},
+		{" " + testSid1 + ":1 ", testSid1 + ":1"},
+		{"3E11FA47-71CA-11E1-9E33-C80AA9429562:9", testSid2 + ":9"},
+	}
+
+	for _, test := range tests {
+		set := mustParseGTIDSet(t, test.in)
+		if got := set.String(); got != test.want {
+			t.Errorf("ParseGTIDSet(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestParseGTIDSetInvalid(t *testing.T) {
+	tests := []string{
+		testSid1,
+		testSid1 + ":",
+		testSid1 + ":0",
+		testSid1 + ":-1",
+		testSid1 + ":5-3",
+		testSid1 + ":1-2-3",
+		testSid1 + ":a",
+		"zz:1",
+	}
+
+	for _, test := range tests {
+		if _, err := ParseGTIDSet(test); err == nil {
+			t.Errorf("ParseGTIDSet(%q): expected error", test)
+		}
+	}
+}
+
+func TestGTIDSetAdd(t *testing.T) {
+	tests := []struct {
+		set  string
+		gno  int64
+		want string
+	}{
+		{"", 1, testSid1 + ":1"},
+		{testSid1 + ":1-5", 6, testSid1 + ":1-6"},
+		{testSid1 + ":2-5", 1, testSid1 + ":1-5"},
+		{testSid1 + ":1-5", 3, testSid1 + ":1-5"},
+		{testSid1 + ":1-5", 5, testSid1 + ":1-5"},
+		{testSid1 + ":1-5", 7, testSid1 + ":1-5:7"},
+		{testSid1 + ":1-5:7-9", 6, testSid1 + ":1-9"},
+		{testSid2 + ":1", 1, testSid1 + ":1," + testSid2 + ":1"},
+	}
+
+	sid := mustParseUUID(t, testSid1)
+	for _, test := range tests {
+		set := mustParseGTIDSet(t, test.set)
+		set.Add(MysqlGtid{sourceId: sid, groupNumber: test.gno})
+		if got := set.String(); got != test.want {
+			t.Errorf("%q + %d = %q, want %q", test.set, test.gno, got, test.want)
+		}
+	}
+}
+
+func TestGTIDSetContains(t *testing.T) {
+	set := mustParseGTIDSet(t, testSid1+":1-5:10,"+testSid2+":3")
+	sid1, sid2 := mustParseUUID(t, testSid1), mustParseUUID(t, testSid2)
+
+	tests := []struct {
+		sid  UUID
+		gno  int64
+		want bool
+	}{
+		{sid1, 1, true},
+		{sid1, 5, true},
+		{sid1, 6, false},
+		{sid1, 9, false},
+		{sid1, 10, true},
+		{sid1, 11, false},
+		{sid2, 3, true},
+		{sid2, 1, false},
+		{UUID{}, 1, false},
+	}
+
+	for _, test := range tests {
+		gtid := MysqlGtid{sourceId: test.sid, groupNumber: test.gno}
+		if got := set.Contains(gtid); got != test.want {
+			t.Errorf("Contains(%s) = %v, want %v", gtid.String(), got, test.want)
+		}
+	}
+
+	if !set.ContainsSet(mustParseGTIDSet(t, testSid1+":2-4:10")) {
+		t.Error("ContainsSet of a subset = false")
+	}
+	if set.ContainsSet(mustParseGTIDSet(t, testSid1+":4-6")) {
+		t.Error("ContainsSet of an overlapping set = true")
+	}
+}
+
+func TestGTIDSetUnion(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want string
+	}{
+		{"", "", ""},
+		{testSid1 + ":1-3", "", testSid1 + ":1-3"},
+		{testSid1 + ":1-3", testSid1 + ":4-6", testSid1 + ":1-6"},
+		{testSid1 + ":1-3", testSid1 + ":2-8", testSid1 + ":1-8"},
+		{testSid1 + ":1-3:9", testSid1 + ":5", testSid1 + ":1-3:5:9"},
+		{testSid1 + ":1", testSid2 + ":1", testSid1 + ":1," + testSid2 + ":1"},
+	}
+
+	for _, test := range tests {
+		a := mustParseGTIDSet(t, test.a)
+		b := mustParseGTIDSet(t, test.b)
+		if got := a.Union(b).String(); got != test.want {
+			t.Errorf("%q + %q = %q, want %q", test.a, test.b, got, test.want)
+		}
+		if a.String() != mustParseGTIDSet(t, test.a).String() {
+			t.Errorf("Union modified %q", test.a)
+		}
+	}
+}
+
+func TestGTIDSetSubtract(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want string
+	}{
+		{testSid1 + ":1-10", "", testSid1 + ":1-10"},
+		{testSid1 + ":1-10", testSid1 + ":1-10", ""},
+		{testSid1 + ":1-10", testSid1 + ":1-3", testSid1 + ":4-10"},
+		{testSid1 + ":1-10", testSid1 + ":8-12", testSid1 + ":1-7"},
+		{testSid1 + ":1-10", testSid1 + ":3-4:6", testSid1 + ":1-2:5:7-10"},
+		{testSid1 + ":1-3:7-9", testSid1 + ":2-8", testSid1 + ":1:9"},
+		{testSid1 + ":1-10", testSid2 + ":1-10", testSid1 + ":1-10"},
+		{testSid1 + ":1-5," + testSid2 + ":1-5", testSid2 + ":1-5", testSid1 + ":1-5"},
+	}
+
+	for _, test := range tests {
+		a := mustParseGTIDSet(t, test.a)
+		b := mustParseGTIDSet(t, test.b)
+		if got := a.Subtract(b).String(); got != test.want {
+			t.Errorf("%q - %q = %q, want %q", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestGTIDSetEncode(t *testing.T) {
+	tests := []string{
+		"",
+		testSid1 + ":1",
+		testSid1 + ":1-5:7:9-20",
+		testSid1 + ":1-3," + testSid2 + ":5-6",
+	}
+
+	for _, test := range tests {
+		set := mustParseGTIDSet(t, test)
+		b := set.Encode()
+
+		decoded, err := DecodeGTIDSet(b)
+		if err != nil {
+			t.Fatalf("DecodeGTIDSet(%q): %v", test, err)
+		}
+		if !decoded.Equal(set) {
+			t.Errorf("round trip of %q = %q", test, decoded.String())
+		}
+	}
+
+	// SID block layout: count, sid, interval count, [start, end)
+	set := mustParseGTIDSet(t, testSid1+":1-5")
+	want := []byte{
+		1, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1,
+		1, 0, 0, 0, 0, 0, 0, 0,
+		1, 0, 0, 0, 0, 0, 0, 0,
+		6, 0, 0, 0, 0, 0, 0, 0,
+	}
+	if got := set.Encode(); !bytes.Equal(got, want) {
+		t.Errorf("Encode(%q) = %v, want %v", set.String(), got, want)
+	}
+
+	// truncated or invalid encodings
+	for _, b := range [][]byte{nil, want[:8+16], want[:len(want)-1],
+		append(append([]byte(nil), want[:40]...), 1, 0, 0, 0, 0, 0, 0, 0)} {
+		if _, err := DecodeGTIDSet(b); err == nil {
+			t.Errorf("DecodeGTIDSet(%v): expected error", b)
+		}
+	}
+}
+
+func TestGTIDSetTags(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{testSid1 + ":tag_1:1-5", testSid1 + ":tag_1:1-5"},
+		{testSid1 + ":TAG_1:1-5", testSid1 + ":tag_1:1-5"},
+		{testSid1 + ":1-3:blue:1-5:7", testSid1 + ":1-3:blue:1-5:7"},
+		// untagged intervals first, then the tags in order
+		{testSid1 + ":red:1:blue:2:3", testSid1 + ":blue:2-3:red:1"},
+		{testSid1 + ":red:1,\n" + testSid1 + ":4", testSid1 + ":4:red:1"},
+		{testSid1 + ":blue:1-3," + testSid1 + ":blue:4", testSid1 + ":blue:1-4"},
+		{testSid2 + ":a:1," + testSid1 + ":b:2", testSid1 + ":b:2," + testSid2 + ":a:1"},
+		{testSid1 + ":_x9:1", testSid1 + ":_x9:1"},
+	}
+
+	for _, test := range tests {
+		set := mustParseGTIDSet(t, test.in)
+		if got := set.String(); got != test.want {
+			t.Errorf("ParseGTIDSet(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+
+	for _, test := range []string{
+		testSid1 + ":tag",
+		testSid1 + ":tag:",
+		testSid1 + ":a:b:1",
+		testSid1 + ":1:tag",
+		testSid1 + ":tag-1:1",
+		testSid1 + ":" + strings.Repeat("x", 33) + ":1",
+	} {
+		if _, err := ParseGTIDSet(test); err == nil {
+			t.Errorf("ParseGTIDSet(%q): expected error", test)
+		}
+	}
+
+	set := mustParseGTIDSet(t, testSid1+":1-5:blue:1-3")
+	sid := mustParseUUID(t, testSid1)
+
+	set.Add(MysqlGtid{sourceId: sid, tag: "blue", groupNumber: 4})
+	set.Add(MysqlGtid{sourceId: sid, tag: "red", groupNumber: 1})
+	if got, want := set.String(), testSid1+":1-5:blue:1-4:red:1"; got != want {
+		t.Errorf("Add = %q, want %q", got, want)
+	}
+
+	if !set.Contains(MysqlGtid{sourceId: sid, tag: "blue", groupNumber: 4}) ||
+		set.Contains(MysqlGtid{sourceId: sid, tag: "blue", groupNumber: 5}) ||
+		set.Contains(MysqlGtid{sourceId: sid, tag: "green", groupNumber: 1}) {
+		t.Error("Contains of tagged GTIDs")
+	}
+
+	sub := set.Subtract(mustParseGTIDSet(t, testSid1+":1-5:blue:2"))
+	if got, want := sub.String(), testSid1+":blue:1:3-4:red:1"; got != want {
+		t.Errorf("Subtract = %q, want %q", got, want)
+	}
+}
+
+func TestGTIDSetEncodeTagged(t *testing.T) {
+	set := mustParseGTIDSet(t, testSid1+":1-2:ab:5")
+
+	// tagged format: format, source ids, format; then the source ids
+	// followed by their tags (empty for the untagged GTIDs)
+	sid := make([]byte, 16)
+	sid[15] = 1
+	want := []byte{1, 2, 0, 0, 0, 0, 0, 1}
+	want = append(want, sid...)
+	want = append(want, 0)
+	want = append(want, 1, 0, 0, 0, 0, 0, 0, 0)
+	want = append(want, 1, 0, 0, 0, 0, 0, 0, 0, 3, 0, 0, 0, 0, 0, 0, 0)
+	want = append(want, sid...)
+	want = append(want, 2<<1, 'a', 'b')
+	want = append(want, 1, 0, 0, 0, 0, 0, 0, 0)
+	want = append(want, 5, 0, 0, 0, 0, 0, 0, 0, 6, 0, 0, 0, 0, 0, 0, 0)
+
+	b := set.Encode()
+	if !bytes.Equal(b, want) {
+		t.Errorf("Encode(%q) = %v, want %v", set.String(), b, want)
+	}
+
+	decoded, err := DecodeGTIDSet(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.Equal(set) {
+		t.Errorf("round trip of %q = %q", set.String(), decoded.String())
+	}
+
+	if _, err = DecodeGTIDSet(b[:len(want)-20]); err == nil {
+		t.Error("DecodeGTIDSet of a truncated set: expected error")
+	}
+}
+
+func TestVarUint(t *testing.T) {
+	for _, v := range []uint64{0, 1, 127, 128, 1<<14 - 1, 1 << 14,
+		1<<56 - 1, 1 << 56, 1<<64 - 1} {
+		b := make([]byte, 9)
+		n := putVarUint(b, v)
+		if n != varUintSize(v) {
+			t.Errorf("putVarUint(%d) wrote %d bytes, size %d", v, n,
+				varUintSize(v))
+		}
+
+		got, m := getVarUint(b[:n])
+		if got != v || m != n {
+			t.Errorf("getVarUint(putVarUint(%d)) = %d (%d bytes), want %d bytes",
+				v, got, m, n)
+		}
+	}
+}
+
+func TestParseMariadbGtidPos(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"", ""},
+		{"0-1-5", "0-1-5"},
+		{"1-2-7,0-1-5", "0-1-5,1-2-7"},
+		{" 0-1-5 , 1-2-7 ", "0-1-5,1-2-7"},
+		{"4294967295-4294967295-18446744073709551615",
+			"4294967295-4294967295-18446744073709551615"},
+	}
+
+	for _, test := range tests {
+		pos, err := parseMariadbGtidPos(test.in)
+		if err != nil {
+			t.Errorf("parseMariadbGtidPos(%q): %v", test.in, err)
+		} else if got := pos.String(); got != test.want {
+			t.Errorf("parseMariadbGtidPos(%q) = %q, want %q", test.in,
+				got, test.want)
+		}
+	}
+
+	invalid := []string{
+		"0-1",
+		"0-1-5-6",
+		"0-1-a",
+		"-1-1-1",
+		"4294967296-1-1",
+		"0-1-5,",
+		// one GTID per domain
+		"0-1-5,0-2-6",
+	}
+
+	for _, test := range invalid {
+		if _, err := parseMariadbGtidPos(test); err == nil {
+			t.Errorf("parseMariadbGtidPos(%q): expected error", test)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+/*
+  The MIT License (MIT)
+
+  Copyright (c) 2015 Nirbhay Choubey
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in all
+  copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+  SOFTWARE.
+*/
+
+package mysql
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+)
+
+// fakeReader delivers the events built by an evBuilder.
+type fakeReader struct {
+	events [][]byte
+	pos    int
+	cur    []byte
+}
+
+func (f *fakeReader) begin(index binlogIndex) error { return nil }
+func (f *fakeReader) close() error                  { return nil }
+func (f *fakeReader) next() bool {
+	if f.pos >= len(f.events) {
+		return false
+	}
+	f.cur = f.events[f.pos]
+	f.pos++
+	return true
+}
+func (f *fakeReader) event() []byte         { return f.cur }
+func (f *fakeReader) error() error          { return nil }
+func (f *fakeReader) rotate(string, uint64) {}
+
+// evBuilder builds the binlog events of the tests, one after the other.
+type evBuilder struct {
+	pos      uint32
+	checksum bool
+	events   [][]byte
+}
+
+// add appends an event of the specified type and body.
+func (e *evBuilder) add(type_ uint8, body []byte) []byte {
+	size := 19 + len(body)
+	if e.checksum {
+		size += 4
+	}
+	if e.pos == 0 {
+		e.pos = 4
+	}
+	e.pos += uint32(size)
+	b := make([]byte, 19, size)
+	binary.LittleEndian.PutUint32(b[0:], 1500000000)
+	b[4] = type_
+	binary.LittleEndian.PutUint32(b[5:], 1)
+	binary.LittleEndian.PutUint32(b[9:], uint32(size))
+	binary.LittleEndian.PutUint32(b[13:], e.pos)
+	b = append(b, body...)
+	if e.checksum {
+		var c [4]byte
+		binary.LittleEndian.PutUint32(c[:], crc32.ChecksumIEEE(b))
+		b = append(b, c[:]...)
+	}
+	e.events = append(e.events, b)
+	return b
+}
+
+// postHeaderLengths returns the post-header lengths of a MySQL 5.7 FDE.
+func postHeaderLengths() []byte {
+	p := make([]byte, 40)
+	p[QUERY_EVENT-1] = 13
+	p[ROTATE_EVENT-1] = 8
+	p[TABLE_MAP_EVENT-1] = 8
+	p[PARTIAL_UPDATE_ROWS_EVENT-1] = 10
+	for _, t := range []int{WRITE_ROWS_EVENT_V1, UPDATE_ROWS_EVENT_V1, DELETE_ROWS_EVENT_V1} {
+		p[t-1] = 8
+	}
+	for _, t := range []int{WRITE_ROWS_EVENT, UPDATE_ROWS_EVENT, DELETE_ROWS_EVENT} {
+		p[t-1] = 10
+	}
+	p[GTID_LOG_EVENT-1] = 42
+	p[ANONYMOUS_GTID_LOG_EVENT-1] = 42
+	return p
+}
+
+// fde appends a format description event.
+func (e *evBuilder) fde() {
+	b := make([]byte, 2+50+4+1)
+	binary.LittleEndian.PutUint16(b, 4)
+	copy(b[2:], "5.7.30-log")
+	b[56] = 19
+	b = append(b, postHeaderLengths()...)
+	if e.checksum {
+		b = append(b, BINLOG_CHECKSUM_ALG_CRC32)
+	} else {
+		b = append(b, BINLOG_CHECKSUM_ALG_OFF, 0, 0, 0, 0)
+	}
+	e.add(FORMAT_DESCRIPTION_EVENT, b)
+}
+
+// query appends a query event.
+func (e *evBuilder) query(schema, q string) {
+	b := make([]byte, 13)
+	b[8] = byte(len(schema))
+	b = append(b, schema...)
+	b = append(b, 0)
+	b = append(b, q...)
+	e.add(QUERY_EVENT, b)
+}
+
+// xid appends a XID event.
+func (e *evBuilder) xid(x uint64) {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, x)
+	e.add(XID_EVENT, b)
+}
+
+// gtid appends a GTID event.
+func (e *evBuilder) gtid(sid UUID, gno int64) {
+	b := make([]byte, 1+16+8)
+	copy(b[1:], sid.data[:])
+	binary.LittleEndian.PutUint64(b[17:], uint64(gno))
+	b = append(b, make([]byte, 17)...)
+	e.add(GTID_LOG_EVENT, b)
+}
+
+// tableMap appends a table map event, the columns being given by their types
+// and meta data.
+func (e *evBuilder) tableMap(id uint64, schema, table string, types []byte, meta []byte, opt ...byte) {
+	b := make([]byte, 8)
+	b[0] = byte(id)
+	b = append(b, byte(len(schema)))
+	b = append(b, schema...)
+	b = append(b, 0, byte(len(table)))
+	b = append(b, table...)
+	b = append(b, 0, byte(len(types)))
+	b = append(b, types...)
+	b = append(b, byte(len(meta)))
+	b = append(b, meta...)
+	nb := make([]byte, (len(types)+7)/8)
+	for i := range nb {
+		nb[i] = 0xff
+	}
+	b = append(b, nb...)
+	b = append(b, opt...)
+	e.add(TABLE_MAP_EVENT, b)
+}
+
+// rows appends a rows event (v2), the rows being encoded (null bitmap and
+// values).
+func (e *evBuilder) rows(type_ uint8, id uint64, flags uint16, ncols int, rows ...[]byte) {
+	b := make([]byte, 10)
+	b[0] = byte(id)
+	binary.LittleEndian.PutUint16(b[6:], flags)
+	b[8] = 2
+	b = append(b, byte(ncols))
+	bm := make([]byte, (ncols+7)/8)
+	for i := range bm {
+		bm[i] = 0xff
+	}
+	b = append(b, bm...)
+	if type_ == UPDATE_ROWS_EVENT {
+		b = append(b, bm...)
+	}
+	for _, r := range rows {
+		b = append(b, r...)
+	}
+	e.add(type_, b)
+}
+
+// newTestBinlog returns a binlog reading the built events.
+func newTestBinlog(e *evBuilder) *Binlog {
+	b := new(Binlog)
+	b.reader = &fakeReader{events: e.events}
+	b.checksum = new(checksumOff)
+	return b
+}
+
+// rowLong returns the encoding of a row of INT columns.
+func rowLong(vals ...int32) []byte {
+	r := []byte{0}
+	for _, v := range vals {
+		var x [4]byte
+		binary.LittleEndian.PutUint32(x[:], uint32(v))
+		r = append(r, x[:]...)
+	}
+	return r
+}
+
+// readEvents returns the built events as read by a binlog.
+func readEvents(t *testing.T, e *evBuilder) []Event {
+	b := newTestBinlog(e)
+
+	var events []Event
+	for b.Next() {
+		re, err := b.RawEvent()
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, re.Event())
+	}
+	if err := b.Error(); err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
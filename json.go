@@ -0,0 +1,743 @@
+/*
+  The MIT License (MIT)
+
+  Copyright (c) 2015 Nirbhay Choubey
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in all
+  copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+  SOFTWARE.
+*/
+
+package mysql
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// binary JSON value types
+const (
+	_JSON_SMALL_OBJECT = 0x00
+	_JSON_LARGE_OBJECT = 0x01
+	_JSON_SMALL_ARRAY  = 0x02
+	_JSON_LARGE_ARRAY  = 0x03
+	_JSON_LITERAL      = 0x04
+	_JSON_INT16        = 0x05
+	_JSON_UINT16       = 0x06
+	_JSON_INT32        = 0x07
+	_JSON_UINT32       = 0x08
+	_JSON_INT64        = 0x09
+	_JSON_UINT64       = 0x0a
+	_JSON_DOUBLE       = 0x0b
+	_JSON_STRING       = 0x0c
+	_JSON_OPAQUE       = 0x0f
+)
+
+// binary JSON literals
+const (
+	_JSON_NULL  = 0x00
+	_JSON_TRUE  = 0x01
+	_JSON_FALSE = 0x02
+)
+
+// DecodeJSON decodes the specified value stored in MySQL's binary JSON
+// format. JSON values are mapped as follows :
+//
+//	null                    nil
+//	true, false             bool
+//	integers                int64 (uint64 for unsigned)
+//	doubles                 float64
+//	strings                 string
+//	arrays                  []interface{}
+//	objects                 map[string]interface{}
+//	decimals                json.Number
+//	dates/times             string
+//	other opaque values     string (base64:type<n>:<data>)
+func DecodeJSON(data []byte) (interface{}, error) {
+	// empty value (e.g. column added with no default)
+	if len(data) == 0 {
+		return nil, nil
+	}
+	return decodeJSONValue(data[0], data[1:])
+}
+
+func decodeJSONValue(type_ uint8, data []byte) (interface{}, error) {
+	switch type_ {
+	case _JSON_SMALL_OBJECT:
+		return decodeJSONContainer(data, false, true)
+	case _JSON_LARGE_OBJECT:
+		return decodeJSONContainer(data, true, true)
+	case _JSON_SMALL_ARRAY:
+		return decodeJSONContainer(data, false, false)
+	case _JSON_LARGE_ARRAY:
+		return decodeJSONContainer(data, true, false)
+	case _JSON_LITERAL:
+		if len(data) < 1 {
+			return nil, jsonTruncated()
+		}
+		switch data[0] {
+		case _JSON_NULL:
+			return nil, nil
+		case _JSON_TRUE:
+			return true, nil
+		case _JSON_FALSE:
+			return false, nil
+		default:
+		}
+		return nil, myError(ErrInvalidJSON,
+			fmt.Sprintf("unknown literal %d", data[0]))
+	case _JSON_INT16:
+		if len(data) < 2 {
+			return nil, jsonTruncated()
+		}
+		return int64(getInt16(data)), nil
+	case _JSON_UINT16:
+		if len(data) < 2 {
+			return nil, jsonTruncated()
+		}
+		return uint64(binary.LittleEndian.Uint16(data)), nil
+	case _JSON_INT32:
+		if len(data) < 4 {
+			return nil, jsonTruncated()
+		}
+		return int64(getInt32(data)), nil
+	case _JSON_UINT32:
+		if len(data) < 4 {
+			return nil, jsonTruncated()
+		}
+		return uint64(binary.LittleEndian.Uint32(data)), nil
+	case _JSON_INT64:
+		if len(data) < 8 {
+			return nil, jsonTruncated()
+		}
+		return getInt64(data), nil
+	case _JSON_UINT64:
+		if len(data) < 8 {
+			return nil, jsonTruncated()
+		}
+		return binary.LittleEndian.Uint64(data), nil
+	case _JSON_DOUBLE:
+		if len(data) < 8 {
+			return nil, jsonTruncated()
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(data)), nil
+	case _JSON_STRING:
+		length, n, err := getJSONVarLength(data)
+		if err != nil {
+			return nil, err
+		}
+		if n+length > len(data) {
+			return nil, jsonTruncated()
+		}
+		return string(data[n : n+length]), nil
+	case _JSON_OPAQUE:
+		return decodeJSONOpaque(data)
+	default:
+	}
+	return nil, myError(ErrInvalidJSON, fmt.Sprintf("unknown type %d", type_))
+}
+
+// decodeJSONContainer decodes an object or an array : element count and
+// size, followed by the key entries (objects), the value entries and the
+// keys and values they point to (offsets are relative to the container).
+func decodeJSONContainer(data []byte, large bool, object bool) (interface{}, error) {
+	var (
+		off       int
+		count     int
+		size      int
+		entrySize int
+	)
+
+	offsetSize := 2
+	if large {
+		offsetSize = 4
+	}
+
+	readOffset := func(b []byte) int {
+		if large {
+			return int(binary.LittleEndian.Uint32(b))
+		}
+		return int(binary.LittleEndian.Uint16(b))
+	}
+
+	if len(data) < 2*offsetSize {
+		return nil, jsonTruncated()
+	}
+
+	count = readOffset(data)
+	off += offsetSize
+	size = readOffset(data[off:])
+	off += offsetSize
+
+	if size > len(data) {
+		return nil, jsonTruncated()
+	}
+	data = data[:size]
+
+	// value entry : type and offset (or inlined value)
+	entrySize = 1 + offsetSize
+
+	var keys []string
+
+	if object {
+		// key entry : offset and (2-byte) length
+		if off+count*(offsetSize+2) > len(data) {
+			return nil, jsonTruncated()
+		}
+
+		keys = make([]string, count)
+		for i := 0; i < count; i++ {
+			keyOffset := readOffset(data[off:])
+			keyLength := int(binary.LittleEndian.Uint16(data[off+offsetSize:]))
+			off += offsetSize + 2
+
+			if keyOffset+keyLength > len(data) {
+				return nil, jsonTruncated()
+			}
+			keys[i] = string(data[keyOffset : keyOffset+keyLength])
+		}
+	}
+
+	if off+count*entrySize > len(data) {
+		return nil, jsonTruncated()
+	}
+
+	values := make([]interface{}, count)
+	for i := 0; i < count; i++ {
+		var err error
+
+		type_ := data[off]
+
+		if isJSONInlined(type_, large) {
+			values[i], err = decodeJSONValue(type_, data[off+1:off+entrySize])
+		} else {
+			valueOffset := readOffset(data[off+1:])
+			if valueOffset >= len(data) {
+				return nil, jsonTruncated()
+			}
+			values[i], err = decodeJSONValue(type_, data[valueOffset:])
+		}
+		if err != nil {
+			return nil, err
+		}
+		off += entrySize
+	}
+
+	if !object {
+		return values, nil
+	}
+
+	m := make(map[string]interface{}, count)
+	for i := 0; i < count; i++ {
+		m[keys[i]] = values[i]
+	}
+	return m, nil
+}
+
+// isJSONInlined returns whether the values of the specified type are stored
+// in the value entry of the container rather than pointed to.
+func isJSONInlined(type_ uint8, large bool) bool {
+	switch type_ {
+	case _JSON_LITERAL, _JSON_INT16, _JSON_UINT16:
+		return true
+	case _JSON_INT32, _JSON_UINT32:
+		return large
+	default:
+	}
+	return false
+}
+
+// decodeJSONOpaque decodes an opaque value : the MySQL type of the value,
+// its length and its data.
+func decodeJSONOpaque(data []byte) (interface{}, error) {
+	if len(data) < 1 {
+		return nil, jsonTruncated()
+	}
+
+	type_ := data[0]
+	length, n, err := getJSONVarLength(data[1:])
+	if err != nil {
+		return nil, err
+	}
+	if 1+n+length > len(data) {
+		return nil, jsonTruncated()
+	}
+	data = data[1+n : 1+n+length]
+
+	switch type_ {
+	case _TYPE_NEW_DECIMAL:
+		// precision, scale and the binary decimal
+		if len(data) < 2 {
+			return nil, jsonTruncated()
+		}
+		v, n := decodeDecimal(data[2:], int(data[0]), int(data[1]))
+		if n == 0 {
+			return nil, jsonTruncated()
+		}
+		return json.Number(v), nil
+
+	case _TYPE_DATE, _TYPE_DATETIME, _TYPE_TIMESTAMP, _TYPE_TIME:
+		if len(data) < 8 {
+			return nil, jsonTruncated()
+		}
+		return formatJSONTemporal(type_, getInt64(data)), nil
+
+	default:
+	}
+	return fmt.Sprintf("base64:type%d:%s", type_,
+		base64.StdEncoding.EncodeToString(data)), nil
+}
+
+// formatJSONTemporal formats a date/time value stored in the packed integer
+// format used by the binary JSON values.
+func formatJSONTemporal(type_ uint8, v int64) string {
+	var sign string
+
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+
+	frac := v & 0xffffff
+	v >>= 24
+
+	if type_ == _TYPE_TIME {
+		hms := v
+		return fmt.Sprintf("%s%02d:%02d:%02d.%06d", sign, hms>>12,
+			(hms>>6)&0x3f, hms&0x3f, frac)
+	}
+
+	ymd := v >> 17
+	hms := v & (1<<17 - 1)
+	ym := ymd >> 5
+
+	date := fmt.Sprintf("%04d-%02d-%02d", ym/13, ym%13, ymd&0x1f)
+	if type_ == _TYPE_DATE {
+		return date
+	}
+	return fmt.Sprintf("%s %02d:%02d:%02d.%06d", date, hms>>12,
+		(hms>>6)&0x3f, hms&0x3f, frac)
+}
+
+// getJSONVarLength retrieves a length stored with 7 bits per byte (the high
+// bit set when more bytes follow) and returns the number of bytes read.
+func getJSONVarLength(data []byte) (length int, n int, err error) {
+	for n < 5 {
+		if n >= len(data) {
+			return 0, 0, jsonTruncated()
+		}
+		b := data[n]
+		length |= int(b&0x7f) << uint(7*n)
+		n++
+		if b&0x80 == 0 {
+			return length, n, nil
+		}
+	}
+	return 0, 0, myError(ErrInvalidJSON, "invalid length")
+}
+
+func jsonTruncated() error {
+	return myError(ErrInvalidJSON, "truncated value")
+}
+
+// FormatJSON returns the JSON text of the specified value (as returned by
+// DecodeJSON) the way MySQL prints it : object keys sorted by length, then
+// bytes, and a space after commas and colons.
+func FormatJSON(v interface{}) string {
+	var buf bytes.Buffer
+
+	writeJSON(&buf, v)
+	return buf.String()
+}
+
+func writeJSON(buf *bytes.Buffer, v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case int64:
+		buf.WriteString(strconv.FormatInt(v, 10))
+	case uint64:
+		buf.WriteString(strconv.FormatUint(v, 10))
+	case float64:
+		buf.WriteString(formatJSONDouble(v))
+	case json.Number:
+		buf.WriteString(string(v))
+	case string:
+		writeJSONString(buf, v)
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, e := range v {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			writeJSON(buf, e)
+		}
+		buf.WriteByte(']')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Sort(jsonKeys(keys))
+
+		buf.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			writeJSONString(buf, k)
+			buf.WriteString(": ")
+			writeJSON(buf, v[k])
+		}
+		buf.WriteByte('}')
+	default:
+		writeJSONString(buf, fmt.Sprint(v))
+	}
+}
+
+// formatJSONDouble formats a double, always with a fractional part or an
+// exponent (so that it reads back as a double).
+func formatJSONDouble(v float64) string {
+	s := strconv.FormatFloat(v, 'g', -1, 64)
+	s = strings.Replace(s, "e+", "e", 1)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return s
+}
+
+func writeJSONString(buf *bytes.Buffer, s string) {
+	buf.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				fmt.Fprintf(buf, `\u%04x`, r)
+			} else {
+				buf.WriteRune(r)
+			}
+		}
+	}
+	buf.WriteByte('"')
+}
+
+// jsonKeys sorts object keys the way MySQL stores them.
+type jsonKeys []string
+
+func (k jsonKeys) Len() int      { return len(k) }
+func (k jsonKeys) Swap(i, j int) { k[i], k[j] = k[j], k[i] }
+func (k jsonKeys) Less(i, j int) bool {
+	if len(k[i]) != len(k[j]) {
+		return len(k[i]) < len(k[j])
+	}
+	return k[i] < k[j]
+}
+
+// parseJSONText parses the specified JSON text into the values returned by
+// DecodeJSON (numbers as json.Number).
+func parseJSONText(text []byte) (interface{}, error) {
+	var v interface{}
+
+	d := json.NewDecoder(bytes.NewReader(text))
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil {
+		return nil, myError(ErrInvalidJSON, err)
+	}
+	return v, nil
+}
+
+// parseJSONColumn parses the value of a JSON column (binary JSON prefixed
+// with its length, stored on meta bytes) and returns its JSON text (or the
+// binary value if it can't be decoded) and the number of bytes read.
+func parseJSONColumn(buf []byte, meta uint16) (interface{}, int) {
+	var length int
+
+	size := int(meta)
+	for i := size - 1; i >= 0; i-- {
+		length = length<<8 | int(buf[i])
+	}
+
+	data := buf[size : size+length]
+	v, err := DecodeJSON(data)
+	if err != nil {
+		return data, size + length
+	}
+	return json.RawMessage(FormatJSON(v)), size + length
+}
+
+// parseJSONDiffColumn parses the partial update of a JSON column (the
+// diffs prefixed with their 4-byte length) and applies it to the before
+// value. It returns the updated JSON text (or the diffs if they can't be
+// applied) and the number of bytes read.
+func parseJSONDiffColumn(buf []byte, before interface{}) (interface{}, int) {
+	length := int(binary.LittleEndian.Uint32(buf))
+	data := buf[4 : 4+length]
+
+	diffs, err := parseJSONDiffs(data)
+	if err != nil {
+		return data, 4 + length
+	}
+
+	text, ok := before.(json.RawMessage)
+	if !ok {
+		return diffs, 4 + length
+	}
+
+	doc, err := parseJSONText(text)
+	if err != nil {
+		return diffs, 4 + length
+	}
+
+	for _, d := range diffs {
+		if doc, err = applyJSONDiff(doc, d); err != nil {
+			return diffs, 4 + length
+		}
+	}
+	return json.RawMessage(FormatJSON(doc)), 4 + length
+}
+
+// JSON diff operations (PARTIAL_UPDATE_ROWS_EVENT)
+const (
+	JSON_DIFF_REPLACE = iota
+	JSON_DIFF_INSERT
+	JSON_DIFF_REMOVE
+)
+
+// JSONDiff is a change made by a partial update to a JSON column.
+type JSONDiff struct {
+	Op    uint8
+	Path  string
+	Value interface{} // nil for JSON_DIFF_REMOVE
+}
+
+// parseJSONDiffs parses a list of JSON diffs : for each of them, the
+// operation, the path and (except for removals) the new binary JSON value,
+// path and value being prefixed with their length.
+func parseJSONDiffs(data []byte) ([]JSONDiff, error) {
+	var diffs []JSONDiff
+
+	for off := 0; off < len(data); {
+		var (
+			d      JSONDiff
+			length uint64
+			n      int
+			err    error
+		)
+
+		d.Op = data[off]
+		off++
+		if d.Op > JSON_DIFF_REMOVE || off >= len(data) {
+			return nil, myError(ErrInvalidJSON, "invalid diff")
+		}
+
+		length, n = getLenencInt(data[off:])
+		off += n
+		if off+int(length) > len(data) {
+			return nil, jsonTruncated()
+		}
+		d.Path = string(data[off : off+int(length)])
+		off += int(length)
+
+		if d.Op != JSON_DIFF_REMOVE {
+			if off >= len(data) {
+				return nil, jsonTruncated()
+			}
+			length, n = getLenencInt(data[off:])
+			off += n
+			if off+int(length) > len(data) {
+				return nil, jsonTruncated()
+			}
+			if d.Value, err = DecodeJSON(data[off : off+int(length)]); err != nil {
+				return nil, err
+			}
+			off += int(length)
+		}
+		diffs = append(diffs, d)
+	}
+	return diffs, nil
+}
+
+// applyJSONDiff applies the specified diff to the given document and returns
+// the updated document.
+func applyJSONDiff(doc interface{}, d JSONDiff) (interface{}, error) {
+	legs, err := parseJSONPath(d.Path)
+	if err != nil {
+		return nil, err
+	}
+	return applyJSONLegs(doc, legs, d)
+}
+
+func applyJSONLegs(v interface{}, legs []interface{}, d JSONDiff) (interface{}, error) {
+	var err error
+
+	if len(legs) == 0 {
+		if d.Op == JSON_DIFF_REMOVE {
+			return nil, myError(ErrInvalidJSON, "can't remove "+d.Path)
+		}
+		return d.Value, nil
+	}
+
+	switch leg := legs[0].(type) {
+	case string:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			break
+		}
+
+		if len(legs) == 1 {
+			if d.Op == JSON_DIFF_REMOVE {
+				delete(m, leg)
+			} else {
+				m[leg] = d.Value
+			}
+			return m, nil
+		}
+
+		child, ok := m[leg]
+		if !ok {
+			break
+		}
+		if m[leg], err = applyJSONLegs(child, legs[1:], d); err != nil {
+			return nil, err
+		}
+		return m, nil
+
+	case int:
+		a, ok := v.([]interface{})
+		if !ok {
+			break
+		}
+
+		if len(legs) == 1 {
+			switch {
+			case d.Op == JSON_DIFF_INSERT && leg >= len(a):
+				return append(a, d.Value), nil
+			case d.Op == JSON_DIFF_INSERT:
+				a = append(a, nil)
+				copy(a[leg+1:], a[leg:])
+				a[leg] = d.Value
+				return a, nil
+			case leg >= len(a):
+			case d.Op == JSON_DIFF_REMOVE:
+				return append(a[:leg], a[leg+1:]...), nil
+			default:
+				a[leg] = d.Value
+				return a, nil
+			}
+			break
+		}
+
+		if leg >= len(a) {
+			break
+		}
+		if a[leg], err = applyJSONLegs(a[leg], legs[1:], d); err != nil {
+			return nil, err
+		}
+		return a, nil
+	}
+	return nil, myError(ErrInvalidJSON, "path not found "+d.Path)
+}
+
+// parseJSONPath parses a JSON path as written in the JSON diffs ($, .key,
+// ."quoted key" and [index] legs) and returns its legs, member names as
+// strings and array indexes as ints.
+func parseJSONPath(path string) ([]interface{}, error) {
+	var legs []interface{}
+
+	invalid := myError(ErrInvalidJSON, "invalid path "+path)
+
+	path = strings.TrimSpace(path)
+	if !strings.HasPrefix(path, "$") {
+		return nil, invalid
+	}
+	path = path[1:]
+
+	for len(path) > 0 {
+		switch path[0] {
+		case '.':
+			path = path[1:]
+			if strings.HasPrefix(path, `"`) {
+				// quoted member, find the closing quote
+				i := 1
+				for ; i < len(path) && path[i] != '"'; i++ {
+					if path[i] == '\\' {
+						i++
+					}
+				}
+				if i >= len(path) {
+					return nil, invalid
+				}
+
+				var key string
+				if err := json.Unmarshal([]byte(path[:i+1]), &key); err != nil {
+					return nil, invalid
+				}
+				legs = append(legs, key)
+				path = path[i+1:]
+			} else {
+				i := strings.IndexAny(path, ".[")
+				if i < 0 {
+					i = len(path)
+				}
+				if i == 0 {
+					return nil, invalid
+				}
+				legs = append(legs, path[:i])
+				path = path[i:]
+			}
+
+		case '[':
+			i := strings.IndexByte(path, ']')
+			if i < 0 {
+				return nil, invalid
+			}
+			index, err := strconv.Atoi(strings.TrimSpace(path[1:i]))
+			if err != nil || index < 0 {
+				return nil, invalid
+			}
+			legs = append(legs, index)
+			path = path[i+1:]
+
+		default:
+			return nil, invalid
+		}
+	}
+	return legs, nil
+}
@@ -0,0 +1,232 @@
+/*
+  The MIT License (MIT)
+
+  Copyright (c) 2015 Nirbhay Choubey
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in all
+  copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+  SOFTWARE.
+*/
+
+package mysql
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+)
+
+func TestDecodeJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"empty", nil, `null`},
+		{"null", []byte{0x04, 0x00}, `null`},
+		{"true", []byte{0x04, 0x01}, `true`},
+		{"false", []byte{0x04, 0x02}, `false`},
+		{"int16", []byte{0x05, 0xff, 0xff}, `-1`},
+		{"uint16", []byte{0x06, 0xff, 0xff}, `65535`},
+		{"int32", []byte{0x07, 0x70, 0x11, 0x01, 0x00}, `70000`},
+		{"uint64", []byte{0x0a, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+			`18446744073709551615`},
+		{"double", []byte{0x0b, 0, 0, 0, 0, 0, 0, 0xf8, 0x3f}, `1.5`},
+		{"string", []byte{0x0c, 0x03, 'a', 'b', 'c'}, `"abc"`},
+
+		// {"a": 1}
+		{"small object", []byte{0x00,
+			0x01, 0x00, 0x0c, 0x00, // count, size
+			0x0b, 0x00, 0x01, 0x00, // key entry
+			0x05, 0x01, 0x00, // value entry (inlined)
+			'a'}, `{"a": 1}`},
+
+		// [1, "x"]
+		{"small array", []byte{0x02,
+			0x02, 0x00, 0x0c, 0x00,
+			0x05, 0x01, 0x00,
+			0x0c, 0x0a, 0x00,
+			0x01, 'x'}, `[1, "x"]`},
+
+		// {"k": [true, null]}
+		{"nested", []byte{0x00,
+			0x01, 0x00, 0x16, 0x00,
+			0x0b, 0x00, 0x01, 0x00,
+			0x02, 0x0c, 0x00,
+			'k',
+			0x02, 0x00, 0x0a, 0x00,
+			0x04, 0x01, 0x00,
+			0x04, 0x00, 0x00}, `{"k": [true, null]}`},
+
+		// {"a": 1, "bb": "c"}
+		{"large object", []byte{0x01,
+			0x02, 0x00, 0x00, 0x00, 0x23, 0x00, 0x00, 0x00,
+			0x1e, 0x00, 0x00, 0x00, 0x01, 0x00,
+			0x1f, 0x00, 0x00, 0x00, 0x02, 0x00,
+			0x05, 0x01, 0x00, 0x00, 0x00,
+			0x0c, 0x21, 0x00, 0x00, 0x00,
+			'a', 'b', 'b',
+			0x01, 'c'}, `{"a": 1, "bb": "c"}`},
+
+		// [70000] (int32 is inlined in large containers)
+		{"large array", []byte{0x03,
+			0x01, 0x00, 0x00, 0x00, 0x0d, 0x00, 0x00, 0x00,
+			0x07, 0x70, 0x11, 0x01, 0x00}, `[70000]`},
+
+		{"decimal", []byte{0x0f, _TYPE_NEW_DECIMAL, 0x09, 14, 4,
+			0x81, 0x0d, 0xfb, 0x38, 0xd2, 0x04, 0xd2}, `1234567890.1234`},
+		{"negative decimal", []byte{0x0f, _TYPE_NEW_DECIMAL, 0x04, 2, 1,
+			0x7e, 0xfa}, `-1.5`},
+		{"decimal 27,9", []byte{0x0f, _TYPE_NEW_DECIMAL, 0x0e, 27, 9,
+			0x87, 0x5b, 0xcd, 0x15, 0x00, 0xbc, 0x61, 0x4e,
+			0x07, 0x5b, 0xcd, 0x15}, `123456789012345678.123456789`},
+		{"datetime", []byte{0x0f, _TYPE_DATETIME, 0x08,
+			0x08, 0x00, 0x00, 0x87, 0x51, 0x08, 0xa9, 0x19},
+			`"2021-03-04 05:06:07.000008"`},
+		{"time", []byte{0x0f, _TYPE_TIME, 0x08,
+			0x20, 0xa1, 0x07, 0xb8, 0xc8, 0x00, 0x00, 0x00},
+			`"12:34:56.500000"`},
+		{"opaque", []byte{0x0f, _TYPE_BLOB, 0x02, 0x01, 0x02},
+			`"base64:type252:AQI="`},
+	}
+
+	for _, test := range tests {
+		v, err := DecodeJSON(test.data)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if got := FormatJSON(v); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestDecodeJSONInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"literal", []byte{0x04}},
+		{"int32", []byte{0x07, 0x01, 0x00}},
+		{"string", []byte{0x0c, 0x05, 'a'}},
+		{"object", []byte{0x00, 0x01, 0x00, 0x0c, 0x00, 0x0b, 0x00}},
+		{"decimal", []byte{0x0f, _TYPE_NEW_DECIMAL, 0x04, 14, 4, 0x81, 0x0d}},
+		{"datetime", []byte{0x0f, _TYPE_DATETIME, 0x02, 0x08, 0x00}},
+	}
+
+	for _, test := range tests {
+		if _, err := DecodeJSON(test.data); err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
+
+// jsonDiff encodes a diff the way the server writes it in a partial update.
+func jsonDiff(op uint8, path string, value []byte) []byte {
+	b := append([]byte{op, byte(len(path))}, path...)
+	if op != JSON_DIFF_REMOVE {
+		b = append(b, byte(len(value)))
+		b = append(b, value...)
+	}
+	return b
+}
+
+func TestJSONDiffs(t *testing.T) {
+	var data []byte
+
+	data = append(data, jsonDiff(JSON_DIFF_REPLACE, "$.a", []byte{0x05, 0x02, 0x00})...)
+	data = append(data, jsonDiff(JSON_DIFF_INSERT, "$.b[1]", []byte{0x0c, 0x01, 'x'})...)
+	data = append(data, jsonDiff(JSON_DIFF_INSERT, "$.b[0]", []byte{0x04, 0x02})...)
+	data = append(data, jsonDiff(JSON_DIFF_REMOVE, "$.c", nil)...)
+	data = append(data, jsonDiff(JSON_DIFF_INSERT, `$."d e"`, []byte{0x04, 0x00})...)
+	data = append(data, jsonDiff(JSON_DIFF_REPLACE, "$.f.g[0]", []byte{0x0c, 0x01, 'y'})...)
+	data = append(data, jsonDiff(JSON_DIFF_REMOVE, "$.f.g[1]", nil)...)
+
+	diffs, err := parseJSONDiffs(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ops := []uint8{JSON_DIFF_REPLACE, JSON_DIFF_INSERT, JSON_DIFF_INSERT,
+		JSON_DIFF_REMOVE, JSON_DIFF_INSERT, JSON_DIFF_REPLACE, JSON_DIFF_REMOVE}
+	if len(diffs) != len(ops) {
+		t.Fatalf("got %d diffs, want %d", len(diffs), len(ops))
+	}
+	for i, d := range diffs {
+		if d.Op != ops[i] {
+			t.Errorf("diff %d: got op %d, want %d", i, d.Op, ops[i])
+		}
+	}
+	if diffs[1].Path != "$.b[1]" || diffs[1].Value != "x" {
+		t.Errorf("got %+v", diffs[1])
+	}
+	if diffs[3].Value != nil {
+		t.Errorf("got a value for a removal : %v", diffs[3].Value)
+	}
+
+	// the column image : diffs prefixed with their length
+	buf := make([]byte, 4, 4+len(data))
+	binary.LittleEndian.PutUint32(buf, uint32(len(data)))
+	buf = append(buf, data...)
+
+	before := json.RawMessage(`{"a": 1, "b": [true], "c": 3, "f": {"g": [1, 2, 3]}}`)
+	v, n := parseJSONDiffColumn(buf, before)
+	if n != len(buf) {
+		t.Errorf("read %d bytes, want %d", n, len(buf))
+	}
+	want := `{"a": 2, "b": [false, true, "x"], "f": {"g": ["y", 3]}, "d e": null}`
+	if text, ok := v.(json.RawMessage); !ok || string(text) != want {
+		t.Errorf("got %v, want %s", v, want)
+	}
+
+	// without a before image, the diffs are returned as is
+	if v, _ := parseJSONDiffColumn(buf, nil); len(v.([]JSONDiff)) != len(ops) {
+		t.Errorf("got %v", v)
+	}
+}
+
+func TestJSONDiffsInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"operation", jsonDiff(3, "$.a", nil)},
+		{"path", []byte{JSON_DIFF_REMOVE, 0x05, '$'}},
+		{"value", []byte{JSON_DIFF_REPLACE, 0x01, '$', 0x03, 0x05}},
+		{"no value", []byte{JSON_DIFF_REPLACE, 0x01, '$'}},
+	}
+
+	for _, test := range tests {
+		if _, err := parseJSONDiffs(test.data); err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+
+	doc := map[string]interface{}{"a": []interface{}{int64(1)}}
+	for _, d := range []JSONDiff{
+		{Op: JSON_DIFF_REMOVE, Path: "$"},
+		{Op: JSON_DIFF_REPLACE, Path: "$.b.c", Value: true},
+		{Op: JSON_DIFF_REPLACE, Path: "$.a[3]", Value: true},
+		{Op: JSON_DIFF_REPLACE, Path: "$.a.b", Value: true},
+		{Op: JSON_DIFF_REPLACE, Path: "a", Value: true},
+	} {
+		if _, err := applyJSONDiff(doc, d); err == nil {
+			t.Errorf("%s: expected an error", d.Path)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+/*
+  The MIT License (MIT)
+
+  Copyright (c) 2015 Nirbhay Choubey
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in all
+  copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+  SOFTWARE.
+*/
+
+package mysql
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// duplicate key handling of EXECUTE_LOAD_QUERY_EVENT
+const (
+	LOAD_DUP_ERROR = iota
+	LOAD_DUP_IGNORE
+	LOAD_DUP_REPLACE
+)
+
+// LOAD_EVENT option flags
+const (
+	_DUMPFILE_FLAG     = 0x1
+	_OPT_ENCLOSED_FLAG = 0x2
+	_REPLACE_FLAG      = 0x4
+	_IGNORE_FLAG       = 0x8
+)
+
+// LOAD_EVENT empty flags (old format)
+const (
+	_FIELD_TERM_EMPTY = 0x1
+	_ENCLOSED_EMPTY   = 0x2
+	_LINE_TERM_EMPTY  = 0x4
+	_LINE_START_EMPTY = 0x8
+	_ESCAPED_EMPTY    = 0x10
+)
+
+// LoadDataAssembler reassembles the LOAD DATA INFILE statements replicated
+// in statement format, whose file is spread over several events :
+// BEGIN_LOAD_QUERY_EVENT, APPEND_BLOCK_EVENTs and EXECUTE_LOAD_QUERY_EVENT
+// (MySQL 5.0.3+), or CREATE_FILE_EVENT, APPEND_BLOCK_EVENTs and
+// EXEC_LOAD_EVENT (older servers). DELETE_FILE_EVENT discards a file.
+type LoadDataAssembler struct {
+	files map[uint32]*loadFile
+}
+
+// file being reassembled
+type loadFile struct {
+	data bytes.Buffer
+	load *LoadEvent // CREATE_FILE_EVENT
+}
+
+// LoadData is a reassembled LOAD DATA INFILE statement.
+type LoadData struct {
+	fileId uint32
+	schema string
+	query  string
+	data   []byte
+
+	// part of the query naming the file (from INFILE to INTO)
+	start int
+	end   int
+	dup   uint8
+}
+
+func NewLoadDataAssembler() *LoadDataAssembler {
+	return &LoadDataAssembler{files: make(map[uint32]*loadFile)}
+}
+
+// Add feeds the specified event to the assembler and returns the
+// reassembled statement when the event executes it, nil otherwise. Events
+// unrelated to LOAD DATA INFILE are ignored.
+func (a *LoadDataAssembler) Add(ev Event) *LoadData {
+	switch e := ev.(type) {
+	case *BeginLoadQueryEvent:
+		f := new(loadFile)
+		f.data.Write(e.data)
+		a.files[e.fileId] = f
+
+	case *CreateFileEvent:
+		f := &loadFile{load: e.load}
+		f.data.Write(e.data)
+		a.files[e.fileId] = f
+
+	case *AppendBlockEvent:
+		if f, ok := a.files[e.fieldId]; ok {
+			f.data.Write(e.data)
+		}
+
+	case *DeleteFileEvent:
+		delete(a.files, e.fileId)
+
+	case *ExecuteLoadQueryEvent:
+		f, ok := a.files[e.fileId]
+		if !ok {
+			break
+		}
+		delete(a.files, e.fileId)
+
+		l := &LoadData{fileId: e.fileId,
+			schema: e.schema,
+			query:  e.query,
+			data:   f.data.Bytes(),
+			start:  int(e.startPosition),
+			end:    int(e.endPosition),
+			dup:    e.dupHandlingFlags}
+
+		if l.start > l.end || l.end > len(l.query) {
+			// can't be rewritten
+			l.start, l.end = 0, 0
+		}
+		return l
+
+	case *ExecLoadEvent:
+		f, ok := a.files[e.fileId]
+		if !ok || f.load == nil {
+			break
+		}
+		delete(a.files, e.fileId)
+
+		l := &LoadData{fileId: e.fileId,
+			schema: f.load.schema,
+			data:   f.data.Bytes()}
+		l.query, l.start, l.end = loadEventQuery(f.load)
+
+		switch {
+		case len(f.load.optFlags) > 0 && (f.load.optFlags[0]&_REPLACE_FLAG) != 0:
+			l.dup = LOAD_DUP_REPLACE
+		case len(f.load.optFlags) > 0 && (f.load.optFlags[0]&_IGNORE_FLAG) != 0:
+			l.dup = LOAD_DUP_IGNORE
+		default:
+			l.dup = LOAD_DUP_ERROR
+		}
+		return l
+
+	default:
+	}
+	return nil
+}
+
+// Pending returns the number of files being reassembled.
+func (a *LoadDataAssembler) Pending() int {
+	return len(a.files)
+}
+
+// loadEventQuery builds the LOAD DATA INFILE statement of the specified load
+// event and returns it along with the offsets of the part naming the file.
+func loadEventQuery(ev *LoadEvent) (query string, start int, end int) {
+	var optFlags uint8
+
+	if len(ev.optFlags) > 0 {
+		optFlags = ev.optFlags[0]
+	}
+
+	// value of an option, empty if so flagged (old format)
+	option := func(v string, flag uint8) string {
+		if (ev.emptyFlags & flag) != 0 {
+			return ""
+		}
+		return v
+	}
+
+	query = "LOAD DATA"
+	start = len(query)
+
+	query += " INFILE " + quoteString(ev.file)
+	switch {
+	case (optFlags & _REPLACE_FLAG) != 0:
+		query += " REPLACE"
+	case (optFlags & _IGNORE_FLAG) != 0:
+		query += " IGNORE"
+	default:
+	}
+	query += " INTO"
+	end = len(query)
+
+	query += " TABLE " + quoteIdentifier(ev.table)
+
+	query += " FIELDS TERMINATED BY " +
+		quoteString(option(ev.fieldTerminator, _FIELD_TERM_EMPTY))
+	if (optFlags & _OPT_ENCLOSED_FLAG) != 0 {
+		query += " OPTIONALLY"
+	}
+	query += " ENCLOSED BY " + quoteString(option(ev.enclosedBy, _ENCLOSED_EMPTY))
+	query += " ESCAPED BY " + quoteString(option(ev.escapedBy, _ESCAPED_EMPTY))
+
+	query += " LINES TERMINATED BY " +
+		quoteString(option(ev.lineTerminator, _LINE_TERM_EMPTY))
+	query += " STARTING BY " + quoteString(option(ev.lineStart, _LINE_START_EMPTY))
+
+	if ev.skipLines > 0 {
+		query += " IGNORE " + strconv.FormatUint(uint64(ev.skipLines), 10) +
+			" LINES"
+	}
+
+	if len(ev.fields) > 0 {
+		fields := make([]string, len(ev.fields))
+		for i, f := range ev.fields {
+			fields[i] = quoteIdentifier(f)
+		}
+		query += " (" + strings.Join(fields, ",") + ")"
+	}
+	return
+}
+
+func (l *LoadData) FileId() uint32 {
+	return l.fileId
+}
+
+// Schema returns the default database the statement was executed in.
+func (l *LoadData) Schema() string {
+	return l.schema
+}
+
+// Query returns the statement as executed on the server, i.e. naming a file
+// of the server.
+func (l *LoadData) Query() string {
+	return l.query
+}
+
+// Size returns the size of the file.
+func (l *LoadData) Size() int {
+	return len(l.data)
+}
+
+// Reader returns a reader of the file content.
+func (l *LoadData) Reader() io.Reader {
+	return bytes.NewReader(l.data)
+}
+
+// Statement returns the statement rewritten to load the specified client
+// file (LOAD DATA LOCAL INFILE), e.g. the file content saved locally.
+func (l *LoadData) Statement(file string) string {
+	if l.start == 0 && l.end == 0 {
+		return l.query
+	}
+
+	query := l.query[:l.start] + " LOCAL INFILE " + quoteString(file)
+	switch l.dup {
+	case LOAD_DUP_REPLACE:
+		query += " REPLACE"
+	case LOAD_DUP_IGNORE:
+		query += " IGNORE"
+	default:
+	}
+	return query + " INTO" + l.query[l.end:]
+}
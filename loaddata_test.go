@@ -0,0 +1,146 @@
+/*
+  The MIT License (MIT)
+
+  Copyright (c) 2015 Nirbhay Choubey
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in all
+  copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+  SOFTWARE.
+*/
+
+package mysql
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestLoadDataAssembler(t *testing.T) {
+	a := NewLoadDataAssembler()
+	query := "LOAD DATA INFILE '/tmp/t1.txt' REPLACE INTO TABLE t1 (a, b)"
+
+	events := []Event{
+		&BeginLoadQueryEvent{fileId: 1, data: []byte("1,a\n")},
+		&QueryEvent{query: "BEGIN"},
+		&AppendBlockEvent{fieldId: 1, data: []byte("2,b\n")},
+		&AppendBlockEvent{fieldId: 2, data: []byte("3,c\n")},
+		&BeginLoadQueryEvent{fileId: 2, data: []byte("4,d\n")},
+		&DeleteFileEvent{fileId: 2},
+		&ExecuteLoadQueryEvent{fileId: 2, query: query},
+	}
+	for i, ev := range events {
+		if l := a.Add(ev); l != nil {
+			t.Fatalf("%d: got %q", i, l.Query())
+		}
+	}
+	if a.Pending() != 1 {
+		t.Fatalf("%d files pending", a.Pending())
+	}
+
+	l := a.Add(&ExecuteLoadQueryEvent{fileId: 1, schema: "test",
+		query:            query,
+		startPosition:    uint32(len("LOAD DATA")),
+		endPosition:      uint32(strings.Index(query, " TABLE")),
+		dupHandlingFlags: LOAD_DUP_REPLACE})
+	if l == nil {
+		t.Fatal("statement not reassembled")
+	}
+	if a.Pending() != 0 {
+		t.Errorf("%d files pending", a.Pending())
+	}
+
+	data, err := ioutil.ReadAll(l.Reader())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.FileId() != 1 || l.Schema() != "test" || l.Query() != query ||
+		l.Size() != 8 || string(data) != "1,a\n2,b\n" {
+		t.Errorf("got %d %q %q %d %q", l.FileId(), l.Schema(), l.Query(),
+			l.Size(), data)
+	}
+
+	want := "LOAD DATA LOCAL INFILE '/var/tmp/it\\'s.txt' REPLACE INTO TABLE t1 (a, b)"
+	if s := l.Statement("/var/tmp/it's.txt"); s != want {
+		t.Errorf("got %q, expected %q", s, want)
+	}
+
+	// positions past the query
+	a.Add(&BeginLoadQueryEvent{fileId: 3})
+	l = a.Add(&ExecuteLoadQueryEvent{fileId: 3, query: query,
+		startPosition: 9, endPosition: uint32(len(query) + 1)})
+	if l == nil || l.Size() != 0 || l.Statement("t1.txt") != query {
+		t.Errorf("got %+v", l)
+	}
+}
+
+func TestLoadDataAssemblerLoadEvent(t *testing.T) {
+	a := NewLoadDataAssembler()
+
+	load := &LoadEvent{schema: "test", table: "t`1", file: "/tmp/it's.txt",
+		fieldTerminator: ",",
+		enclosedBy:      "\"",
+		lineTerminator:  "\n",
+		lineStart:       ">",
+		escapedBy:       "\\",
+		optFlags:        []byte{_OPT_ENCLOSED_FLAG | _IGNORE_FLAG},
+		emptyFlags:      _LINE_START_EMPTY,
+		skipLines:       1,
+		fields:          []string{"a", "b"}}
+
+	if l := a.Add(&ExecLoadEvent{fileId: 1}); l != nil {
+		t.Fatalf("got %q", l.Query())
+	}
+	a.Add(&CreateFileEvent{fileId: 1, load: load, data: []byte("1,a\n")})
+	a.Add(&AppendBlockEvent{fieldId: 1, data: []byte("2,b\n")})
+
+	l := a.Add(&ExecLoadEvent{fileId: 1})
+	if l == nil {
+		t.Fatal("statement not reassembled")
+	}
+
+	query := "LOAD DATA INFILE '/tmp/it\\'s.txt' IGNORE INTO TABLE `t``1`" +
+		" FIELDS TERMINATED BY ',' OPTIONALLY ENCLOSED BY '\"'" +
+		" ESCAPED BY '\\\\' LINES TERMINATED BY '\\n' STARTING BY ''" +
+		" IGNORE 1 LINES (`a`,`b`)"
+	if l.Schema() != "test" || l.Query() != query || l.Size() != 8 {
+		t.Errorf("got %q %q %d", l.Schema(), l.Query(), l.Size())
+	}
+
+	want := "LOAD DATA LOCAL INFILE 't1.txt' IGNORE INTO TABLE `t``1`" +
+		query[strings.Index(query, " FIELDS"):]
+	if s := l.Statement("t1.txt"); s != want {
+		t.Errorf("got %q, expected %q", s, want)
+	}
+
+	tests := []struct {
+		optFlags []byte
+		dup      string
+	}{
+		{[]byte{_REPLACE_FLAG}, " REPLACE INTO"},
+		{nil, "' INTO"},
+	}
+	for _, test := range tests {
+		load := &LoadEvent{file: "t1.txt", table: "t1", optFlags: test.optFlags}
+		a.Add(&CreateFileEvent{fileId: 2, load: load})
+		l := a.Add(&ExecLoadEvent{fileId: 2})
+		if l == nil || !strings.Contains(l.Query(), test.dup) ||
+			!strings.Contains(l.Statement("t2.txt"), test.dup) {
+			t.Errorf("%v: got %+v", test.optFlags, l)
+		}
+	}
+}
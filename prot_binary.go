@@ -27,9 +27,11 @@ package mysql
 import (
 	"database/sql/driver"
 	"encoding/binary"
+	"fmt"
 	"math"
 	"math/big"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -693,6 +695,7 @@ const (
 	_TYPE_DATETIME2
 	_TYPE_TIME2
 	// ...
+	_TYPE_JSON        = 245
 	_TYPE_NEW_DECIMAL = 246
 	_TYPE_ENUM        = 247
 	_TYPE_SET         = 248
@@ -803,6 +806,82 @@ func parseNewDecimal(b []byte, size uint16) (float64, int) {
 	return result, decimalSize
 }
 
+// decodeDecimal decodes the specified binary decimal value and returns it as
+// an exact decimal string along with the number of bytes read. Unlike
+// parseNewDecimal, the buffer is left unmodified.
+func decodeDecimal(b []byte, precision, scale int) (string, int) {
+	if precision < scale {
+		return "", 0
+	}
+
+	size := getDecimalBinarySize(precision, scale)
+	if size > len(b) {
+		return "", 0
+	}
+
+	buf := make([]byte, size)
+	copy(buf, b)
+
+	// sign bit (set for positive values), negative values are stored
+	// inverted
+	negative := (buf[0] & 0x80) == 0
+	buf[0] ^= 0x80
+	if negative {
+		for i := range buf {
+			buf[i] ^= 0xff
+		}
+	}
+
+	var off int
+
+	// read the next group of n bytes (big-endian)
+	read := func(n int) uint64 {
+		var v uint64
+		for i := 0; i < n; i++ {
+			v = v<<8 | uint64(buf[off+i])
+		}
+		off += n
+		return v
+	}
+
+	x := precision - scale
+	ipDigits := x / _DIGITS_PER_INTEGER
+	ipDigitsX := x - ipDigits*_DIGITS_PER_INTEGER
+	fpDigits := scale / _DIGITS_PER_INTEGER
+	fpDigitsX := scale - fpDigits*_DIGITS_PER_INTEGER
+
+	// integral part, 9 digits per 4 bytes (leading digits first)
+	var ip string
+	if ipDigitsX > 0 {
+		ip = strconv.FormatUint(read(_DIGITS_TO_BYTES[ipDigitsX]), 10)
+	}
+	for i := 0; i < ipDigits; i++ {
+		ip += fmt.Sprintf("%09d", read(4))
+	}
+	ip = strings.TrimLeft(ip, "0")
+	if ip == "" {
+		ip = "0"
+	}
+
+	// fractional part (trailing digits last)
+	var fp string
+	for i := 0; i < fpDigits; i++ {
+		fp += fmt.Sprintf("%09d", read(4))
+	}
+	if fpDigitsX > 0 {
+		fp += fmt.Sprintf("%0*d", fpDigitsX, read(_DIGITS_TO_BYTES[fpDigitsX]))
+	}
+
+	value := ip
+	if fp != "" {
+		value += "." + fp
+	}
+	if negative {
+		value = "-" + value
+	}
+	return value, size
+}
+
 func getDecimalBinarySize(precision, scale int) int {
 	x := precision - scale
 	ipd := x / _DIGITS_PER_INTEGER
@@ -25,12 +25,22 @@
 package mysql
 
 import (
+	"bytes"
+	"compress/zlib"
+	"database/sql/driver"
 	"encoding/binary"
+	"fmt"
+	"hash/crc32"
 	"io"
+	"io/ioutil"
+	"math"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 )
 
 const (
@@ -43,14 +53,25 @@ const (
 )
 
 const (
-	_EVENT_TYPE_OFFSET = 4
-	_FLAGS_OFFSET      = 17
+	_EVENT_TYPE_OFFSET   = 4
+	_LOG_POS_OFFSET      = 13
+	_FLAGS_OFFSET        = 17
+	_EVENT_HEADER_LENGTH = 19
+)
+
+// binlog file magic number, the first event follows it
+const (
+	_BINLOG_MAGIC       = "\xfebin"
+	_BINLOG_HEADER_SIZE = 4
 )
 
 type netReader struct {
-	conn        *Conn
-	slave       binlogSlave
-	nonBlocking bool
+	p               properties
+	conn            *Conn
+	slave           binlogSlave
+	nonBlocking     bool
+	gtidStrictMode  bool
+	heartbeatPeriod time.Duration
 
 	first  bool
 	eof    bool
@@ -58,6 +79,10 @@ type netReader struct {
 
 	e         error
 	nextEvent []byte
+
+	// closed by close() to interrupt the reconnection attempts
+	stop     chan struct{}
+	stopOnce sync.Once
 }
 
 // init
@@ -83,7 +108,13 @@ func (nr *netReader) init(p properties) error {
 	nr.slave.replicationRank = 0
 	nr.slave.masterId = 0
 
+	nr.p = p
+	if nr.stop == nil {
+		nr.stop = make(chan struct{})
+	}
 	nr.nonBlocking = p.binlogDumpNonBlock
+	nr.gtidStrictMode = p.binlogGtidStrictMode
+	nr.heartbeatPeriod = p.binlogHeartbeatPeriod
 
 	// establish a connection with the master server
 	if nr.conn, err = open(p); err != nil {
@@ -98,6 +129,14 @@ func (nr *netReader) init(p properties) error {
 		}
 	}
 
+	// ask master to send heartbeats when idle
+	if nr.heartbeatPeriod > 0 {
+		if _, err = nr.conn.handleExec(fmt.Sprintf("SET @master_heartbeat_period = %d",
+			nr.heartbeatPeriod.Nanoseconds()), nil); err != nil {
+			return err
+		}
+	}
+
 	// send COM_REGISTER_SLAVE to (master) server
 	if err = nr.registerSlave(); err != nil {
 		return err
@@ -106,6 +145,77 @@ func (nr *netReader) init(p properties) error {
 	return nil
 }
 
+// delays between the reconnection attempts
+const (
+	_BINLOG_RECONNECT_MIN_DELAY = 1 * time.Second
+	_BINLOG_RECONNECT_MAX_DELAY = 1 * time.Minute
+)
+
+// reconnect re-establishes the connection with the master (waiting longer
+// after each failed attempt) and restarts the binlog dump from the specified
+// index. It gives up on errors other than network errors, after the maximum
+// number of attempts (BinlogReconnectAttempts) or when the reader gets closed,
+// and returns the last error.
+func (nr *netReader) reconnect(index binlogIndex) error {
+	err := nr.e
+
+	delay := _BINLOG_RECONNECT_MIN_DELAY
+
+	// the link is dead, ignore the error
+	nr.conn.conn.Close()
+	nr.closed = true
+
+	for attempt := uint(1); ; attempt++ {
+		select {
+		case <-nr.stop:
+			return nr.giveUp(err)
+		case <-time.After(delay):
+		}
+
+		nr.closed, nr.eof = false, false
+		if err = nr.init(nr.p); err == nil {
+			if err = nr.begin(index); err == nil {
+				nr.e = nil
+				return nil
+			}
+		}
+
+		if nr.conn != nil {
+			nr.conn.conn.Close()
+		}
+		nr.closed = true
+
+		if !isNetworkError(err) || attempt >= nr.p.binlogReconnectAttempts {
+			return nr.giveUp(err)
+		}
+
+		if delay *= 2; delay > _BINLOG_RECONNECT_MAX_DELAY {
+			delay = _BINLOG_RECONNECT_MAX_DELAY
+		}
+	}
+}
+
+// giveUp ends the binlog stream with the specified error.
+func (nr *netReader) giveUp(err error) error {
+	nr.e = err
+	nr.eof = true
+	nr.closed = true
+	return err
+}
+
+// isNetworkError returns whether the specified error was caused by a failure
+// of the network link.
+func isNetworkError(err error) bool {
+	if e, ok := err.(*Error); ok {
+		switch e.code {
+		case ErrConnection, ErrRead, ErrWrite:
+			return true
+		default:
+		}
+	}
+	return false
+}
+
 type binlogSlave struct {
 	id              uint32
 	host            string
@@ -127,20 +237,34 @@ func (nr *netReader) begin(index binlogIndex) error {
 
 func (nr *netReader) binlogDump(index binlogIndex) error {
 	var (
-		b   []byte
-		err error
+		b     []byte
+		err   error
+		flags uint16
 	)
 
 	c := nr.conn
 
+	if nr.nonBlocking {
+		flags |= _BINLOG_DUMP_NON_BLOCK
+	}
+
+	if index.mariadbGtidPos != nil {
+		// MariaDB: the GTID position is passed via session variables
+		if err = setMariadbGtidState(c, index.mariadbGtidPos,
+			nr.gtidStrictMode); err != nil {
+			return err
+		}
+		flags |= _BINLOG_SEND_ANNOTATE_ROWS_EVENT
+	}
+
 	// reset the protocol packet sequence number
 	c.resetSeqno()
 
-	if b, err = c.createComBinlogDump(nr.slave, index, nr.nonBlocking); err != nil {
+	if b, err = c.createBinlogDumpPacket(nr.slave, index, flags); err != nil {
 		return err
 	}
 
-	// send COM_BINLOG_DUMP packet to (master) server
+	// send COM_BINLOG_DUMP/COM_BINLOG_DUMP_GTID packet to (master) server
 	if err = c.writePacket(b); err != nil {
 		return err
 	}
@@ -154,7 +278,45 @@ func (nr *netReader) binlogDump(index binlogIndex) error {
 	return nil
 }
 
+// setMariadbGtidState sets the session variables required by a MariaDB master
+// to start streaming from the specified GTID position.
+func setMariadbGtidState(c *Conn, pos mariadbGtidPos, strict bool) error {
+	var err error
+
+	// MARIA_SLAVE_CAPABILITY_GTID
+	if _, err = c.handleExec("SET @mariadb_slave_capability = 4", nil); err != nil {
+		return err
+	}
+
+	if _, err = c.handleExec("SET @slave_connect_state = ?",
+		[]driver.Value{pos.String()}); err != nil {
+		return err
+	}
+
+	if strict {
+		_, err = c.handleExec("SET @slave_gtid_strict_mode = 1", nil)
+	} else {
+		_, err = c.handleExec("SET @slave_gtid_strict_mode = 0", nil)
+	}
+	return err
+}
+
+// rotate is a no-op, the server switches to the next file by itself.
+func (nr *netReader) rotate(file string, position uint64) {
+}
+
 func (nr *netReader) close() error {
+	// interrupt the reconnection attempts (if any)
+	nr.stopOnce.Do(func() {
+		if nr.stop != nil {
+			close(nr.stop)
+		}
+	})
+
+	if nr.closed {
+		return nil
+	}
+
 	if err := nr.conn.Close(); err != nil {
 		return err
 	}
@@ -284,8 +446,32 @@ func (c *Conn) createComRegisterSlave(s binlogSlave) ([]byte, error) {
 	return b[0:off], nil
 }
 
+// createBinlogDumpPacket returns the command starting the binlog stream at
+// the specified index: COM_BINLOG_DUMP_GTID from a GTID set, or from a
+// position beyond 4GB (MySQL only), COM_BINLOG_DUMP otherwise.
+func (c *Conn) createBinlogDumpPacket(slave binlogSlave, index binlogIndex,
+	flags uint16) ([]byte, error) {
+	switch {
+	case index.gtidSet != nil:
+		return c.createComBinlogDumpGtid(slave, index,
+			flags|_BINLOG_THROUGH_GTID)
+	case index.position > math.MaxUint32:
+		// COM_BINLOG_DUMP can't address beyond 4GB, and MariaDB has no
+		// COM_BINLOG_DUMP_GTID
+		if strings.Contains(c.serverVersion, "MariaDB") {
+			return nil, myError(ErrBinlogPosition, index.file,
+				index.position, "beyond 4GB, start from a GTID "+
+					"position instead")
+		}
+		return c.createComBinlogDumpGtid(slave, index,
+			flags|_BINLOG_THROUGH_POSITION)
+	default:
+	}
+	return c.createComBinlogDump(slave, index, flags)
+}
+
 func (c *Conn) createComBinlogDump(slave binlogSlave, index binlogIndex,
-	nonBlocking bool) ([]byte, error) {
+	flags uint16) ([]byte, error) {
 	var (
 		b                  []byte
 		off, payloadLength int
@@ -303,21 +489,71 @@ func (c *Conn) createComBinlogDump(slave binlogSlave, index binlogIndex,
 	b[off] = _COM_BINLOG_DUMP
 	off++
 
-	binary.LittleEndian.PutUint32(b[off:off+4], index.position)
+	binary.LittleEndian.PutUint32(b[off:off+4], uint32(index.position))
 	off += 4
-	if nonBlocking {
-		// flags (0x01 : BINLOG_DUMP_NON_BLOCK)
-		binary.LittleEndian.PutUint16(b[off:off+2], uint16(0x01))
-	} else {
-		binary.LittleEndian.PutUint16(b[off:off+2], uint16(0))
+
+	binary.LittleEndian.PutUint16(b[off:off+2], flags)
+	off += 2
+	binary.LittleEndian.PutUint32(b[off:off+4], slave.id)
+	off += 4
+
+	off += copy(b[off:], index.file)
+
+	return b[0:off], nil
+}
+
+// COM_BINLOG_DUMP/COM_BINLOG_DUMP_GTID flags
+const (
+	_BINLOG_DUMP_NON_BLOCK   = 0x01
+	_BINLOG_THROUGH_POSITION = 0x02
+	_BINLOG_THROUGH_GTID     = 0x04
+
+	// MariaDB
+	_BINLOG_SEND_ANNOTATE_ROWS_EVENT = 0x02
+)
+
+func (c *Conn) createComBinlogDumpGtid(slave binlogSlave, index binlogIndex,
+	flags uint16) ([]byte, error) {
+	var (
+		b                  []byte
+		off, payloadLength int
+		err                error
+	)
+
+	payloadLength = 19 + len(index.file)
+	if (flags & _BINLOG_THROUGH_GTID) != 0 {
+		payloadLength += 4 + index.gtidSet.encodedLength()
+	}
+
+	if b, err = c.buff.Reset(4 + payloadLength); err != nil {
+		return nil, err
 	}
 
+	off += 4 // placeholder for protocol packet header
+
+	b[off] = _COM_BINLOG_DUMP_GTID
+	off++
+
+	binary.LittleEndian.PutUint16(b[off:off+2], flags)
 	off += 2
+
 	binary.LittleEndian.PutUint32(b[off:off+4], slave.id)
 	off += 4
 
+	binary.LittleEndian.PutUint32(b[off:off+4], uint32(len(index.file)))
+	off += 4
 	off += copy(b[off:], index.file)
 
+	binary.LittleEndian.PutUint64(b[off:off+8], index.position)
+	off += 8
+
+	if (flags & _BINLOG_THROUGH_GTID) != 0 {
+		binary.LittleEndian.PutUint32(b[off:off+4],
+			uint32(index.gtidSet.encodedLength()))
+		off += 4
+		off += index.gtidSet.encode(b[off:])
+	}
+
 	return b[0:off], nil
 }
 
@@ -351,6 +587,11 @@ func (nr *netReader) readEvent() error {
 
 	c := nr.conn
 
+	if nr.heartbeatPeriod > 0 {
+		// at least a heartbeat is expected within this time
+		c.conn.SetReadDeadline(time.Now().Add(2 * nr.heartbeatPeriod))
+	}
+
 	if b, err = c.readPacket(); err != nil {
 		return err
 	}
@@ -420,6 +661,7 @@ func (b *Binlog) parseQueryEvent(buf []byte, ev *QueryEvent) (err error) {
 	}
 
 	ev.statusVars = string(buf[off : off+varLength])
+	parseQueryStatusVars(buf[off:off+varLength], &ev.status)
 	off += varLength
 
 	ev.schema = string(buf[off : off+schemaLength])
@@ -430,6 +672,199 @@ func (b *Binlog) parseQueryEvent(buf []byte, ev *QueryEvent) (err error) {
 	return nil
 }
 
+// over_max_dbs_in_event_mts, updated databases not logged
+const _OVER_MAX_DBS_IN_EVENT_MTS = 254
+
+// parseQueryStatusVars parses the status variables block of a query event.
+// Each variable is a code followed by its value. The length of the value of
+// an unknown code (hence the position of the following variables) can't be
+// determined, decoding then stops, keeping the variables already decoded.
+func parseQueryStatusVars(buf []byte, v *QueryStatusVars) {
+	var off int
+
+	// readString reads a string prefixed with its 1-byte length
+	readString := func() (string, bool) {
+		if off >= len(buf) || off+1+int(buf[off]) > len(buf) {
+			return "", false
+		}
+		length := int(buf[off])
+		s := string(buf[off+1 : off+1+length])
+		off += 1 + length
+		return s, true
+	}
+
+	// need returns whether n more bytes are available
+	need := func(n int) bool {
+		return off+n <= len(buf)
+	}
+
+	for off < len(buf) {
+		code := buf[off]
+		off++
+
+		ok := true
+
+		switch code {
+		case Q_FLAGS2_CODE:
+			if ok = need(4); ok {
+				v.Flags2 = binary.LittleEndian.Uint32(buf[off:])
+				off += 4
+			}
+		case Q_SQL_MODE_CODE:
+			if ok = need(8); ok {
+				v.SqlMode = binary.LittleEndian.Uint64(buf[off:])
+				off += 8
+			}
+		case Q_CATALOG_CODE:
+			// null-terminated (MySQL 5.0.0 - 5.0.3)
+			if v.Catalog, ok = readString(); ok && need(1) {
+				off++
+			}
+		case Q_AUTO_INCREMENT:
+			if ok = need(4); ok {
+				v.AutoIncrementIncrement = binary.LittleEndian.Uint16(buf[off:])
+				v.AutoIncrementOffset = binary.LittleEndian.Uint16(buf[off+2:])
+				off += 4
+			}
+		case Q_CHARSET_CODE:
+			if ok = need(6); ok {
+				v.CharsetClient = binary.LittleEndian.Uint16(buf[off:])
+				v.CollationConnection = binary.LittleEndian.Uint16(buf[off+2:])
+				v.CollationServer = binary.LittleEndian.Uint16(buf[off+4:])
+				off += 6
+			}
+		case Q_TIME_ZONE_CODE:
+			v.TimeZone, ok = readString()
+		case Q_CATALOG_NZ_CODE:
+			v.Catalog, ok = readString()
+		case Q_LC_TIME_NAMES_CODE:
+			if ok = need(2); ok {
+				v.LcTimeNames = binary.LittleEndian.Uint16(buf[off:])
+				off += 2
+			}
+		case Q_CHARSET_DATABASE_CODE:
+			if ok = need(2); ok {
+				v.CollationDatabase = binary.LittleEndian.Uint16(buf[off:])
+				off += 2
+			}
+		case Q_TABLE_MAP_FOR_UPDATE_CODE:
+			if ok = need(8); ok {
+				v.TableMapForUpdate = binary.LittleEndian.Uint64(buf[off:])
+				off += 8
+			}
+		case Q_MASTER_DATA_WRITTEN_CODE:
+			if ok = need(4); ok {
+				v.MasterDataWritten = binary.LittleEndian.Uint32(buf[off:])
+				off += 4
+			}
+		case Q_INVOKER:
+			if v.InvokerUser, ok = readString(); ok {
+				v.InvokerHost, ok = readString()
+			}
+		case Q_UPDATED_DB_NAMES:
+			if ok = need(1); !ok {
+				break
+			}
+			count := int(buf[off])
+			off++
+			if count == _OVER_MAX_DBS_IN_EVENT_MTS {
+				break
+			}
+
+			// null-terminated names
+			v.UpdatedDbNames = make([]string, 0, count)
+			for i := 0; i < count && ok; i++ {
+				end := bytes.IndexByte(buf[off:], 0)
+				if ok = end >= 0; ok {
+					v.UpdatedDbNames = append(v.UpdatedDbNames,
+						string(buf[off:off+end]))
+					off += end + 1
+				}
+			}
+		case Q_MICROSECONDS:
+			if ok = need(3); ok {
+				v.Microseconds = getUint24(buf[off:])
+				off += 3
+			}
+		case Q_EXPLICIT_DEFAULTS_FOR_TIMESTAMP:
+			if ok = need(1); ok {
+				v.ExplicitDefaultsForTimestamp = (buf[off] != 0)
+				off++
+			}
+		case Q_DDL_LOGGED_WITH_XID:
+			if ok = need(8); ok {
+				v.DdlXid = binary.LittleEndian.Uint64(buf[off:])
+				off += 8
+			}
+		case Q_DEFAULT_COLLATION_FOR_UTF8MB4:
+			if ok = need(2); ok {
+				v.DefaultCollationForUtf8mb4 = binary.LittleEndian.Uint16(buf[off:])
+				off += 2
+			}
+		case Q_SQL_REQUIRE_PRIMARY_KEY:
+			if ok = need(1); ok {
+				v.SqlRequirePrimaryKey = (buf[off] != 0)
+				off++
+			}
+		case Q_DEFAULT_TABLE_ENCRYPTION:
+			if ok = need(1); ok {
+				v.DefaultTableEncryption = (buf[off] != 0)
+				off++
+			}
+		case Q_HRNOW:
+			if ok = need(3); ok {
+				v.HrNow = getUint24(buf[off:])
+				off += 3
+			}
+		case Q_XID:
+			if ok = need(8); ok {
+				v.Xid = binary.LittleEndian.Uint64(buf[off:])
+				off += 8
+			}
+		case Q_GTID_FLAGS3:
+			if ok = need(1); !ok {
+				break
+			}
+			v.GtidFlags3 = buf[off]
+			off++
+
+			// sequence number of the START ALTER
+			if v.GtidFlags3&(FL_COMMIT_ALTER_E1|FL_ROLLBACK_ALTER_E1) != 0 {
+				if ok = need(8); ok {
+					v.StartAlterSeqno = binary.LittleEndian.Uint64(buf[off:])
+					off += 8
+				}
+			}
+		case Q_CHARACTER_SET_COLLATIONS:
+			// count, then (charset id, collation id) pairs
+			if ok = need(1); !ok {
+				break
+			}
+			count := int(buf[off])
+			off++
+
+			if ok = need(4 * count); ok {
+				v.CharacterSetCollations = make(map[uint16]uint16, count)
+				for i := 0; i < count; i++ {
+					v.CharacterSetCollations[binary.LittleEndian.Uint16(buf[off:])] =
+						binary.LittleEndian.Uint16(buf[off+2:])
+					off += 4
+				}
+			}
+		default:
+			// unknown code (e.g. Q_COMMIT_TS)
+			ok = false
+		}
+
+		if !ok {
+			// unknown or truncated
+			v.incomplete = true
+			return
+		}
+		v.codes = append(v.codes, code)
+	}
+}
+
 func (b *Binlog) parseRotateEvent(buf []byte, ev *RotateEvent) (err error) {
 	var off int
 
@@ -453,6 +888,15 @@ func (b *Binlog) parseIntvarEvent(buf []byte, ev *IntvarEvent) (err error) {
 
 // parseLoadEvent parses LOAD_EVENT as well as NEW_LOAD_EVENT
 func (b *Binlog) parseLoadEvent(buf []byte, ev *LoadEvent) (err error) {
+	b.parseLoadEventData(buf, ev, ev.header.type_ != LOAD_EVENT)
+	return
+}
+
+// parseLoadEventData parses the post-header and body of a load event (also
+// found in CREATE_FILE_EVENT), the field and line options being stored in
+// the old (single character) or new format, and returns the number of bytes
+// read.
+func (b *Binlog) parseLoadEventData(buf []byte, ev *LoadEvent, newFormat bool) int {
 	var off int
 
 	ev.slaveProxyId = binary.LittleEndian.Uint32(buf[off:])
@@ -473,7 +917,7 @@ func (b *Binlog) parseLoadEvent(buf []byte, ev *LoadEvent) (err error) {
 	ev.fieldCount = binary.LittleEndian.Uint32(buf[off:])
 	off += 4
 
-	if ev.header.type_ == LOAD_EVENT {
+	if !newFormat {
 		ev.fieldTerminator = string(buf[off])
 		off++
 
@@ -553,7 +997,7 @@ func (b *Binlog) parseLoadEvent(buf []byte, ev *LoadEvent) (err error) {
 	ev.file, n = getNullTerminatedString(buf[off:])
 	off += n
 
-	return
+	return off
 }
 
 func (b *Binlog) parseSlaveEvent(buf []byte, ev *SlaveEvent) (err error) {
@@ -575,13 +1019,30 @@ func (b *Binlog) parseSlaveEvent(buf []byte, ev *SlaveEvent) (err error) {
 	return
 }
 
+// length of the load event post-header
+const _LOAD_HEADER_LENGTH = 18
+
 func (b *Binlog) parseCreateFileEvent(buf []byte, ev *CreateFileEvent) (err error) {
 	var off int
 
-	ev.fileId = binary.LittleEndian.Uint32(buf[off:])
-	off += 4
+	// load event post-header, file id, load event body and the first block
+	if len(buf) < _LOAD_HEADER_LENGTH+4 {
+		return
+	}
 
-	ev.data = buf[off:]
+	ev.fileId = binary.LittleEndian.Uint32(buf[_LOAD_HEADER_LENGTH:])
+
+	load := make([]byte, 0, len(buf)-4)
+	load = append(load, buf[:_LOAD_HEADER_LENGTH]...)
+	load = append(load, buf[_LOAD_HEADER_LENGTH+4:]...)
+
+	ev.load = new(LoadEvent)
+	ev.load.header = ev.header
+	off = b.parseLoadEventData(load, ev.load, true) + 4
+
+	if off < len(buf) {
+		ev.data = buf[off:]
+	}
 	return
 }
 
@@ -718,7 +1179,7 @@ func (b *Binlog) parseExecuteLoadQueryEvent(buf []byte, ev *ExecuteLoadQueryEven
 	off += 2
 
 	ev.statusVarsLength = binary.LittleEndian.Uint16(buf[off:])
-	off += 4
+	off += 2
 
 	ev.fileId = binary.LittleEndian.Uint32(buf[off:])
 	off += 4
@@ -730,7 +1191,21 @@ func (b *Binlog) parseExecuteLoadQueryEvent(buf []byte, ev *ExecuteLoadQueryEven
 	off += 4
 
 	ev.dupHandlingFlags = uint8(buf[off])
+	off++
+
+	// same as query event
+	length := int(ev.statusVarsLength)
+	if off+length+int(ev.schemaLength)+1 > len(buf) {
+		return
+	}
+	ev.statusVars = string(buf[off : off+length])
+	parseQueryStatusVars(buf[off:off+length], &ev.status)
+	off += length
 
+	ev.schema = string(buf[off : off+int(ev.schemaLength)])
+	off += int(ev.schemaLength) + 1
+
+	ev.query = string(buf[off:])
 	return
 }
 
@@ -805,14 +1280,168 @@ func (b *Binlog) parseTableMapEvent(buf []byte, ev *TableMapEvent) (err error) {
 			ev.columns[i].nullable = true
 		}
 	}
+	off += nullBitmapSize
+
+	// optional meta data (binlog_row_metadata, MySQL 8.0+)
+	for off+1 < len(buf) {
+		type_ := uint8(buf[off])
+		off++
+
+		length, n := getLenencInt(buf[off:])
+		off += n
+
+		if off+int(length) > len(buf) {
+			break
+		}
+		b.parseTableMapMetadata(buf[off:off+int(length)], type_, ev)
+		off += int(length)
+	}
 
 	return
 }
 
+// table map optional meta data types
+const (
+	_TABLE_MAP_SIGNEDNESS = iota + 1
+	_TABLE_MAP_DEFAULT_CHARSET
+	_TABLE_MAP_COLUMN_CHARSET
+	_TABLE_MAP_COLUMN_NAME
+	_TABLE_MAP_SET_STR_VALUE
+	_TABLE_MAP_ENUM_STR_VALUE
+	_TABLE_MAP_GEOMETRY_TYPE
+	_TABLE_MAP_SIMPLE_PRIMARY_KEY
+	_TABLE_MAP_PRIMARY_KEY_WITH_PREFIX
+)
+
+// parseTableMapMetadata parses a single optional meta data field of the
+// table map event; unknown fields are ignored.
+func (b *Binlog) parseTableMapMetadata(buf []byte, type_ uint8, ev *TableMapEvent) {
+	var (
+		off int
+		v   uint64
+		n   int
+	)
+
+	switch type_ {
+	case _TABLE_MAP_SIGNEDNESS:
+		// one bit (MSB first) per numeric column
+		var j uint
+		for i := range ev.columns {
+			if !isNumericType(ev.columns[i].type_) {
+				continue
+			}
+			if int(j/8) >= len(buf) {
+				break
+			}
+			ev.columns[i].unsigned = buf[j/8]&(0x80>>(j%8)) != 0
+			ev.columns[i].signed = true
+			j++
+		}
+
+	case _TABLE_MAP_DEFAULT_CHARSET, _TABLE_MAP_COLUMN_CHARSET:
+		var charsets []uint16
+
+		if type_ == _TABLE_MAP_DEFAULT_CHARSET {
+			// default charset followed by (index, charset) pairs for
+			// the character columns using a different one
+			v, n = getLenencInt(buf[off:])
+			off += n
+			def := uint16(v)
+
+			exceptions := make(map[uint64]uint16)
+			for off < len(buf) {
+				idx, n := getLenencInt(buf[off:])
+				off += n
+				v, n = getLenencInt(buf[off:])
+				off += n
+				exceptions[idx] = uint16(v)
+			}
+
+			for i := range ev.columns {
+				if isCharacterType(&ev.columns[i]) {
+					charset, ok := exceptions[uint64(len(charsets))]
+					if !ok {
+						charset = def
+					}
+					charsets = append(charsets, charset)
+				}
+			}
+		} else {
+			for off < len(buf) {
+				v, n = getLenencInt(buf[off:])
+				off += n
+				charsets = append(charsets, uint16(v))
+			}
+		}
+
+		var j int
+		for i := range ev.columns {
+			if isCharacterType(&ev.columns[i]) && j < len(charsets) {
+				ev.columns[i].charset = charsets[j]
+				j++
+			}
+		}
+
+	case _TABLE_MAP_COLUMN_NAME:
+		for i := range ev.columns {
+			if off >= len(buf) {
+				break
+			}
+			v, n = getLenencInt(buf[off:])
+			off += n
+			ev.columns[i].name = string(buf[off : off+int(v)])
+			off += int(v)
+		}
+
+	case _TABLE_MAP_SIMPLE_PRIMARY_KEY, _TABLE_MAP_PRIMARY_KEY_WITH_PREFIX:
+		for off < len(buf) {
+			v, n = getLenencInt(buf[off:])
+			off += n
+			ev.primaryKey = append(ev.primaryKey, int(v))
+
+			// skip the prefix length
+			if type_ == _TABLE_MAP_PRIMARY_KEY_WITH_PREFIX {
+				_, n = getLenencInt(buf[off:])
+				off += n
+			}
+		}
+
+	default:
+	}
+}
+
+// isNumericType returns whether the columns of the specified type are
+// covered by the signedness meta data.
+func isNumericType(type_ uint8) bool {
+	switch type_ {
+	case _TYPE_TINY, _TYPE_SHORT, _TYPE_INT24, _TYPE_LONG,
+		_TYPE_LONG_LONG, _TYPE_NEW_DECIMAL, _TYPE_FLOAT, _TYPE_DOUBLE,
+		_TYPE_DECIMAL:
+		return true
+	default:
+	}
+	return false
+}
+
+// isCharacterType returns whether the specified column is covered by the
+// charset meta data. Note: ENUM and SET columns are logged as _TYPE_STRING
+// with their real type in the meta data.
+func isCharacterType(c *EventColumn) bool {
+	switch c.type_ {
+	case _TYPE_VARCHAR, _TYPE_VARSTRING, _TYPE_BLOB:
+		return true
+	case _TYPE_STRING:
+		realType := uint8(c.meta & 0xff)
+		return realType != _TYPE_ENUM && realType != _TYPE_SET
+	default:
+	}
+	return false
+}
+
 func getMetaDataSize(type_ uint8) uint8 {
 	switch type_ {
 	case _TYPE_TINY_BLOB, _TYPE_BLOB, _TYPE_MEDIUM_BLOB, _TYPE_LONG_BLOB,
-		_TYPE_DOUBLE, _TYPE_FLOAT, _TYPE_GEOMETRY, _TYPE_TIME2,
+		_TYPE_JSON, _TYPE_DOUBLE, _TYPE_FLOAT, _TYPE_GEOMETRY, _TYPE_TIME2,
 		_TYPE_DATETIME2, _TYPE_TIMESTAMP2:
 		return 1
 
@@ -840,176 +1469,1110 @@ func (b *Binlog) parseIncidentEvent(buf []byte, ev *IncidentEvent) (err error) {
 	return
 }
 
-// Note: There was no after-image in v0.
-func (b *Binlog) parseRowsEvent(buf []byte, ev *RowsEvent) (err error) {
-	var (
-		off    int
-		length int
-	)
+// parseRowsEventPostHeader returns the table id and flags stored in the
+// post-header of a rows event.
+func (b *Binlog) parseRowsEventPostHeader(buf []byte, type_ uint8) (tableId uint64, flags uint16) {
+	var off int
 
-	if b.desc.postHeaderLength[ev.header.type_-1] == 6 {
-		ev.tableId = uint64(binary.LittleEndian.Uint32(buf[off:]))
+	if b.desc.postHeaderLength[type_-1] == 6 {
+		tableId = uint64(binary.LittleEndian.Uint32(buf[off:]))
 		off += 4
 	} else {
-		ev.tableId = getUint48(buf)
+		tableId = getUint48(buf)
+		off += 6
+	}
+
+	flags = binary.LittleEndian.Uint16(buf[off:])
+	return
+}
+
+// Note: There was no after-image in v0.
+func (b *Binlog) parseRowsEvent(buf []byte, ev *RowsEvent) (err error) {
+	off := b.parseRowsEventHeader(buf, ev)
+
+	ev.rows1.Rows = make([]EventRow, 0)
+	if isUpdateRowsEvent(ev.header.type_) {
+		ev.rows2.Rows = make([]EventRow, 0)
+	}
+
+	// the rows can't be decoded without the table map
+	if ev.tableMap == nil {
+		return
+	}
+
+	if ev.columnCount > uint64(len(ev.tableMap.columns)) {
+		ev.err = myError(ErrInvalidRow, "column count mismatch")
+		return ev.err
+	}
+
+	var (
+		n             int
+		before, after EventRow
+	)
+
+	// the rows decoded before an error are kept, a row (or pair of
+	// before/after images) is only added once fully decoded
+	for off < len(buf) {
+		before, n, err = b.parseEventRow(buf[off:], ev.tableMap,
+			ev.columnCount, ev.columnsPresentBitmap1, nil)
+		if err != nil {
+			ev.err = err
+			return
+		}
+		off += n
+
+		if isUpdateRowsEvent(ev.header.type_) {
+			var partial *partialJSON
+
+			if ev.header.type_ == PARTIAL_UPDATE_ROWS_EVENT {
+				partial, n = parsePartialJSON(buf[off:], ev, before)
+				off += n
+			}
+
+			after, n, err = b.parseEventRow(buf[off:], ev.tableMap,
+				ev.columnCount, ev.columnsPresentBitmap2, partial)
+			if err != nil {
+				ev.err = err
+				return
+			}
+			off += n
+			ev.rows2.Rows = append(ev.rows2.Rows, after)
+		}
+		ev.rows1.Rows = append(ev.rows1.Rows, before)
+	}
+
+	return
+}
+
+// isUpdateRowsEvent returns whether the rows events of the specified type
+// carry both a before and an after image.
+func isUpdateRowsEvent(type_ uint8) bool {
+	switch type_ {
+	case UPDATE_ROWS_EVENT_V1, UPDATE_ROWS_EVENT, PARTIAL_UPDATE_ROWS_EVENT:
+		return true
+	default:
+	}
+	return false
+}
+
+// value options of the after image (PARTIAL_UPDATE_ROWS_EVENT)
+const _PARTIAL_JSON_UPDATES = 1
+
+// partial JSON update of an after image
+type partialJSON struct {
+	bits   []byte   // one bit per JSON column of the image
+	before EventRow // before image
+}
+
+// parsePartialJSON parses the value options preceding the after image of a
+// PARTIAL_UPDATE_ROWS_EVENT row and returns the partial update (nil if
+// none) and the number of bytes read.
+func parsePartialJSON(buf []byte, ev *RowsEvent, before EventRow) (*partialJSON, int) {
+	var (
+		off     int
+		options uint64
+	)
+
+	options, off = getLenencInt(buf)
+	if (options & _PARTIAL_JSON_UPDATES) == 0 {
+		return nil, off
+	}
+
+	// one bit per JSON column of the after image
+	var count int
+	for i := uint64(0); i < ev.columnCount; i++ {
+		if ev.tableMap.columns[i].type_ == _TYPE_JSON &&
+			isNull(ev.columnsPresentBitmap2, uint16(i), 0) {
+			count++
+		}
+	}
+
+	length := (count + 7) / 8
+	if off+length > len(buf) {
+		// truncated, so will be the after image
+		return nil, len(buf)
+	}
+	p := &partialJSON{bits: buf[off : off+length], before: before}
+	return p, off + length
+}
+
+// parseRowsEventHeader parses the rows event fields preceding the rows
+// and returns the offset of the first row.
+func (b *Binlog) parseRowsEventHeader(buf []byte, ev *RowsEvent) int {
+	var (
+		off    int
+		length int
+	)
+
+	ev.tableId, ev.flags = b.parseRowsEventPostHeader(buf, ev.header.type_)
+	if b.desc.postHeaderLength[ev.header.type_-1] == 6 {
 		off += 6
+	} else {
+		off += 8
+	}
+
+	if b.desc.postHeaderLength[ev.header.type_-1] == 10 {
+		length = int(binary.LittleEndian.Uint16(buf[off:])) - 2
+		off += 2
+		ev.extraData = buf[off : off+length]
+		off += length
+	}
+
+	ev.columnCount, length = getLenencInt(buf[off:])
+	off += length
+
+	length = int((ev.columnCount + 7) / 8)
+	ev.columnsPresentBitmap1 = buf[off : off+length]
+	off += length
+	if isUpdateRowsEvent(ev.header.type_) {
+		ev.columnsPresentBitmap2 = buf[off : off+length]
+		off += length
+	}
+	return off
+}
+
+func (b *Binlog) parseEventRow(buf []byte, tableMap *TableMapEvent,
+	columnCount uint64, columnsPresentBitmap []byte,
+	partial *partialJSON) (EventRow, int, error) {
+	var (
+		off int
+		r   EventRow
+
+		// index of the column (resp. JSON column) in the image
+		index     uint16
+		jsonIndex int
+	)
+
+	r.Columns = make([]interface{}, 0, columnCount)
+
+	nullBitmapSize := int((setBitCount(columnsPresentBitmap) + 7) / 8)
+	if nullBitmapSize > len(buf) {
+		return r, 0, rowTruncated()
+	}
+	nullBitmap := buf[off : off+nullBitmapSize]
+	off += nullBitmapSize
+
+	for i := uint64(0); i < columnCount; i++ {
+		c := &tableMap.columns[i]
+
+		if !isNull(columnsPresentBitmap, uint16(i), 0) {
+			// not part of the image
+			r.Columns = append(r.Columns, nil)
+			continue
+		}
+
+		null := isNull(nullBitmap, index, 0)
+		index++
+
+		if null {
+			r.Columns = append(r.Columns, nil)
+		} else if c.type_ == _TYPE_JSON {
+			// json.RawMessage ([]JSONDiff for partial updates that
+			// can't be applied)
+			var (
+				v interface{}
+				n int
+			)
+
+			if partial != nil && isNull(partial.bits, uint16(jsonIndex), 0) {
+				var before interface{}
+				if i < uint64(len(partial.before.Columns)) {
+					before = partial.before.Columns[i]
+				}
+				if len(buf[off:]) < 4 ||
+					4+int(binary.LittleEndian.Uint32(buf[off:])) > len(buf[off:]) {
+					return r, 0, rowTruncated()
+				}
+				v, n = parseJSONDiffColumn(buf[off:], before)
+			} else {
+				size, err := rowValueSize(buf[off:], c.type_, c.meta)
+				if err != nil {
+					return r, 0, err
+				}
+				if size > len(buf[off:]) {
+					return r, 0, rowTruncated()
+				}
+				v, n = parseJSONColumn(buf[off:off+size], c.meta)
+			}
+			r.Columns = append(r.Columns, v)
+			off += n
+		} else {
+			v, n, err := parseRowValue(buf[off:], c)
+			if err != nil {
+				return r, 0, err
+			}
+			r.Columns = append(r.Columns, v)
+			off += n
+		}
+
+		if c.type_ == _TYPE_JSON {
+			jsonIndex++
+		}
+	}
+	return r, off, nil
+}
+
+// parseStringMeta returns the real type (_TYPE_STRING, _TYPE_ENUM or
+// _TYPE_SET) and the length of a _TYPE_STRING column from its meta data :
+// the real type, then the length, whose 2 high bits are stored inverted in
+// the real type for CHAR columns longer than 255 bytes.
+func parseStringMeta(meta uint16) (uint8, int) {
+	realType, length := uint8(meta&0xff), int(meta>>8)
+	if (realType & 0x30) != 0x30 {
+		length |= int((realType&0x30)^0x30) << 4
+		realType |= 0x30
+	}
+	return realType, length
+}
+
+// rowValueSize returns the size of the value of the specified column type in
+// a row image, length prefix included.
+func rowValueSize(b []byte, type_ uint8, meta uint16) (int, error) {
+	var prefix int
+
+	switch type_ {
+	case _TYPE_TINY, _TYPE_YEAR:
+		return 1, nil
+	case _TYPE_SHORT:
+		return 2, nil
+	case _TYPE_INT24, _TYPE_DATE, _TYPE_NEW_DATE, _TYPE_TIME:
+		return 3, nil
+	case _TYPE_LONG, _TYPE_FLOAT, _TYPE_TIMESTAMP:
+		return 4, nil
+	case _TYPE_LONG_LONG, _TYPE_DOUBLE, _TYPE_DATETIME:
+		return 8, nil
+
+	// fractional seconds stored on (fsp + 1) / 2 bytes
+	case _TYPE_TIMESTAMP2:
+		return 4 + int(meta+1)/2, nil
+	case _TYPE_DATETIME2:
+		return 5 + int(meta+1)/2, nil
+	case _TYPE_TIME2:
+		return 3 + int(meta+1)/2, nil
+
+	case _TYPE_NEW_DECIMAL:
+		precision, scale := int(meta&0xff), int(meta>>8)
+		if precision == 0 || precision < scale {
+			break
+		}
+		return getDecimalBinarySize(precision, scale), nil
+
+	case _TYPE_BIT:
+		// number of bits % 8, then number of bytes
+		return int(meta>>8) + int(meta&0xff+7)/8, nil
+
+	case _TYPE_STRING, _TYPE_ENUM, _TYPE_SET:
+		realType, length := parseStringMeta(meta)
+		switch realType {
+		case _TYPE_ENUM, _TYPE_SET:
+			// index or bitmap
+			return length, nil
+		case _TYPE_STRING:
+			if prefix = 1; length > 255 {
+				prefix = 2
+			}
+		default:
+			return 0, myError(ErrInvalidRow, fmt.Sprintf("column type %d",
+				realType))
+		}
+
+	case _TYPE_VARCHAR, _TYPE_VARSTRING:
+		if prefix = 1; meta > 255 {
+			prefix = 2
+		}
+
+	case _TYPE_TINY_BLOB, _TYPE_BLOB, _TYPE_MEDIUM_BLOB, _TYPE_LONG_BLOB,
+		_TYPE_GEOMETRY, _TYPE_JSON:
+		// length stored on meta bytes
+		prefix = int(meta)
+		if prefix < 1 || prefix > 4 {
+			break
+		}
+
+	default:
+	}
+
+	if prefix == 0 {
+		return 0, myError(ErrInvalidRow, fmt.Sprintf("column type %d", type_))
+	}
+	if prefix > len(b) {
+		return 0, rowTruncated()
+	}
+
+	var length int
+	for i := prefix - 1; i >= 0; i-- {
+		length = length<<8 | int(b[i])
+	}
+	return prefix + length, nil
+}
+
+// parseRowValue parses the value of the specified column in a row image and
+// returns it along with the number of bytes read :
+//
+//	TINYINT ... BIGINT        int8, int16, int32 (MEDIUMINT), int64
+//	YEAR                      int16
+//	FLOAT, DOUBLE             float32, float64
+//	DECIMAL                   string (exact value)
+//	DATE, DATETIME, TIMESTAMP time.Time (UTC), string if zero (invalid)
+//	TIME                      time.Duration
+//	ENUM, SET                 uint16 (index), uint64 (bitmap)
+//	BIT                       string (big-endian bytes)
+//	strings, BLOB, GEOMETRY   string
+func parseRowValue(b []byte, c *EventColumn) (interface{}, int, error) {
+	type_, meta := c.type_, c.meta
+
+	size, err := rowValueSize(b, type_, meta)
+	if err != nil {
+		return nil, 0, err
+	}
+	if size > len(b) {
+		return nil, 0, rowTruncated()
+	}
+	b = b[:size]
+
+	if type_ == _TYPE_STRING || type_ == _TYPE_ENUM || type_ == _TYPE_SET {
+		type_, _ = parseStringMeta(meta)
+	}
+
+	switch type_ {
+	case _TYPE_TINY:
+		return parseInt8(b), size, nil
+	case _TYPE_SHORT:
+		return parseInt16(b), size, nil
+	case _TYPE_INT24:
+		// sign extended
+		return int32(getUint24(b)<<8) >> 8, size, nil
+	case _TYPE_LONG:
+		return parseInt32(b), size, nil
+	case _TYPE_LONG_LONG:
+		return parseInt64(b), size, nil
+	case _TYPE_YEAR:
+		if b[0] == 0 {
+			return int16(0), size, nil
+		}
+		return int16(1900 + int(b[0])), size, nil
+	case _TYPE_FLOAT:
+		return parseFloat(b), size, nil
+	case _TYPE_DOUBLE:
+		return parseDouble(b), size, nil
+
+	case _TYPE_NEW_DECIMAL:
+		v, n := decodeDecimal(b, int(meta&0xff), int(meta>>8))
+		if n == 0 {
+			return nil, 0, myError(ErrInvalidRow, "invalid decimal")
+		}
+		return v, size, nil
+
+	case _TYPE_DATE, _TYPE_NEW_DATE:
+		// year (15 bits), month (4 bits), day (5 bits)
+		v := int(getUint24(b))
+		return dateValue(_TYPE_DATE, v>>9, (v>>5)&0x0f, v&0x1f, 0, 0, 0, 0),
+			size, nil
+
+	case _TYPE_DATETIME:
+		// YYYYMMDDhhmmss
+		v := binary.LittleEndian.Uint64(b)
+		d, t := int(v/1000000), int(v%1000000)
+		return dateValue(_TYPE_DATETIME, d/10000, (d/100)%100, d%100,
+			t/10000, (t/100)%100, t%100, 0), size, nil
+
+	case _TYPE_TIMESTAMP, _TYPE_TIMESTAMP2:
+		var sec, usec int64
+		if type_ == _TYPE_TIMESTAMP {
+			sec = int64(binary.LittleEndian.Uint32(b))
+		} else {
+			sec = int64(bigEndianInteger(b, 0, 4))
+			usec = int64(parseFraction(b[4:], meta))
+		}
+		if sec == 0 && usec == 0 {
+			// zero timestamp
+			return dateValue(_TYPE_TIMESTAMP, 0, 0, 0, 0, 0, 0, 0), size, nil
+		}
+		return time.Unix(sec, usec*1000).UTC(), size, nil
+
+	case _TYPE_DATETIME2:
+		// sign (1 bit, set), year * 13 + month (17 bits), day (5 bits),
+		// hour (5 bits), minute (6 bits), second (6 bits)
+		v := int64(bigEndianInteger(b, 0, 5)) - 0x8000000000
+		ymd, hms := int(v>>17), int(v&(1<<17-1))
+		ym := ymd >> 5
+		return dateValue(_TYPE_DATETIME, ym/13, ym%13, ymd&0x1f, hms>>12,
+			(hms>>6)&0x3f, hms&0x3f, parseFraction(b[5:], meta)), size, nil
+
+	case _TYPE_TIME:
+		// [-]HHMMSS
+		v := int(int32(getUint24(b)<<8) >> 8)
+		d := time.Duration(v/10000)*time.Hour +
+			time.Duration((v/100)%100)*time.Minute +
+			time.Duration(v%100)*time.Second
+		return d, size, nil
+
+	case _TYPE_TIME2:
+		return parseTime2(b, meta), size, nil
+
+	case _TYPE_ENUM, _TYPE_SET:
+		// index or bitmap (little-endian)
+		var v uint64
+		for i := len(b) - 1; i >= 0; i-- {
+			v = v<<8 | uint64(b[i])
+		}
+		if type_ == _TYPE_ENUM {
+			return uint16(v), size, nil
+		}
+		return v, size, nil
+
+	case _TYPE_BIT:
+		return string(b), size, nil
+
+	default:
+	}
+
+	// strings, skip the length prefix
+	prefix := 1
+	switch type_ {
+	case _TYPE_VARCHAR, _TYPE_VARSTRING:
+		if meta > 255 {
+			prefix = 2
+		}
+	case _TYPE_STRING:
+		if _, length := parseStringMeta(meta); length > 255 {
+			prefix = 2
+		}
+	default:
+		prefix = int(meta)
+	}
+	return string(b[prefix:]), size, nil
+}
+
+// parseFraction parses the fractional seconds of a TIMESTAMP2, DATETIME2
+// value, stored big-endian on (fsp + 1) / 2 bytes, and returns them in
+// microseconds.
+func parseFraction(b []byte, fsp uint16) int {
+	switch fsp {
+	case 1, 2:
+		return int(b[0]) * 10000
+	case 3, 4:
+		return bigEndianInteger(b, 0, 2) * 100
+	case 5, 6:
+		return bigEndianInteger(b, 0, 3)
+	default:
+	}
+	return 0
+}
+
+// parseTime2 parses a TIME2 value : the sign (1 bit, set), hour (10 bits),
+// minute (6 bits), second (6 bits) then the fractional seconds, the whole
+// value being stored big-endian with an offset (negative values are thus
+// stored as their two's complement).
+func parseTime2(b []byte, fsp uint16) time.Duration {
+	var (
+		v    int64 // hms << 24 | microseconds
+		frac int64
+	)
+
+	hms := int64(bigEndianInteger(b, 0, 3)) - 0x800000
+
+	switch fsp {
+	case 1, 2:
+		if frac = int64(b[3]); hms < 0 && frac != 0 {
+			hms++
+			frac -= 0x100
+		}
+		v = hms<<24 + frac*10000
+	case 3, 4:
+		if frac = int64(bigEndianInteger(b, 3, 2)); hms < 0 && frac != 0 {
+			hms++
+			frac -= 0x10000
+		}
+		v = hms<<24 + frac*100
+	case 5, 6:
+		v = int64(bigEndianInteger(b, 0, 6)) - 0x800000000000
+	default:
+		v = hms << 24
+	}
+
+	neg := v < 0
+	if neg {
+		v = -v
+	}
+
+	hms, frac = v>>24, v&0xffffff
+	d := time.Duration((hms>>12)&0x3ff)*time.Hour +
+		time.Duration((hms>>6)&0x3f)*time.Minute +
+		time.Duration(hms&0x3f)*time.Second +
+		time.Duration(frac)*time.Microsecond
+	if neg {
+		d = -d
+	}
+	return d
+}
+
+// dateValue returns the specified date as a time.Time (UTC), or as a string if
+// it can't be represented as such (zero dates, dates with a zero month or
+// day).
+func dateValue(type_ uint8, year, month, day, hour, min, sec, usec int) interface{} {
+	if month == 0 || day == 0 {
+		s := fmt.Sprintf("%04d-%02d-%02d", year, month, day)
+		if type_ != _TYPE_DATE {
+			s += fmt.Sprintf(" %02d:%02d:%02d", hour, min, sec)
+		}
+		if usec != 0 {
+			s += fmt.Sprintf(".%06d", usec)
+		}
+		return s
+	}
+	return time.Date(year, time.Month(month), day, hour, min, sec, usec*1000,
+		time.UTC)
+}
+
+func rowTruncated() error {
+	return myError(ErrInvalidRow, "truncated row image")
+}
+
+func (b *Binlog) parseGtidLogEvent(buf []byte, ev *GtidLogEvent) {
+	var off int
+	ev.gtid.commitFlag = (buf[off] != 0)
+	off++
+	copy(ev.gtid.sourceId.data[0:], buf[off:off+16])
+	off += 16
+	ev.gtid.groupNumber = getInt64(buf[off:])
+	off += 8
+
+	// logical clock (MySQL 5.7+), preceded by its type code
+	if len(buf) < off+17 {
+		return
+	}
+	off++
+	ev.lastCommitted = getInt64(buf[off:])
+	off += 8
+	ev.sequenceNumber = getInt64(buf[off:])
+	off += 8
+
+	// commit timestamps (MySQL 8.0.1+), the original commit timestamp is
+	// only present if it differs from the immediate one
+	if len(buf) < off+7 {
+		return
+	}
+	ev.immediateCommitTimestamp = getUint56(buf[off:])
+	off += 7
+	ev.originalCommitTimestamp = ev.immediateCommitTimestamp
+	if (ev.immediateCommitTimestamp & (1 << 55)) != 0 {
+		ev.immediateCommitTimestamp &^= 1 << 55
+		if len(buf) < off+7 {
+			return
+		}
+		ev.originalCommitTimestamp = getUint56(buf[off:])
+		off += 7
+	}
+
+	// transaction length (MySQL 8.0.2+)
+	if len(buf) <= off {
+		return
+	}
+	var n int
+	ev.transactionLength, n = getLenencInt(buf[off:])
+	off += n
+
+	// server versions (MySQL 8.0.14+), the original server version is
+	// only present if it differs from the immediate one
+	if len(buf) < off+4 {
+		return
+	}
+	ev.immediateServerVersion = binary.LittleEndian.Uint32(buf[off:])
+	off += 4
+	ev.originalServerVersion = ev.immediateServerVersion
+	if (ev.immediateServerVersion & (1 << 31)) != 0 {
+		ev.immediateServerVersion &^= 1 << 31
+		if len(buf) < off+4 {
+			return
+		}
+		ev.originalServerVersion = binary.LittleEndian.Uint32(buf[off:])
+	}
+	return
+}
+
+// tagged GTID event fields
+const (
+	_GTID_TAGGED_FLAGS = iota
+	_GTID_TAGGED_UUID
+	_GTID_TAGGED_GNO
+	_GTID_TAGGED_TAG
+	_GTID_TAGGED_LAST_COMMITTED
+	_GTID_TAGGED_SEQUENCE_NUMBER
+	_GTID_TAGGED_IMMEDIATE_COMMIT_TIMESTAMP
+	_GTID_TAGGED_ORIGINAL_COMMIT_TIMESTAMP
+	_GTID_TAGGED_TRANSACTION_LENGTH
+	_GTID_TAGGED_IMMEDIATE_SERVER_VERSION
+	_GTID_TAGGED_ORIGINAL_SERVER_VERSION
+	_GTID_TAGGED_COMMIT_GROUP_TICKET
+)
+
+// parseGtidTaggedLogEvent parses GTID_TAGGED_LOG_EVENT, which (unlike the
+// other events) is written in the MySQL serialization format : the format
+// version, the message size and the last non-ignorable field id, followed by
+// the fields, each prefixed with its id.
+func (b *Binlog) parseGtidTaggedLogEvent(buf []byte, ev *GtidTaggedLogEvent) {
+	var (
+		off  int
+		n    int
+		v    uint64
+		size uint64
+	)
+
+	// header : version, size and last non-ignorable field
+	for i := 0; i < 3; i++ {
+		if off >= len(buf) {
+			return
+		}
+		v, n = getVarUint(buf[off:])
+		off += n
+		if i == 1 {
+			size = v
+		}
+	}
+
+	if size != 0 && size < uint64(len(buf)) {
+		buf = buf[:size]
+	}
+
+	// optional fields default to their immediate counterpart
+	var hasOriginalTs, hasOriginalVersion bool
+
+	for off < len(buf) {
+		var id uint64
+
+		id, n = getVarUint(buf[off:])
+		off += n
+		if off >= len(buf) {
+			break
+		}
+
+		switch id {
+		case _GTID_TAGGED_FLAGS:
+			ev.gtid.commitFlag = (buf[off] != 0)
+			off++
+		case _GTID_TAGGED_UUID:
+			if off+16 > len(buf) {
+				return
+			}
+			copy(ev.gtid.sourceId.data[0:], buf[off:off+16])
+			off += 16
+		case _GTID_TAGGED_GNO:
+			ev.gtid.groupNumber, n = getVarInt(buf[off:])
+			off += n
+		case _GTID_TAGGED_TAG:
+			v, n = getVarUint(buf[off:])
+			off += n
+			if off+int(v) > len(buf) {
+				return
+			}
+			ev.gtid.tag = string(buf[off : off+int(v)])
+			off += int(v)
+		case _GTID_TAGGED_LAST_COMMITTED:
+			ev.lastCommitted, n = getVarInt(buf[off:])
+			off += n
+		case _GTID_TAGGED_SEQUENCE_NUMBER:
+			ev.sequenceNumber, n = getVarInt(buf[off:])
+			off += n
+		case _GTID_TAGGED_IMMEDIATE_COMMIT_TIMESTAMP:
+			ev.immediateCommitTimestamp, n = getVarUint(buf[off:])
+			off += n
+		case _GTID_TAGGED_ORIGINAL_COMMIT_TIMESTAMP:
+			ev.originalCommitTimestamp, n = getVarUint(buf[off:])
+			off += n
+			hasOriginalTs = true
+		case _GTID_TAGGED_TRANSACTION_LENGTH:
+			ev.transactionLength, n = getVarUint(buf[off:])
+			off += n
+		case _GTID_TAGGED_IMMEDIATE_SERVER_VERSION:
+			v, n = getVarUint(buf[off:])
+			off += n
+			ev.immediateServerVersion = uint32(v)
+		case _GTID_TAGGED_ORIGINAL_SERVER_VERSION:
+			v, n = getVarUint(buf[off:])
+			off += n
+			ev.originalServerVersion = uint32(v)
+			hasOriginalVersion = true
+		case _GTID_TAGGED_COMMIT_GROUP_TICKET:
+			_, n = getVarUint(buf[off:])
+			off += n
+		default:
+			// unknown field, its size can't be determined
+			off = len(buf)
+		}
+	}
+
+	if !hasOriginalTs {
+		ev.originalCommitTimestamp = ev.immediateCommitTimestamp
+	}
+	if !hasOriginalVersion {
+		ev.originalServerVersion = ev.immediateServerVersion
+	}
+	return
+}
+
+func (b *Binlog) parseTransactionContextEvent(buf []byte, ev *TransactionContextEvent) {
+	var (
+		off              int
+		serverUuidLength int
+		snapshotLength   int
+		writeSetLength   int
+		readSetLength    int
+		err              error
+	)
+
+	if len(buf) < 18 {
+		return
+	}
+
+	serverUuidLength = int(buf[off])
+	off++
+	ev.threadId = binary.LittleEndian.Uint32(buf[off:])
+	off += 4
+	ev.gtidSpecified = (buf[off] != 0)
+	off++
+	snapshotLength = int(binary.LittleEndian.Uint32(buf[off:]))
+	off += 4
+	writeSetLength = int(binary.LittleEndian.Uint32(buf[off:]))
+	off += 4
+	readSetLength = int(binary.LittleEndian.Uint32(buf[off:]))
+	off += 4
+
+	if off+serverUuidLength+snapshotLength > len(buf) {
+		return
+	}
+	ev.serverUuid = string(buf[off : off+serverUuidLength])
+	off += serverUuidLength
+
+	if ev.snapshotVersion, _, err = decodeGTIDSet(buf[off : off+snapshotLength]); err != nil {
+		ev.snapshotVersion = new(GTIDSet)
+	}
+	off += snapshotLength
+
+	ev.writeSet, off = parseTransactionContextSet(buf, off, writeSetLength)
+	ev.readSet, off = parseTransactionContextSet(buf, off, readSetLength)
+	return
+}
+
+// parseTransactionContextSet reads count items (2-byte length followed by
+// the item) starting at off and returns them along with the new offset.
+func parseTransactionContextSet(buf []byte, off int, count int) ([]string, int) {
+	var set []string
+
+	for i := 0; i < count && off+2 <= len(buf); i++ {
+		length := int(binary.LittleEndian.Uint16(buf[off:]))
+		off += 2
+		if off+length > len(buf) {
+			break
+		}
+		set = append(set, string(buf[off:off+length]))
+		off += length
+	}
+	return set, off
+}
+
+func (b *Binlog) parseViewChangeEvent(buf []byte, ev *ViewChangeEvent) {
+	var (
+		off   int
+		count int
+	)
+
+	if len(buf) < 52 {
+		return
+	}
+
+	// null-padded
+	ev.viewId = strings.TrimRight(string(buf[off:off+40]), "\x00")
+	off += 40
+	ev.seqNumber = getInt64(buf[off:])
+	off += 8
+	count = int(binary.LittleEndian.Uint32(buf[off:]))
+	off += 4
+
+	ev.certificationInfo = make(map[string]string)
+	for i := 0; i < count && off+2 <= len(buf); i++ {
+		keyLength := int(binary.LittleEndian.Uint16(buf[off:]))
+		off += 2
+		if off+keyLength+4 > len(buf) {
+			break
+		}
+		key := string(buf[off : off+keyLength])
+		off += keyLength
+
+		valueLength := int(binary.LittleEndian.Uint32(buf[off:]))
+		off += 4
+		if off+valueLength > len(buf) {
+			break
+		}
+		ev.certificationInfo[key] = string(buf[off : off+valueLength])
+		off += valueLength
+	}
+	return
+}
+
+func (b *Binlog) parseXaPrepareLogEvent(buf []byte, ev *XaPrepareLogEvent) {
+	var (
+		off         int
+		gtridLength int
+		bqualLength int
+	)
+
+	if len(buf) < 13 {
+		return
 	}
 
-	ev.flags = binary.LittleEndian.Uint16(buf[off:])
-	off += 2
+	ev.onePhase = (buf[off] != 0)
+	off++
+	ev.formatId = getInt32(buf[off:])
+	off += 4
+	gtridLength = int(binary.LittleEndian.Uint32(buf[off:]))
+	off += 4
+	bqualLength = int(binary.LittleEndian.Uint32(buf[off:]))
+	off += 4
 
-	if b.desc.postHeaderLength[ev.header.type_-1] == 10 {
-		length = int(binary.LittleEndian.Uint16(buf[off:])) - 2
-		off += 2
-		ev.extraData = buf[off : off+length]
-		off += length
+	if off+gtridLength+bqualLength > len(buf) {
+		return
 	}
+	ev.gtrid = buf[off : off+gtridLength]
+	off += gtridLength
+	ev.bqual = buf[off : off+bqualLength]
+	return
+}
 
-	ev.columnCount, length = getLenencInt(buf[off:])
-	off += length
+func (b *Binlog) parsePreviousGtidsLogEvent(buf []byte, ev *PreviousGtidsLogEvent) {
+	var err error
 
-	length = int((ev.columnCount + 7) / 8)
-	ev.columnsPresentBitmap1 = buf[off : off+length]
-	off += length
-	if (ev.header.type_ == UPDATE_ROWS_EVENT_V1) ||
-		(ev.header.type_ == UPDATE_ROWS_EVENT) {
-		ev.columnsPresentBitmap2 = buf[off : off+length]
-		off += length
-	}
+	ev.data = make([]byte, len(buf))
+	copy(ev.data, buf[:])
 
-	ev.rows1.Rows = make([]EventRow, 0)
-	if (ev.header.type_ == UPDATE_ROWS_EVENT_V1) ||
-		(ev.header.type_ == UPDATE_ROWS_EVENT) {
-		ev.rows2.Rows = make([]EventRow, 0)
+	if ev.gtidSet, _, err = decodeGTIDSet(ev.data); err != nil {
+		// malformed event, report an empty set
+		ev.gtidSet = new(GTIDSet)
 	}
+	return
+}
+
+// transaction payload header fields
+const (
+	_PAYLOAD_HEADER_END_MARK = iota
+	_PAYLOAD_SIZE
+	_PAYLOAD_COMPRESSION_TYPE
+	_PAYLOAD_UNCOMPRESSED_SIZE
+)
 
+func (b *Binlog) parseTransactionPayloadEvent(buf []byte, ev *TransactionPayloadEvent) {
 	var (
-		n int
-		r EventRow
+		off int
+		n   int
 	)
 
+	if int(ev.header.type_) <= len(b.desc.postHeaderLength) {
+		off = int(b.desc.postHeaderLength[ev.header.type_-1])
+	}
+
+	// type, length, value fields up to the end mark
 	for off < len(buf) {
-		r, n = b.parseEventRow(buf[off:], ev.columnCount,
-			ev.columnsPresentBitmap1)
-		ev.rows1.Rows = append(ev.rows1.Rows, r)
+		var type_, length, v uint64
+
+		type_, n = getLenencInt(buf[off:])
 		off += n
-		if (ev.header.type_ == UPDATE_ROWS_EVENT_V1) ||
-			(ev.header.type_ == UPDATE_ROWS_EVENT) {
-			r, n = b.parseEventRow(buf[off:], ev.columnCount,
-				ev.columnsPresentBitmap2)
-			ev.rows2.Rows = append(ev.rows2.Rows, r)
-			off += n
+		if type_ == _PAYLOAD_HEADER_END_MARK {
+			break
+		}
+
+		length, n = getLenencInt(buf[off:])
+		off += n
+
+		if off+int(length) > len(buf) {
+			break
+		}
+		v, _ = getLenencInt(buf[off:])
+
+		switch type_ {
+		case _PAYLOAD_SIZE:
+			ev.payloadSize = v
+		case _PAYLOAD_COMPRESSION_TYPE:
+			ev.compressionType = v
+		case _PAYLOAD_UNCOMPRESSED_SIZE:
+			ev.uncompressedSize = v
+		default:
 		}
+		off += int(length)
 	}
 
+	ev.payload = buf[off:]
 	return
 }
 
-func (b *Binlog) parseEventRow(buf []byte, columnCount uint64,
-	columnsPresentBitmap []byte) (EventRow, int) {
+// events decompresses the payload and returns the events it contains.
+func (e *TransactionPayloadEvent) events() ([][]byte, error) {
 	var (
-		off int
-		r   EventRow
+		data []byte
+		err  error
 	)
 
-	r.Columns = make([]interface{}, 0, columnCount)
-
-	nullBitmapSize := int((setBitCount(columnsPresentBitmap) + 7) / 8)
-	nullBitmap := buf[off : off+nullBitmapSize]
-	off += nullBitmapSize
+	switch e.compressionType {
+	case PAYLOAD_COMPRESSION_NONE:
+		data = e.payload
 
-	for i := uint64(0); i < columnCount; i++ {
-		if isNull(nullBitmap, uint16(i), 0) == true {
-			r.Columns = append(r.Columns, nil)
-		} else {
-			switch b.tableMap.columns[i].type_ {
-			// string
-			case _TYPE_VARCHAR, _TYPE_VARSTRING:
-				v, n := parseString2(buf[off:], b.tableMap.columns[i].meta)
-				r.Columns = append(r.Columns, v)
-				off += n
+	case PAYLOAD_COMPRESSION_ZSTD:
+		fn := lookupDecompressor("zstd")
+		if fn == nil {
+			return nil, myError(ErrCompression, "zstd compressed "+
+				"transaction payload, no decompressor registered "+
+				"(see RegisterDecompressor)")
+		}
 
-			case _TYPE_STRING, _TYPE_ENUM,
-				_TYPE_SET, _TYPE_BLOB,
-				_TYPE_TINY_BLOB, _TYPE_MEDIUM_BLOB,
-				_TYPE_LONG_BLOB, _TYPE_GEOMETRY,
-				_TYPE_BIT, _TYPE_DECIMAL:
-				v, n := parseString(buf[off:])
-				r.Columns = append(r.Columns, v)
-				off += n
-			case _TYPE_NEW_DECIMAL:
-				v, n := parseNewDecimal(buf[off:], b.tableMap.columns[i].meta)
-				r.Columns = append(r.Columns, v)
-				off += n
-			// int64
-			case _TYPE_LONG_LONG:
-				r.Columns = append(r.Columns, parseInt64(buf[off:off+8]))
-				off += 8
+		var r io.Reader
+		if r, err = fn(bytes.NewReader(e.payload)); err != nil {
+			return nil, myError(ErrCompression, err)
+		}
+		data, err = ioutil.ReadAll(r)
+		if c, ok := r.(io.Closer); ok {
+			c.Close()
+		}
+		if err != nil {
+			return nil, myError(ErrCompression, err)
+		}
 
-			// int32
-			case _TYPE_LONG, _TYPE_INT24:
-				r.Columns = append(r.Columns, parseInt32(buf[off:off+4]))
-				off += 4
+	default:
+		return nil, myError(ErrCompression, fmt.Sprintf("unknown "+
+			"transaction payload compression type %d", e.compressionType))
+	}
 
-			// int16
-			case _TYPE_SHORT, _TYPE_YEAR:
-				r.Columns = append(r.Columns, parseInt16(buf[off:off+2]))
-				off += 2
+	if e.uncompressedSize != 0 && uint64(len(data)) != e.uncompressedSize {
+		return nil, myError(ErrCompression, fmt.Sprintf("transaction "+
+			"payload of %d bytes, expected %d", len(data),
+			e.uncompressedSize))
+	}
 
-			// int8
-			case _TYPE_TINY:
-				r.Columns = append(r.Columns, parseInt8(buf[off:off+1]))
-				off++
+	// split the events
+	var events [][]byte
 
-			// float64
-			case _TYPE_DOUBLE:
-				r.Columns = append(r.Columns, parseDouble(buf[off:off+8]))
-				off += 8
+	for off := 0; off < len(data); {
+		if len(data)-off < _EVENT_HEADER_LENGTH {
+			return nil, myError(ErrCompression, "truncated event in "+
+				"transaction payload")
+		}
 
-			// float32
-			case _TYPE_FLOAT:
-				r.Columns = append(r.Columns, parseFloat(buf[off:off+4]))
-				off += 4
+		size := int(binary.LittleEndian.Uint32(data[off+9:]))
+		if size < _EVENT_HEADER_LENGTH || off+size > len(data) {
+			return nil, myError(ErrCompression, "invalid event in "+
+				"transaction payload")
+		}
 
-			// time.Time
-			case _TYPE_DATE, _TYPE_DATETIME,
-				_TYPE_TIMESTAMP:
-				v, n := parseDate(buf[off:])
-				r.Columns = append(r.Columns, v)
-				off += n
+		events = append(events, data[off:off+size])
+		off += size
+	}
+	return events, nil
+}
 
-			// time.Duration
-			case _TYPE_TIME:
-				v, n := parseTime(buf[off:])
-				r.Columns = append(r.Columns, v)
-				off += n
+func isCompressedEvent(type_ uint8) bool {
+	return type_ >= QUERY_COMPRESSED_EVENT &&
+		type_ <= DELETE_ROWS_COMPRESSED_EVENT
+}
 
-			// TODO: map the following unhandled types accordingly
-			case _TYPE_NEW_DATE, _TYPE_TIMESTAMP2,
-				_TYPE_DATETIME2, _TYPE_TIME2,
-				_TYPE_NULL:
-				fallthrough
-			default:
-			}
-		}
+// uncompressedEventType returns the type of the event a compressed event
+// decompresses to, other types are returned unchanged.
+func uncompressedEventType(type_ uint8) uint8 {
+	switch type_ {
+	case QUERY_COMPRESSED_EVENT:
+		return QUERY_EVENT
+	case WRITE_ROWS_COMPRESSED_EVENT_V1:
+		return WRITE_ROWS_EVENT_V1
+	case UPDATE_ROWS_COMPRESSED_EVENT_V1:
+		return UPDATE_ROWS_EVENT_V1
+	case DELETE_ROWS_COMPRESSED_EVENT_V1:
+		return DELETE_ROWS_EVENT_V1
+	case WRITE_ROWS_COMPRESSED_EVENT:
+		return WRITE_ROWS_EVENT
+	case UPDATE_ROWS_COMPRESSED_EVENT:
+		return UPDATE_ROWS_EVENT
+	case DELETE_ROWS_COMPRESSED_EVENT:
+		return DELETE_ROWS_EVENT
+	default:
 	}
-	return r, off
+	return type_
 }
 
-func (b *Binlog) parseGtidLogEvent(buf []byte, ev *GtidLogEvent) {
+// uncompressEvent returns the body of a compressed event laid out as its
+// uncompressed counterpart. Only the query text (query event) or the rows
+// (rows events) are compressed.
+func (b *Binlog) uncompressEvent(type_ uint8, buf []byte) ([]byte, error) {
 	var off int
-	ev.gtid.commitFlag = (buf[off] != 0)
-	off++
-	copy(ev.gtid.sourceId.data[0:], buf[off:off+16])
-	off += 16
-	ev.gtid.groupNumber = getInt64(buf[off:])
-	return
+
+	realType := uncompressedEventType(type_)
+
+	switch realType {
+	case QUERY_EVENT:
+		off = 13
+		if len(buf) < off {
+			return nil, myError(ErrCompression, "truncated compressed "+
+				"query event")
+		}
+		off += int(binary.LittleEndian.Uint16(buf[11:])) // status vars
+		off += int(buf[8]) + 1                           // schema
+	default:
+		var ev RowsEvent
+		ev.header.type_ = realType
+		off = b.parseRowsEventHeader(buf, &ev)
+	}
+
+	if off > len(buf) {
+		return nil, myError(ErrCompression, "truncated compressed event")
+	}
+
+	data, err := decompressMariadbEvent(buf[off:])
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, off+len(data))
+	out = append(out, buf[:off]...)
+	return append(out, data...), nil
 }
 
-func (b *Binlog) parsePreviousGtidsLogEvent(buf []byte, ev *PreviousGtidsLogEvent) {
-	ev.data = make([]byte, len(buf))
-	copy(ev.data, buf[:])
-	return
+// decompressMariadbEvent decompresses the compressed part of a MariaDB
+// compressed event : a header byte (0x80 | algorithm << 4 | length size),
+// the uncompressed length (big-endian) and the zlib compressed data.
+func decompressMariadbEvent(buf []byte) ([]byte, error) {
+	if len(buf) < 1 || buf[0]&0x80 == 0 {
+		return nil, myError(ErrCompression, "invalid compressed event "+
+			"header")
+	}
+
+	if alg := (buf[0] >> 4) & 0x07; alg != 0 {
+		return nil, myError(ErrCompression, fmt.Sprintf("unknown "+
+			"event compression algorithm %d", alg))
+	}
+
+	lenlen := int(buf[0] & 0x07)
+	if lenlen < 1 || lenlen > 4 || len(buf) < 1+lenlen {
+		return nil, myError(ErrCompression, "invalid compressed event "+
+			"header")
+	}
+
+	var length int
+	for i := 1; i <= lenlen; i++ {
+		length = length<<8 | int(buf[i])
+	}
+
+	r, err := zlib.NewReader(bytes.NewReader(buf[1+lenlen:]))
+	if err != nil {
+		return nil, myError(ErrCompression, err)
+	}
+	defer r.Close()
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, myError(ErrCompression, err)
+	}
+
+	if len(data) != length {
+		return nil, myError(ErrCompression, fmt.Sprintf("compressed "+
+			"event of %d bytes, expected %d", len(data), length))
+	}
+	return data, nil
 }
 
 func (b *Binlog) parseAnnotateRowsEvent(buf []byte, ev *AnnotateRowsEvent) {
@@ -1052,8 +2615,7 @@ func (b *Binlog) parseGtidListEvent(buf []byte, ev *GtidListEvent) {
 	off += 4
 
 	ev.count = val & ((1 << 28) - 1)
-	ev.flags = uint8(val & (0xF << 28))
-	off++
+	ev.flags = uint8(val >> 28)
 
 	ev.list = make([]MariadbGtid, ev.count)
 
@@ -1068,61 +2630,256 @@ func (b *Binlog) parseGtidListEvent(buf []byte, ev *GtidListEvent) {
 }
 
 type fileReader struct {
-	name      string
-	file      *os.File
+	name   string // current binlog file
+	file   *os.File
+	offset uint64 // offset of the next event in the current file
+
+	// binlog files listed in the index file, if reading through one
+	index   string
+	files   []string
+	current int
+
+	// next file named by the ROTATE_EVENT at the end of the current file
+	rotateFile     string
+	rotatePosition uint64
+
+	// called with the name of the file entered and the position reading
+	// starts from (Binlog.enterFile)
+	enterFile func(file string, position uint64)
+
+	// wait for the events being written instead of stopping at the end
+	// of the last file
+	follow         bool
+	followInterval time.Duration
+	quit           chan struct{}
+	quitOnce       sync.Once
+
+	// format description event to be delivered first, when reading
+	// starts past the beginning of the file
+	description []byte
+
 	closed    bool
-	first     bool
 	eof       bool
 	e         error
 	nextEvent []byte
 }
 
+// begin starts reading at the specified position of the specified file;
+// with an index file, the file is looked up (by name) among the listed ones.
 func (fr *fileReader) begin(index binlogIndex) error {
 	var err error
 
-	if index.file != "" && index.file != fr.name {
-		fr.name = index.file
+	name := fr.name
+
+	if fr.index != "" {
+		if fr.files, err = readBinlogIndex(fr.index); err != nil {
+			return err
+		}
+
+		if len(fr.files) == 0 {
+			return myError(ErrFile, "no binlog files listed in "+fr.index)
+		}
 
-		// close the previously opened file
-		if !fr.closed {
-			if err = fr.close(); err != nil {
-				return myError(ErrFile, err)
+		fr.current = 0
+		if index.file != "" {
+			if fr.current = fr.lookup(index.file); fr.current < 0 {
+				return myError(ErrFile, "binlog file "+index.file+
+					" not listed in "+fr.index)
 			}
 		}
-		// open the new file
-		if fr.file, err = os.Open(fr.name); err != nil {
-			return myError(ErrFile, err)
+		name = fr.files[fr.current]
+	} else if index.file != "" && filepath.Base(index.file) != filepath.Base(fr.name) {
+		name = filepath.Join(filepath.Dir(fr.name), filepath.Base(index.file))
+	}
+
+	fr.eof = false
+	return fr.enter(name, index.position)
+}
+
+// open opens the specified binlog file, verifies its magic number and moves
+// to the specified position (the first event if 0).
+func (fr *fileReader) open(name string, position uint64) error {
+	var err error
+
+	// close the previously opened file
+	if err = fr.closeFile(); err != nil {
+		return err
+	}
+
+	if fr.file, err = os.Open(name); err != nil {
+		return myError(ErrFile, err)
+	}
+	fr.closed = false
+	fr.name = name
+
+	// read and verify magic number
+	magic := make([]byte, _BINLOG_HEADER_SIZE)
+	if _, err = io.ReadFull(fr.file, magic); err != nil ||
+		string(magic) != _BINLOG_MAGIC {
+		return myError(ErrFile, name+": invalid binlog magic")
+	}
+
+	if position < _BINLOG_HEADER_SIZE {
+		position = _BINLOG_HEADER_SIZE
+	}
+	fr.offset = position
+	fr.rotateFile = ""
+	fr.description = nil
+	return nil
+}
+
+// lookup returns the index of the specified file among the ones listed in
+// the index file, -1 if not found.
+func (fr *fileReader) lookup(name string) int {
+	for i, f := range fr.files {
+		if f == name || filepath.Base(f) == filepath.Base(name) {
+			return i
 		}
-		fr.closed = false
 	}
+	return -1
+}
 
-	if index.position > 0 {
-		if _, err = fr.file.Seek(int64(index.position), 0); err != nil {
-			// seek operation failed
-			return myError(ErrFile, err)
+// rotate records the file the events continue in once the current file is
+// over.
+func (fr *fileReader) rotate(file string, position uint64) {
+	fr.rotateFile = file
+	fr.rotatePosition = position
+}
+
+// nextFile switches to the file following the current one: the next one
+// listed in the index file, or the one named by the last ROTATE_EVENT (when
+// following, the next one in sequence if the server got restarted). It
+// returns io.EOF if there is none (yet).
+func (fr *fileReader) nextFile() error {
+	var err error
+
+	if fr.index != "" {
+		if fr.current+1 >= len(fr.files) && fr.follow {
+			// the server might have added some
+			if fr.files, err = readBinlogIndex(fr.index); err != nil {
+				return err
+			}
+		}
+
+		if fr.current+1 >= len(fr.files) {
+			return io.EOF
 		}
+		fr.current++
+		return fr.enter(fr.files[fr.current], 0)
 	}
 
-	// read and verify magic number
-	magic := make([]byte, 4)
-	if _, err = fr.file.Read(magic); err != nil {
-		return myError(ErrFile, err)
+	var name string
+	position := uint64(0)
 
+	if fr.rotateFile != "" {
+		name = filepath.Join(filepath.Dir(fr.name), filepath.Base(fr.rotateFile))
+		position = fr.rotatePosition
+	} else if fr.follow {
+		if name = nextBinlogFileName(fr.name); name == "" {
+			return io.EOF
+		}
 	} else {
-		// TODO: verify magic number [0xfe, 'b', 'i', 'n']
+		return io.EOF
 	}
-	if err = fr.readEvent(); err != nil {
+
+	if _, err = os.Stat(name); err != nil {
+		// the series ends here
+		return io.EOF
+	}
+	return fr.enter(name, position)
+}
+
+// enter opens the specified binlog file, to be read from the specified
+// position, and reports the switch.
+func (fr *fileReader) enter(name string, position uint64) error {
+	if err := fr.open(name, position); err != nil {
 		return err
 	}
 
-	fr.first = true
+	if fr.offset > _BINLOG_HEADER_SIZE {
+		if err := fr.readDescription(); err != nil {
+			return err
+		}
+	}
+
+	if fr.enterFile != nil {
+		fr.enterFile(filepath.Base(name), fr.offset)
+	}
 	return nil
 }
 
-func (fr *fileReader) close() error {
+// readDescription reads the format description event of the current file, to
+// be delivered before the event reading starts from (as done by the server):
+// the events can't be decoded without it.
+func (fr *fileReader) readDescription() error {
+	offset := fr.offset
+	fr.offset = _BINLOG_HEADER_SIZE
+	err := fr.readEvent()
+	fr.offset = offset
+
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return myError(ErrFile, fr.name+": no format description event")
+	} else if err != nil {
+		return err
+	}
+
+	if fr.nextEvent[_EVENT_TYPE_OFFSET] == FORMAT_DESCRIPTION_EVENT {
+		fr.description = artificialFDE(fr.nextEvent)
+	}
+	return nil
+}
+
+// artificialFDE returns a copy of the specified format description event
+// with a zero position, as sent by the server when the stream starts past the
+// beginning of a binlog file: the event does not move the binlog position.
+func artificialFDE(ev []byte) []byte {
+	fde := append([]byte(nil), ev...)
+	binary.LittleEndian.PutUint32(fde[_LOG_POS_OFFSET:], 0)
+
+	if fdeChecksumAlg(fde) == BINLOG_CHECKSUM_ALG_CRC32 {
+		// checksummed without the _LOG_EVENT_BINLOG_IN_USE_F flag
+		end := len(fde) - _BINLOG_CHECKSUM_LENGTH
+		flags := binary.LittleEndian.Uint16(fde[_FLAGS_OFFSET:])
+		binary.LittleEndian.PutUint16(fde[_FLAGS_OFFSET:],
+			flags&^_LOG_EVENT_BINLOG_IN_USE_F)
+		binary.LittleEndian.PutUint32(fde[end:], crc32.ChecksumIEEE(fde[:end]))
+		binary.LittleEndian.PutUint16(fde[_FLAGS_OFFSET:], flags)
+	}
+	return fde
+}
+
+// finished returns whether there is nothing more to be read from the
+// current file: always when not following, otherwise if it has been
+// rotated or closed by the server (LOG_EVENT_BINLOG_IN_USE_F cleared).
+func (fr *fileReader) finished() bool {
+	if !fr.follow || fr.rotateFile != "" {
+		return true
+	}
+
+	// the server clears the flag of the first event (FDE) in place
+	flags := make([]byte, 2)
+	if _, err := fr.file.ReadAt(flags,
+		_BINLOG_HEADER_SIZE+_FLAGS_OFFSET); err != nil {
+		return false
+	}
+	return (binary.LittleEndian.Uint16(flags) & _LOG_EVENT_BINLOG_IN_USE_F) == 0
+}
+
+// wait waits for more events to be written, it returns false if the reader
+// got closed in the meantime.
+func (fr *fileReader) wait() bool {
+	select {
+	case <-time.After(fr.followInterval):
+		return true
+	case <-fr.quit:
+		return false
+	}
+}
+
+func (fr *fileReader) closeFile() error {
 	var err error
 
-	if !fr.closed {
+	if !fr.closed && fr.file != nil {
 		if err = fr.file.Close(); err != nil {
 			// file close operation failed
 			return myError(ErrFile, err)
@@ -1132,6 +2889,15 @@ func (fr *fileReader) close() error {
 	return nil
 }
 
+// close closes the current file, it also stops a (blocked) follow mode
+// reader.
+func (fr *fileReader) close() error {
+	fr.quitOnce.Do(func() {
+		close(fr.quit)
+	})
+	return fr.closeFile()
+}
+
 func (fr *fileReader) next() bool {
 	var err error
 
@@ -1142,9 +2908,44 @@ func (fr *fileReader) next() bool {
 		return false
 	}
 
-	if fr.first { // first event has already been read
-		fr.first = false
-	} else if err = fr.readEvent(); err != nil { // read the next event
+	// read the next event, moving on to the next file at the end of the
+	// current one
+	for {
+		if fr.description != nil {
+			fr.nextEvent, fr.description = fr.description, nil
+			break
+		}
+
+		if err = fr.readEvent(); err == nil {
+			break
+		}
+
+		if err == io.ErrUnexpectedEOF && !fr.follow {
+			err = myError(ErrFile, fmt.Sprintf("truncated event at %s:%d",
+				fr.name, fr.offset))
+			break
+		}
+
+		if err != io.EOF && err != io.ErrUnexpectedEOF {
+			break
+		}
+
+		if err == io.EOF && fr.finished() {
+			if err = fr.nextFile(); err == nil {
+				continue
+			} else if err != io.EOF || !fr.follow {
+				break
+			}
+		}
+
+		// wait for the (rest of the) event to be written
+		if !fr.wait() {
+			err = io.EOF
+			break
+		}
+	}
+
+	if err != nil {
 		fr.eof = true
 		if err != io.EOF {
 			fr.e = err
@@ -1158,60 +2959,150 @@ func (fr *fileReader) event() []byte {
 	return fr.nextEvent
 }
 
+// init prepares the reader for the specified file, which can either be a
+// binlog file or a binlog (or relay log) index file listing them.
 func (fr *fileReader) init(p properties) error {
-	var err error
-	fr.name = p.file
-
-	if fr.file, err = os.Open(fr.name); err != nil {
+	f, err := os.Open(p.file)
+	if err != nil {
 		fr.closed = true
 		return myError(ErrFile, err)
 	}
-	fr.closed = false
+	defer f.Close()
+
+	// enough to tell an index file from a binlog file
+	head := make([]byte, 4096)
+	n, err := io.ReadFull(f, head)
+	if err == nil {
+		// the last line might be cut
+		if i := bytes.LastIndexByte(head, '\n'); i >= 0 {
+			n = i + 1
+		}
+	} else if err != io.EOF && err != io.ErrUnexpectedEOF {
+		fr.closed = true
+		return myError(ErrFile, err)
+	}
+	head = head[:n]
+
+	if !bytes.HasPrefix(head, []byte(_BINLOG_MAGIC)) {
+		if !strings.HasSuffix(p.file, ".index") && !isBinlogIndex(head) {
+			// corrupted, truncated or empty binlog file
+			fr.closed = true
+			return myError(ErrFile, p.file+": invalid binlog magic")
+		}
+		fr.index = p.file
+	}
+
+	fr.name = p.file
+	fr.closed = true
+	fr.follow = p.binlogFollow
+	fr.followInterval = p.binlogFollowInterval
+	fr.quit = make(chan struct{})
 	return nil
 }
 
+// readEvent reads the event at the current offset, it returns io.EOF if
+// there is none and io.ErrUnexpectedEOF if it is incomplete (e.g. still
+// being written).
 func (fr *fileReader) readEvent() error {
 	var (
-		err                          error
-		headerBuf, bodyBuf, eventBuf []byte
-		header                       eventHeader
+		err    error
+		n      int
+		header eventHeader
 	)
 
 	// read the binlog header
-	headerBuf = make([]byte, 19)
-	if _, err = fr.file.Read(headerBuf); err != nil {
+	headerBuf := make([]byte, _EVENT_HEADER_LENGTH)
+	if n, err = fr.file.ReadAt(headerBuf, int64(fr.offset)); n < len(headerBuf) {
 		goto E
 	}
 
 	header, _ = parseEventHeader(headerBuf)
+	if header.size < _EVENT_HEADER_LENGTH {
+		return myError(ErrFile, fmt.Sprintf("invalid event size %d at %s:%d",
+			header.size, fr.name, fr.offset))
+	}
 
-	// read the event body
-	bodyBuf = make([]byte, header.size-19)
-	_, err = fr.file.Read(bodyBuf)
-	if err != nil {
+	// read the whole event
+	fr.nextEvent = make([]byte, header.size)
+	if n, err = fr.file.ReadAt(fr.nextEvent, int64(fr.offset)); n < len(fr.nextEvent) {
 		goto E
 	}
 
-	// combine both the buffers
-	eventBuf = make([]byte, header.size)
-	copy(eventBuf, headerBuf)
-	copy(eventBuf[19:], bodyBuf)
-
-	fr.nextEvent = eventBuf
+	fr.offset += uint64(header.size)
 	return nil
 
 E:
 	if err == io.EOF {
-		return err
-	} else {
-		return myError(ErrFile, err)
+		if n == 0 {
+			return io.EOF
+		}
+		return io.ErrUnexpectedEOF
 	}
+	return myError(ErrFile, err)
 }
 
 func (fr *fileReader) error() error {
 	return fr.e
 }
 
+// nextBinlogFileName returns the name of the binlog file following the
+// specified one in sequence (e.g. mysql-bin.000009 -> mysql-bin.000010), ""
+// if it has no sequence number.
+func nextBinlogFileName(name string) string {
+	ext := filepath.Ext(name)
+
+	n, err := strconv.ParseUint(strings.TrimPrefix(ext, "."), 10, 64)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%s.%0*d", strings.TrimSuffix(name, ext), len(ext)-1, n+1)
+}
+
+// isBinlogIndex returns whether the specified start of a file reads as a
+// binlog index file, i.e. as a list of paths (one per line).
+func isBinlogIndex(head []byte) bool {
+	if len(head) == 0 || !utf8.Valid(head) {
+		return false
+	}
+
+	for _, c := range head {
+		if c < 0x20 && c != '\n' && c != '\r' && c != '\t' {
+			return false
+		}
+	}
+	return len(bytes.TrimSpace(head)) > 0
+}
+
+// readBinlogIndex returns the binlog files listed in the specified index
+// file; relative paths are resolved against the directory of the index file
+// and files that have since been moved are looked up next to it.
+func readBinlogIndex(index string) ([]string, error) {
+	data, err := ioutil.ReadFile(index)
+	if err != nil {
+		return nil, myError(ErrFile, err)
+	}
+
+	dir := filepath.Dir(index)
+
+	var files []string
+	for _, line := range strings.Split(string(data), "\n") {
+		name := strings.TrimSpace(line)
+		if name == "" {
+			continue
+		}
+
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(dir, name)
+		}
+
+		if _, err = os.Stat(name); err != nil {
+			name = filepath.Join(dir, filepath.Base(name))
+		}
+		files = append(files, name)
+	}
+	return files, nil
+}
+
 func parseString2(b []byte, length uint16) (string, int) {
 	if length < 256 {
 		length = uint16(b[0])
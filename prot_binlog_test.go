@@ -0,0 +1,886 @@
+/*
+  The MIT License (MIT)
+
+  Copyright (c) 2015 Nirbhay Choubey
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in all
+  copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+  SOFTWARE.
+*/
+
+package mysql
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeBinlogFile writes the built events to the specified binlog file.
+func writeBinlogFile(t *testing.T, name string, e *evBuilder) {
+	buf := bytes.NewBufferString(_BINLOG_MAGIC)
+	for _, ev := range e.events {
+		buf.Write(ev)
+	}
+
+	if err := ioutil.WriteFile(name, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileReaderIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "binlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the first file ends with a STOP_EVENT (server shutdown), no rotate
+	e := new(evBuilder)
+	e.fde()
+	e.query("test", "CREATE TABLE t1 (a INT)")
+	e.add(STOP_EVENT, nil)
+	writeBinlogFile(t, filepath.Join(dir, "bin.000001"), e)
+
+	// positions are smaller in the second file
+	e = new(evBuilder)
+	e.fde()
+	e.query("test", "CREATE TABLE t2 (a INT)")
+	writeBinlogFile(t, filepath.Join(dir, "bin.000002"), e)
+	end := uint64(e.pos)
+
+	if err = ioutil.WriteFile(filepath.Join(dir, "bin.index"),
+		[]byte("./bin.000001\n./bin.000002\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	b := new(Binlog)
+	if err = b.Connect("file://" + filepath.Join(dir, "bin.index")); err != nil {
+		t.Fatal(err)
+	}
+	if err = b.Begin(); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	var queries []string
+	for b.Next() {
+		re, err := b.RawEvent()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ev, ok := re.Event().(*QueryEvent); ok {
+			queries = append(queries, b.GetFile()+": "+ev.Query())
+		}
+	}
+
+	if err = b.Error(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"bin.000001: CREATE TABLE t1 (a INT)",
+		"bin.000002: CREATE TABLE t2 (a INT)",
+	}
+	if len(queries) != len(want) || queries[0] != want[0] ||
+		queries[1] != want[1] {
+		t.Errorf("queries = %q, want %q", queries, want)
+	}
+
+	if b.GetFile() != "bin.000002" || b.GetPosition() != end {
+		t.Errorf("position = %s:%d, want bin.000002:%d", b.GetFile(),
+			b.GetPosition(), end)
+	}
+
+	cp := b.checkpoint()
+	if cp.File != "bin.000002" || cp.Position != end {
+		t.Errorf("checkpoint = %s:%d, want bin.000002:%d", cp.File,
+			cp.Position, end)
+	}
+}
+
+func TestFileReaderKind(t *testing.T) {
+	dir, err := ioutil.TempDir("", "binlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	e := new(evBuilder)
+	e.fde()
+	e.query("test", "CREATE TABLE t1 (a INT)")
+	writeBinlogFile(t, filepath.Join(dir, "bin.000001"), e)
+
+	tests := []struct {
+		name  string
+		data  string
+		index bool   // read as an index file
+		err   string // expected error, if any
+	}{
+		{"bin.index", "./bin.000001\n", true, ""},
+		{"binlogs", "./bin.000001\n", true, ""},
+		{"empty.index", "", true, "no binlog files listed"},
+		{"empty", "", false, "invalid binlog magic"},
+		{"truncated", _BINLOG_MAGIC[:2], false, "invalid binlog magic"},
+		{"corrupted", "\x00\x00\x00\x00\x13\x01", false, "invalid binlog magic"},
+	}
+
+	for _, test := range tests {
+		name := filepath.Join(dir, test.name)
+		if err = ioutil.WriteFile(name, []byte(test.data), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		b := new(Binlog)
+		err = b.Connect("file://" + name)
+		if err == nil {
+			if fr := b.reader.(*fileReader); (fr.index != "") != test.index {
+				t.Errorf("%s: index file %v, want %v", test.name,
+					fr.index != "", test.index)
+			}
+			err = b.Begin()
+			b.Close()
+		}
+
+		if test.err == "" && err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if test.err != "" && (err == nil ||
+			!strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: got %v, want %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestParseQueryStatusVars(t *testing.T) {
+	buf := []byte{
+		Q_FLAGS2_CODE, 0x01, 0x00, 0x00, 0x00,
+		Q_SQL_MODE_CODE, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		Q_CATALOG_NZ_CODE, 0x03, 's', 't', 'd',
+		Q_CHARSET_CODE, 0x21, 0x00, 0x21, 0x00, 0x08, 0x00,
+		Q_INVOKER, 0x04, 'r', 'o', 'o', 't', 0x01, '%',
+		Q_UPDATED_DB_NAMES, 0x02, 'a', 0x00, 'b', 'c', 0x00,
+		Q_XID, 0x07, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		Q_GTID_FLAGS3, FL_COMMIT_ALTER_E1, 0x05, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		Q_CHARACTER_SET_COLLATIONS, 0x02, 0x21, 0x00, 0xc0, 0x00, 0xff, 0x00, 0x2e, 0x01,
+	}
+
+	var v QueryStatusVars
+	parseQueryStatusVars(buf, &v)
+	if v.Flags2 != 1 || v.SqlMode != 2 || v.Catalog != "std" ||
+		v.CollationServer != 8 || v.InvokerUser != "root" ||
+		v.InvokerHost != "%" || len(v.UpdatedDbNames) != 2 ||
+		v.UpdatedDbNames[1] != "bc" || v.Xid != 7 {
+		t.Errorf("got %+v", v)
+	}
+	if v.GtidFlags3 != FL_COMMIT_ALTER_E1 || v.StartAlterSeqno != 5 {
+		t.Errorf("got flags %d, seqno %d", v.GtidFlags3, v.StartAlterSeqno)
+	}
+	if len(v.CharacterSetCollations) != 2 ||
+		v.CharacterSetCollations[33] != 192 ||
+		v.CharacterSetCollations[255] != 302 {
+		t.Errorf("got collations %v", v.CharacterSetCollations)
+	}
+	if !v.Has(Q_CHARACTER_SET_COLLATIONS) || v.Has(Q_HRNOW) {
+		t.Errorf("got codes %v", v.codes)
+	}
+
+	// no START ALTER sequence number
+	v = QueryStatusVars{}
+	parseQueryStatusVars([]byte{Q_GTID_FLAGS3, FL_START_ALTER_E1,
+		Q_HRNOW, 0x01, 0x00, 0x00}, &v)
+	if v.HrNow != 1 || v.Incomplete() {
+		t.Errorf("got %+v", v)
+	}
+
+	// decoding stops at unknown and truncated variables
+	for _, buf := range [][]byte{
+		{Q_FLAGS2_CODE, 0x01, 0x00, 0x00, 0x00, Q_COMMIT_TS, 0x01},
+		{Q_FLAGS2_CODE, 0x01, 0x00, 0x00, 0x00, 0x63},
+		{Q_FLAGS2_CODE, 0x01, 0x00, 0x00, 0x00, Q_SQL_MODE_CODE, 0x01, 0x00},
+		{Q_FLAGS2_CODE, 0x01, 0x00, 0x00, 0x00, Q_GTID_FLAGS3,
+			FL_ROLLBACK_ALTER_E1, 0x01},
+		{Q_FLAGS2_CODE, 0x01, 0x00, 0x00, 0x00, Q_CHARACTER_SET_COLLATIONS,
+			0x02, 0x21, 0x00, 0xc0, 0x00},
+	} {
+		v = QueryStatusVars{}
+		parseQueryStatusVars(buf, &v)
+		if !v.Incomplete() || v.Flags2 != 1 || !v.Has(Q_FLAGS2_CODE) ||
+			len(v.codes) != 1 {
+			t.Errorf("%v: got %+v", buf, v)
+		}
+	}
+}
+
+func TestQueryEventUnknownStatusVar(t *testing.T) {
+	vars := []byte{
+		Q_FLAGS2_CODE, 0x01, 0x00, 0x00, 0x00,
+		Q_COMMIT_TS, 0x01, 0x02, 0x03,
+	}
+	b := make([]byte, 13)
+	b[8] = 4
+	binary.LittleEndian.PutUint16(b[11:], uint16(len(vars)))
+	b = append(b, vars...)
+	b = append(b, "test"...)
+	b = append(b, 0)
+	b = append(b, "CREATE TABLE t1 (a INT)"...)
+
+	e := new(evBuilder)
+	e.fde()
+	e.add(QUERY_EVENT, b)
+
+	events := readEvents(t, e)
+	if len(events) != 2 {
+		t.Fatalf("got %d events", len(events))
+	}
+	ev, ok := events[1].(*QueryEvent)
+	if !ok {
+		t.Fatalf("got %T", events[1])
+	}
+	if ev.Schema() != "test" || ev.Query() != "CREATE TABLE t1 (a INT)" {
+		t.Errorf("got %q: %q", ev.Schema(), ev.Query())
+	}
+	if ev.StatusVars() != string(vars) {
+		t.Errorf("got status variables %v", []byte(ev.StatusVars()))
+	}
+	if st := ev.Status(); st.Flags2 != 1 || !st.Incomplete() {
+		t.Errorf("got %+v", *st)
+	}
+}
+
+func TestParseRowValue(t *testing.T) {
+	date := func(s string) time.Time {
+		v, err := time.Parse("2006-01-02 15:04:05.999999", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	duration := func(s string) time.Duration {
+		v, err := time.ParseDuration(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	tests := []struct {
+		name  string
+		type_ uint8
+		meta  uint16
+		data  []byte
+		want  interface{}
+	}{
+		{"tinyint", _TYPE_TINY, 0, []byte{0xff}, int8(-1)},
+		{"mediumint", _TYPE_INT24, 0, []byte{0xfe, 0xff, 0xff}, int32(-2)},
+		{"mediumint max", _TYPE_INT24, 0, []byte{0xff, 0xff, 0x7f}, int32(8388607)},
+		{"year", _TYPE_YEAR, 0, []byte{0x79}, int16(2021)},
+		{"zero year", _TYPE_YEAR, 0, []byte{0x00}, int16(0)},
+		{"decimal", _TYPE_NEW_DECIMAL, 4<<8 | 14,
+			[]byte{0x81, 0x0d, 0xfb, 0x38, 0xd2, 0x04, 0xd2}, "1234567890.1234"},
+		{"date", _TYPE_DATE, 0, []byte{0x64, 0xca, 0x0f},
+			date("2021-03-04 00:00:00")},
+		{"zero date", _TYPE_DATE, 0, []byte{0x00, 0x00, 0x00}, "0000-00-00"},
+		{"datetime", _TYPE_DATETIME, 0,
+			[]byte{0xaf, 0x65, 0xfe, 0x93, 0x61, 0x12, 0x00, 0x00},
+			date("2021-03-04 05:06:07")},
+		{"time", _TYPE_TIME, 0, []byte{0xc0, 0x1d, 0xfe},
+			-duration("12h34m56s")},
+		{"timestamp", _TYPE_TIMESTAMP, 0, []byte{0x00, 0x2f, 0x68, 0x59},
+			time.Unix(1500000000, 0).UTC()},
+		{"timestamp2(2)", _TYPE_TIMESTAMP2, 2, []byte{0x59, 0x68, 0x2f, 0x00, 0x0c},
+			time.Unix(1500000000, 120000000).UTC()},
+		{"zero timestamp2", _TYPE_TIMESTAMP2, 0, []byte{0x00, 0x00, 0x00, 0x00},
+			"0000-00-00 00:00:00"},
+		{"datetime2", _TYPE_DATETIME2, 0, []byte{0x99, 0xa9, 0x08, 0x51, 0x87},
+			date("2021-03-04 05:06:07")},
+		{"datetime2(3)", _TYPE_DATETIME2, 3,
+			[]byte{0x99, 0xa9, 0x08, 0x51, 0x87, 0x04, 0xce},
+			date("2021-03-04 05:06:07.123")},
+		{"datetime2(6)", _TYPE_DATETIME2, 6,
+			[]byte{0x99, 0xa9, 0x08, 0x51, 0x87, 0x00, 0x00, 0x08},
+			date("2021-03-04 05:06:07.000008")},
+		{"zero datetime2", _TYPE_DATETIME2, 0,
+			[]byte{0x80, 0x00, 0x00, 0x00, 0x00}, "0000-00-00 00:00:00"},
+		{"time2", _TYPE_TIME2, 0, []byte{0x80, 0xc8, 0xb8},
+			duration("12h34m56s")},
+		{"negative time2", _TYPE_TIME2, 0, []byte{0x7f, 0x37, 0x48},
+			-duration("12h34m56s")},
+		{"negative time2(3)", _TYPE_TIME2, 3,
+			[]byte{0x7f, 0xff, 0xfe, 0xec, 0x78}, -duration("1.5s")},
+		{"negative time2(6)", _TYPE_TIME2, 6,
+			[]byte{0x7f, 0x37, 0x47, 0xff, 0xff, 0xff}, -duration("12h34m56.000001s")},
+		{"char", _TYPE_STRING, 4<<8 | _TYPE_STRING, []byte{0x02, 'a', 'b'}, "ab"},
+		{"long char", _TYPE_STRING, 0xfc<<8 | 0xce,
+			[]byte{0x02, 0x00, 'a', 'b'}, "ab"},
+		{"enum", _TYPE_STRING, 1<<8 | _TYPE_ENUM, []byte{0x02}, uint16(2)},
+		{"set", _TYPE_STRING, 2<<8 | _TYPE_SET, []byte{0x05, 0x01}, uint64(0x105)},
+		{"varchar", _TYPE_VARCHAR, 300, []byte{0x01, 0x00, 'x'}, "x"},
+		{"blob", _TYPE_BLOB, 2, []byte{0x02, 0x00, 0x00, 0xff}, "\x00\xff"},
+		{"bit(12)", _TYPE_BIT, 1<<8 | 4, []byte{0x0a, 0xbc}, "\x0a\xbc"},
+	}
+
+	for _, test := range tests {
+		c := &EventColumn{type_: test.type_, meta: test.meta}
+
+		// followed by another value, that must not be read
+		data := append(append([]byte{}, test.data...), 0xee)
+
+		v, n, err := parseRowValue(data, c)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if n != len(test.data) {
+			t.Errorf("%s: read %d bytes, want %d", test.name, n, len(test.data))
+		}
+		if tm, ok := test.want.(time.Time); ok {
+			if got, ok := v.(time.Time); !ok || !got.Equal(tm) {
+				t.Errorf("%s: got %v, want %v", test.name, v, tm)
+			}
+		} else if v != test.want {
+			t.Errorf("%s: got %#v, want %#v", test.name, v, test.want)
+		}
+	}
+}
+
+func TestParseRowValueInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		type_ uint8
+		meta  uint16
+		data  []byte
+	}{
+		{"truncated", _TYPE_LONG, 0, []byte{0x01, 0x02}},
+		{"truncated datetime2", _TYPE_DATETIME2, 6, []byte{0x99, 0xa9, 0x08, 0x51, 0x87}},
+		{"truncated varchar", _TYPE_VARCHAR, 10, []byte{0x05, 'a'}},
+		{"old decimal", _TYPE_DECIMAL, 0, []byte{0x01}},
+		{"decimal meta", _TYPE_NEW_DECIMAL, 9<<8 | 4, []byte{0x01}},
+		{"blob meta", _TYPE_BLOB, 0, []byte{0x01}},
+		{"unknown", 0xf1, 0, []byte{0x01}},
+	}
+
+	for _, test := range tests {
+		c := &EventColumn{type_: test.type_, meta: test.meta}
+		if _, _, err := parseRowValue(test.data, c); err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
+
+func TestRowsEventUndecodable(t *testing.T) {
+	e := &evBuilder{}
+	e.fde()
+
+	// id INT, ts TIMESTAMP(2), n MEDIUMINT
+	e.tableMap(1, "test", "t1",
+		[]byte{_TYPE_LONG, _TYPE_TIMESTAMP2, _TYPE_INT24}, []byte{2})
+	e.rows(WRITE_ROWS_EVENT, 1, 0, 3,
+		[]byte{0x00, 0x01, 0x00, 0x00, 0x00,
+			0x59, 0x68, 0x2f, 0x00, 0x0c, 0x03, 0x00, 0x00})
+
+	// id INT, d DECIMAL (pre-5.0, can't be decoded)
+	e.tableMap(2, "test", "t2", []byte{_TYPE_LONG, _TYPE_DECIMAL}, nil)
+	e.rows(WRITE_ROWS_EVENT, 2, STMT_END_F, 2,
+		[]byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x01, '1'},
+		[]byte{0x00, 0x02, 0x00, 0x00, 0x00, 0x01, '2'})
+
+	events := readEvents(t, e)
+
+	ev := events[2].(*RowsEvent)
+	if ev.Error() != nil || len(ev.Image().Rows) != 1 {
+		t.Fatalf("got %v, %v", ev.Error(), ev.Image().Rows)
+	}
+	if cols := ev.Image().Rows[0].Columns; cols[0] != int32(1) ||
+		!cols[1].(time.Time).Equal(time.Unix(1500000000, 120000000)) ||
+		cols[2] != int32(3) {
+		t.Errorf("got %v", cols)
+	}
+
+	ev = events[4].(*RowsEvent)
+	if ev.Error() == nil || len(ev.Image().Rows) != 0 {
+		t.Fatalf("got %v, %v", ev.Error(), ev.Image().Rows)
+	}
+	if _, err := RowsEventSQL(ev); err == nil {
+		t.Error("rendered an undecodable event")
+	}
+}
+
+func TestBinlogDumpPacket(t *testing.T) {
+	slave := binlogSlave{id: 7}
+
+	c := &Conn{serverVersion: "8.0.32"}
+	c.buff.New(64)
+
+	// COM_BINLOG_DUMP up to 4GB
+	index := binlogIndex{file: "bin.000001", position: 1 << 31}
+	b, err := c.createBinlogDumpPacket(slave, index, _BINLOG_DUMP_NON_BLOCK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b[4] != _COM_BINLOG_DUMP || binary.LittleEndian.Uint32(b[5:]) != 1<<31 ||
+		binary.LittleEndian.Uint16(b[9:]) != _BINLOG_DUMP_NON_BLOCK ||
+		binary.LittleEndian.Uint32(b[11:]) != 7 ||
+		string(b[15:]) != "bin.000001" {
+		t.Errorf("got %x", b)
+	}
+
+	// COM_BINLOG_DUMP_GTID through position beyond 4GB
+	index.position = 5 << 30
+	if b, err = c.createBinlogDumpPacket(slave, index, 0); err != nil {
+		t.Fatal(err)
+	}
+	if b[4] != _COM_BINLOG_DUMP_GTID ||
+		binary.LittleEndian.Uint16(b[5:]) != _BINLOG_THROUGH_POSITION ||
+		binary.LittleEndian.Uint32(b[7:]) != 7 ||
+		binary.LittleEndian.Uint32(b[11:]) != 10 ||
+		string(b[15:25]) != "bin.000001" ||
+		binary.LittleEndian.Uint64(b[25:]) != 5<<30 || len(b) != 33 {
+		t.Errorf("got %x", b)
+	}
+
+	// not supported by MariaDB
+	c.serverVersion = "5.5.5-10.6.12-MariaDB-log"
+	if _, err = c.createBinlogDumpPacket(slave, index, 0); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestIsNetworkError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{myError(ErrConnection, "refused"), true},
+		{myError(ErrRead, "reset"), true},
+		{myError(ErrWrite, "broken pipe"), true},
+		{myError(ErrInvalidPacket), false},
+		{io.EOF, false},
+		{nil, false},
+	}
+
+	for _, test := range tests {
+		if got := isNetworkError(test.err); got != test.want {
+			t.Errorf("isNetworkError(%v) = %v, want %v", test.err, got,
+				test.want)
+		}
+	}
+}
+
+// closedAddress returns the address of a port nobody listens on.
+func closedAddress(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+// newLostNetReader returns a net reader whose connection just got lost.
+func newLostNetReader(p properties) *netReader {
+	c, _ := net.Pipe()
+	nr := &netReader{p: p, stop: make(chan struct{})}
+	nr.conn = &Conn{conn: c}
+	nr.e = myError(ErrRead, "connection reset")
+	return nr
+}
+
+func TestNetReaderReconnect(t *testing.T) {
+	// the server can't be reached
+	nr := newLostNetReader(properties{address: closedAddress(t),
+		binlogReconnectAttempts: 1})
+	err := nr.reconnect(binlogIndex{})
+	if e, ok := err.(*Error); !ok || e.code != ErrConnection {
+		t.Errorf("got %v, want a connection error", err)
+	}
+	if !nr.eof || !nr.closed || nr.error() != err {
+		t.Errorf("reader not ended by %v", err)
+	}
+
+	// the server refuses the connection, no more attempts
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	accepted := make(chan bool, 10)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- true
+
+			// greeting of a server without SSL support
+			greeting := []byte{0x0a, '5', '.', '7', 0, 1, 0, 0, 0,
+				1, 2, 3, 4, 5, 6, 7, 8, 0, 0, 0}
+			c.Write(append([]byte{byte(len(greeting)), 0, 0, 0},
+				greeting...))
+			c.Close()
+		}
+	}()
+
+	nr = newLostNetReader(properties{address: l.Addr().String(),
+		maxPacketSize: _DEFAULT_MAX_PACKET_SIZE, clientCapabilities: _CLIENT_SSL,
+		binlogReconnectAttempts: 5})
+	err = nr.reconnect(binlogIndex{})
+	if e, ok := err.(*Error); !ok || e.code != ErrSSLSupport {
+		t.Errorf("got %v, want a SSL support error", err)
+	}
+	if len(accepted) != 1 {
+		t.Errorf("got %d attempts, want 1", len(accepted))
+	}
+}
+
+func TestNetReaderReconnectStop(t *testing.T) {
+	nr := newLostNetReader(properties{address: closedAddress(t),
+		binlogReconnectAttempts: 100})
+	lost := nr.e
+
+	// as done by close()
+	time.AfterFunc(100*time.Millisecond, func() { close(nr.stop) })
+
+	start := time.Now()
+	if err := nr.reconnect(binlogIndex{}); err != lost {
+		t.Errorf("got %v, want %v", err, lost)
+	}
+	if d := time.Since(start); d >= _BINLOG_RECONNECT_MIN_DELAY {
+		t.Errorf("reconnection attempts stopped after %v", d)
+	}
+}
+
+// appendFile appends the specified data to the specified file.
+func appendFile(t *testing.T, name string, data []byte) {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, err = f.Write(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// inUse flags the format description event of the built events as written
+// to a binlog file in use.
+func inUse(e *evBuilder) {
+	binary.LittleEndian.PutUint16(e.events[0][_FLAGS_OFFSET:],
+		_LOG_EVENT_BINLOG_IN_USE_F)
+}
+
+func TestFileReaderFollow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "binlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	name1 := filepath.Join(dir, "bin.000001")
+	e1 := new(evBuilder)
+	e1.fde()
+	inUse(e1)
+	e1.query("test", "INSERT INTO t1 VALUES (1)")
+	writeBinlogFile(t, name1, e1)
+
+	b := new(Binlog)
+	if err = b.Connect("file://" + name1 +
+		"?BinlogFollow=true&BinlogFollowInterval=10ms"); err != nil {
+		t.Fatal(err)
+	}
+	if err = b.Begin(); err != nil {
+		t.Fatal(err)
+	}
+
+	queries := make(chan string)
+	go func() {
+		for b.Next() {
+			re, err := b.RawEvent()
+			if err != nil {
+				break
+			}
+			if ev, ok := re.Event().(*QueryEvent); ok {
+				queries <- b.GetFile() + ": " + ev.Query()
+			}
+		}
+		close(queries)
+	}()
+
+	expect := func(want string) {
+		select {
+		case got := <-queries:
+			if got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %q", want)
+		}
+	}
+
+	expect("bin.000001: INSERT INTO t1 VALUES (1)")
+
+	// an event being written is delivered once complete
+	e1.query("test", "INSERT INTO t1 VALUES (2)")
+	appendFile(t, name1, e1.events[2][:25])
+	select {
+	case got := <-queries:
+		t.Errorf("got %q from an incomplete event", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+	appendFile(t, name1, e1.events[2][25:])
+	expect("bin.000001: INSERT INTO t1 VALUES (2)")
+
+	// the server got restarted: the file is closed without a rotate event
+	// and the next one in sequence follows
+	f, err := os.OpenFile(name1, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteAt([]byte{0, 0}, _BINLOG_HEADER_SIZE+_FLAGS_OFFSET)
+	f.Close()
+
+	name2 := filepath.Join(dir, "bin.000002")
+	e2 := new(evBuilder)
+	e2.fde()
+	inUse(e2)
+	e2.query("test", "INSERT INTO t1 VALUES (3)")
+	writeBinlogFile(t, name2, e2)
+	expect("bin.000002: INSERT INTO t1 VALUES (3)")
+
+	// rotation
+	e3 := new(evBuilder)
+	e3.fde()
+	inUse(e3)
+	e3.query("test", "INSERT INTO t1 VALUES (4)")
+	writeBinlogFile(t, filepath.Join(dir, "bin.000003"), e3)
+
+	e2.rotate("bin.000003", 4, false)
+	appendFile(t, name2, e2.events[2])
+	expect("bin.000003: INSERT INTO t1 VALUES (4)")
+
+	// closing the binlog stops the reader waiting for more events
+	b.Close()
+	select {
+	case got, ok := <-queries:
+		if ok {
+			t.Errorf("got %q after close", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("reader not stopped by close")
+	}
+}
+
+func TestNextBinlogFileName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"bin.000009", "bin.000010"},
+		{"/var/lib/mysql/mysql-bin.999999", "/var/lib/mysql/mysql-bin.1000000"},
+		{"relay.log.01", "relay.log.02"},
+		{"bin.index", ""},
+		{"bin", ""},
+	}
+
+	for _, test := range tests {
+		if got := nextBinlogFileName(test.name); got != test.want {
+			t.Errorf("nextBinlogFileName(%q) = %q, want %q", test.name,
+				got, test.want)
+		}
+	}
+}
+
+func TestFileReaderStartPosition(t *testing.T) {
+	dir, err := ioutil.TempDir("", "binlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	e := &evBuilder{checksum: true}
+	e.fde()
+	// still a valid checksum, computed without the flag
+	inUse(e)
+	e.query("test", "CREATE TABLE t1 (a INT)")
+	start := uint64(e.pos)
+	e.query("test", "CREATE TABLE t2 (a INT)")
+	end := uint64(e.pos)
+	name := filepath.Join(dir, "bin.000001")
+	writeBinlogFile(t, name, e)
+
+	b := new(Binlog)
+	if err = b.Connect("file://" + name); err != nil {
+		t.Fatal(err)
+	}
+	b.SetFile("bin.000001")
+	b.SetPosition(start)
+	if err = b.Begin(); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	var (
+		types     []uint8
+		positions []uint64
+		queries   []string
+	)
+	for b.Next() {
+		re, err := b.RawEvent()
+		if err != nil {
+			t.Fatal(err)
+		}
+		types = append(types, re.header.type_)
+		positions = append(positions, b.GetPosition())
+		if ev, ok := re.Event().(*QueryEvent); ok {
+			queries = append(queries, ev.Query())
+		}
+	}
+	if err = b.Error(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the format description event comes first, without moving the
+	// position
+	if len(types) != 2 || types[0] != FORMAT_DESCRIPTION_EVENT ||
+		positions[0] != start || positions[1] != end {
+		t.Errorf("got events %v at %v", types, positions)
+	}
+	if len(queries) != 1 || queries[0] != "CREATE TABLE t2 (a INT)" {
+		t.Errorf("got queries %q", queries)
+	}
+}
+
+// mariadbCompress returns the data compressed as in the MariaDB compressed
+// events.
+func mariadbCompress(data []byte) []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte{0x82, byte(len(data) >> 8), byte(len(data))})
+	w := zlib.NewWriter(&buf)
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+func TestDecompressMariadbEvent(t *testing.T) {
+	data := []byte(strings.Repeat("INSERT INTO t1 VALUES (1);", 20))
+
+	var compressed bytes.Buffer
+	w := zlib.NewWriter(&compressed)
+	w.Write(data)
+	w.Close()
+	z := compressed.Bytes()
+
+	tests := []struct {
+		name string
+		buf  []byte
+		ok   bool
+	}{
+		{"2-byte length", mariadbCompress(data), true},
+		{"3-byte length", append([]byte{0x83, 0, byte(len(data) >> 8),
+			byte(len(data))}, z...), true},
+		{"no header", nil, false},
+		{"not compressed", append([]byte{0x02, byte(len(data) >> 8),
+			byte(len(data))}, z...), false},
+		{"algorithm", append([]byte{0x92, byte(len(data) >> 8),
+			byte(len(data))}, z...), false},
+		{"length size", append([]byte{0x85, 0, 0, 0, 0, 0}, z...), false},
+		{"truncated length", []byte{0x84, 0, 0}, false},
+		{"length", append([]byte{0x82, 0, 10}, z...), false},
+		{"corrupted", append([]byte{0x82, byte(len(data) >> 8),
+			byte(len(data))}, z[:len(z)/2]...), false},
+	}
+
+	for _, test := range tests {
+		got, err := decompressMariadbEvent(test.buf)
+		if test.ok && (err != nil || !bytes.Equal(got, data)) {
+			t.Errorf("%s: got %q, %v", test.name, got, err)
+		} else if !test.ok && err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
+
+func TestCompressedEvents(t *testing.T) {
+	e := new(evBuilder)
+	e.fde()
+
+	query := "INSERT INTO t1 VALUES (" + strings.Repeat("1", 100) + ")"
+	b := make([]byte, 13)
+	b[8] = 4
+	b = append(b, "test"...)
+	b = append(b, 0)
+	e.add(QUERY_COMPRESSED_EVENT, append(b, mariadbCompress([]byte(query))...))
+
+	e.tableMap(5, "test", "t1", []byte{_TYPE_LONG}, nil)
+	b = make([]byte, 10)
+	b[0] = 5
+	binary.LittleEndian.PutUint16(b[6:], STMT_END_F)
+	b[8] = 2
+	b = append(b, 1, 0xff)
+	e.add(WRITE_ROWS_COMPRESSED_EVENT, append(b,
+		mariadbCompress(append(rowLong(5), rowLong(6)...))...))
+
+	// corrupted
+	e.add(QUERY_COMPRESSED_EVENT, append(b[:13:13], 0x82, 0, 1, 0))
+
+	bl := newTestBinlog(e)
+	var events []Event
+	for bl.Next() {
+		re, err := bl.RawEvent()
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, re.Event())
+	}
+	if bl.Error() == nil {
+		t.Error("decompressed a corrupted event")
+	}
+
+	if len(events) != 4 {
+		t.Fatalf("got %d events", len(events))
+	}
+
+	if ev, ok := events[1].(*QueryEvent); !ok || ev.Query() != query ||
+		ev.Schema() != "test" {
+		t.Errorf("got %#v", events[1])
+	}
+
+	ev, ok := events[3].(*RowsEvent)
+	if !ok {
+		t.Fatalf("got %#v", events[3])
+	}
+	if rows := ev.Image().Rows; ev.Error() != nil || len(rows) != 2 ||
+		rows[0].Columns[0] != int32(5) || rows[1].Columns[0] != int32(6) {
+		t.Errorf("got rows %v, %v", rows, ev.Error())
+	}
+	// delivered as their uncompressed counterparts
+	if ev.Type() != WRITE_ROWS_EVENT {
+		t.Errorf("got type %d", ev.Type())
+	}
+}
@@ -66,7 +66,8 @@ const (
 	_COM_STMT_RESET
 	_COM_SET_OPTION
 	_COM_STMT_FETCH
-	_        // _COM_DAEMON
+	_ // _COM_DAEMON
+	_COM_BINLOG_DUMP_GTID
 	_COM_END // must always be last
 )
 
@@ -0,0 +1,486 @@
+/*
+  The MIT License (MIT)
+
+  Copyright (c) 2015 Nirbhay Choubey
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in all
+  copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+  SOFTWARE.
+*/
+
+package mysql
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RowsEventSQL returns the statements applying the changes of the specified
+// rows event, one INSERT, UPDATE or DELETE statement per row. The columns
+// are named after the table map meta data (see SchemaTracker), columns
+// without a name are called col_<n> (1-based). UPDATE and DELETE statements
+// locate the row by its primary key when known, by all the columns of the
+// before image otherwise. Integer values with the high bit set can only be
+// rendered if the signedness of their column is known (binlog_row_metadata or
+// SchemaTracker), ErrSignedness is returned otherwise.
+func RowsEventSQL(ev *RowsEvent) ([]string, error) {
+	return renderRowsEvent(ev, false)
+}
+
+// FlashbackSQL returns the statements undoing the changes of the specified
+// rows event, last row first : DELETE for inserted rows, INSERT for deleted
+// rows and UPDATE back to the before image for updated rows.
+func FlashbackSQL(ev *RowsEvent) ([]string, error) {
+	return renderRowsEvent(ev, true)
+}
+
+func renderRowsEvent(ev *RowsEvent, flashback bool) ([]string, error) {
+	if ev.tableMap == nil {
+		return nil, myError(ErrUnknownTable, ev.tableId)
+	}
+	if ev.err != nil {
+		return nil, ev.err
+	}
+
+	var (
+		stmts []string
+		stmt  string
+		err   error
+	)
+
+	t := &sqlTable{tableMap: ev.tableMap}
+	before := ev.columnsPresentBitmap1
+	after := ev.columnsPresentBitmap2
+
+	for i, r := range ev.rows1.Rows {
+		switch ev.header.type_ {
+		case PRE_GA_WRITE_ROWS_EVENT, WRITE_ROWS_EVENT_V1, WRITE_ROWS_EVENT:
+			if flashback {
+				stmt, err = t.delete(r, before)
+			} else {
+				stmt, err = t.insert(r, before)
+			}
+
+		case PRE_GA_DELETE_ROWS_EVENT, DELETE_ROWS_EVENT_V1, DELETE_ROWS_EVENT:
+			if flashback {
+				stmt, err = t.insert(r, before)
+			} else {
+				stmt, err = t.delete(r, before)
+			}
+
+		case PRE_GA_UPDATE_ROWS_EVENT, UPDATE_ROWS_EVENT_V1, UPDATE_ROWS_EVENT,
+			PARTIAL_UPDATE_ROWS_EVENT:
+			if i >= len(ev.rows2.Rows) {
+				break
+			}
+			if flashback {
+				stmt, err = t.update(r, before, ev.rows2.Rows[i], after, true)
+			} else {
+				stmt, err = t.update(ev.rows2.Rows[i], after, r, before, false)
+			}
+
+		default:
+			return nil, myError(ErrEventType, ev.header.type_)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, stmt)
+	}
+
+	if flashback {
+		reverseStrings(stmts)
+	}
+	return stmts, nil
+}
+
+func reverseStrings(s []string) {
+	for i, j := 0, len(s)-1; i < j; i, j = i+1, j-1 {
+		s[i], s[j] = s[j], s[i]
+	}
+}
+
+// table the statements are rendered for
+type sqlTable struct {
+	tableMap *TableMapEvent
+}
+
+func (t *sqlTable) name() string {
+	if t.tableMap.schema == "" {
+		return quoteIdentifier(t.tableMap.table)
+	}
+	return quoteIdentifier(t.tableMap.schema) + "." +
+		quoteIdentifier(t.tableMap.table)
+}
+
+func (t *sqlTable) column(i int) string {
+	if name := t.tableMap.columns[i].name; name != "" {
+		return quoteIdentifier(name)
+	}
+	return quoteIdentifier("col_" + strconv.Itoa(i+1))
+}
+
+// present returns the indexes of the columns of the image.
+func (t *sqlTable) present(r EventRow, bitmap []byte) []int {
+	var cols []int
+
+	for i := range r.Columns {
+		if i < len(t.tableMap.columns) && isPresent(bitmap, i) {
+			cols = append(cols, i)
+		}
+	}
+	return cols
+}
+
+// isPresent returns whether the column at the given position is logged.
+func isPresent(bitmap []byte, i int) bool {
+	return i/8 < len(bitmap) && isNull(bitmap, uint16(i), 0)
+}
+
+func (t *sqlTable) insert(r EventRow, bitmap []byte) (string, error) {
+	return t.write("INSERT", r, bitmap)
+}
+
+// write returns the INSERT or REPLACE (verb) statement of the specified row.
+func (t *sqlTable) write(verb string, r EventRow, bitmap []byte) (string, error) {
+	cols := t.present(r, bitmap)
+
+	names := make([]string, len(cols))
+	values := make([]string, len(cols))
+	for j, i := range cols {
+		v, err := sqlLiteral(&t.tableMap.columns[i], r.Columns[i])
+		if err != nil {
+			return "", err
+		}
+		names[j] = t.column(i)
+		values[j] = v
+	}
+
+	return verb + " INTO " + t.name() + " (" + strings.Join(names, ", ") +
+		") VALUES (" + strings.Join(values, ", ") + ")", nil
+}
+
+func (t *sqlTable) delete(r EventRow, bitmap []byte) (string, error) {
+	where, err := t.where(r, bitmap)
+	if err != nil {
+		return "", err
+	}
+	return "DELETE FROM " + t.name() + " WHERE " + where + " LIMIT 1", nil
+}
+
+// update returns the statement changing the row located by the where image
+// to the set image. Partial JSON updates can't be reverted.
+func (t *sqlTable) update(set EventRow, setBitmap []byte, where EventRow,
+	whereBitmap []byte, flashback bool) (string, error) {
+	var assignments []string
+
+	for _, i := range t.present(set, setBitmap) {
+		var (
+			v   string
+			err error
+		)
+
+		if diffs, ok := set.Columns[i].([]JSONDiff); ok {
+			v, err = jsonDiffsSQL(t.column(i), diffs)
+		} else if _, ok := where.Columns[i].([]JSONDiff); ok && flashback {
+			err = myError(ErrInvalidJSON, "partial update can't be reverted")
+		} else {
+			v, err = sqlLiteral(&t.tableMap.columns[i], set.Columns[i])
+		}
+		if err != nil {
+			return "", err
+		}
+		assignments = append(assignments, t.column(i)+" = "+v)
+	}
+
+	condition, err := t.where(where, whereBitmap)
+	if err != nil {
+		return "", err
+	}
+
+	return "UPDATE " + t.name() + " SET " + strings.Join(assignments, ", ") +
+		" WHERE " + condition + " LIMIT 1", nil
+}
+
+// where returns the condition locating the specified row : its primary key
+// if known and logged, all the columns of the image otherwise.
+func (t *sqlTable) where(r EventRow, bitmap []byte) (string, error) {
+	cols := t.present(r, bitmap)
+
+	if pk := t.tableMap.primaryKey; len(pk) > 0 {
+		complete := true
+		for _, i := range pk {
+			if i >= len(r.Columns) || !isPresent(bitmap, i) {
+				complete = false
+				break
+			}
+		}
+		if complete {
+			cols = pk
+		}
+	}
+
+	var conditions []string
+	for _, i := range cols {
+		if r.Columns[i] == nil {
+			conditions = append(conditions, t.column(i)+" IS NULL")
+			continue
+		}
+
+		// rows changed by a partial update are located by the other
+		// columns
+		if _, ok := r.Columns[i].([]JSONDiff); ok {
+			continue
+		}
+
+		v, err := sqlLiteral(&t.tableMap.columns[i], r.Columns[i])
+		if err != nil {
+			return "", err
+		}
+		conditions = append(conditions, t.column(i)+" = "+v)
+	}
+
+	if len(conditions) == 0 {
+		return "", myError(ErrInvalidType, "row can't be located")
+	}
+	return strings.Join(conditions, " AND "), nil
+}
+
+// sqlLiteral returns the SQL literal of the specified column value :
+//
+//	NULL                      NULL
+//	integers                  number (unsigned if so logged), an error if
+//	                          negative and the signedness is unknown
+//	ENUM, SET                 number (index, bitmap)
+//	FLOAT, DOUBLE             number
+//	DECIMAL                   exact number
+//	DATE, DATETIME            '2006-01-02[ 15:04:05.999999]'
+//	TIMESTAMP                 FROM_UNIXTIME(<seconds>[.<fraction>])
+//	zero dates                '0000-00-00[ 00:00:00]'
+//	TIME                      '[-]hh:mm:ss[.ffffff]'
+//	BLOB, BIT, GEOMETRY,
+//	binary strings and
+//	strings of unknown charset X'<hex>'
+//	JSON                      CAST('<text>' AS JSON)
+//	other strings             _utf8mb4'<text>' (converted to UTF-8)
+func sqlLiteral(c *EventColumn, v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "NULL", nil
+	case int8, int16, int32, int64:
+		if !c.signed && isNegative(c, v) {
+			// -1 or the maximum value of an UNSIGNED column
+			return "", myError(ErrSignedness, v)
+		}
+		return fmt.Sprint(jsonValue(c, v)), nil
+	case uint8, uint16, uint32, uint64:
+		return fmt.Sprint(jsonValue(c, v)), nil
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32), nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case time.Time:
+		switch c.type_ {
+		case _TYPE_DATE, _TYPE_NEW_DATE:
+			return quoteString(v.Format("2006-01-02")), nil
+		case _TYPE_TIMESTAMP, _TYPE_TIMESTAMP2:
+			// independent of the session time zone
+			s := strconv.FormatInt(v.Unix(), 10)
+			if us := v.Nanosecond() / 1000; us != 0 {
+				s += fmt.Sprintf(".%06d", us)
+			}
+			return "FROM_UNIXTIME(" + s + ")", nil
+		default:
+		}
+		return quoteString(v.Format("2006-01-02 15:04:05.999999")), nil
+	case time.Duration:
+		return quoteString(formatTimeValue(v)), nil
+	case json.RawMessage:
+		return "CAST(" + quoteString(string(v)) + " AS JSON)", nil
+	case string:
+		if c.type_ == _TYPE_NEW_DECIMAL {
+			// exact decimal value, never emitted empty
+			if v == "" {
+				return "", myError(ErrInvalidRow, "invalid decimal")
+			}
+			return v, nil
+		}
+		return stringLiteral(c, v), nil
+	case []byte:
+		if c.type_ == _TYPE_JSON {
+			return hexLiteral(v), nil
+		}
+		return stringLiteral(c, string(v)), nil
+	default:
+	}
+	return "", myError(ErrInvalidType, fmt.Sprintf("%T", v))
+}
+
+// isNegative returns whether the specified signed integer value of the given
+// column is negative, i.e. whether its high bit is set.
+func isNegative(c *EventColumn, v interface{}) bool {
+	switch v := v.(type) {
+	case int8:
+		return v < 0
+	case int16:
+		return v < 0
+	case int32:
+		return v < 0
+	case int64:
+		return v < 0
+	default:
+	}
+	return false
+}
+
+// stringLiteral returns the SQL literal of the specified string value of the
+// given column, independent of the character set of the session it gets
+// executed in. The text of an unknown character set is kept as bytes, stored
+// as is in the column.
+func stringLiteral(c *EventColumn, v string) string {
+	if isBinaryColumn(c) {
+		return hexLiteral([]byte(v))
+	}
+	if !isCharacterType(c) {
+		// zero dates
+		return quoteString(v)
+	}
+	if s, ok := columnText(c, v); ok {
+		return "_utf8mb4" + quoteString(s)
+	}
+	return hexLiteral([]byte(v))
+}
+
+func hexLiteral(b []byte) string {
+	return "X'" + hex.EncodeToString(b) + "'"
+}
+
+// jsonDiffsSQL returns the expression applying the specified partial JSON
+// update to the given column.
+func jsonDiffsSQL(column string, diffs []JSONDiff) (string, error) {
+	expr := column
+
+	for _, d := range diffs {
+		path := quoteString(d.Path)
+		value := "CAST(" + quoteString(FormatJSON(d.Value)) + " AS JSON)"
+
+		switch d.Op {
+		case JSON_DIFF_REPLACE:
+			expr = "JSON_REPLACE(" + expr + ", " + path + ", " + value + ")"
+		case JSON_DIFF_INSERT:
+			// array elements are inserted at their index
+			if strings.HasSuffix(d.Path, "]") {
+				expr = "JSON_ARRAY_INSERT(" + expr + ", " + path + ", " +
+					value + ")"
+			} else {
+				expr = "JSON_INSERT(" + expr + ", " + path + ", " + value + ")"
+			}
+		case JSON_DIFF_REMOVE:
+			expr = "JSON_REMOVE(" + expr + ", " + path + ")"
+		default:
+			return "", myError(ErrInvalidJSON, "invalid diff")
+		}
+	}
+	return expr, nil
+}
+
+// SQLWindow selects the events of a binlog by position and/or time, zero
+// values meaning no limit. The events of a transaction payload (binlog
+// transaction compression) are at the position of the payload event.
+type SQLWindow struct {
+	File          string    // binlog file the positions refer to
+	StartPosition uint64    // events starting at or after
+	StopPosition  uint64    // events starting before
+	StartTime     time.Time // events logged at or after
+	StopTime      time.Time // events logged before
+}
+
+// contains returns whether the event starting at the specified position of
+// the given file and logged at the specified time is in the window.
+func (w *SQLWindow) contains(file string, pos uint64, t time.Time) bool {
+	if w.File != "" {
+		if file != w.File || pos < w.StartPosition ||
+			(w.StopPosition != 0 && pos >= w.StopPosition) {
+			return false
+		}
+	}
+
+	if (!w.StartTime.IsZero() && t.Before(w.StartTime)) ||
+		(!w.StopTime.IsZero() && !t.Before(w.StopTime)) {
+		return false
+	}
+	return true
+}
+
+// done returns whether the window has been passed, assuming the events of a
+// file are read in order.
+func (w *SQLWindow) done(file string, pos uint64) bool {
+	return w.File != "" && file == w.File && w.StopPosition != 0 &&
+		pos >= w.StopPosition
+}
+
+// Flashback reads the binlog up to its end (or up to the stop position of
+// the window) and returns the statements undoing the changes of the rows
+// events of the window, last change first.
+func Flashback(b *Binlog, w SQLWindow) ([]string, error) {
+	var events [][]string
+
+	for b.Next() {
+		re, err := b.RawEvent()
+		if err != nil {
+			return nil, err
+		}
+
+		source := b.Source()
+		if w.done(source.File, source.Pos) {
+			break
+		}
+
+		if !isRowsEvent(uncompressedEventType(re.header.type_)) ||
+			!w.contains(source.File, source.Pos, re.Time()) {
+			continue
+		}
+
+		ev, ok := re.Event().(*RowsEvent)
+		if !ok {
+			continue
+		}
+
+		stmts, err := FlashbackSQL(ev)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, stmts)
+	}
+
+	if err := b.Error(); err != nil {
+		return nil, err
+	}
+
+	// last event first, the statements of each event being already in
+	// reverse order
+	var stmts []string
+	for i := len(events) - 1; i >= 0; i-- {
+		stmts = append(stmts, events[i]...)
+	}
+	return stmts, nil
+}
@@ -0,0 +1,232 @@
+/*
+  The MIT License (MIT)
+
+  Copyright (c) 2015 Nirbhay Choubey
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in all
+  copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+  SOFTWARE.
+*/
+
+package mysql
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestDecimalRoundTrip(t *testing.T) {
+	e := &evBuilder{}
+	e.fde()
+
+	// id INT, d DECIMAL(27,9)
+	e.tableMap(1, "test", "t1", []byte{_TYPE_LONG, _TYPE_NEW_DECIMAL},
+		[]byte{27, 9}, _TABLE_MAP_COLUMN_NAME, 5, 2, 'i', 'd', 1, 'd')
+	e.rows(WRITE_ROWS_EVENT, 1, STMT_END_F, 2,
+		[]byte{0x00, 0x01, 0x00, 0x00, 0x00,
+			0x87, 0x5b, 0xcd, 0x15, 0x00, 0xbc, 0x61, 0x4e,
+			0x07, 0x5b, 0xcd, 0x15},
+		[]byte{0x00, 0x02, 0x00, 0x00, 0x00,
+			0x78, 0xa4, 0x32, 0xea, 0xff, 0x43, 0x9e, 0xb1,
+			0xf8, 0xa4, 0x32, 0xea})
+
+	events := readEvents(t, e)
+	ev, ok := events[len(events)-1].(*RowsEvent)
+	if !ok {
+		t.Fatalf("got %T", events[len(events)-1])
+	}
+
+	stmts, err := RowsEventSQL(ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"INSERT INTO `test`.`t1` (`id`, `d`) VALUES (1, 123456789012345678.123456789)",
+		"INSERT INTO `test`.`t1` (`id`, `d`) VALUES (2, -123456789012345678.123456789)",
+	}
+	if len(stmts) != len(want) {
+		t.Fatalf("got %q", stmts)
+	}
+	for i := range want {
+		if stmts[i] != want[i] {
+			t.Errorf("got %s, want %s", stmts[i], want[i])
+		}
+	}
+
+	changes, err := EncodeRowsEvent(ev, ChangeSource{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var change struct {
+		After map[string]interface{} `json:"after"`
+	}
+	if err := json.Unmarshal(changes[0], &change); err != nil {
+		t.Fatal(err)
+	}
+	if v := change.After["d"]; v != "123456789012345678.123456789" {
+		t.Errorf("got %#v", v)
+	}
+}
+
+func TestInvalidDecimal(t *testing.T) {
+	c := &EventColumn{type_: _TYPE_NEW_DECIMAL, meta: 2<<8 | 4}
+
+	// the value can't be decoded
+	if v, _, err := parseRowValue([]byte{0x80}, c); err == nil {
+		t.Errorf("decoded a truncated decimal as %q", v)
+	}
+
+	// nor rendered empty
+	if s, err := sqlLiteral(c, ""); err == nil {
+		t.Errorf("rendered an empty decimal as %q", s)
+	}
+}
+
+func TestFlashbackWindowPayload(t *testing.T) {
+	// zstd stand-in, the payload being gzip compressed
+	defer func(list []decompressor) {
+		decompressors.list = list
+	}(append([]decompressor(nil), decompressors.list...))
+	RegisterDecompressor("zstd", zstdMagic, func(r io.Reader) (io.Reader, error) {
+		return gzip.NewReader(r)
+	})
+
+	// id INT, v VARCHAR(200)
+	value := strings.Repeat("a", 150)
+	tx := func(e *evBuilder, id int32) {
+		e.tableMap(1, "test", "t1", []byte{_TYPE_LONG, _TYPE_VARCHAR},
+			[]byte{200, 0}, _TABLE_MAP_COLUMN_NAME, 5, 2, 'i', 'd', 1, 'v')
+		r := append(rowLong(id), byte(len(value)))
+		e.rows(WRITE_ROWS_EVENT, 1, STMT_END_F, 2, append(r, value...))
+		e.xid(uint64(id))
+	}
+
+	e := &evBuilder{}
+	e.fde()
+	tx(e, 1)
+	start := uint64(e.pos)
+
+	// the second transaction is compressed, its events being larger than
+	// the payload
+	inner := &evBuilder{}
+	tx(inner, 2)
+	var payload bytes.Buffer
+	w := gzip.NewWriter(&payload)
+	for _, ev := range inner.events {
+		w.Write(ev)
+	}
+	w.Close()
+	e.add(TRANSACTION_PAYLOAD_EVENT, append([]byte{_PAYLOAD_COMPRESSION_TYPE,
+		1, PAYLOAD_COMPRESSION_ZSTD, _PAYLOAD_HEADER_END_MARK},
+		payload.Bytes()...))
+	stop := uint64(e.pos)
+
+	tx(e, 3)
+
+	b := newTestBinlog(e)
+	b.SetFile("bin.000001")
+	stmts, err := Flashback(b, SQLWindow{File: "bin.000001",
+		StartPosition: start, StopPosition: stop})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stmts) != 1 || !strings.HasPrefix(stmts[0],
+		"DELETE FROM `test`.`t1` WHERE `id` = 2 AND ") {
+		t.Errorf("got %q", stmts)
+	}
+}
+
+func TestRenderSignedness(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  []byte // table map meta data
+		want string // "" for an error
+	}{
+		{"unknown", nil, ""},
+		{"signed", []byte{_TABLE_MAP_SIGNEDNESS, 1, 0x00}, "-1"},
+		{"unsigned", []byte{_TABLE_MAP_SIGNEDNESS, 1, 0x80}, "4294967295"},
+	}
+
+	for _, test := range tests {
+		e := &evBuilder{}
+		e.fde()
+		e.tableMap(1, "test", "t1", []byte{_TYPE_LONG}, nil, test.opt...)
+		e.rows(WRITE_ROWS_EVENT, 1, 0, 1, rowLong(1))
+		e.rows(WRITE_ROWS_EVENT, 1, STMT_END_F, 1, rowLong(-1))
+
+		events := readEvents(t, e)
+
+		// positive values are the same either way
+		stmts, err := RowsEventSQL(events[2].(*RowsEvent))
+		if err != nil || len(stmts) != 1 ||
+			stmts[0] != "INSERT INTO `test`.`t1` (`col_1`) VALUES (1)" {
+			t.Errorf("%s: got %q, %v", test.name, stmts, err)
+		}
+
+		stmts, err = RowsEventSQL(events[3].(*RowsEvent))
+		if test.want == "" {
+			if e, ok := err.(*Error); !ok || e.Code() != ErrSignedness {
+				t.Errorf("%s: got %q, %v", test.name, stmts, err)
+			}
+			continue
+		}
+		if err != nil || len(stmts) != 1 || stmts[0] !=
+			"INSERT INTO `test`.`t1` (`col_1`) VALUES ("+test.want+")" {
+			t.Errorf("%s: got %q, %v", test.name, stmts, err)
+		}
+	}
+}
+
+func TestRenderCharset(t *testing.T) {
+	// id INT, a VARCHAR(20) latin1, b VARCHAR(20) utf16, c VARCHAR(20) gbk
+	types := []byte{_TYPE_LONG, _TYPE_VARCHAR, _TYPE_VARCHAR, _TYPE_VARCHAR}
+	meta := []byte{20, 0, 20, 0, 20, 0}
+
+	row := rowLong(1)
+	row = append(row, 5, 'c', 'a', 'f', 0xe9, '\'')
+	row = append(row, 4, 0, 'h', 0, 'i')
+	row = append(row, 2, 0xc4, 0xe3)
+
+	tests := []struct {
+		opt  []byte // table map meta data
+		want string
+	}{
+		{[]byte{_TABLE_MAP_COLUMN_CHARSET, 3, 8, 54, 28},
+			`(1, _utf8mb4'café\'', _utf8mb4'hi', X'c4e3')`},
+		// no charset meta data
+		{nil, `(1, X'636166e927', X'00680069', X'c4e3')`},
+	}
+
+	for _, test := range tests {
+		e := &evBuilder{}
+		e.fde()
+		e.tableMap(1, "test", "t1", types, meta, test.opt...)
+		e.rows(WRITE_ROWS_EVENT, 1, STMT_END_F, 4, row)
+
+		events := readEvents(t, e)
+		stmts, err := RowsEventSQL(events[len(events)-1].(*RowsEvent))
+		want := "INSERT INTO `test`.`t1` (`col_1`, `col_2`, `col_3`, `col_4`) " +
+			"VALUES " + test.want
+		if err != nil || len(stmts) != 1 || stmts[0] != want {
+			t.Errorf("got %q, %v, want %s", stmts, err, want)
+		}
+	}
+}
@@ -0,0 +1,304 @@
+/*
+  The MIT License (MIT)
+
+  Copyright (c) 2015 Nirbhay Choubey
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in all
+  copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+  SOFTWARE.
+*/
+
+package mysql
+
+import (
+	"database/sql"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// SchemaTracker keeps track of the table definitions by applying the DDL
+// statements found in the binlog, so that the rows events can be decoded
+// into named columns even when the server does not log them
+// (binlog_row_metadata=MINIMAL).
+type SchemaTracker struct {
+	mu     sync.Mutex
+	tables map[string]*TableSchema
+
+	// called for the tables forgotten
+	forget func(schema, table, query string)
+}
+
+// TableSchema describes a tracked table.
+type TableSchema struct {
+	Schema     string         `json:"schema"`
+	Table      string         `json:"table"`
+	Columns    []ColumnSchema `json:"columns"`
+	PrimaryKey []string       `json:"primaryKey,omitempty"`
+}
+
+// ColumnSchema describes a column of a tracked table.
+type ColumnSchema struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Unsigned bool   `json:"unsigned,omitempty"`
+}
+
+func NewSchemaTracker() *SchemaTracker {
+	return &SchemaTracker{tables: make(map[string]*TableSchema)}
+}
+
+// SetSchemaTracker sets the schema tracker to be kept up to date with the
+// DDL statements and used to name the columns of the table maps.
+func (b *Binlog) SetSchemaTracker(t *SchemaTracker) {
+	b.schemas = t
+}
+
+// Load seeds the tracker with the tables of the specified schemas (all the
+// user schemas if none) read from information_schema.
+func (t *SchemaTracker) Load(db *sql.DB, schemas ...string) error {
+	var cond string
+
+	if len(schemas) > 0 {
+		quoted := make([]string, len(schemas))
+		for i, s := range schemas {
+			quoted[i] = quoteString(s)
+		}
+		cond = "TABLE_SCHEMA IN (" + strings.Join(quoted, ", ") + ")"
+	} else {
+		cond = "TABLE_SCHEMA NOT IN ('mysql', 'information_schema', " +
+			"'performance_schema', 'sys')"
+	}
+
+	tables := make(map[string]*TableSchema)
+
+	rows, err := db.Query("SELECT TABLE_SCHEMA, TABLE_NAME, COLUMN_NAME, " +
+		"COLUMN_TYPE FROM information_schema.COLUMNS WHERE " + cond +
+		" ORDER BY TABLE_SCHEMA, TABLE_NAME, ORDINAL_POSITION")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var schema, table, column, type_ string
+
+		if err = rows.Scan(&schema, &table, &column, &type_); err != nil {
+			return err
+		}
+
+		key := tableKey(schema, table)
+		ts, ok := tables[key]
+		if !ok {
+			ts = &TableSchema{Schema: schema, Table: table}
+			tables[key] = ts
+		}
+
+		type_ = strings.ToLower(type_)
+		ts.Columns = append(ts.Columns, ColumnSchema{
+			Name:     column,
+			Type:     columnBaseType(type_),
+			Unsigned: strings.Contains(type_, "unsigned"),
+		})
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	keys, err := db.Query("SELECT TABLE_SCHEMA, TABLE_NAME, COLUMN_NAME " +
+		"FROM information_schema.KEY_COLUMN_USAGE WHERE " + cond +
+		" AND CONSTRAINT_NAME = 'PRIMARY' ORDER BY TABLE_SCHEMA, " +
+		"TABLE_NAME, ORDINAL_POSITION")
+	if err != nil {
+		return err
+	}
+	defer keys.Close()
+
+	for keys.Next() {
+		var schema, table, column string
+
+		if err = keys.Scan(&schema, &table, &column); err != nil {
+			return err
+		}
+
+		if ts, ok := tables[tableKey(schema, table)]; ok {
+			ts.PrimaryKey = append(ts.PrimaryKey, column)
+		}
+	}
+	if err = keys.Err(); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for key, ts := range tables {
+		t.tables[key] = ts
+	}
+	return nil
+}
+
+// Snapshot returns the tracked table definitions in JSON, suitable to be
+// saved along with the binlog checkpoint and later passed to Restore.
+func (t *SchemaTracker) Snapshot() ([]byte, error) {
+	return json.Marshal(t.Tables())
+}
+
+// Restore replaces the tracked table definitions with the ones of the
+// specified snapshot.
+func (t *SchemaTracker) Restore(snapshot []byte) error {
+	var tables []*TableSchema
+
+	if err := json.Unmarshal(snapshot, &tables); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.tables = make(map[string]*TableSchema, len(tables))
+	for _, ts := range tables {
+		t.tables[tableKey(ts.Schema, ts.Table)] = ts
+	}
+	return nil
+}
+
+// Tables returns the tracked table definitions, sorted by name.
+func (t *SchemaTracker) Tables() []*TableSchema {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tables := make([]*TableSchema, 0, len(t.tables))
+	for _, ts := range t.tables {
+		tables = append(tables, ts.clone())
+	}
+
+	sort.Slice(tables, func(i, j int) bool {
+		if tables[i].Schema != tables[j].Schema {
+			return tables[i].Schema < tables[j].Schema
+		}
+		return tables[i].Table < tables[j].Table
+	})
+	return tables
+}
+
+// Table returns the definition of the specified table, nil if unknown.
+func (t *SchemaTracker) Table(schema, table string) *TableSchema {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if ts, ok := t.tables[tableKey(schema, table)]; ok {
+		return ts.clone()
+	}
+	return nil
+}
+
+// SetForgetHandler sets the function called when a table is forgotten, i.e.
+// when its columns stop being named, along with the statement responsible.
+func (t *SchemaTracker) SetForgetHandler(fn func(schema, table, query string)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.forget = fn
+}
+
+// ApplyQuery applies the specified statement, executed with the specified
+// default schema, to the tracked table definitions. Statements other than
+// CREATE/ALTER/DROP/RENAME TABLE and DROP DATABASE are ignored; tables
+// affected by statements that can't be parsed (or applied, e.g. a column
+// added after an unknown one) are forgotten rather than being tracked
+// incorrectly, which is reported to the forget handler (if any).
+func (t *SchemaTracker) ApplyQuery(schema, query string) {
+	t.mu.Lock()
+	p := newDDLParser(query, schema)
+	p.apply(t.tables)
+	forget := t.forget
+	t.mu.Unlock()
+
+	if forget == nil {
+		return
+	}
+	for _, ts := range p.forgotten {
+		forget(ts.Schema, ts.Table, query)
+	}
+}
+
+// annotate names the columns of the specified table map after the tracked
+// table definition, unless the server logged the names itself.
+func (t *SchemaTracker) annotate(ev *TableMapEvent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ts, ok := t.tables[tableKey(ev.schema, ev.table)]
+	if !ok || len(ts.Columns) != len(ev.columns) {
+		// unknown table or out of sync definition
+		return
+	}
+
+	for i := range ev.columns {
+		if ev.columns[i].name != "" {
+			// column meta data already logged
+			return
+		}
+	}
+
+	for i := range ev.columns {
+		ev.columns[i].name = ts.Columns[i].Name
+		ev.columns[i].unsigned = ts.Columns[i].Unsigned
+		ev.columns[i].signed = true
+	}
+
+	if ev.primaryKey == nil {
+		for _, name := range ts.PrimaryKey {
+			if i := ts.columnIndex(name); i >= 0 {
+				ev.primaryKey = append(ev.primaryKey, i)
+			}
+		}
+	}
+}
+
+func (ts *TableSchema) clone() *TableSchema {
+	c := *ts
+	c.Columns = append([]ColumnSchema(nil), ts.Columns...)
+	c.PrimaryKey = append([]string(nil), ts.PrimaryKey...)
+	return &c
+}
+
+// columnIndex returns the index of the specified column, -1 if not found.
+// Note: column names are case-insensitive.
+func (ts *TableSchema) columnIndex(name string) int {
+	for i := range ts.Columns {
+		if strings.EqualFold(ts.Columns[i].Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+func tableKey(schema, table string) string {
+	return schema + "." + table
+}
+
+// columnBaseType returns the type name of the specified column type
+// (e.g. int(10) unsigned -> int).
+func columnBaseType(type_ string) string {
+	if i := strings.IndexAny(type_, "( "); i >= 0 {
+		return type_[:i]
+	}
+	return type_
+}
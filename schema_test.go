@@ -0,0 +1,280 @@
+/*
+  The MIT License (MIT)
+
+  Copyright (c) 2015 Nirbhay Choubey
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in all
+  copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+  SOFTWARE.
+*/
+
+package mysql
+
+import (
+	"strings"
+	"testing"
+)
+
+// describeTables returns the tracked tables as
+// schema.table(column type[ unsigned], ...)[ pk(columns)], separated by
+// semicolons.
+func describeTables(st *SchemaTracker) string {
+	var tables []string
+
+	for _, ts := range st.Tables() {
+		var cols []string
+
+		for _, c := range ts.Columns {
+			col := c.Name + " " + c.Type
+			if c.Unsigned {
+				col += " unsigned"
+			}
+			cols = append(cols, col)
+		}
+
+		s := ts.Schema + "." + ts.Table + "(" + strings.Join(cols, ", ") + ")"
+		if len(ts.PrimaryKey) > 0 {
+			s += " pk(" + strings.Join(ts.PrimaryKey, ", ") + ")"
+		}
+		tables = append(tables, s)
+	}
+	return strings.Join(tables, "; ")
+}
+
+func TestSchemaTrackerDDL(t *testing.T) {
+	const create = "CREATE TABLE t1 (id INT UNSIGNED NOT NULL, name VARCHAR(20), " +
+		"d DECIMAL(10,2), PRIMARY KEY (id))"
+
+	tests := []struct {
+		name    string
+		queries []string
+		want    string
+	}{
+		{"create", []string{create},
+			"test.t1(id int unsigned, name varchar, d decimal) pk(id)"},
+		{"create inline primary key", []string{
+			"CREATE TABLE t1 (a BIGINT PRIMARY KEY, b TEXT)"},
+			"test.t1(a bigint, b text) pk(a)"},
+		{"create inline key", []string{
+			"CREATE TABLE t1 (a INT UNIQUE KEY, b INT KEY, c INT UNIQUE)"},
+			"test.t1(a int, b int, c int) pk(b)"},
+		{"create with options", []string{
+			"CREATE TABLE IF NOT EXISTS t1 (id INT(10) UNSIGNED NOT NULL " +
+				"AUTO_INCREMENT, name VARCHAR(20) DEFAULT 'x,y' COMMENT " +
+				"'primary', KEY k (name(5)), PRIMARY KEY (`id`)) " +
+				"ENGINE=InnoDB /*!50100 PARTITION BY HASH (id) */"},
+			"test.t1(id int unsigned, name varchar) pk(id)"},
+		{"create if not exists", []string{create,
+			"CREATE TABLE IF NOT EXISTS t1 (a INT PRIMARY KEY)",
+			"CREATE TABLE IF NOT EXISTS t2 LIKE t1",
+			"CREATE TABLE IF NOT EXISTS t2 (b INT)"},
+			"test.t1(id int unsigned, name varchar, d decimal) pk(id); " +
+				"test.t2(id int unsigned, name varchar, d decimal) pk(id)"},
+		{"create qualified", []string{
+			"CREATE TABLE other.t1 (a INT)"},
+			"other.t1(a int)"},
+		{"create like", []string{create,
+			"CREATE TABLE t2 LIKE t1"},
+			"test.t1(id int unsigned, name varchar, d decimal) pk(id); " +
+				"test.t2(id int unsigned, name varchar, d decimal) pk(id)"},
+		{"create temporary", []string{
+			"CREATE TEMPORARY TABLE t1 (a INT)"},
+			""},
+		{"quoted identifiers", []string{
+			"CREATE TABLE `my db`.`my``table` (`a b` INT, `select` INT, " +
+				"PRIMARY KEY (`a b`))"},
+			"my db.my`table(a b int, select int) pk(a b)"},
+		{"quoted identifiers default schema", []string{
+			"CREATE TABLE `T1` (`ID` INT)",
+			"ALTER TABLE `T1` ADD COLUMN `x` INT"},
+			"test.T1(ID int, x int)"},
+		{"add column", []string{create,
+			"ALTER TABLE t1 ADD COLUMN e INT"},
+			"test.t1(id int unsigned, name varchar, d decimal, e int) pk(id)"},
+		{"add column first/after", []string{create,
+			"ALTER TABLE t1 ADD a INT FIRST, ADD COLUMN b INT AFTER id"},
+			"test.t1(a int, id int unsigned, b int, name varchar, d decimal) pk(id)"},
+		{"add columns", []string{create,
+			"ALTER TABLE t1 ADD (e INT, f BLOB)"},
+			"test.t1(id int unsigned, name varchar, d decimal, e int, f blob) pk(id)"},
+		{"drop column", []string{create,
+			"ALTER TABLE t1 DROP COLUMN name, DROP d"},
+			"test.t1(id int unsigned) pk(id)"},
+		{"modify column", []string{create,
+			"ALTER TABLE t1 MODIFY name TEXT, MODIFY COLUMN d INT UNSIGNED FIRST"},
+			"test.t1(d int unsigned, id int unsigned, name text) pk(id)"},
+		{"change column", []string{create,
+			"ALTER TABLE t1 CHANGE name nm VARCHAR(30), CHANGE COLUMN d dd DOUBLE AFTER id"},
+			"test.t1(id int unsigned, dd double, nm varchar) pk(id)"},
+		{"change column first", []string{create,
+			"ALTER TABLE t1 CHANGE d dd DOUBLE FIRST"},
+			"test.t1(dd double, id int unsigned, name varchar) pk(id)"},
+		{"modify column after", []string{create,
+			"ALTER TABLE t1 MODIFY id INT UNSIGNED AFTER d"},
+			"test.t1(name varchar, d decimal, id int unsigned) pk(id)"},
+		{"add column after then rename", []string{create,
+			"ALTER TABLE t1 ADD e INT AFTER name, RENAME TO t2"},
+			"test.t2(id int unsigned, name varchar, e int, d decimal) pk(id)"},
+		{"add column after unknown column", []string{create,
+			"ALTER TABLE t1 ADD e INT AFTER nope"},
+			""},
+		{"change primary key column", []string{create,
+			"ALTER TABLE t1 CHANGE id pk BIGINT"},
+			"test.t1(pk bigint, name varchar, d decimal) pk(pk)"},
+		{"rename column", []string{create,
+			"ALTER TABLE t1 RENAME COLUMN name TO nm"},
+			"test.t1(id int unsigned, nm varchar, d decimal) pk(id)"},
+		{"primary key", []string{create,
+			"ALTER TABLE t1 DROP PRIMARY KEY, ADD PRIMARY KEY (name, id)"},
+			"test.t1(id int unsigned, name varchar, d decimal) pk(name, id)"},
+		{"alter options", []string{create,
+			"ALTER TABLE t1 ADD INDEX k (name), ENGINE=InnoDB, ALGORITHM=INPLACE"},
+			"test.t1(id int unsigned, name varchar, d decimal) pk(id)"},
+		{"alter rename", []string{create,
+			"ALTER TABLE t1 RENAME TO other.t2"},
+			"other.t2(id int unsigned, name varchar, d decimal) pk(id)"},
+		{"alter rename as", []string{create,
+			"ALTER TABLE t1 RENAME AS t2"},
+			"test.t2(id int unsigned, name varchar, d decimal) pk(id)"},
+		{"rename table", []string{create,
+			"CREATE TABLE t2 (a INT)",
+			"RENAME TABLE t1 TO t3, t2 TO other.t4"},
+			"other.t4(a int); " +
+				"test.t3(id int unsigned, name varchar, d decimal) pk(id)"},
+		{"rename swap", []string{create,
+			"CREATE TABLE t2 (a INT)",
+			"RENAME TABLE t1 TO tmp, t2 TO t1, tmp TO t2"},
+			"test.t1(a int); " +
+				"test.t2(id int unsigned, name varchar, d decimal) pk(id)"},
+		{"drop table", []string{create,
+			"CREATE TABLE t2 (a INT)",
+			"DROP TABLE IF EXISTS t1, `t2`"},
+			""},
+		{"drop database", []string{create,
+			"CREATE TABLE other.t2 (a INT)",
+			"DROP DATABASE test"},
+			"other.t2(a int)"},
+		{"unparsable alter forgets the table", []string{create,
+			"ALTER TABLE t1 ADD COLUMN"},
+			""},
+		{"other statements", []string{create,
+			"INSERT INTO t1 VALUES (1, 'a', 1.5)",
+			"CREATE INDEX k ON t1 (name)"},
+			"test.t1(id int unsigned, name varchar, d decimal) pk(id)"},
+	}
+
+	for _, test := range tests {
+		st := NewSchemaTracker()
+		for _, q := range test.queries {
+			st.ApplyQuery("test", q)
+		}
+		if got := describeTables(st); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSchemaTrackerForget(t *testing.T) {
+	st := NewSchemaTracker()
+
+	var forgotten []string
+	st.SetForgetHandler(func(schema, table, query string) {
+		forgotten = append(forgotten, schema+"."+table+": "+query)
+	})
+
+	queries := []string{
+		"CREATE TABLE t1 (a INT)",
+		"CREATE TABLE t2 (a INT)",
+		"CREATE TABLE t3 (a INT)",
+		"ALTER TABLE t1 ADD b INT AFTER nope",
+		"CREATE TABLE t4 SELECT * FROM t2",
+		"DROP TABLE t2",
+		"RENAME TABLE t3 TO t5",
+	}
+	for _, q := range queries {
+		st.ApplyQuery("test", q)
+	}
+
+	want := []string{
+		"test.t1: ALTER TABLE t1 ADD b INT AFTER nope",
+		"test.t4: CREATE TABLE t4 SELECT * FROM t2",
+	}
+	if strings.Join(forgotten, "\n") != strings.Join(want, "\n") {
+		t.Errorf("forgotten %q, want %q", forgotten, want)
+	}
+	if got := describeTables(st); got != "test.t5(a int)" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestSchemaTrackerSnapshot(t *testing.T) {
+	st := NewSchemaTracker()
+	st.ApplyQuery("test", "CREATE TABLE t1 (a INT UNSIGNED PRIMARY KEY, b TEXT)")
+	st.ApplyQuery("other", "CREATE TABLE t2 (c DATE)")
+
+	snapshot, err := st.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewSchemaTracker()
+	if err = restored.Restore(snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := describeTables(restored), describeTables(st); got != want {
+		t.Errorf("restored %q, want %q", got, want)
+	}
+}
+
+func TestSchemaTrackerAnnotate(t *testing.T) {
+	e := new(evBuilder)
+	e.fde()
+	e.query("test", "CREATE TABLE t1 (id INT UNSIGNED, v INT, PRIMARY KEY (id))")
+	e.query("test", "BEGIN")
+	e.tableMap(5, "test", "t1", []byte{_TYPE_LONG, _TYPE_LONG}, nil)
+	e.rows(WRITE_ROWS_EVENT, 5, STMT_END_F, 2, rowLong(-1, -1))
+	e.xid(1)
+
+	b := newTestBinlog(e)
+	b.SetSchemaTracker(NewSchemaTracker())
+
+	var tableMap *TableMapEvent
+	for b.Next() {
+		re, err := b.RawEvent()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ev, ok := re.Event().(*TableMapEvent); ok {
+			tableMap = ev
+		}
+	}
+
+	if tableMap == nil {
+		t.Fatal("no table map event")
+	}
+
+	cols := tableMap.columns
+	if cols[0].name != "id" || !cols[0].unsigned || cols[1].name != "v" ||
+		cols[1].unsigned {
+		t.Errorf("columns not annotated: %+v", cols)
+	}
+
+	if pk := tableMap.PrimaryKey(); len(pk) != 1 || pk[0] != 0 {
+		t.Errorf("primary key = %v, want [0]", pk)
+	}
+}
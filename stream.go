@@ -0,0 +1,324 @@
+/*
+  The MIT License (MIT)
+
+  Copyright (c) 2015 Nirbhay Choubey
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in all
+  copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+  SOFTWARE.
+*/
+
+package mysql
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// Decompressor returns a reader decompressing the specified compressed
+// stream.
+type Decompressor func(r io.Reader) (io.Reader, error)
+
+type decompressor struct {
+	name  string
+	magic []byte
+	fn    Decompressor
+}
+
+var decompressors struct {
+	sync.RWMutex
+	list []decompressor
+}
+
+// well-known compression formats
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+func init() {
+	RegisterDecompressor("gzip", gzipMagic, func(r io.Reader) (io.Reader, error) {
+		return gzip.NewReader(r)
+	})
+}
+
+// RegisterDecompressor registers a decompressor for the streams starting with
+// the specified magic number, replacing any previously registered one with
+// the same name. Only gzip is built in; zstd (used for the archives as well
+// as the MySQL compressed transaction payloads) can be added with:
+//
+//	mysql.RegisterDecompressor("zstd", []byte{0x28, 0xb5, 0x2f, 0xfd},
+//		func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) })
+//
+// Without it, a binlog stream fails on the first compressed transaction
+// payload rather than passing it on without its row changes.
+func RegisterDecompressor(name string, magic []byte, fn Decompressor) {
+	decompressors.Lock()
+	defer decompressors.Unlock()
+
+	for i := range decompressors.list {
+		if decompressors.list[i].name == name {
+			decompressors.list[i] = decompressor{name, magic, fn}
+			return
+		}
+	}
+	decompressors.list = append(decompressors.list,
+		decompressor{name, magic, fn})
+}
+
+// lookupDecompressor returns the decompressor registered with the specified
+// name, nil if none.
+func lookupDecompressor(name string) Decompressor {
+	decompressors.RLock()
+	defer decompressors.RUnlock()
+
+	for _, d := range decompressors.list {
+		if d.name == name {
+			return d.fn
+		}
+	}
+	return nil
+}
+
+// detectDecompressor returns the decompressor for the stream starting with
+// the specified bytes, nil if the stream is not compressed.
+func detectDecompressor(head []byte) (Decompressor, error) {
+	decompressors.RLock()
+	defer decompressors.RUnlock()
+
+	for _, d := range decompressors.list {
+		if bytes.HasPrefix(head, d.magic) {
+			return d.fn, nil
+		}
+	}
+
+	if bytes.HasPrefix(head, zstdMagic) {
+		return nil, myError(ErrFile, "zstd compressed stream, no "+
+			"decompressor registered (see RegisterDecompressor)")
+	}
+	return nil, nil
+}
+
+// streamReader reads the binlog events from an io.Reader, which can be
+// compressed with any registered format. Seeking to the start position is
+// only possible with an uncompressed io.ReadSeeker, the preceding events are
+// otherwise skipped.
+type streamReader struct {
+	r      io.Reader
+	seeker io.ReadSeeker // nil if not seekable
+	base   int64         // offset of the magic number in seeker
+	offset uint64        // offset of the next event
+
+	// decompressing reader, to be closed
+	decompressor io.Reader
+
+	// format description event to be delivered first, when reading
+	// starts past the beginning of the stream
+	description []byte
+
+	eof       bool
+	e         error
+	nextEvent []byte
+}
+
+// NewBinlogFromReader returns a Binlog reading the events of a binlog file
+// from the specified reader, which can be compressed (gzip, or any
+// registered format); the reader is not closed by Binlog.Close.
+func NewBinlogFromReader(r io.Reader) (*Binlog, error) {
+	sr, err := newStreamReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	b := new(Binlog)
+	b.reader = sr
+	b.checksum = new(checksumOff)
+	b.p.binlogVerifyChecksum = true
+	return b, nil
+}
+
+func newStreamReader(r io.Reader) (*streamReader, error) {
+	var (
+		sr  streamReader
+		err error
+	)
+
+	if seeker, ok := r.(io.ReadSeeker); ok {
+		if sr.base, err = seeker.Seek(0, io.SeekCurrent); err == nil {
+			sr.seeker = seeker
+		}
+	}
+
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(len(zstdMagic))
+
+	fn, err := detectDecompressor(head)
+	if err != nil {
+		return nil, err
+	}
+
+	if fn != nil {
+		if sr.decompressor, err = fn(br); err != nil {
+			return nil, myError(ErrFile, err)
+		}
+		br = bufio.NewReader(sr.decompressor)
+		sr.seeker = nil
+	}
+	sr.r = br
+
+	// read and verify magic number
+	magic := make([]byte, _BINLOG_HEADER_SIZE)
+	if _, err = io.ReadFull(sr.r, magic); err != nil ||
+		string(magic) != _BINLOG_MAGIC {
+		return nil, myError(ErrFile, "not a binlog stream")
+	}
+	sr.offset = _BINLOG_HEADER_SIZE
+
+	return &sr, nil
+}
+
+// begin moves to the specified position, the file is ignored. The format
+// description event gets delivered first (as done by the server), the events
+// can't be decoded without it.
+func (sr *streamReader) begin(index binlogIndex) error {
+	var err error
+
+	if index.position > _BINLOG_HEADER_SIZE && sr.offset == _BINLOG_HEADER_SIZE {
+		if !sr.next() {
+			if sr.e != nil {
+				return sr.e
+			}
+			return myError(ErrFile, "no format description event")
+		}
+
+		if sr.nextEvent[_EVENT_TYPE_OFFSET] == FORMAT_DESCRIPTION_EVENT {
+			sr.description = artificialFDE(sr.nextEvent)
+		}
+	}
+
+	if index.position <= sr.offset {
+		if index.position == 0 || index.position == sr.offset {
+			return nil
+		}
+		if sr.seeker == nil {
+			return myError(ErrFile, fmt.Sprintf("can't go back to "+
+				"position %d in a stream", index.position))
+		}
+	}
+
+	if sr.seeker != nil {
+		if _, err = sr.seeker.Seek(sr.base+int64(index.position),
+			io.SeekStart); err != nil {
+			return myError(ErrFile, err)
+		}
+		sr.r = bufio.NewReader(sr.seeker)
+	} else if _, err = io.CopyN(ioutil.Discard, sr.r,
+		int64(index.position-sr.offset)); err != nil {
+		return myError(ErrFile, err)
+	}
+
+	sr.offset = index.position
+	return nil
+}
+
+func (sr *streamReader) next() bool {
+	// reset last error
+	sr.e = nil
+
+	if sr.eof {
+		return false
+	}
+
+	if sr.description != nil {
+		sr.nextEvent, sr.description = sr.description, nil
+		return true
+	}
+
+	header := make([]byte, _EVENT_HEADER_LENGTH)
+	if _, err := io.ReadFull(sr.r, header); err != nil {
+		return sr.fail(err)
+	}
+
+	size := binary.LittleEndian.Uint32(header[9:])
+	if size < _EVENT_HEADER_LENGTH {
+		return sr.fail(myError(ErrFile, fmt.Sprintf("invalid event size "+
+			"%d at %d", size, sr.offset)))
+	}
+
+	sr.nextEvent = make([]byte, size)
+	copy(sr.nextEvent, header)
+	if _, err := io.ReadFull(sr.r, sr.nextEvent[_EVENT_HEADER_LENGTH:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return sr.fail(err)
+	}
+
+	sr.offset += uint64(size)
+	return true
+}
+
+// fail ends the stream, the error is reported unless it is a clean end of
+// stream.
+func (sr *streamReader) fail(err error) bool {
+	sr.eof = true
+
+	switch err {
+	case io.EOF:
+	case io.ErrUnexpectedEOF:
+		sr.e = myError(ErrFile, fmt.Sprintf("truncated event at %d",
+			sr.offset))
+	default:
+		if _, ok := err.(*Error); ok {
+			sr.e = err
+		} else {
+			sr.e = myError(ErrFile, err)
+		}
+	}
+	return false
+}
+
+func (sr *streamReader) event() []byte {
+	return sr.nextEvent
+}
+
+func (sr *streamReader) error() error {
+	return sr.e
+}
+
+// rotate is a no-op, a stream holds a single binlog file.
+func (sr *streamReader) rotate(file string, position uint64) {
+}
+
+// close releases the decompressor, if any; the underlying reader is left
+// open.
+func (sr *streamReader) close() error {
+	sr.eof = true
+
+	if c, ok := sr.decompressor.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			return myError(ErrFile, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+/*
+  The MIT License (MIT)
+
+  Copyright (c) 2015 Nirbhay Choubey
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in all
+  copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+  SOFTWARE.
+*/
+
+package mysql
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strings"
+	"testing"
+)
+
+// binlogBytes returns the contents of the binlog file holding the built
+// events.
+func binlogBytes(e *evBuilder) []byte {
+	buf := bytes.NewBufferString(_BINLOG_MAGIC)
+	for _, ev := range e.events {
+		buf.Write(ev)
+	}
+	return buf.Bytes()
+}
+
+// gzipBytes returns the gzip compressed data.
+func gzipBytes(data []byte) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+// onlyReader hides all the methods of the wrapped reader but Read.
+type onlyReader struct {
+	io.Reader
+}
+
+// readQueries returns the queries read from the specified stream, starting at
+// the specified position.
+func readQueries(r io.Reader, position uint64) ([]string, error) {
+	b, err := NewBinlogFromReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer b.Close()
+
+	b.SetPosition(position)
+	if err = b.Begin(); err != nil {
+		return nil, err
+	}
+
+	var queries []string
+	for b.Next() {
+		re, err := b.RawEvent()
+		if err != nil {
+			return nil, err
+		}
+		if ev, ok := re.Event().(*QueryEvent); ok {
+			queries = append(queries, ev.Query())
+		}
+	}
+	return queries, b.Error()
+}
+
+func TestNewBinlogFromReader(t *testing.T) {
+	e := new(evBuilder)
+	e.fde()
+	e.query("test", "CREATE TABLE t1 (a INT)")
+	second := uint64(e.pos)
+	e.query("test", "CREATE TABLE t2 (a INT)")
+	data := binlogBytes(e)
+
+	tests := []struct {
+		name     string
+		r        io.Reader
+		position uint64
+		want     string // queries, or the expected error
+	}{
+		{"plain", bytes.NewReader(data), 0,
+			"CREATE TABLE t1 (a INT);CREATE TABLE t2 (a INT)"},
+		{"seek", bytes.NewReader(data), second, "CREATE TABLE t2 (a INT)"},
+		{"skip", onlyReader{bytes.NewReader(data)}, second,
+			"CREATE TABLE t2 (a INT)"},
+		{"gzip", bytes.NewReader(gzipBytes(data)), 0,
+			"CREATE TABLE t1 (a INT);CREATE TABLE t2 (a INT)"},
+		{"gzip skip", bytes.NewReader(gzipBytes(data)), second,
+			"CREATE TABLE t2 (a INT)"},
+		{"gzip back", bytes.NewReader(gzipBytes(data)), 2,
+			"can't go back to position 2"},
+		{"truncated", bytes.NewReader(data[:len(data)-5]), 0,
+			"truncated event"},
+		{"not a binlog", strings.NewReader("CREATE TABLE t1"), 0,
+			"not a binlog stream"},
+		{"zstd", bytes.NewReader(append(append([]byte(nil), zstdMagic...),
+			data...)), 0, "no decompressor registered"},
+	}
+
+	for _, test := range tests {
+		queries, err := readQueries(test.r, test.position)
+		if err != nil {
+			if !strings.Contains(err.Error(), test.want) {
+				t.Errorf("%s: got %v, want %q", test.name, err, test.want)
+			}
+		} else if got := strings.Join(queries, ";"); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRegisterDecompressor(t *testing.T) {
+	defer func(list []decompressor) {
+		decompressors.list = list
+	}(append([]decompressor(nil), decompressors.list...))
+
+	e := new(evBuilder)
+	e.fde()
+	e.query("test", "CREATE TABLE t1 (a INT)")
+
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	w.Write(binlogBytes(e))
+	w.Close()
+	data := buf.Bytes()
+
+	var calls []string
+	zlibMagic := []byte{0x78, 0x9c}
+	register := func(name string) {
+		RegisterDecompressor("zlib", zlibMagic, func(r io.Reader) (io.Reader, error) {
+			calls = append(calls, name)
+			return zlib.NewReader(r)
+		})
+	}
+
+	// not registered yet
+	if _, err := readQueries(bytes.NewReader(data), 0); err == nil {
+		t.Error("read a zlib stream without decompressor")
+	}
+
+	register("first")
+	n := len(decompressors.list)
+	// replaces the first one
+	register("second")
+	if len(decompressors.list) != n || lookupDecompressor("zlib") == nil {
+		t.Errorf("got %d decompressors, want %d", len(decompressors.list), n)
+	}
+
+	queries, err := readQueries(bytes.NewReader(data), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queries) != 1 || queries[0] != "CREATE TABLE t1 (a INT)" {
+		t.Errorf("got %q", queries)
+	}
+	if len(calls) != 1 || calls[0] != "second" {
+		t.Errorf("decompressors called: %q", calls)
+	}
+}
@@ -0,0 +1,323 @@
+/*
+  The MIT License (MIT)
+
+  Copyright (c) 2015 Nirbhay Choubey
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in all
+  copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+  SOFTWARE.
+*/
+
+package mysql
+
+import (
+	"io"
+	"strings"
+	"time"
+)
+
+// Transaction represents a committed transaction, assembled from the binlog
+// events between its GTID/BEGIN and XID/COMMIT. The events of an XA
+// transaction are those of its XA PREPARE event group followed by those of
+// its XA COMMIT one.
+type Transaction struct {
+	gtid       string
+	xid        string
+	commitTime time.Time
+	file       string
+	position   uint64
+	events     []Event
+	statements []*QueryEvent
+	rows       []*RowsEvent
+
+	begin    bool // BEGIN seen
+	body     int  // events other than the boundaries
+	filtered bool // events rejected by the filter
+}
+
+// Gtid returns the GTID (MySQL or MariaDB) of the transaction, empty if the
+// transaction has none. The GTID of an XA transaction is the one of its XA
+// COMMIT.
+func (tx *Transaction) Gtid() string {
+	return tx.gtid
+}
+
+// Xid returns the XID of an XA transaction (e.g. X'31',X”,1), empty if the
+// transaction is not an XA transaction.
+func (tx *Transaction) Xid() string {
+	return tx.xid
+}
+
+// CommitTime returns the timestamp of the commit event (XID or COMMIT).
+func (tx *Transaction) CommitTime() time.Time {
+	return tx.commitTime
+}
+
+// File returns the binlog file the transaction was read from.
+func (tx *Transaction) File() string {
+	return tx.file
+}
+
+// Position returns the position right after the transaction in its binlog
+// file.
+func (tx *Transaction) Position() uint64 {
+	return tx.position
+}
+
+// Events returns all the events of the transaction.
+func (tx *Transaction) Events() []Event {
+	return tx.events
+}
+
+// Statements returns the statements executed in the transaction, excluding
+// BEGIN and COMMIT.
+func (tx *Transaction) Statements() []*QueryEvent {
+	return tx.statements
+}
+
+// Rows returns the row changes of the transaction, in order.
+func (tx *Transaction) Rows() []*RowsEvent {
+	return tx.rows
+}
+
+// add appends the specified event to the transaction.
+func (tx *Transaction) add(ev Event) {
+	tx.events = append(tx.events, ev)
+
+	if !isTransactionBoundary(ev) {
+		tx.body++
+	}
+
+	switch e := ev.(type) {
+	case *GtidLogEvent:
+		tx.gtid = e.gtid.String()
+	case *GtidTaggedLogEvent:
+		tx.gtid = e.gtid.String()
+	case *GtidEvent:
+		tx.gtid = e.gtid.String()
+	case *QueryEvent:
+		if xid, ok := xaStatementXid(e.query, "XA START"); ok {
+			tx.begin = true
+			tx.xid = xid
+		} else if e.query == "BEGIN" {
+			tx.begin = true
+		} else if !isTransactionBoundary(ev) {
+			tx.statements = append(tx.statements, e)
+		}
+	case *RowsEvent:
+		tx.rows = append(tx.rows, e)
+	default:
+	}
+}
+
+// isTransactionBoundary returns whether the specified event is a GTID, a
+// BEGIN/COMMIT/ROLLBACK or an XA statement; XID and XA_PREPARE events are
+// detected as commits.
+func isTransactionBoundary(ev Event) bool {
+	switch e := ev.(type) {
+	case *GtidLogEvent, *GtidTaggedLogEvent, *AnonymousGtidLogEvent,
+		*GtidEvent, *XidEvent, *XaPrepareLogEvent:
+		return true
+	case *QueryEvent:
+		switch e.query {
+		case "BEGIN", "COMMIT", "ROLLBACK":
+			return true
+		default:
+		}
+		return isXAStatement(e.query, "XA")
+	default:
+	}
+	return false
+}
+
+// xaStatementXid returns the XID of the specified XA statement, as logged
+// (e.g. XA START X'31',X”,1), ok being false if the query is not such a
+// statement.
+func xaStatementXid(query, statement string) (xid string, ok bool) {
+	prefix := statement + " "
+	if len(query) < len(prefix) || !strings.EqualFold(query[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(query[len(prefix):]), true
+}
+
+// isXAStatement returns whether the specified query is the given XA
+// statement.
+func isXAStatement(query, statement string) bool {
+	_, ok := xaStatementXid(query, statement)
+	return ok
+}
+
+// xidKey returns the specified XID in a canonical form, so that the XIDs of
+// the events and statements of an XA transaction compare equal.
+func xidKey(xid string) string {
+	return strings.ToLower(strings.Replace(xid, " ", "", -1))
+}
+
+// startsTransaction returns whether the specified event marks the beginning of
+// a new transaction, given the transaction in progress.
+func startsTransaction(tx *Transaction, ev Event) bool {
+	switch e := ev.(type) {
+	case *GtidLogEvent, *GtidTaggedLogEvent, *AnonymousGtidLogEvent,
+		*GtidEvent:
+		return true
+	case *QueryEvent:
+		// a second BEGIN
+		return tx.begin &&
+			(e.query == "BEGIN" || isXAStatement(e.query, "XA START"))
+	default:
+	}
+	return false
+}
+
+// NextTransaction reads the events up to the end of the next committed
+// transaction and returns them assembled as a Transaction. Transactions that
+// are rolled back or incomplete (e.g. interrupted by a server crash) are
+// discarded. With a filter set, the transaction boundaries (GTID, BEGIN and
+// commit events) are kept and the transactions whose events all got filtered
+// out are skipped. It returns io.EOF when there are no more events to read.
+//
+// XA transactions are held once prepared (XA_PREPARE_LOG_EVENT) and returned
+// on their XA COMMIT, or discarded on their XA ROLLBACK; those committed in
+// one phase are returned right away. The XA COMMITs of the transactions
+// prepared before the stream start are skipped, their events being unknown.
+func (b *Binlog) NextTransaction() (*Transaction, error) {
+	var tx *Transaction
+
+	b.deliverFiltered = true
+	defer func() {
+		b.deliverFiltered = false
+	}()
+
+	for {
+		commits := b.commits
+
+		if !b.Next() {
+			break
+		}
+
+		re, err := b.RawEvent()
+		if err != nil {
+			return nil, err
+		}
+
+		if !isTransactionEvent(re.header.type_) {
+			continue
+		}
+
+		// the event body must outlive the reader's buffer
+		re.body = append([]byte(nil), re.body...)
+
+		// note: ev is nil for unimplemented events
+		ev := re.Event()
+
+		if tx == nil || (ev != nil && startsTransaction(tx, ev)) {
+			// discard the incomplete transaction (if any)
+			tx = new(Transaction)
+		}
+
+		committed := b.commits != commits
+
+		if b.filtered {
+			tx.filtered = true
+		}
+
+		if ev != nil && (!b.filtered || committed ||
+			isTransactionBoundary(ev)) {
+			tx.add(ev)
+		}
+
+		if !committed {
+			continue
+		}
+
+		// end of transaction
+		if q, ok := ev.(*QueryEvent); ok && q.query == "ROLLBACK" {
+			tx = nil
+			continue
+		}
+
+		if tx = b.resolveXA(tx, ev); tx == nil {
+			continue
+		}
+
+		if tx.filtered && tx.body == 0 {
+			// nothing left
+			tx = nil
+			continue
+		}
+
+		tx.commitTime = re.Time()
+		tx.file = b.index.file
+		tx.position = b.index.position
+		return tx, nil
+	}
+
+	if err := b.Error(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// resolveXA returns the specified transaction, ended by the given event, as
+// committed. The prepared XA transactions are held until their XA COMMIT,
+// which returns them (the events of the XA COMMIT group being appended), nil
+// is returned if the transaction is not committed.
+func (b *Binlog) resolveXA(tx *Transaction, ev Event) *Transaction {
+	switch e := ev.(type) {
+	case *XaPrepareLogEvent:
+		if e.onePhase {
+			break
+		}
+		if b.prepared == nil {
+			b.prepared = make(map[string]*Transaction)
+		}
+		b.prepared[xidKey(e.String())] = tx
+		return nil
+
+	case *QueryEvent:
+		if xid, ok := xaStatementXid(e.query, "XA ROLLBACK"); ok {
+			delete(b.prepared, xidKey(xid))
+			return nil
+		}
+
+		xid, ok := xaStatementXid(e.query, "XA COMMIT")
+		if !ok {
+			break
+		}
+		if strings.HasSuffix(strings.ToUpper(xid), " ONE PHASE") {
+			// the XA transaction in progress
+			break
+		}
+
+		prepared, ok := b.prepared[xidKey(xid)]
+		if !ok {
+			// prepared before the stream start
+			return nil
+		}
+		delete(b.prepared, xidKey(xid))
+
+		for _, ev := range tx.events {
+			prepared.add(ev)
+		}
+		prepared.filtered = prepared.filtered || tx.filtered
+		return prepared
+
+	default:
+	}
+	return tx
+}
@@ -0,0 +1,324 @@
+/*
+  The MIT License (MIT)
+
+  Copyright (c) 2015 Nirbhay Choubey
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in all
+  copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+  SOFTWARE.
+*/
+
+package mysql
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+)
+
+// readTransactions returns all the transactions of the binlog.
+func readTransactions(t *testing.T, b *Binlog) []*Transaction {
+	var txs []*Transaction
+
+	for {
+		tx, err := b.NextTransaction()
+		if err == io.EOF {
+			return txs
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		txs = append(txs, tx)
+	}
+}
+
+func TestNextTransaction(t *testing.T) {
+	sid := mustParseUUID(t, testSid1)
+
+	e := new(evBuilder)
+	e.fde()
+	e.gtid(sid, 1)
+	e.query("test", "BEGIN")
+	e.tableMap(5, "test", "t1", []byte{_TYPE_LONG}, nil)
+	e.rows(WRITE_ROWS_EVENT, 5, STMT_END_F, 1, rowLong(1))
+	e.xid(10)
+	e.gtid(sid, 2)
+	e.query("test", "CREATE TABLE t2 (a INT)")
+	e.gtid(sid, 3)
+	e.query("test", "BEGIN")
+	e.query("test", "INSERT INTO t2 VALUES (1)")
+	e.query("test", "ROLLBACK")
+
+	txs := readTransactions(t, newTestBinlog(e))
+	if len(txs) != 2 {
+		t.Fatalf("got %d transactions, want 2", len(txs))
+	}
+
+	if got := txs[0].Gtid(); got != testSid1+":1" {
+		t.Errorf("first transaction GTID = %s", got)
+	}
+	if len(txs[0].Rows()) != 1 || len(txs[0].Events()) != 5 {
+		t.Errorf("first transaction: %d rows events, %d events",
+			len(txs[0].Rows()), len(txs[0].Events()))
+	}
+
+	if got := txs[1].Statements(); len(got) != 1 ||
+		got[0].Query() != "CREATE TABLE t2 (a INT)" {
+		t.Errorf("second transaction statements = %v", got)
+	}
+}
+
+func TestNextTransactionFilter(t *testing.T) {
+	sid := mustParseUUID(t, testSid1)
+
+	e := new(evBuilder)
+	e.fde()
+	e.gtid(sid, 1)
+	e.query("test", "BEGIN")
+	e.tableMap(5, "test", "t1", []byte{_TYPE_LONG}, nil)
+	e.rows(WRITE_ROWS_EVENT, 5, STMT_END_F, 1, rowLong(1))
+	e.xid(10)
+	e.gtid(sid, 2)
+	e.query("test", "BEGIN")
+	e.tableMap(6, "test", "t2", []byte{_TYPE_LONG}, nil)
+	e.rows(WRITE_ROWS_EVENT, 6, STMT_END_F, 1, rowLong(2))
+	e.xid(11)
+
+	tests := []struct {
+		name  string
+		setup func(f *EventFilter)
+		gtids []string
+	}{
+		{"no XID", func(f *EventFilter) {
+			f.ExcludeEventTypes(XID_EVENT)
+		}, []string{testSid1 + ":1", testSid1 + ":2"}},
+		{"no GTID", func(f *EventFilter) {
+			f.ExcludeEventTypes(GTID_LOG_EVENT, XID_EVENT)
+		}, []string{testSid1 + ":1", testSid1 + ":2"}},
+		{"rows only", func(f *EventFilter) {
+			f.IncludeEventTypes(TABLE_MAP_EVENT, WRITE_ROWS_EVENT)
+		}, []string{testSid1 + ":1", testSid1 + ":2"}},
+		{"table", func(f *EventFilter) {
+			f.ExcludeEventTypes(XID_EVENT)
+			f.ExcludeTable("test.t1")
+		}, []string{testSid1 + ":2"}},
+	}
+
+	for _, test := range tests {
+		f := NewEventFilter()
+		test.setup(f)
+
+		b := newTestBinlog(e)
+		b.SetFilter(f)
+		txs := readTransactions(t, b)
+
+		if len(txs) != len(test.gtids) {
+			t.Errorf("%s: got %d transactions, want %d", test.name,
+				len(txs), len(test.gtids))
+			continue
+		}
+
+		for i, tx := range txs {
+			if tx.Gtid() != test.gtids[i] {
+				t.Errorf("%s: transaction %d GTID = %q, want %q",
+					test.name, i, tx.Gtid(), test.gtids[i])
+			}
+
+			events := tx.Events()
+			if len(tx.Rows()) != 1 || len(events) != 5 {
+				t.Errorf("%s: transaction %d: %d rows events, %d events",
+					test.name, i, len(tx.Rows()), len(events))
+				continue
+			}
+
+			if _, ok := events[len(events)-1].(*XidEvent); !ok {
+				t.Errorf("%s: transaction %d does not end with its XID",
+					test.name, i)
+			}
+		}
+	}
+
+	// Next still honors the filter
+	f := NewEventFilter()
+	f.ExcludeEventTypes(XID_EVENT)
+	b := newTestBinlog(e)
+	b.SetFilter(f)
+	for b.Next() {
+		if b.current.header.type_ == XID_EVENT {
+			t.Error("Next delivered a filtered XID event")
+		}
+	}
+}
+
+func TestNextTransactionIncomplete(t *testing.T) {
+	sid := mustParseUUID(t, testSid1)
+
+	e := new(evBuilder)
+	e.rotate("bin.000004", 4, true)
+	e.fde()
+	// interrupted by a server crash
+	e.gtid(sid, 1)
+	e.query("test", "BEGIN")
+	e.query("test", "INSERT INTO t1 VALUES (1)")
+	e.gtid(sid, 2)
+	e.query("test", "BEGIN")
+	e.query("test", "INSERT INTO t1 VALUES (2)")
+	e.xid(10)
+	first := uint64(e.pos)
+	// MariaDB
+	e.mariadbGtid(0, 9, 0)
+	e.query("test", "BEGIN")
+	e.query("test", "INSERT INTO t1 VALUES (3)")
+	e.query("test", "COMMIT")
+	second := uint64(e.pos)
+	// no GTIDs, the first BEGIN is not committed
+	e.query("test", "BEGIN")
+	e.query("test", "INSERT INTO t1 VALUES (4)")
+	e.query("test", "BEGIN")
+	e.query("test", "INSERT INTO t1 VALUES (5)")
+	e.query("test", "COMMIT")
+	third := uint64(e.pos)
+	e.query("test", "BEGIN")
+	e.query("test", "INSERT INTO t1 VALUES (6)")
+
+	want := []struct {
+		gtid      string
+		statement string
+		position  uint64
+	}{
+		{testSid1 + ":2", "INSERT INTO t1 VALUES (2)", first},
+		{"0-1-9", "INSERT INTO t1 VALUES (3)", second},
+		{"", "INSERT INTO t1 VALUES (5)", third},
+	}
+
+	txs := readTransactions(t, newTestBinlog(e))
+	if len(txs) != len(want) {
+		t.Fatalf("got %d transactions, want %d", len(txs), len(want))
+	}
+
+	for i, tx := range txs {
+		if tx.Gtid() != want[i].gtid {
+			t.Errorf("transaction %d: GTID %q, want %q", i, tx.Gtid(),
+				want[i].gtid)
+		}
+		if s := tx.Statements(); len(s) != 1 || s[0].Query() != want[i].statement {
+			t.Errorf("transaction %d: statements %v, want %q", i, s,
+				want[i].statement)
+		}
+		if tx.File() != "bin.000004" || tx.Position() != want[i].position {
+			t.Errorf("transaction %d: position %s:%d, want bin.000004:%d",
+				i, tx.File(), tx.Position(), want[i].position)
+		}
+		if !tx.CommitTime().Equal(time.Unix(1500000000, 0)) {
+			t.Errorf("transaction %d: commit time %v", i, tx.CommitTime())
+		}
+	}
+}
+
+// xaPrepare adds an XA_PREPARE_LOG_EVENT of the specified XID (no bqual,
+// format 1).
+func (e *evBuilder) xaPrepare(gtrid string, onePhase bool) {
+	b := make([]byte, 13)
+	if onePhase {
+		b[0] = 1
+	}
+	binary.LittleEndian.PutUint32(b[1:], 1)
+	binary.LittleEndian.PutUint32(b[5:], uint32(len(gtrid)))
+	e.add(XA_PREPARE_LOG_EVENT, append(b, gtrid...))
+}
+
+func TestNextTransactionXA(t *testing.T) {
+	sid := mustParseUUID(t, testSid1)
+
+	// XA START, rows, XA END and the XA PREPARE event group of an XID
+	xa := func(e *evBuilder, gno int64, gtrid string, row int32) {
+		xid := fmt.Sprintf("X'%x',X'',1", gtrid)
+		e.gtid(sid, gno)
+		e.query("test", "XA START "+xid)
+		e.tableMap(5, "test", "t1", []byte{_TYPE_LONG}, nil)
+		e.rows(WRITE_ROWS_EVENT, 5, STMT_END_F, 1, rowLong(row))
+		e.query("test", "XA END "+xid)
+	}
+
+	e := new(evBuilder)
+	e.fde()
+	xa(e, 1, "1", 1)
+	e.xaPrepare("1", false)
+	e.gtid(sid, 2)
+	e.query("test", "BEGIN")
+	e.tableMap(5, "test", "t1", []byte{_TYPE_LONG}, nil)
+	e.rows(WRITE_ROWS_EVENT, 5, STMT_END_F, 1, rowLong(2))
+	e.xid(10)
+	xa(e, 3, "2", 3)
+	e.xaPrepare("2", false)
+	e.gtid(sid, 4)
+	e.query("test", "XA COMMIT X'31',X'',1")
+	e.gtid(sid, 5)
+	e.query("test", "XA ROLLBACK X'32',X'',1")
+	xa(e, 6, "3", 6)
+	e.xaPrepare("3", true)
+	// prepared before the stream start
+	e.gtid(sid, 7)
+	e.query("test", "XA COMMIT X'34',X'',1")
+	// MariaDB
+	xa(e, 8, "5", 8)
+	e.query("test", "XA COMMIT X'35',X'',1 ONE PHASE")
+	// prepared, not committed yet
+	xa(e, 9, "6", 9)
+	e.xaPrepare("6", false)
+
+	want := []struct {
+		gtid string
+		xid  string
+		row  int32
+	}{
+		{testSid1 + ":2", "", 2},
+		{testSid1 + ":4", "X'31',X'',1", 1},
+		{testSid1 + ":6", "X'33',X'',1", 6},
+		{testSid1 + ":8", "X'35',X'',1", 8},
+	}
+
+	b := newTestBinlog(e)
+	if err := b.SetGTIDSet(""); err != nil {
+		t.Fatal(err)
+	}
+	txs := readTransactions(t, b)
+	if len(txs) != len(want) {
+		t.Fatalf("got %d transactions, want %d", len(txs), len(want))
+	}
+
+	for i, tx := range txs {
+		if tx.Gtid() != want[i].gtid || tx.Xid() != want[i].xid {
+			t.Errorf("transaction %d: GTID %q, XID %q, want %q, %q", i,
+				tx.Gtid(), tx.Xid(), want[i].gtid, want[i].xid)
+		}
+		if rows := tx.Rows(); len(rows) != 1 ||
+			rows[0].Image().Rows[0].Columns[0] != want[i].row {
+			t.Errorf("transaction %d: rows %v, want %d", i, rows,
+				want[i].row)
+		}
+		if s := tx.Statements(); len(s) != 0 {
+			t.Errorf("transaction %d: statements %v", i, s)
+		}
+	}
+
+	// the event groups (XA PREPARE included) are all executed
+	if got := b.GetGTIDSet(); got != testSid1+":1-9" {
+		t.Errorf("GTID set %q", got)
+	}
+}
@@ -28,6 +28,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // default properties (unexported)
@@ -43,7 +44,10 @@ const (
 		_CLIENT_SECURE_CONNECTION |
 		_CLIENT_MULTI_RESULTS |
 		_CLIENT_PLUGIN_AUTH)
-	_DEFAULT_BINLOG_VERIFY_CHECKSUM = false
+	_DEFAULT_BINLOG_VERIFY_CHECKSUM  = false
+	_DEFAULT_BINLOG_HEARTBEAT_PERIOD = 30 * time.Second
+	_DEFAULT_BINLOG_FOLLOW_INTERVAL  = 250 * time.Millisecond
+	_DEFAULT_BINLOG_RECONNECT_TRIES  = 10
 )
 
 const (
@@ -73,6 +77,19 @@ type properties struct {
 	binlogDumpNonBlock bool
 	// verify checksum of binary log events
 	binlogVerifyChecksum bool
+	// @slave_gtid_strict_mode for MariaDB GTID binlog streams
+	binlogGtidStrictMode bool
+	// reconnect and resume the binlog stream if the connection is lost
+	binlogAutoReconnect bool
+	// number of reconnection attempts before giving up
+	binlogReconnectAttempts uint
+	// interval at which master sends heartbeats when idle, the link is
+	// considered dead if nothing is received in twice that time
+	binlogHeartbeatPeriod time.Duration
+	// keep reading binlog files as they get written (file://)
+	binlogFollow bool
+	// interval at which followed binlog files are polled for new events
+	binlogFollowInterval time.Duration
 }
 
 func (p *properties) parseUrl(dsn string) error {
@@ -198,10 +215,80 @@ func (p *properties) parseUrl(dsn string) error {
 		} else {
 			p.binlogVerifyChecksum = v
 		}
+	} else if p.scheme == "file" {
+		// local files are verified against the checksums enabled
+		// by their format description events
+		p.binlogVerifyChecksum = true
 	} else {
 		p.binlogVerifyChecksum = _DEFAULT_BINLOG_VERIFY_CHECKSUM
 	}
 
+	// BinlogAutoReconnect
+	if val := query.Get("BinlogAutoReconnect"); val != "" {
+		if v, err := strconv.ParseBool(val); err != nil {
+			return myError(ErrInvalidProperty, "BinlogAutoReconnect", err)
+		} else {
+			p.binlogAutoReconnect = v
+		}
+	}
+
+	// BinlogReconnectAttempts
+	if val := query.Get("BinlogReconnectAttempts"); val != "" {
+		if v, err := strconv.ParseUint(val, 10, 32); err != nil {
+			return myError(ErrInvalidProperty, "BinlogReconnectAttempts", err)
+		} else if v == 0 {
+			return myError(ErrInvalidPropertyValue, "BinlogReconnectAttempts", v)
+		} else {
+			p.binlogReconnectAttempts = uint(v)
+		}
+	} else {
+		p.binlogReconnectAttempts = _DEFAULT_BINLOG_RECONNECT_TRIES
+	}
+
+	// BinlogHeartbeatPeriod
+	if val := query.Get("BinlogHeartbeatPeriod"); val != "" {
+		if v, err := time.ParseDuration(val); err != nil {
+			return myError(ErrInvalidProperty, "BinlogHeartbeatPeriod", err)
+		} else if v <= 0 {
+			return myError(ErrInvalidPropertyValue, "BinlogHeartbeatPeriod", v)
+		} else {
+			p.binlogHeartbeatPeriod = v
+		}
+	} else if p.binlogAutoReconnect {
+		// heartbeats are required to detect a dead link
+		p.binlogHeartbeatPeriod = _DEFAULT_BINLOG_HEARTBEAT_PERIOD
+	}
+
+	// BinlogGtidStrictMode
+	if val := query.Get("BinlogGtidStrictMode"); val != "" {
+		if v, err := strconv.ParseBool(val); err != nil {
+			return myError(ErrInvalidProperty, "BinlogGtidStrictMode", err)
+		} else {
+			p.binlogGtidStrictMode = v
+		}
+	}
+
+	// BinlogFollow
+	if val := query.Get("BinlogFollow"); val != "" {
+		if v, err := strconv.ParseBool(val); err != nil {
+			return myError(ErrInvalidProperty, "BinlogFollow", err)
+		} else {
+			p.binlogFollow = v
+		}
+	}
+
+	// BinlogFollowInterval
+	p.binlogFollowInterval = _DEFAULT_BINLOG_FOLLOW_INTERVAL
+	if val := query.Get("BinlogFollowInterval"); val != "" {
+		if v, err := time.ParseDuration(val); err != nil {
+			return myError(ErrInvalidProperty, "BinlogFollowInterval", err)
+		} else if v <= 0 {
+			return myError(ErrInvalidPropertyValue, "BinlogFollowInterval", v)
+		} else {
+			p.binlogFollowInterval = v
+		}
+	}
+
 	return nil
 }
 
@@ -26,6 +26,7 @@ package mysql
 
 import (
 	"encoding/binary"
+	"strings"
 )
 
 // getUint24 converts 3-byte byte little-endian slice into uint32
@@ -45,6 +46,11 @@ func getUint48(b []byte) uint64 {
 		uint64(b[5])<<40
 }
 
+// getUint56 converts 7-byte byte little-endian slice into uint64
+func getUint56(b []byte) uint64 {
+	return getUint48(b) | uint64(b[6])<<48
+}
+
 func getInt16(b []byte) int16 {
 	return int16(b[0]) |
 		int16(b[1])<<8
@@ -103,6 +109,73 @@ func getLenencInt(b []byte) (v uint64, n int) {
 	return
 }
 
+// getVarUint retrieves the number from the specified buffer stored in the
+// variable-length format of the MySQL serialization library (the number of
+// trailing 1 bits of the first byte gives the number of extra bytes) and
+// returns the number of bytes read.
+func getVarUint(b []byte) (v uint64, n int) {
+	n = 1
+	for n < 9 && (b[0]>>uint(n-1))&1 == 1 {
+		n++
+	}
+
+	if n > len(b) {
+		return 0, len(b)
+	}
+
+	if n == 9 {
+		return binary.LittleEndian.Uint64(b[1:9]), n
+	}
+
+	for i := n - 1; i >= 0; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+	return v >> uint(n), n
+}
+
+// putVarUint stores the specified number into the buffer in the
+// variable-length format of the MySQL serialization library and returns the
+// number of bytes written.
+func putVarUint(b []byte, v uint64) int {
+	n := varUintSize(v)
+
+	if n == 9 {
+		b[0] = 0xff
+		binary.LittleEndian.PutUint64(b[1:9], v)
+		return n
+	}
+
+	// n-1 trailing 1 bits, followed by the number
+	v = v<<uint(n) | (1<<uint(n-1) - 1)
+	for i := 0; i < n; i++ {
+		b[i] = byte(v >> uint(8*i))
+	}
+	return n
+}
+
+// varUintSize returns the number of bytes the specified number takes in the
+// variable-length format of the MySQL serialization library.
+func varUintSize(v uint64) int {
+	n := 1
+	for n < 9 && v >= 1<<uint(7*n) {
+		n++
+	}
+	return n
+}
+
+// getVarInt retrieves the signed number from the specified buffer stored in
+// the variable-length format of the MySQL serialization library (sign in the
+// lowest bit) and returns the number of bytes read.
+func getVarInt(b []byte) (v int64, n int) {
+	var u uint64
+
+	u, n = getVarUint(b)
+	if (u & 1) != 0 {
+		return -int64(u>>1) - 1, n
+	}
+	return int64(u >> 1), n
+}
+
 // putLenencInt stores the given number into the specified buffer using
 // length-encoded integer format and returns the number of bytes written.
 func putLenencInt(b []byte, v uint64) (n int) {
@@ -165,7 +238,7 @@ func putLenencString(b []byte, v string) (n int) {
 
 func getNullTerminatedString(b []byte) (v string, n int) {
 	for {
-		if n > len(b) || b[n] == 0 {
+		if n >= len(b) || b[n] == 0 {
 			break
 		} else {
 			n++
@@ -215,3 +288,17 @@ func zerofy(b []byte) {
 		b[i] = 0
 	}
 }
+
+// quoteIdentifier quotes the specified identifier (schema, table or column
+// name) with backticks.
+func quoteIdentifier(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
+// quoteString returns the specified value as a quoted string literal.
+func quoteString(s string) string {
+	return "'" + stringEscaper.Replace(s) + "'"
+}
+
+var stringEscaper = strings.NewReplacer(`\`, `\\`, "'", `\'`, "\x00", `\0`,
+	"\n", `\n`, "\r", `\r`, "\x1a", `\Z`)
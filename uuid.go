@@ -61,3 +61,52 @@ func (uuid *UUID) String() string {
 	}
 	return string(res[:])
 }
+
+// parseUUID converts the specified readable string (with or without the
+// group separators) to UUID.
+func parseUUID(s string) (UUID, error) {
+	var (
+		uuid UUID
+		pos  int
+	)
+
+	for i := 0; i < len(s); i++ {
+		if s[i] == '-' {
+			continue
+		}
+
+		if pos >= 2*len(uuid.data) {
+			return uuid, myError(ErrInvalidGtid, s)
+		}
+
+		v, ok := hexToByte(s[i])
+		if !ok {
+			return uuid, myError(ErrInvalidGtid, s)
+		}
+
+		if pos%2 == 0 {
+			uuid.data[pos/2] = v << 4
+		} else {
+			uuid.data[pos/2] |= v
+		}
+		pos++
+	}
+
+	if pos != 2*len(uuid.data) {
+		return uuid, myError(ErrInvalidGtid, s)
+	}
+	return uuid, nil
+}
+
+// hexToByte returns the value of the specified hexadecimal digit.
+func hexToByte(c byte) (byte, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0', true
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10, true
+	case c >= 'A' && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
@@ -0,0 +1,228 @@
+/*
+  The MIT License (MIT)
+
+  Copyright (c) 2015 Nirbhay Choubey
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in all
+  copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+  SOFTWARE.
+*/
+
+package mysql
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// binlog integrity problems
+const (
+	PROBLEM_BAD_MAGIC = iota + 1 // not a binlog file
+	PROBLEM_CHECKSUM             // event checksum mismatch
+	PROBLEM_POSITION             // broken next position chain
+	PROBLEM_TRUNCATED            // incomplete event at the end of the file
+	PROBLEM_SIZE                 // invalid event size
+	PROBLEM_ROTATE               // rotation to an unexpected file
+)
+
+// VerifyProblem describes a problem found by Verify.
+type VerifyProblem struct {
+	File    string
+	Offset  uint64 // offset of the offending event
+	Type    int
+	Message string
+}
+
+func (p VerifyProblem) String() string {
+	return fmt.Sprintf("%s:%d: %s", p.File, p.Offset, p.Message)
+}
+
+// VerifyReport is the outcome of Verify.
+type VerifyReport struct {
+	Files    []string
+	Events   uint64
+	Problems []VerifyProblem
+}
+
+// OK returns whether no problem was found.
+func (r *VerifyReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+func (r *VerifyReport) add(file string, offset uint64, type_ int,
+	format string, a ...interface{}) {
+	r.Problems = append(r.Problems, VerifyProblem{
+		File:    file,
+		Offset:  offset,
+		Type:    type_,
+		Message: fmt.Sprintf(format, a...),
+	})
+}
+
+// Verify checks the integrity of the specified binlog file, or of all the
+// files listed in the specified binlog index file: magic numbers, event
+// checksums (if enabled by the format description events), next position
+// chains, event sizes, truncation and the rotations between the files. The
+// error is only set if a file could not be read at all.
+func Verify(path string) (*VerifyReport, error) {
+	var (
+		r   VerifyReport
+		err error
+	)
+
+	if isBinlogFile(path) {
+		r.Files = []string{path}
+	} else if _, err = os.Stat(path); err == nil {
+		if r.Files, err = readBinlogIndex(path); err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, myError(ErrFile, err)
+	}
+
+	var rotate string // file named by the last rotate event
+
+	for i, file := range r.Files {
+		if i > 0 && rotate != "" && filepath.Base(rotate) != filepath.Base(file) {
+			r.add(file, 0, PROBLEM_ROTATE, "%s rotates to %s",
+				r.Files[i-1], rotate)
+		}
+
+		if rotate, err = verifyFile(&r, file); err != nil {
+			return nil, err
+		}
+	}
+	return &r, nil
+}
+
+// verifyFile checks the integrity of a single binlog file, it returns the
+// file named by its final ROTATE_EVENT, if any.
+func verifyFile(r *VerifyReport, file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", myError(ErrFile, err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", myError(ErrFile, err)
+	}
+	size := uint64(info.Size())
+
+	br := bufio.NewReader(f)
+
+	magic := make([]byte, _BINLOG_HEADER_SIZE)
+	if _, err = io.ReadFull(br, magic); err != nil || string(magic) != _BINLOG_MAGIC {
+		r.add(file, 0, PROBLEM_BAD_MAGIC, "invalid magic number")
+		return "", nil
+	}
+
+	var (
+		checksum checksumVerifier = new(checksumOff)
+		relayLog bool
+		rotate   string
+		header   eventHeader
+	)
+
+	offset := uint64(_BINLOG_HEADER_SIZE)
+	headerBuf := make([]byte, _EVENT_HEADER_LENGTH)
+
+	for offset < size {
+		if size-offset < _EVENT_HEADER_LENGTH {
+			r.add(file, offset, PROBLEM_TRUNCATED,
+				"truncated event header (%d bytes)", size-offset)
+			break
+		}
+
+		if _, err = io.ReadFull(br, headerBuf); err != nil {
+			return "", myError(ErrFile, err)
+		}
+		header, _ = parseEventHeader(headerBuf)
+
+		if header.size < _EVENT_HEADER_LENGTH ||
+			header.size > _MAX_PACKET_SIZE_MAX {
+			// the following events can't be located
+			r.add(file, offset, PROBLEM_SIZE, "invalid event size %d",
+				header.size)
+			break
+		}
+
+		if offset+uint64(header.size) > size {
+			r.add(file, offset, PROBLEM_TRUNCATED,
+				"truncated event (%d of %d bytes)", size-offset,
+				header.size)
+			break
+		}
+
+		ev := make([]byte, header.size)
+		copy(ev, headerBuf)
+		if _, err = io.ReadFull(br, ev[_EVENT_HEADER_LENGTH:]); err != nil {
+			return "", myError(ErrFile, err)
+		}
+		r.Events++
+
+		rotate = ""
+
+		switch header.type_ {
+		case FORMAT_DESCRIPTION_EVENT:
+			switch fdeChecksumAlg(ev) {
+			case BINLOG_CHECKSUM_ALG_CRC32:
+				checksum = new(checksumCRC32IEEE)
+			default:
+				checksum = new(checksumOff)
+			}
+
+			// relay logs keep the positions of the master
+			if offset == _BINLOG_HEADER_SIZE {
+				relayLog = (header.flags & _LOG_EVENT_RELAY_LOG_F) != 0
+			}
+
+		case ROTATE_EVENT:
+			end := len(ev)
+			if checksum.algorithm() != BINLOG_CHECKSUM_ALG_OFF {
+				end -= _BINLOG_CHECKSUM_LENGTH
+			}
+			if end >= _EVENT_HEADER_LENGTH+8 {
+				rotate = string(ev[_EVENT_HEADER_LENGTH+8 : end])
+			}
+
+		default:
+		}
+
+		if !checksum.test(ev) {
+			r.add(file, offset, PROBLEM_CHECKSUM,
+				"checksum mismatch (%s event, stored %#08x)",
+				eventName(header.type_),
+				binary.LittleEndian.Uint32(ev[len(ev)-_BINLOG_CHECKSUM_LENGTH:]))
+		}
+
+		next := offset + uint64(header.size)
+		if !relayLog && header.position != 0 &&
+			header.position != uint32(next) {
+			r.add(file, offset, PROBLEM_POSITION,
+				"next position %d, expected %d", header.position,
+				uint32(next))
+		}
+		offset = next
+	}
+	return rotate, nil
+}
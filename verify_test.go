@@ -0,0 +1,218 @@
+/*
+  The MIT License (MIT)
+
+  Copyright (c) 2015 Nirbhay Choubey
+
+  Permission is hereby granted, free of charge, to any person obtaining a copy
+  of this software and associated documentation files (the "Software"), to deal
+  in the Software without restriction, including without limitation the rights
+  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  copies of the Software, and to permit persons to whom the Software is
+  furnished to do so, subject to the following conditions:
+
+  The above copyright notice and this permission notice shall be included in all
+  copies or substantial portions of the Software.
+
+  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+  SOFTWARE.
+*/
+
+package mysql
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// verifyFiles returns the events of two binlog files, the first one rotating
+// to the second one.
+func verifyFiles() (*evBuilder, *evBuilder) {
+	e1 := &evBuilder{checksum: true}
+	e1.fde()
+	e1.query("test", "CREATE TABLE t1 (a INT)")
+	e1.rotate("bin.000002", 4, false)
+
+	e2 := &evBuilder{checksum: true}
+	e2.fde()
+	e2.query("test", "CREATE TABLE t2 (a INT)")
+	return e1, e2
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(e1, e2 *evBuilder) // nil for intact files
+		index   string                  // index file contents
+		problem int                     // 0 for none
+		file    string                  // of the problem
+		offset  int                     // of the problem (event index)
+	}{
+		{"intact", nil, "", 0, "", 0},
+		{"checksum", func(e1, e2 *evBuilder) {
+			e2.events[1][25] ^= 0xff
+		}, "", PROBLEM_CHECKSUM, "bin.000002", 1},
+		{"position", func(e1, e2 *evBuilder) {
+			ev := e1.events[1]
+			binary.LittleEndian.PutUint32(ev[13:], 1000)
+			binary.LittleEndian.PutUint32(ev[len(ev)-4:],
+				crc32.ChecksumIEEE(ev[:len(ev)-4]))
+		}, "", PROBLEM_POSITION, "bin.000001", 1},
+		{"size", func(e1, e2 *evBuilder) {
+			binary.LittleEndian.PutUint32(e2.events[1][9:], 3)
+		}, "", PROBLEM_SIZE, "bin.000002", 1},
+		{"truncated", func(e1, e2 *evBuilder) {
+			e2.events[1] = e2.events[1][:30]
+		}, "", PROBLEM_TRUNCATED, "bin.000002", 1},
+		{"truncated header", func(e1, e2 *evBuilder) {
+			e2.events[1] = e2.events[1][:10]
+		}, "", PROBLEM_TRUNCATED, "bin.000002", 1},
+		{"rotate", nil, "./bin.000001\n./bin.000003\n", PROBLEM_ROTATE,
+			"bin.000003", -1},
+		{"magic", nil, "./bin.index\n./bin.000001\n", PROBLEM_BAD_MAGIC,
+			"bin.index", -1},
+	}
+
+	for _, test := range tests {
+		dir, err := ioutil.TempDir("", "verify")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		e1, e2 := verifyFiles()
+		if test.corrupt != nil {
+			test.corrupt(e1, e2)
+		}
+		writeBinlogFile(t, filepath.Join(dir, "bin.000001"), e1)
+		writeBinlogFile(t, filepath.Join(dir, "bin.000002"), e2)
+		writeBinlogFile(t, filepath.Join(dir, "bin.000003"), e2)
+
+		index := test.index
+		if index == "" {
+			index = "./bin.000001\n./bin.000002\n"
+		}
+		if err = ioutil.WriteFile(filepath.Join(dir, "bin.index"),
+			[]byte(index), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := Verify(filepath.Join(dir, "bin.index"))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		if len(r.Files) != 2 {
+			t.Errorf("%s: verified files %q", test.name, r.Files)
+		}
+
+		if test.problem == 0 {
+			if !r.OK() || r.Events != 5 {
+				t.Errorf("%s: %d events, problems %v", test.name,
+					r.Events, r.Problems)
+			}
+			continue
+		}
+
+		if len(r.Problems) != 1 {
+			t.Errorf("%s: problems %v", test.name, r.Problems)
+			continue
+		}
+
+		p := r.Problems[0]
+		offset := uint64(0)
+		if test.offset >= 0 {
+			events := e2.events
+			if test.file == "bin.000001" {
+				events = e1.events
+			}
+
+			offset = _BINLOG_HEADER_SIZE
+			for _, ev := range events[:test.offset] {
+				offset += uint64(len(ev))
+			}
+		}
+		if p.Type != test.problem || filepath.Base(p.File) != test.file ||
+			p.Offset != offset {
+			t.Errorf("%s: got %v (%d), want %s:%d (%d)", test.name, p,
+				p.Type, test.file, offset, test.problem)
+		}
+	}
+
+	// a single file
+	dir, err := ioutil.TempDir("", "verify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	e1, _ := verifyFiles()
+	writeBinlogFile(t, filepath.Join(dir, "bin.000001"), e1)
+	if r, err := Verify(filepath.Join(dir, "bin.000001")); err != nil ||
+		!r.OK() || len(r.Files) != 1 || r.Events != 3 {
+		t.Errorf("got %+v, %v", r, err)
+	}
+}
+
+func TestChecksumVerification(t *testing.T) {
+	dir, err := ioutil.TempDir("", "binlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	_, e := verifyFiles()
+	e.events[1][25] ^= 0xff
+	name := filepath.Join(dir, "bin.000002")
+	writeBinlogFile(t, name, e)
+
+	tests := []struct {
+		dsn string
+		err bool
+	}{
+		// verified by default
+		{"file://" + name, true},
+		{"file://" + name + "?BinlogVerifyChecksum=false", false},
+	}
+
+	for _, test := range tests {
+		b := new(Binlog)
+		if err = b.Connect(test.dsn); err != nil {
+			t.Fatal(err)
+		}
+		if err = b.Begin(); err != nil {
+			t.Fatal(err)
+		}
+
+		err = nil
+		for err == nil && b.Next() {
+			_, err = b.RawEvent()
+		}
+		if err == nil {
+			err = b.Error()
+		}
+		position := b.GetPosition()
+		b.Close()
+
+		// the corrupted event must not move the binlog past it
+		if want := uint64(4 + len(e.events[0])); test.err && position != want {
+			t.Errorf("%s: at %d, expected %d", test.dsn, position, want)
+		}
+
+		if e, ok := err.(*Error); test.err &&
+			(!ok || e.Code() != ErrEventChecksumFailure) {
+			t.Errorf("%s: got %v, want a checksum failure", test.dsn, err)
+		} else if !test.err && err != nil {
+			t.Errorf("%s: %v", test.dsn, err)
+		}
+	}
+}